@@ -0,0 +1,143 @@
+// Copyright 2017 The Upspin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package inprocess
+
+// Aliases.
+//
+// An alias, made by PutAlias, is a second name for an entry in the same
+// directory. Unlike a link it names the entry, not a path, and is not
+// itself an entry: Lookup of the alias returns the target's entry, and a
+// Glob pattern matches the target's entry if it matches the alias,
+// returning it once however many of its names match. ReadDir, Filter and
+// the other listings return only the entries themselves; Aliases lists
+// the aliases in a directory.
+//
+// An alias is recorded in db.aliases, keyed by its full name. If the
+// target is removed the alias refers to nothing, and if an entry is put
+// under the alias's name the alias is dropped. Aliases are forgotten when
+// their directory is deleted and move with it under MoveTree.
+
+import (
+	"strings"
+
+	"upspin.io/access"
+	"upspin.io/errors"
+	"upspin.io/path"
+	"upspin.io/upspin"
+)
+
+// PutAlias makes aliasName, which must not name an entry, an alias for
+// the entry named target, the last element of a name, in the same
+// directory. If target is empty, the alias is removed. The caller needs
+// create rights for aliasName.
+func (s *server) PutAlias(aliasName upspin.PathName, target string) error {
+	const op = "dir/inprocess.PutAlias"
+	parsed, err := s.parse(aliasName)
+	if err != nil {
+		return errors.E(op, err)
+	}
+	if parsed.IsRoot() {
+		return errors.E(op, aliasName, errors.Invalid, errors.Str("cannot alias a root"))
+	}
+	if strings.Contains(target, "/") || target == "." || target == ".." {
+		return errors.E(op, aliasName, errors.Invalid, errors.Errorf("bad alias target %q", target))
+	}
+	// Report links in the path before taking the lock.
+	if entry, err := s.lookup(op, parsed.Drop(1), true); err != nil {
+		_, err = s.errLink(op, entry, err)
+		return err
+	}
+	can, err := s.can(access.Create, parsed)
+	if err != nil {
+		return errors.E(op, err)
+	}
+	if !can {
+		return s.errPerm(op, parsed)
+	}
+
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	if s.db.readOnly {
+		return errors.E(op, aliasName, errors.Permission, errReadOnly)
+	}
+	if target == "" {
+		delete(s.db.aliases, parsed.Path())
+		return nil
+	}
+	if _, err := s.lookupLocked(op, parsed, false); !errors.Match(notExist, err) {
+		if err == nil {
+			return errors.E(op, aliasName, errors.Exist)
+		}
+		return errors.E(op, err)
+	}
+	targetParsed, err := path.Parse(path.Join(parsed.Drop(1).Path(), target))
+	if err != nil {
+		return errors.E(op, err)
+	}
+	if _, err := s.lookupLocked(op, targetParsed, false); err != nil {
+		return errors.E(op, err)
+	}
+	s.db.aliases[parsed.Path()] = targetParsed.Path()
+	return nil
+}
+
+// Aliases returns the aliases in the named directory, mapping the last
+// element of each alias to that of its target. The caller needs list
+// rights for the directory.
+func (s *server) Aliases(dirName upspin.PathName) (map[string]string, error) {
+	const op = "dir/inprocess.Aliases"
+	parsed, err := s.parse(dirName)
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+	entry, err := s.lookup(op, parsed, true)
+	if err != nil {
+		_, err = s.errLink(op, entry, err)
+		return nil, err
+	}
+	if !entry.IsDir() {
+		return nil, errors.E(op, dirName, errors.NotDir)
+	}
+	can, err := s.can(access.List, parsed)
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+	if !can {
+		return nil, s.errPerm(op, parsed)
+	}
+	aliases := make(map[string]string)
+	for alias, target := range s.db.aliasesIn(parsed.Path()) {
+		aliases[lastElem(alias)] = lastElem(target)
+	}
+	return aliases, nil
+}
+
+// aliasesIn returns the aliases in the named directory, keyed by full
+// name. s.db.mu is _not_ held.
+func (db *database) aliasesIn(dirName upspin.PathName) map[upspin.PathName]upspin.PathName {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	aliases := make(map[upspin.PathName]upspin.PathName)
+	for alias, target := range db.aliases {
+		if path.DropPath(alias, 1) == dirName {
+			aliases[alias] = target
+		}
+	}
+	return aliases
+}
+
+// aliasTarget returns the target of the named alias, if there is one.
+// s.db.mu is _not_ held.
+func (db *database) aliasTarget(name upspin.PathName) (upspin.PathName, bool) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	target, ok := db.aliases[name]
+	return target, ok
+}
+
+// lastElem returns the last element of the name.
+func lastElem(name upspin.PathName) string {
+	return string(name[strings.LastIndexByte(string(name), '/')+1:])
+}
@@ -0,0 +1,137 @@
+// Copyright 2017 The Upspin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package inprocess
+
+import (
+	"reflect"
+	"testing"
+
+	"upspin.io/errors"
+	"upspin.io/upspin"
+)
+
+func TestAlias(t *testing.T) {
+	config, dir := globTree(t)
+	s := dir.(*server)
+	user := config.UserName()
+	p := func(name string) upspin.PathName { return upspin.PathName(string(user) + name) }
+
+	if err := s.PutAlias(p("/a/latest"), "f2"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.PutAlias(p("/a/current"), "f2"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Lookup returns the target's entry.
+	entry, err := dir.Lookup(p("/a/latest"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.Name != p("/a/f2") {
+		t.Errorf("Lookup of alias returned %q; want %q", entry.Name, p("/a/f2"))
+	}
+
+	// Glob matches the target through its aliases, once.
+	for _, test := range []struct {
+		pattern string
+		want    []string
+	}{
+		{"/a/latest", []string{"/a/f2"}},
+		{"/a/l*", []string{"/a/f2"}},
+		{"/a/*", []string{"/a/b", "/a/f2"}},
+		{"/?/cur*", []string{"/a/f2"}},
+	} {
+		entries, err := dir.Glob(string(p(test.pattern)))
+		if err != nil {
+			t.Fatalf("Glob(%s): %v", test.pattern, err)
+		}
+		var got []string
+		for _, e := range entries {
+			got = append(got, string(e.Name[len(user):]))
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("Glob(%s) = %q; want %q", test.pattern, got, test.want)
+		}
+	}
+
+	// ReadDir does not list aliases; Aliases does.
+	entries, err := s.ReadDir(p("/a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Errorf("ReadDir returned %d entries; want 2", len(entries))
+	}
+	aliases, err := s.Aliases(p("/a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]string{"latest": "f2", "current": "f2"}; !reflect.DeepEqual(aliases, want) {
+		t.Errorf("Aliases = %v; want %v", aliases, want)
+	}
+
+	// Putting an entry under the alias's name replaces the alias.
+	if _, err := dir.Put(storeData(t, config, []byte("real"), p("/a/current"))); err != nil {
+		t.Fatal(err)
+	}
+	if entry, err := dir.Lookup(p("/a/current")); err != nil || entry.Name != p("/a/current") {
+		t.Errorf("Lookup after Put = %v, %v; want the new entry", entry, err)
+	}
+
+	// Removing the alias.
+	if err := s.PutAlias(p("/a/latest"), ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dir.Lookup(p("/a/latest")); !errors.Match(errors.E(errors.NotExist), err) {
+		t.Errorf("Lookup of removed alias: err = %v; want NotExist", err)
+	}
+
+	for _, test := range []struct {
+		alias, target string
+		kind          errors.Kind
+	}{
+		{"/a/f2", "b", errors.Exist},         // Alias names an entry.
+		{"/a/x", "nothing", errors.NotExist}, // No such target.
+		{"/a/x", "../f1", errors.Invalid},    // Not in the same directory.
+		{"/", "f1", errors.Invalid},
+	} {
+		if err := s.PutAlias(p(test.alias), test.target); !errors.Match(errors.E(test.kind), err) {
+			t.Errorf("PutAlias(%s, %s): err = %v; want %v", test.alias, test.target, err, test.kind)
+		}
+	}
+}
+
+func TestAliasMoveAndDelete(t *testing.T) {
+	config, dir := globTree(t)
+	s := dir.(*server)
+	user := config.UserName()
+	p := func(name string) upspin.PathName { return upspin.PathName(string(user) + name) }
+
+	if err := s.PutAlias(p("/c/d/five"), "f5"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.MoveTree(p("/c/d"), p("/a")); err != nil {
+		t.Fatal(err)
+	}
+	entry, err := dir.Lookup(p("/a/d/five"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.Name != p("/a/d/f5") {
+		t.Errorf("Lookup of moved alias returned %q; want %q", entry.Name, p("/a/d/f5"))
+	}
+
+	// Once the target and its directory are gone, so is the alias.
+	if _, err := dir.Delete(p("/a/d/f5")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dir.Delete(p("/a/d")); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := s.db.aliases[p("/a/d/five")]; ok {
+		t.Error("alias not forgotten with its directory")
+	}
+}
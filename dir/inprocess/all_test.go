@@ -11,6 +11,7 @@ import (
 	"fmt"
 	"sort"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"testing"
 
@@ -34,6 +35,23 @@ import (
 func init() {
 	bind.RegisterKeyServer(upspin.InProcess, keyserver.New())
 	bind.RegisterStoreServer(upspin.InProcess, storeserver.New())
+	// A second, separate store, for testing the dirStore option.
+	bind.RegisterStoreServer(upspin.Unassigned, otherStore{storeserver.New()})
+}
+
+// otherStore is an in-process StoreServer that accepts the Unassigned
+// transport, so tests can use two separate stores.
+type otherStore struct {
+	upspin.StoreServer
+}
+
+func (s otherStore) Dial(config upspin.Config, e upspin.Endpoint) (upspin.Service, error) {
+	e.Transport = upspin.InProcess
+	svc, err := s.StoreServer.Dial(config, e)
+	if err != nil {
+		return nil, err
+	}
+	return otherStore{svc.(upspin.StoreServer)}, nil
 }
 
 var (
@@ -919,3 +937,409 @@ func equal(d0, d1 *upspin.DirEntry) bool {
 	}
 	return bytes.Equal(b0, b1)
 }
+
+func TestClock(t *testing.T) {
+	config, dir := setup()
+	user := config.UserName()
+	s := dir.(*server)
+
+	// With a fixed clock, every directory written gets the same time.
+	const fixed = upspin.Time(123456)
+	s.db.now = func() upspin.Time { return fixed }
+	names := []upspin.PathName{
+		upspin.PathName(user + "/a"),
+		upspin.PathName(user + "/a/b"),
+	}
+	for _, name := range names {
+		if _, err := makeDirectory(dir, name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, name := range append(names, upspin.PathName(user+"/")) {
+		entry, err := dir.Lookup(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if entry.Time != fixed {
+			t.Errorf("%s: time = %d; want %d", name, entry.Time, fixed)
+		}
+	}
+
+	// With a stepping clock, the root's time advances on every write.
+	now := fixed
+	s.db.now = func() upspin.Time {
+		now++
+		return now
+	}
+	last := fixed
+	for i := 0; i < 3; i++ {
+		if _, err := makeDirectory(dir, upspin.PathName(fmt.Sprintf("%s/dir%d", user, i))); err != nil {
+			t.Fatal(err)
+		}
+		root, err := dir.Lookup(upspin.PathName(user + "/"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if root.Time <= last {
+			t.Fatalf("root time %d did not advance past %d", root.Time, last)
+		}
+		last = root.Time
+	}
+}
+
+func TestMakeDirectoryEntry(t *testing.T) {
+	config, dir := setup()
+	s := dir.(*server)
+	user := config.UserName()
+	dirName := upspin.PathName(user + "/dir")
+	entry, err := s.MakeDirectoryEntry(dirName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.Name != dirName || !entry.IsDir() {
+		t.Fatalf("got entry %q (dir=%t); want directory %q", entry.Name, entry.IsDir(), dirName)
+	}
+	lookup, err := dir.Lookup(dirName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !equal(entry, lookup) {
+		t.Fatalf("MakeDirectoryEntry returned\n\t%#v\nLookup returned\n\t%#v", entry, lookup)
+	}
+	// The wrapper returns no entry.
+	e, err := s.MakeDirectory(dirName + "/sub")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e != nil {
+		t.Fatal("non-nil entry from MakeDirectory")
+	}
+}
+
+func TestPutEntry(t *testing.T) {
+	config, dir := setup()
+	s := dir.(*server)
+	user := config.UserName()
+	name := upspin.PathName(user + "/file")
+	entry, err := s.PutEntry(storeData(t, config, []byte("one"), name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lookup, err := dir.Lookup(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !equal(entry, lookup) {
+		t.Fatalf("PutEntry returned\n\t%#v\nLookup returned\n\t%#v", entry, lookup)
+	}
+	// The returned sequence number makes the next Put conditional.
+	next := storeData(t, config, []byte("two"), name)
+	next.Sequence = entry.Sequence
+	entry2, err := s.PutEntry(next)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry2.Sequence != upspin.SeqNext(entry.Sequence) {
+		t.Errorf("Sequence = %d; want %d", entry2.Sequence, upspin.SeqNext(entry.Sequence))
+	}
+	stale := storeData(t, config, []byte("three"), name)
+	stale.Sequence = entry.Sequence
+	if _, err := s.PutEntry(stale); !errors.Match(errors.E(errSeq), err) {
+		t.Errorf("PutEntry with stale sequence: err = %v; want %v", err, errSeq)
+	}
+}
+
+func TestConcurrentMakeDirectory(t *testing.T) {
+	const n = 10
+	for _, idempotent := range []bool{false, true} {
+		config, dir := setup()
+		s := dir.(*server)
+		s.db.idempotentMkdir = idempotent
+		dirName := upspin.PathName(config.UserName() + "/dir")
+		var wg sync.WaitGroup
+		entries := make([]*upspin.DirEntry, n)
+		errs := make([]error, n)
+		for i := 0; i < n; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				entries[i], errs[i] = s.MakeDirectoryEntry(dirName)
+			}(i)
+		}
+		wg.Wait()
+		created := 0
+		for i, err := range errs {
+			switch {
+			case err == nil:
+				created++
+				if entries[i] == nil || entries[i].Name != dirName {
+					t.Errorf("idempotent=%t: bad entry %v", idempotent, entries[i])
+				}
+			case errors.Match(errors.E(errors.Exist), err):
+				if idempotent {
+					t.Errorf("idempotent=%t: unexpected error %v", idempotent, err)
+				}
+			default:
+				t.Errorf("idempotent=%t: unexpected error %v", idempotent, err)
+			}
+		}
+		want := 1
+		if idempotent {
+			want = n
+		}
+		if created != want {
+			t.Errorf("idempotent=%t: %d successful creates; want %d", idempotent, created, want)
+		}
+		// Exactly one directory exists.
+		found, err := dir.Glob(string(config.UserName() + "/*"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(found) != 1 {
+			t.Errorf("idempotent=%t: %d entries in root; want 1", idempotent, len(found))
+		}
+	}
+}
+
+func TestOptions(t *testing.T) {
+	cfg, _, _, _ := newConfigAndServices(nextUser())
+	s := New(cfg, "foldLocal=true", "idempotentMkdir=1").(*server)
+	if !s.db.foldLocal || !s.db.idempotentMkdir {
+		t.Errorf("options not set: foldLocal=%t idempotentMkdir=%t", s.db.foldLocal, s.db.idempotentMkdir)
+	}
+	for _, bad := range []string{"foldLocal", "foldLocal=maybe", "noSuchOption=true"} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("New with option %q did not panic", bad)
+				}
+			}()
+			New(cfg, bad)
+		}()
+	}
+}
+
+func TestDirStore(t *testing.T) {
+	config, dir := setup()
+	s := dir.(*server)
+	user := config.UserName()
+	if err := s.db.setOption("dirStore=unassigned"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := makeDirectory(dir, upspin.PathName(user+"/dir")); err != nil {
+		t.Fatal(err)
+	}
+	fileName := upspin.PathName(user + "/dir/file")
+	if _, err := dir.Put(storeData(t, config, []byte("data"), fileName)); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []upspin.PathName{upspin.PathName(user + "/"), upspin.PathName(user + "/dir"), fileName} {
+		entry, err := dir.Lookup(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := upspin.Unassigned
+		if !entry.IsDir() {
+			want = upspin.InProcess
+		}
+		if got := entry.Blocks[0].Location.Endpoint.Transport; got != want {
+			t.Errorf("%s is stored with transport %v; want %v", name, got, want)
+		}
+		// The data is in that store and only there.
+		main, err := bind.StoreServer(config, config.StoreEndpoint())
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _, _, err = main.Get(entry.Blocks[0].Location.Reference)
+		if (err == nil) != (want == upspin.InProcess) {
+			t.Errorf("%s: Get from main store: err = %v", name, err)
+		}
+	}
+	if got, err := s.GetData(fileName); err != nil || string(got) != "data" {
+		t.Errorf("GetData = %q, %v; want %q", got, err, "data")
+	}
+	if err := s.db.setOption("dirStore=nowhere"); !errors.Match(errors.E(errors.Invalid), err) {
+		t.Errorf("bad dirStore option: err = %v; want Invalid", err)
+	}
+}
+
+func TestMaxEntriesPerDir(t *testing.T) {
+	config, dir := setup()
+	s := dir.(*server)
+	user := config.UserName()
+	const max = 3
+	if err := s.db.setOption(fmt.Sprintf("maxEntriesPerDir=%d", max)); err != nil {
+		t.Fatal(err)
+	}
+	name := func(i int) upspin.PathName {
+		return upspin.PathName(fmt.Sprintf("%s/file%d", user, i))
+	}
+	for i := 0; i < max; i++ {
+		if _, err := dir.Put(storeData(t, config, []byte("x"), name(i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := dir.Put(storeData(t, config, []byte("x"), name(max))); !errors.Match(errors.E(errors.Invalid), err) {
+		t.Errorf("Put into full directory: err = %v; want Invalid", err)
+	}
+	if _, err := makeDirectory(dir, upspin.PathName(user+"/dir")); !errors.Match(errors.E(errors.Invalid), err) {
+		t.Errorf("MakeDirectory in full directory: err = %v; want Invalid", err)
+	}
+	// Replacing an entry is fine, as is making room.
+	if _, err := dir.Put(storeData(t, config, []byte("y"), name(0))); err != nil {
+		t.Errorf("overwrite in full directory: %v", err)
+	}
+	if _, err := dir.Delete(name(0)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dir.Put(storeData(t, config, []byte("x"), name(max))); err != nil {
+		t.Errorf("Put after Delete: %v", err)
+	}
+}
+
+func TestReportNotDir(t *testing.T) {
+	config, dir := setup()
+	s := dir.(*server)
+	user := config.UserName()
+	file := upspin.PathName(user + "/file")
+	if _, err := dir.Put(storeData(t, config, []byte("x"), file)); err != nil {
+		t.Fatal(err)
+	}
+	notDir := errors.E(file, errors.NotDir, ErrNotDirectory)
+	if _, err := dir.Put(storeData(t, config, []byte("x"), file+"/x")); !errors.Match(notDir, err) {
+		t.Errorf("Put below a file: err = %v; want %v", err, notDir)
+	}
+	deep := file + "/x/y"
+	if _, err := dir.Lookup(deep); !errors.Match(errors.E(deep, errors.NotExist), err) {
+		t.Errorf("Lookup through a file: err = %v; want NotExist", err)
+	}
+	if err := s.db.setOption("reportNotDir=true"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dir.Lookup(deep); !errors.Match(notDir, err) {
+		t.Errorf("Lookup through a file with reportNotDir: err = %v; want %v", err, notDir)
+	}
+}
+
+func TestNewIsolated(t *testing.T) {
+	config, dir := setup()
+	user := config.UserName()
+	fresh := New(config)
+	if _, err := fresh.Lookup(upspin.PathName(user + "/")); !errors.Match(errors.E(errors.NotExist), err) {
+		t.Errorf("Lookup of root in new server: err = %v; want NotExist", err)
+	}
+	if _, err := makeDirectory(fresh, upspin.PathName(user+"/")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := makeDirectory(fresh, upspin.PathName(user+"/only")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dir.Lookup(upspin.PathName(user + "/only")); !errors.Match(errors.E(errors.NotExist), err) {
+		t.Errorf("directory made in one server found in another: err = %v", err)
+	}
+	// Dial, unlike New, shares the tree.
+	svc, err := fresh.Dial(config, fresh.Endpoint())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := svc.(upspin.DirServer).Lookup(upspin.PathName(user + "/only")); err != nil {
+		t.Errorf("directory not found through Dial: %v", err)
+	}
+}
+
+// blockingStore is a StoreServer whose Puts wait, once started is
+// closed, until release is closed.
+type blockingStore struct {
+	upspin.StoreServer
+	block            int32 // Atomic; Puts block if non-zero.
+	started, release chan struct{}
+	once             sync.Once
+}
+
+func (b *blockingStore) Put(data []byte) (*upspin.Refdata, error) {
+	if atomic.LoadInt32(&b.block) != 0 {
+		b.once.Do(func() { close(b.started) })
+		<-b.release
+	}
+	return b.StoreServer.Put(data)
+}
+
+func TestLookupDuringPut(t *testing.T) {
+	config, dir := setup()
+	s := dir.(*server)
+	user := config.UserName()
+	store, err := bind.StoreServer(config, config.StoreEndpoint())
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := &blockingStore{StoreServer: store, started: make(chan struct{}), release: make(chan struct{})}
+	s.SetStore(b, config.StoreEndpoint())
+	if _, err := makeDirectory(dir, upspin.PathName(user+"/a")); err != nil {
+		t.Fatal(err)
+	}
+
+	// Start a Put and wait until it is storing the new directories.
+	atomic.StoreInt32(&b.block, 1)
+	done := make(chan error)
+	go func() {
+		_, err := makeDirectory(dir, upspin.PathName(user+"/a/b"))
+		done <- err
+	}()
+	<-b.started
+	// Lookups are not held up by the Put.
+	if _, err := dir.Lookup(upspin.PathName(user + "/a")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dir.Lookup(upspin.PathName(user + "/a/b")); !errors.Match(errors.E(errors.NotExist), err) {
+		t.Errorf("Lookup during Put: err = %v; want NotExist", err)
+	}
+	atomic.StoreInt32(&b.block, 0)
+	close(b.release)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dir.Lookup(upspin.PathName(user + "/a/b")); err != nil {
+		t.Errorf("Lookup after Put: %v", err)
+	}
+}
+
+func TestConcurrentPut(t *testing.T) {
+	config, dir := setup()
+	s := dir.(*server)
+	user := config.UserName()
+	const n = 20
+	entries := make([]*upspin.DirEntry, n)
+	for i := range entries {
+		name := upspin.PathName(fmt.Sprintf("%s/file%d", user, i))
+		entries[i] = storeData(t, config, []byte(name), name)
+	}
+	var wg sync.WaitGroup
+	errs := make([]error, n)
+	for i := range entries {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, errs[i] = dir.Put(entries[i])
+			if errs[i] == nil {
+				_, errs[i] = dir.Lookup(entries[i].Name)
+			}
+		}(i)
+	}
+	wg.Wait()
+	for i, err := range errs {
+		if err != nil {
+			t.Errorf("file%d: %v", i, err)
+		}
+	}
+	// Every Put survived the others.
+	found, err := dir.Glob(string(user) + "/*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(found) != n {
+		t.Errorf("found %d files; want %d", len(found), n)
+	}
+	if errs := s.checkUser(user); len(errs) != 0 {
+		t.Errorf("tree is inconsistent: %v", errs)
+	}
+}
@@ -0,0 +1,27 @@
+// Copyright 2017 The Upspin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package inprocess
+
+// When the dirBlockSize option is set, a directory whose data is larger
+// than that is split across several blocks, each holding at most that
+// many bytes, as a large file is. Readers, which concatenate the blocks,
+// see the same data either way, and the Merkle tree still refers to the
+// directory by its first block. Since a directory's data is stored anew
+// whenever it changes, the split is made afresh each time, so a directory
+// that shrinks returns to fewer blocks and a small directory to one.
+
+// splitBlocks returns the data cut into pieces of at most size bytes.
+// If size is not positive, or the data is empty, it returns one piece.
+func splitBlocks(data []byte, size int) [][]byte {
+	if size <= 0 || len(data) <= size {
+		return [][]byte{data}
+	}
+	blocks := make([][]byte, 0, (len(data)+size-1)/size)
+	for len(data) > size {
+		blocks = append(blocks, data[:size])
+		data = data[size:]
+	}
+	return append(blocks, data)
+}
@@ -0,0 +1,117 @@
+// Copyright 2017 The Upspin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package inprocess
+
+import (
+	"fmt"
+	"testing"
+
+	"upspin.io/upspin"
+)
+
+func TestDirBlockSize(t *testing.T) {
+	config, dir := setup()
+	s := dir.(*server)
+	s.db.dirBlockSize = 500
+	user := config.UserName()
+	root := upspin.PathName(user + "/")
+	// blocks returns the number of blocks holding the named directory.
+	blocks := func(name upspin.PathName) int {
+		entry, err := dir.Lookup(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(entry.Blocks)
+	}
+
+	if _, err := makeDirectory(dir, root+"dir"); err != nil {
+		t.Fatal(err)
+	}
+	if got := blocks(root + "dir"); got != 1 {
+		t.Fatalf("empty directory has %d blocks; want 1", got)
+	}
+	const n = 20
+	for i := 0; i < n; i++ {
+		name := upspin.PathName(fmt.Sprintf("%sdir/file%d", root, i))
+		if _, err := dir.Put(storeData(t, config, []byte(name), name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	grown := blocks(root + "dir")
+	if grown < 3 {
+		t.Fatalf("large directory has %d blocks; want several", grown)
+	}
+	entry, err := dir.Lookup(root + "dir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, b := range entry.Blocks {
+		if b.Size > int64(s.db.dirBlockSize)+100 {
+			t.Errorf("block %d has %d bytes", i, b.Size)
+		}
+	}
+	// Everything is still reachable across the blocks.
+	entries, err := dir.Glob(string(root + "dir/*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != n {
+		t.Fatalf("Glob found %d entries; want %d", len(entries), n)
+	}
+	if _, err := dir.Lookup(root + "dir/file13"); err != nil {
+		t.Fatal(err)
+	}
+	// The directory is split anew as it shrinks.
+	for i := 0; i < n-1; i++ {
+		if _, err := dir.Delete(upspin.PathName(fmt.Sprintf("%sdir/file%d", root, i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := blocks(root + "dir"); got != 1 {
+		t.Fatalf("directory with one entry has %d blocks; want 1", got)
+	}
+	entries, err = dir.Glob(string(root + "dir/*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Name != root+"dir/file19" {
+		t.Fatalf("Glob found %v; want file19", entryNames(entries))
+	}
+}
+
+func TestDirBlockSizeIndirect(t *testing.T) {
+	config, dir := setup()
+	s := dir.(*server)
+	s.db.dirBlockSize = 500
+	s.db.indirectSize = 500
+	root := upspin.PathName(config.UserName() + "/")
+	for i := 0; i < 20; i++ {
+		name := upspin.PathName(fmt.Sprintf("%sfile%d", root, i))
+		if _, err := dir.Put(storeData(t, config, []byte(name), name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	entry, err := dir.Lookup(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entry.Blocks) < 2 {
+		t.Fatalf("root has %d blocks; want several", len(entry.Blocks))
+	}
+	for i, b := range entry.Blocks {
+		if _, locs, err := s.RawGet(b.Location.Reference); err != nil {
+			t.Fatal(err)
+		} else if locs == nil {
+			t.Errorf("block %d is stored directly", i)
+		}
+	}
+	entries, err := dir.Glob(string(root + "*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 20 {
+		t.Fatalf("Glob found %d entries; want 20", len(entries))
+	}
+}
@@ -0,0 +1,110 @@
+// Copyright 2017 The Upspin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package inprocess
+
+import (
+	"upspin.io/errors"
+	"upspin.io/upspin"
+)
+
+// DecodeDir returns the entries held in the directory whose data is
+// stored under the reference, which must be that of a directory now in
+// the tree of the user named in name; the rest of name is ignored. It is
+// meant for inspecting the nodes of the Merkle tree: unlike Glob or
+// ReadDir, it makes no access checks below the root and does not
+// follow links. Directories are encrypted, so the entry that refers to
+// the data is needed to unpack it; the server finds it by walking the
+// tree. Only the user may do this.
+func (s *server) DecodeDir(ref upspin.Reference, name upspin.PathName) ([]*upspin.DirEntry, error) {
+	const op = "dir/inprocess.DecodeDir"
+	parsed, err := s.parse(name)
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+	dir, err := s.dirByRef(op, parsed.User(), ref)
+	if err != nil {
+		return nil, err
+	}
+	payload, err := s.readAll(dir)
+	if err != nil {
+		return nil, errors.E(op, dir.Name, err)
+	}
+	contents, err := parseDir(payload)
+	if err != nil {
+		return nil, errors.E(op, dir.Name, err)
+	}
+	entries := make([]*upspin.DirEntry, contents.len())
+	for i := range entries {
+		if entries[i], err = contents.entry(i); err != nil {
+			return nil, errors.E(op, dir.Name, err)
+		}
+	}
+	return entries, nil
+}
+
+// EntryByKey is like DecodeDir but returns a single entry: if name has
+// no elements after the user name, the entry for the directory itself,
+// and otherwise the entry in the directory whose name has the same last
+// element as name. Only the user may do this.
+func (s *server) EntryByKey(ref upspin.Reference, name upspin.PathName) (*upspin.DirEntry, error) {
+	const op = "dir/inprocess.EntryByKey"
+	parsed, err := s.parse(name)
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+	dir, err := s.dirByRef(op, parsed.User(), ref)
+	if err != nil {
+		return nil, err
+	}
+	if parsed.IsRoot() {
+		return dir, nil
+	}
+	payload, err := s.readAll(dir)
+	if err != nil {
+		return nil, errors.E(op, dir.Name, err)
+	}
+	return s.dirEntLookup(op, dir.Name, payload, parsed.Elem(parsed.NElem()-1))
+}
+
+// errFound stops the walk in dirByRef.
+var errFound = errors.Str("found")
+
+// dirByRef returns the entry for the directory in the user's tree whose
+// data is stored under the reference. Only the user may do this.
+// s.db.mu is held.
+func (s *server) dirByRef(op string, userName upspin.UserName, ref upspin.Reference) (*upspin.DirEntry, error) {
+	if normalizeUser(s.config.UserName(), s.db.foldLocal) != userName {
+		return nil, errors.E(op, userName, errors.Permission)
+	}
+	root, ok := s.db.root[userName]
+	if !ok {
+		return nil, errors.E(op, userName, errors.NotExist, errors.Str("no such user"))
+	}
+	storedAt := func(e *upspin.DirEntry) bool {
+		return len(e.Blocks) > 0 && e.Blocks[0].Location.Reference == ref
+	}
+	if storedAt(root) {
+		return root, nil
+	}
+	var found *upspin.DirEntry
+	err := s.walkTree(root, func(e *upspin.DirEntry) error {
+		if e.IsDir() && storedAt(e) {
+			found = e
+			return errFound
+		}
+		return nil
+	})
+	if err != nil && err != errFound {
+		return nil, errors.E(op, err)
+	}
+	if found == nil {
+		return nil, errors.E(op, upspin.PathName(userName+"/"), errors.NotExist, errors.Errorf("no directory with reference %q", ref))
+	}
+	return found, nil
+}
@@ -0,0 +1,71 @@
+// Copyright 2017 The Upspin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package inprocess
+
+import (
+	"strings"
+	"testing"
+
+	"upspin.io/errors"
+	"upspin.io/upspin"
+)
+
+func TestDecodeDir(t *testing.T) {
+	config, dir := globTree(t)
+	s := dir.(*server)
+	user := string(config.UserName())
+	ref := func(name string) upspin.Reference {
+		e, err := dir.Lookup(upspin.PathName(user + name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return e.Blocks[0].Location.Reference
+	}
+
+	for _, test := range []struct {
+		dir, want string
+	}{
+		{"/", "/a /c /f1 /link"},
+		{"/a/b", "/a/b/f3"},
+	} {
+		entries, err := s.DecodeDir(ref(test.dir), upspin.PathName(user+"/"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, e := range entries {
+			got = append(got, strings.TrimPrefix(string(e.Name), user))
+		}
+		if strings.Join(got, " ") != test.want {
+			t.Errorf("DecodeDir(%s) = %q; want %q", test.dir, got, test.want)
+		}
+	}
+
+	e, err := s.EntryByKey(ref("/c"), upspin.PathName(user+"/any/f4"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Name != upspin.PathName(user+"/c/f4") {
+		t.Errorf("EntryByKey found %s; want %s/c/f4", e.Name, user)
+	}
+	e, err = s.EntryByKey(ref("/c/d"), upspin.PathName(user+"/"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Name != upspin.PathName(user+"/c/d") || !e.IsDir() {
+		t.Errorf("EntryByKey of directory itself returned %v", e)
+	}
+	if _, err := s.EntryByKey(ref("/c"), upspin.PathName(user+"/nothing")); !errors.Match(errors.E(errors.NotExist), err) {
+		t.Errorf("EntryByKey of missing entry: err = %v; want NotExist", err)
+	}
+	// A file's reference is not a directory's.
+	if _, err := s.DecodeDir(ref("/f1"), upspin.PathName(user+"/")); !errors.Match(errors.E(errors.NotExist), err) {
+		t.Errorf("DecodeDir of file reference: err = %v; want NotExist", err)
+	}
+	_, other := setup()
+	if _, err := other.(*server).DecodeDir(ref("/"), upspin.PathName(user+"/")); !errors.Match(errors.E(errors.Permission), err) {
+		t.Errorf("DecodeDir by another user: err = %v; want Permission", err)
+	}
+}
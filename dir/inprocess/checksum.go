@@ -0,0 +1,89 @@
+// Copyright 2017 The Upspin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package inprocess
+
+import (
+	"bytes"
+	"crypto/sha256"
+
+	"upspin.io/errors"
+	"upspin.io/upspin"
+)
+
+// PutReader computes a checksum of the cleartext it stores, before any
+// compression, and Verify checks the data against it. This catches a
+// store that returns the wrong bytes, which packings such as plain pack
+// do not detect. The entry is signed and has no room for the checksum,
+// so, like the compressed flag, it is kept by the server and keyed by
+// the reference of the file's first block, and forgotten with the flag
+// once no file or kept version refers to the data. Files stored by Put,
+// and empty files, have no checksum.
+
+// checksumAlg identifies the algorithm of a checksum.
+type checksumAlg uint8
+
+const (
+	checksumSHA256 checksumAlg = 1 + iota
+)
+
+// checksum is a checksum of a file's cleartext.
+type checksum struct {
+	alg checksumAlg
+	sum []byte
+}
+
+// sumData returns the checksum of the data computed with the algorithm.
+func sumData(alg checksumAlg, data []byte) ([]byte, error) {
+	switch alg {
+	case checksumSHA256:
+		sum := sha256.Sum256(data)
+		return sum[:], nil
+	}
+	return nil, errors.E(errors.Invalid, errors.Errorf("unknown checksum algorithm %d", alg))
+}
+
+// checksumOf returns the checksum recorded for the entry's data, if any.
+func (db *database) checksumOf(entry *upspin.DirEntry) (checksum, bool) {
+	if len(entry.Blocks) == 0 {
+		return checksum{}, false
+	}
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	c, ok := db.checksums[entry.Blocks[0].Location.Reference]
+	return c, ok
+}
+
+// Verify reads the data of the named file, as GetData does, and checks
+// it against the checksum recorded when PutReader stored it. It returns
+// an IO error if they differ. A file with no checksum is not checked.
+func (s *server) Verify(name upspin.PathName) error {
+	const op = "dir/inprocess.Verify"
+	entry, err := s.Lookup(name)
+	if err == upspin.ErrFollowLink {
+		return err
+	}
+	if err != nil {
+		return errors.E(op, err)
+	}
+	if entry.IsDir() {
+		return errors.E(op, entry.Name, errors.IsDir)
+	}
+	c, ok := s.db.checksumOf(entry)
+	if !ok {
+		return nil
+	}
+	data, err := s.readData(entry)
+	if err != nil {
+		return errors.E(op, err)
+	}
+	sum, err := sumData(c.alg, data)
+	if err != nil {
+		return errors.E(op, entry.Name, err)
+	}
+	if !bytes.Equal(sum, c.sum) {
+		return errors.E(op, entry.Name, errors.IO, errors.Str("data does not match checksum"))
+	}
+	return nil
+}
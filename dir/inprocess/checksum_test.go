@@ -0,0 +1,108 @@
+// Copyright 2017 The Upspin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package inprocess
+
+import (
+	"bytes"
+	"testing"
+
+	"upspin.io/errors"
+	"upspin.io/upspin"
+)
+
+func TestVerify(t *testing.T) {
+	config, dir := setup()
+	s := dir.(*server)
+	user := config.UserName()
+	data := bytes.Repeat([]byte("checksum "), 1000)
+
+	plain := upspin.PathName(user + "/plain")
+	compressed := upspin.PathName(user + "/compressed")
+	noSum := upspin.PathName(user + "/nosum")
+	entry, err := s.PutReader(plain, bytes.NewReader(data), upspin.PlainPack, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.PutReader(compressed, bytes.NewReader(data), upspin.EEPack, &PutOptions{Compress: true}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dir.Put(storeData(t, config, data, noSum)); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []upspin.PathName{plain, compressed, noSum} {
+		if err := s.Verify(name); err != nil {
+			t.Errorf("Verify(%s): %v", name, err)
+		}
+	}
+	if err := s.Verify(upspin.PathName(user + "/")); !errors.Match(errors.E(errors.IsDir), err) {
+		t.Errorf("Verify of directory: err = %v; want IsDir", err)
+	}
+
+	// Simulate a store returning other bytes by changing the checksum.
+	ref := entry.Blocks[0].Location.Reference
+	s.db.checksums[ref] = checksum{alg: checksumSHA256, sum: make([]byte, 32)}
+	if err := s.Verify(plain); !errors.Match(errors.E(errors.IO), err) {
+		t.Errorf("Verify with bad checksum: err = %v; want IO", err)
+	}
+	s.db.checksums[ref] = checksum{alg: 99}
+	if err := s.Verify(plain); !errors.Match(errors.E(errors.Invalid), err) {
+		t.Errorf("Verify with unknown algorithm: err = %v; want Invalid", err)
+	}
+}
+
+func TestChecksumForgotten(t *testing.T) {
+	config, dir := setup()
+	s := dir.(*server)
+	user := config.UserName()
+	name := upspin.PathName(user + "/file")
+	link := upspin.PathName(user + "/link")
+	entry, err := s.PutReader(name, bytes.NewReader([]byte("checksum")), upspin.PlainPack, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.PutHardLink(link, name); err != nil {
+		t.Fatal(err)
+	}
+	ref := entry.Blocks[0].Location.Reference
+	// The checksum is kept while any name refers to the data.
+	if _, err := s.Delete(name); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := s.db.checksums[ref]; !ok {
+		t.Fatal("checksum forgotten while data still referenced")
+	}
+	if err := s.Verify(link); err != nil {
+		t.Errorf("Verify(%s): %v", link, err)
+	}
+	if _, err := s.Delete(link); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := s.db.checksums[ref]; ok {
+		t.Error("checksum kept after last entry for the data deleted")
+	}
+}
+
+func TestChecksumFailedPut(t *testing.T) {
+	config, dir := setup()
+	s := dir.(*server)
+	user := config.UserName()
+	name := upspin.PathName(user + "/file")
+	entry, err := s.PutReader(name, bytes.NewReader([]byte("existing")), upspin.PlainPack, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The name exists, so the put fails after the data is stored.
+	mustNotExist := upspin.Reference("")
+	opts := &PutOptions{IfMatchKey: &mustNotExist}
+	if _, err := s.PutReader(name, bytes.NewReader([]byte("other")), upspin.PlainPack, opts); !errors.Match(errors.E(errors.Exist), err) {
+		t.Fatalf("PutReader over existing name: err = %v; want Exist", err)
+	}
+	if n := len(s.db.checksums); n != 1 {
+		t.Errorf("%d checksums recorded; want 1", n)
+	}
+	if _, ok := s.db.checksums[entry.Blocks[0].Location.Reference]; !ok {
+		t.Error("checksum of the existing file lost")
+	}
+}
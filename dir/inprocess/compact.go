@@ -0,0 +1,91 @@
+// Copyright 2017 The Upspin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package inprocess
+
+import (
+	"upspin.io/access"
+	"upspin.io/errors"
+	"upspin.io/upspin"
+)
+
+// Compact rewrites the named directory, which may be a root, with its
+// live entries marshaled afresh, and stores it and the directories above
+// it again. Expired entries are reaped. The entries listed are unchanged,
+// so no event is sent. The caller needs write rights for the directory.
+//
+// Each change to a directory already rebuilds its data from its records,
+// so Compact does not usually make the data smaller, but it does drop
+// expired entries, which otherwise remain until the directory is next
+// written.
+func (s *server) Compact(dirName upspin.PathName) error {
+	const op = "dir/inprocess.Compact"
+	parsed, err := s.parse(dirName)
+	if err != nil {
+		return errors.E(op, err)
+	}
+	entry, err := s.lookup(op, parsed, true)
+	if err != nil {
+		_, err = s.errLink(op, entry, err)
+		return err
+	}
+	can, err := s.can(access.Write, parsed)
+	if err != nil {
+		return errors.E(op, err)
+	}
+	if !can {
+		return s.errPerm(op, parsed)
+	}
+
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	if s.db.readOnly {
+		return errors.E(op, dirName, errors.Permission, errReadOnly)
+	}
+	// Things may have changed since we looked.
+	_, entries, err := s.pathEntries(op, parsed)
+	if err != nil {
+		return errors.E(op, err)
+	}
+	dir := entries[len(entries)-1]
+	data, err := s.readAll(dir)
+	if err != nil {
+		return errors.E(op, dirName, err)
+	}
+	contents, err := parseDir(data)
+	if err != nil {
+		return errors.E(op, dirName, err)
+	}
+	c := *s // Make a copy to collect the reaped entries.
+	c.reaped = nil
+	contents, err = c.reap(parsed.Path(), contents)
+	if err != nil {
+		return errors.E(op, dirName, err)
+	}
+	records := make([][]byte, contents.len())
+	for i := range records {
+		e, err := contents.entry(i)
+		if err != nil {
+			return errors.E(op, dirName, err)
+		}
+		records[i], err = e.Marshal()
+		if err != nil {
+			return errors.E(op, dirName, err)
+		}
+	}
+	newDir, err := s.newDirEntry(parsed.Path(), formatDir(records), upspin.SeqNext(dir.Sequence))
+	if err != nil {
+		return errors.E(op, err)
+	}
+	root, err := s.rewritePath(op, parsed, entries, newDir)
+	if err != nil {
+		return err
+	}
+	if err := s.recordMutation("compact", parsed.Path(), nil, root); err != nil {
+		return errors.E(op, err)
+	}
+	s.db.root[parsed.User()] = root
+	s.db.dropReaped(c.reaped)
+	return nil
+}
@@ -0,0 +1,111 @@
+// Copyright 2017 The Upspin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package inprocess
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"upspin.io/errors"
+	"upspin.io/upspin"
+)
+
+func TestCompact(t *testing.T) {
+	config, dir := globTree(t)
+	s := dir.(*server)
+	user := config.UserName()
+	p := func(name string) upspin.PathName { return upspin.PathName(string(user) + name) }
+	now := upspin.Now()
+	s.db.now = func() upspin.Time { return now }
+
+	// Churn the directory with entries of varying length.
+	for i, data := range []string{"x", strings.Repeat("y", 100), "z"} {
+		if _, err := s.PutReader(p("/a/churn"), strings.NewReader(data), upspin.PlainPack, nil); err != nil {
+			t.Fatalf("put %d: %v", i, err)
+		}
+	}
+	if _, err := s.PutReader(p("/a/temp"), strings.NewReader("temp"), upspin.PlainPack, &PutOptions{ExpireAt: now + 10}); err != nil {
+		t.Fatal(err)
+	}
+	now += 10
+
+	before, err := dir.Glob(string(p("/a/*")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldDir, err := dir.Lookup(p("/a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Compact(p("/a")); err != nil {
+		t.Fatal(err)
+	}
+	after, err := dir.Glob(string(p("/a/*")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(entryNames(before), entryNames(after)) {
+		t.Errorf("entries after Compact:\n\t%q\nwant\n\t%q", entryNames(after), entryNames(before))
+	}
+	newDir, err := dir.Lookup(p("/a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The expired entry is gone from the data, not just hidden.
+	if newDir.Sequence == oldDir.Sequence {
+		t.Error("directory was not rewritten")
+	}
+	if dirSize(t, newDir) >= dirSize(t, oldDir) {
+		t.Errorf("compacted directory holds %d bytes; was %d", dirSize(t, newDir), dirSize(t, oldDir))
+	}
+	if _, ok := s.db.expire[p("/a/temp")]; ok {
+		t.Error("expiry record not dropped")
+	}
+	if errs := s.checkUser(user); len(errs) != 0 {
+		t.Errorf("tree is inconsistent: %v", errs)
+	}
+
+	// The root may be compacted too.
+	if err := s.Compact(p("/")); err != nil {
+		t.Fatal(err)
+	}
+	got := walkNames(t, s, user)
+	want := []string{"/", "/a", "/a/b", "/a/b/f3", "/a/churn", "/a/f2", "/c", "/c/d", "/c/d/f5", "/c/f4", "/f1", "/link"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("tree after compacting the root:\n\t%q\nwant\n\t%q", got, want)
+	}
+
+	for _, test := range []struct {
+		name string
+		kind errors.Kind
+	}{
+		{"/f1", errors.NotDir},
+		{"/nowhere", errors.NotExist},
+	} {
+		if err := s.Compact(p(test.name)); !errors.Match(errors.E(test.kind), err) {
+			t.Errorf("Compact(%s): err = %v; want %v", test.name, err, test.kind)
+		}
+	}
+	if err := s.Compact(p("/link")); err != upspin.ErrFollowLink {
+		t.Errorf("Compact(/link): err = %v; want ErrFollowLink", err)
+	}
+}
+
+func entryNames(entries []*upspin.DirEntry) []upspin.PathName {
+	var n []upspin.PathName
+	for _, e := range entries {
+		n = append(n, e.Name)
+	}
+	return n
+}
+
+func dirSize(t *testing.T, entry *upspin.DirEntry) int64 {
+	n, err := entry.Size()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
@@ -0,0 +1,123 @@
+// Copyright 2017 The Upspin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package inprocess
+
+// Content types.
+//
+// A file may be given a MIME content type by SetContentType, for a
+// server that serves the tree over HTTP. A file without one has the type
+// its extension implies, according to a small table of common types,
+// and otherwise none.
+//
+// A content type is not part of the DirEntry, so it is recorded in
+// db.contentType, keyed by file name. It survives the file being
+// replaced, is forgotten when the file is deleted, and moves with the
+// file under MoveTree.
+
+import (
+	"mime"
+	goPath "path"
+	"strings"
+
+	"upspin.io/access"
+	"upspin.io/errors"
+	"upspin.io/upspin"
+)
+
+// contentTypes maps file extensions, lower case, to the content types
+// ContentType infers for them.
+var contentTypes = map[string]string{
+	".css":  "text/css; charset=utf-8",
+	".gif":  "image/gif",
+	".go":   "text/plain; charset=utf-8",
+	".htm":  "text/html; charset=utf-8",
+	".html": "text/html; charset=utf-8",
+	".jpeg": "image/jpeg",
+	".jpg":  "image/jpeg",
+	".js":   "text/javascript; charset=utf-8",
+	".json": "application/json",
+	".md":   "text/markdown; charset=utf-8",
+	".pdf":  "application/pdf",
+	".png":  "image/png",
+	".svg":  "image/svg+xml",
+	".txt":  "text/plain; charset=utf-8",
+	".xml":  "text/xml; charset=utf-8",
+	".zip":  "application/zip",
+}
+
+// SetContentType sets the content type of the named file, which must be
+// a valid media type as defined by RFC 1521, such as "text/plain" or
+// "text/html; charset=utf-8". Setting the empty string removes it, so
+// that the type is again inferred from the extension. The caller needs
+// write rights for the file.
+func (s *server) SetContentType(name upspin.PathName, ctype string) error {
+	const op = "dir/inprocess.SetContentType"
+	if ctype != "" {
+		if _, _, err := mime.ParseMediaType(ctype); err != nil {
+			return errors.E(op, name, errors.Invalid, err)
+		}
+	}
+	parsed, err := s.parse(name)
+	if err != nil {
+		return errors.E(op, err)
+	}
+	entry, err := s.lookup(op, parsed, true)
+	if err != nil {
+		_, err = s.errLink(op, entry, err)
+		return err
+	}
+	can, err := s.can(access.Write, parsed)
+	if err != nil {
+		return errors.E(op, err)
+	}
+	if !can {
+		return s.errPerm(op, parsed)
+	}
+
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	if s.db.readOnly {
+		return errors.E(op, name, errors.Permission, errReadOnly)
+	}
+	// Things may have changed since we looked.
+	entry, err = s.lookupLocked(op, parsed, false)
+	if err != nil {
+		return errors.E(op, err)
+	}
+	if entry.IsDir() {
+		return errors.E(op, name, errors.IsDir)
+	}
+	if ctype == "" {
+		delete(s.db.contentType, parsed.Path())
+	} else {
+		s.db.contentType[parsed.Path()] = ctype
+	}
+	return nil
+}
+
+// ContentType returns the content type of the named file: the one set
+// by SetContentType or, if there is none, the one its extension implies.
+// If neither is known it returns the empty string. The caller needs
+// some right for the file.
+func (s *server) ContentType(name upspin.PathName) (string, error) {
+	const op = "dir/inprocess.ContentType"
+	entry, err := s.Lookup(name)
+	if err == upspin.ErrFollowLink {
+		return "", err
+	}
+	if err != nil {
+		return "", errors.E(op, err)
+	}
+	if entry.IsDir() {
+		return "", errors.E(op, entry.Name, errors.IsDir)
+	}
+	s.db.mu.RLock()
+	ctype, ok := s.db.contentType[entry.Name]
+	s.db.mu.RUnlock()
+	if ok {
+		return ctype, nil
+	}
+	return contentTypes[strings.ToLower(goPath.Ext(string(entry.Name)))], nil
+}
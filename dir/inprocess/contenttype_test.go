@@ -0,0 +1,69 @@
+// Copyright 2017 The Upspin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package inprocess
+
+import (
+	"testing"
+
+	"upspin.io/errors"
+	"upspin.io/upspin"
+)
+
+func TestContentType(t *testing.T) {
+	config, dir := setup()
+	s := dir.(*server)
+	user := config.UserName()
+	put := func(name string) upspin.PathName {
+		t.Helper()
+		p := upspin.PathName(user) + upspin.PathName(name)
+		if _, err := dir.Put(storeData(t, config, []byte(name), p)); err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+	check := func(name upspin.PathName, want string) {
+		t.Helper()
+		got, err := s.ContentType(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("ContentType(%s) = %q; want %q", name, got, want)
+		}
+	}
+
+	page := put("/index.HTML")
+	data := put("/data")
+	check(page, "text/html; charset=utf-8") // From the extension.
+	check(data, "")                         // Unknown.
+
+	if err := s.SetContentType(data, "application/octet-stream"); err != nil {
+		t.Fatal(err)
+	}
+	check(data, "application/octet-stream")
+	put("/data") // Replacing the file keeps the type.
+	check(data, "application/octet-stream")
+	if err := s.SetContentType(data, ""); err != nil {
+		t.Fatal(err)
+	}
+	check(data, "")
+
+	if err := s.SetContentType(page, "not a type"); !errors.Match(errors.E(errors.Invalid), err) {
+		t.Errorf("SetContentType with bad type: err = %v; want Invalid", err)
+	}
+	if err := s.SetContentType(upspin.PathName(user+"/"), "text/plain"); !errors.Match(errors.E(errors.IsDir), err) {
+		t.Errorf("SetContentType of directory: err = %v; want IsDir", err)
+	}
+
+	// Deleting the file forgets the type.
+	if err := s.SetContentType(data, "text/csv"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dir.Delete(data); err != nil {
+		t.Fatal(err)
+	}
+	put("/data")
+	check(data, "")
+}
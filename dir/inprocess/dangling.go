@@ -0,0 +1,55 @@
+// Copyright 2017 The Upspin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package inprocess
+
+import (
+	"upspin.io/client/clientutil"
+	"upspin.io/errors"
+	"upspin.io/upspin"
+)
+
+// FindDangling returns the names of the files in the user's tree with a
+// block whose data is not in the store, as when a directory was updated
+// but the write of the data was lost. A reference that redirects to
+// other locations is followed, and the block is dangling only if none
+// of them holds the data. Other failures to fetch a block are returned
+// as an error. Unlike the scanner, which reports every inconsistency it
+// finds, FindDangling looks only at file data. Only the user may do
+// this.
+func (s *server) FindDangling(userName upspin.UserName) ([]upspin.PathName, error) {
+	const op = "dir/inprocess.FindDangling"
+	userName = normalizeUser(userName, s.db.foldLocal)
+	if normalizeUser(s.config.UserName(), s.db.foldLocal) != userName {
+		return nil, errors.E(op, userName, errors.Permission)
+	}
+
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+	root, ok := s.db.root[userName]
+	if !ok {
+		return nil, errors.E(op, userName, errors.NotExist, errors.Str("no such user"))
+	}
+	var dangling []upspin.PathName
+	err := s.walkTree(root, func(entry *upspin.DirEntry) error {
+		if !entry.IsRegular() {
+			return nil
+		}
+		for _, b := range entry.Blocks {
+			_, err := clientutil.ReadLocation(s.config, b.Location)
+			if errors.Match(notExist, err) {
+				dangling = append(dangling, entry.Name)
+				break
+			}
+			if err != nil {
+				return errors.E(entry.Name, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+	return dangling, nil
+}
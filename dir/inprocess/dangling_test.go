@@ -0,0 +1,69 @@
+// Copyright 2017 The Upspin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package inprocess
+
+import (
+	"fmt"
+	"testing"
+
+	"upspin.io/bind"
+	"upspin.io/errors"
+	"upspin.io/upspin"
+)
+
+func TestFindDangling(t *testing.T) {
+	config, dir := globTree(t)
+	s := dir.(*server)
+	user := config.UserName()
+	if dangling, err := s.FindDangling(user); err != nil || len(dangling) != 0 {
+		t.Fatalf("healthy tree: FindDangling = %v, %v; want none", dangling, err)
+	}
+	store, err := bind.StoreServer(config, config.StoreEndpoint())
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := store.(redirector)
+
+	// Two files whose blocks redirect to the data: one whose data is
+	// there and one whose data is lost.
+	var targets []upspin.Reference
+	for _, name := range []string{"/redirected", "/lost"} {
+		entry := storeData(t, config, []byte("data of "+name), upspin.PathName(user)+upspin.PathName(name))
+		targets = append(targets, entry.Blocks[0].Location.Reference)
+		refdata, err := r.PutRedirect([]upspin.Location{entry.Blocks[0].Location})
+		if err != nil {
+			t.Fatal(err)
+		}
+		entry.Blocks[0].Location.Reference = refdata.Reference
+		if _, err := dir.Put(entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.Delete(targets[1]); err != nil {
+		t.Fatal(err)
+	}
+	// And a file whose data is lost directly.
+	entry, err := dir.Lookup(upspin.PathName(user + "/c/f4"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Delete(entry.Blocks[0].Location.Reference); err != nil {
+		t.Fatal(err)
+	}
+
+	dangling, err := s.FindDangling(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := fmt.Sprint([]upspin.PathName{upspin.PathName(user + "/c/f4"), upspin.PathName(user + "/lost")})
+	if got := fmt.Sprint(dangling); got != want {
+		t.Errorf("FindDangling = %s; want %s", got, want)
+	}
+
+	other := nextUser()
+	if _, err := s.FindDangling(other); !errors.Match(errors.E(errors.Permission), err) {
+		t.Errorf("FindDangling of another user: err = %v; want Permission", err)
+	}
+}
@@ -0,0 +1,376 @@
+// Copyright 2017 The Upspin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package inprocess
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"io"
+	"io/ioutil"
+
+	"upspin.io/bind"
+	"upspin.io/client/clientutil"
+	"upspin.io/errors"
+	"upspin.io/pack"
+	"upspin.io/upspin"
+)
+
+// PutOptions holds optional parameters for PutReader.
+// The zero value provides the default behavior.
+type PutOptions struct {
+	// Compress specifies that the data be compressed with gzip before
+	// it is packed. The server remembers which data is compressed and
+	// GetData decompresses it; other readers see the compressed data.
+	Compress bool
+
+	// Size, if non-zero, is the expected length of the data. It is
+	// checked only if the server's validateSize option is set, in which
+	// case PutReader fails if the length of the data read differs.
+	Size int64
+
+	// IdempotencyKey, if non-empty, identifies the request so that it
+	// can be retried safely. See idempotency.go.
+	IdempotencyKey string
+
+	// ExpireAt, if non-zero, is the time after which the entry is
+	// treated as absent. See expire.go.
+	ExpireAt upspin.Time
+
+	// IfMatchKey, if non-nil, makes the Put conditional on the key of
+	// the existing entry, the reference of its first block: if that
+	// differs from *IfMatchKey, PutReader fails with a key mismatch
+	// error and stores no entry. If *IfMatchKey is empty, the name must
+	// not exist, and if it does PutReader fails with an Exist error.
+	// The check is made with the tree locked, so it is atomic with
+	// the update.
+	IfMatchKey *upspin.Reference
+
+	// Lock specifies that the entry be locked, so that it cannot be
+	// replaced or removed until it is unlocked. See lock.go.
+	Lock bool
+
+	// Unlock allows the entry to replace a locked one. The new entry
+	// is not locked unless Lock is also set.
+	Unlock bool
+
+	// Sticky specifies that the directory made by MakeDirectoryOptions
+	// be sticky. Only the owner of the tree may set it, and PutReader
+	// rejects it. See sticky.go.
+	Sticky bool
+}
+
+// PutReader stores the data read from r, packed with the given packing,
+// in the calling user's store and installs a file entry for it under name.
+// It returns the installed entry. A nil opts is equivalent to a zero PutOptions.
+// If packing is upspin.UnassignedPack, the default packing of the directory
+// holding the entry is used; see SetDefaultPacking.
+//
+// The data is read, packed and stored one block (upspin.BlockSize bytes)
+// at a time, so at most one block of cleartext is buffered in memory.
+// The entry's blocks, and hence its size, reflect the bytes actually read,
+// after compression if requested.
+func (s *server) PutReader(name upspin.PathName, r io.Reader, packing upspin.Packing, opts *PutOptions) (*upspin.DirEntry, error) {
+	const op = "dir/inprocess.PutReader"
+	if opts == nil {
+		opts = &PutOptions{}
+	}
+	if opts.Sticky {
+		return nil, errors.E(op, name, errors.Invalid, errors.Str("only a directory can be sticky"))
+	}
+	parsed, err := s.parse(name)
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+	remembered := false
+	if opts.IdempotencyKey != "" {
+		entry, err := s.idempotentResult(parsed.Path(), opts.IdempotencyKey)
+		if err != nil {
+			return nil, errors.E(op, err)
+		}
+		if entry != nil {
+			return entry, nil
+		}
+		// The key is reserved for this call; release it if the call fails.
+		defer func() {
+			if !remembered {
+				s.forgetResult(opts.IdempotencyKey)
+			}
+		}()
+	}
+	if packing == upspin.UnassignedPack {
+		packing = s.db.defaultPackingFor(parsed.Drop(1).Path())
+	}
+	packer := pack.Lookup(packing)
+	if packer == nil {
+		return nil, errors.E(op, name, errors.Invalid, errors.Errorf("no packing %#x registered", packing))
+	}
+	store, err := bind.StoreServer(s.config, s.config.StoreEndpoint())
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+	entry := &upspin.DirEntry{
+		Name:       parsed.Path(),
+		SignedName: parsed.Path(),
+		Packing:    packing,
+		Time:       s.db.now(),
+		Attr:       upspin.AttrNone,
+		Sequence:   upspin.SeqIgnore,
+		Writer:     s.config.UserName(),
+	}
+	bp, err := packer.Pack(s.config, entry)
+	if err != nil {
+		return nil, errors.E(op, name, err)
+	}
+	counter := &countingReader{r: r}
+	hash := sha256.New()
+	r = io.TeeReader(counter, hash)
+	if opts.Compress {
+		// Compression is layered above packing: the packer sees
+		// the compressed bytes as the cleartext.
+		pr, pw := io.Pipe()
+		defer pr.Close()
+		go func(r io.Reader) {
+			zw := gzip.NewWriter(pw)
+			_, err := io.Copy(zw, r)
+			if err == nil {
+				err = zw.Close()
+			}
+			pw.CloseWithError(err)
+		}(r)
+		r = pr
+	}
+	buf := make([]byte, upspin.BlockSize)
+	for {
+		n, readErr := io.ReadFull(r, buf)
+		if n > 0 {
+			ciphertext, err := bp.Pack(buf[:n])
+			if err != nil {
+				return nil, errors.E(op, name, err)
+			}
+			span := s.startSpan("Store.Put", parsed.Path())
+			var refdata *upspin.Refdata
+			err = s.retry(func() error {
+				var err error
+				refdata, err = store.Put(ciphertext)
+				return err
+			})
+			if span != nil {
+				span.End()
+			}
+			if err != nil {
+				return nil, errors.E(op, name, err)
+			}
+			bp.SetLocation(upspin.Location{
+				Endpoint:  s.config.StoreEndpoint(),
+				Reference: refdata.Reference,
+			})
+		}
+		if readErr == io.EOF || readErr == io.ErrUnexpectedEOF {
+			break
+		}
+		if readErr != nil {
+			return nil, errors.E(op, name, errors.IO, readErr)
+		}
+	}
+	if opts.Size != 0 && s.db.validateSize && counter.n != opts.Size {
+		return nil, errors.E(op, name, errors.Invalid, errors.Errorf("read %d bytes; expected %d", counter.n, opts.Size))
+	}
+	if err := bp.Close(); err != nil {
+		return nil, errors.E(op, name, err)
+	}
+	c := *s // Make a copy.
+	c.ifMatch = opts.IfMatchKey
+	c.lock = opts.Lock
+	c.unlock = opts.Unlock
+	c.compressed = opts.Compress
+	c.checksum = &checksum{alg: checksumSHA256, sum: hash.Sum(nil)}
+	c.expireAt = opts.ExpireAt
+	entry, err = c.putEntry(op, entry)
+	if err != nil {
+		return nil, err
+	}
+	if opts.IdempotencyKey != "" {
+		s.rememberResult(opts.IdempotencyKey, entry)
+		remembered = true
+	}
+	return entry, nil
+}
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// GetData returns the contents of the named file, decompressing
+// them if they were stored compressed by PutReader. It does a Lookup,
+// with the usual access checks, then reads each block from the store,
+// following any redirection the store returns, and unpacks it using
+// the caller's config.
+// It is an error to get the data of a directory. If the name, or any
+// element of its path, is a link, GetData returns ErrFollowLink; use
+// Lookup to retrieve the link.
+func (s *server) GetData(name upspin.PathName) ([]byte, error) {
+	const op = "dir/inprocess.GetData"
+	entry, err := s.Lookup(name)
+	if err == upspin.ErrFollowLink {
+		return nil, err
+	}
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+	if entry.IsDir() {
+		return nil, errors.E(op, entry.Name, errors.IsDir)
+	}
+	data, err := s.readData(entry)
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+	return data, nil
+}
+
+// GetDataRange is like GetData but returns only the length bytes of the
+// file starting at offset. Only the blocks holding those bytes are read
+// from the store, unless the data was stored compressed, in which case
+// it must all be read and decompressed. As with io.ReaderAt, if the range
+// extends past the end of the file GetDataRange returns the bytes up to
+// the end along with io.EOF, and if offset is at or past the end it
+// returns no data and io.EOF.
+func (s *server) GetDataRange(name upspin.PathName, offset, length int64) ([]byte, error) {
+	const op = "dir/inprocess.GetDataRange"
+	if offset < 0 || length < 0 {
+		return nil, errors.E(op, name, errors.Invalid, errors.Str("negative offset or length"))
+	}
+	entry, err := s.Lookup(name)
+	if err == upspin.ErrFollowLink {
+		return nil, err
+	}
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+	if entry.IsDir() {
+		return nil, errors.E(op, entry.Name, errors.IsDir)
+	}
+	if s.db.isCompressed(entry) {
+		data, err := s.readData(entry)
+		if err != nil {
+			return nil, errors.E(op, err)
+		}
+		return dataRange(data, offset, length)
+	}
+	size, err := entry.Size()
+	if err != nil {
+		return nil, errors.E(op, entry.Name, err)
+	}
+	if offset >= size {
+		return nil, io.EOF
+	}
+	end := offset + length
+	var short error
+	if end > size || end < offset {
+		end, short = size, io.EOF
+	}
+	data, err := s.readRange(entry, offset, end)
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+	return data, short
+}
+
+// dataRange returns the length bytes of data starting at offset,
+// following the rules of GetDataRange.
+func dataRange(data []byte, offset, length int64) ([]byte, error) {
+	size := int64(len(data))
+	if offset >= size {
+		return nil, io.EOF
+	}
+	if length > size-offset {
+		return data[offset:], io.EOF
+	}
+	return data[offset : offset+length], nil
+}
+
+// readRange reads and unpacks the blocks of the file that hold the bytes
+// from offset up to end, using the caller's config, and returns those
+// bytes. The data must not be compressed.
+func (s *server) readRange(entry *upspin.DirEntry, offset, end int64) ([]byte, error) {
+	if entry.IsIncomplete() {
+		return nil, errors.E(entry.Name, errors.Permission)
+	}
+	packer := pack.Lookup(entry.Packing)
+	if packer == nil {
+		return nil, errors.E(entry.Name, errors.Errorf("unrecognized Packing %d", entry.Packing))
+	}
+	bu, err := packer.Unpack(s.config, entry)
+	if err != nil {
+		return nil, errors.E(entry.Name, err)
+	}
+	data := make([]byte, 0, end-offset)
+	for i := range entry.Blocks {
+		block, ok := bu.SeekBlock(i)
+		if !ok {
+			return nil, errors.E(entry.Name, errors.IO, errors.Errorf("no block %d", i))
+		}
+		if block.Offset+block.Size <= offset || block.Offset >= end {
+			continue
+		}
+		cipher, err := clientutil.ReadLocation(s.config, block.Location)
+		if err != nil {
+			return nil, err
+		}
+		clear, err := bu.Unpack(cipher)
+		if err != nil {
+			return nil, errors.E(entry.Name, err)
+		}
+		lo, hi := offset-block.Offset, end-block.Offset
+		if lo < 0 {
+			lo = 0
+		}
+		if hi > int64(len(clear)) {
+			hi = int64(len(clear))
+		}
+		data = append(data, clear[lo:hi]...)
+	}
+	return data, nil
+}
+
+// readData reads and unpacks the data of the file, using the caller's
+// config, and decompresses it if it was stored compressed.
+func (s *server) readData(entry *upspin.DirEntry) ([]byte, error) {
+	data, err := clientutil.ReadAll(s.config, entry)
+	if err != nil {
+		return nil, err
+	}
+	if !s.db.isCompressed(entry) {
+		return data, nil
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, errors.E(entry.Name, errors.IO, err)
+	}
+	data, err = ioutil.ReadAll(zr)
+	if err != nil {
+		return nil, errors.E(entry.Name, errors.IO, err)
+	}
+	return data, nil
+}
+
+// isCompressed reports whether the entry's data was compressed by PutReader.
+// The record is kept by reference, not name, so it survives hard links.
+func (db *database) isCompressed(entry *upspin.DirEntry) bool {
+	if len(entry.Blocks) == 0 {
+		return false
+	}
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return db.compressed[entry.Blocks[0].Location.Reference]
+}
@@ -0,0 +1,300 @@
+// Copyright 2017 The Upspin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package inprocess
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"upspin.io/errors"
+	"upspin.io/upspin"
+)
+
+func TestPutReader(t *testing.T) {
+	config, dir := setup()
+	s := dir.(*server)
+	user := config.UserName()
+	for _, size := range []int{0, 10, upspin.BlockSize, 2*upspin.BlockSize + 17} {
+		name := upspin.PathName(user + "/file")
+		data := bytes.Repeat([]byte{'x'}, size)
+		entry, err := s.PutReader(name, bytes.NewReader(data), upspin.PlainPack, nil)
+		if err != nil {
+			t.Fatalf("size %d: %v", size, err)
+		}
+		if got, want := len(entry.Blocks), (size+upspin.BlockSize-1)/upspin.BlockSize; got != want {
+			t.Errorf("size %d: %d blocks; want %d", size, got, want)
+		}
+		entrySize, err := entry.Size()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if entrySize != int64(size) {
+			t.Errorf("entry size = %d; want %d", entrySize, size)
+		}
+		lookup, err := dir.Lookup(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := readAll(config, lookup)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, data) {
+			t.Errorf("size %d: read back %d bytes that differ from those written", size, len(got))
+		}
+	}
+}
+
+func TestPutReaderCompress(t *testing.T) {
+	config, dir := setup()
+	s := dir.(*server)
+	user := config.UserName()
+	plainName := upspin.PathName(user + "/plain")
+	zipName := upspin.PathName(user + "/compressed")
+	data := bytes.Repeat([]byte("compressible "), 10000)
+
+	if _, err := s.PutReader(plainName, bytes.NewReader(data), upspin.EEPack, nil); err != nil {
+		t.Fatal(err)
+	}
+	entry, err := s.PutReader(zipName, bytes.NewReader(data), upspin.EEPack, &PutOptions{Compress: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	size, err := entry.Size()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size >= int64(len(data)) {
+		t.Errorf("compressed size %d not less than data size %d", size, len(data))
+	}
+	for _, name := range []upspin.PathName{plainName, zipName} {
+		got, err := s.GetData(name)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if !bytes.Equal(got, data) {
+			t.Errorf("%s: GetData returned %d bytes that differ from those written", name, len(got))
+		}
+	}
+}
+
+func TestPutReaderCompressForgotten(t *testing.T) {
+	config, dir := setup()
+	s := dir.(*server)
+	user := config.UserName()
+	if err := s.db.setOption("history=1"); err != nil {
+		t.Fatal(err)
+	}
+	name := upspin.PathName(user + "/compressed")
+	put := func(text string) (upspin.Reference, int64) {
+		t.Helper()
+		data := bytes.Repeat([]byte(text), 1000)
+		entry, err := s.PutReader(name, bytes.NewReader(data), upspin.PlainPack, &PutOptions{Compress: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return entry.Blocks[0].Location.Reference, entry.Sequence
+	}
+	compressed := func(ref upspin.Reference) bool {
+		s.db.mu.RLock()
+		defer s.db.mu.RUnlock()
+		return s.db.compressed[ref]
+	}
+
+	// A replaced version kept in the history keeps its flag and so
+	// can still be read.
+	ref1, seq1 := put("one ")
+	ref2, _ := put("two ")
+	if !compressed(ref1) || !compressed(ref2) {
+		t.Fatal("compressed data not recorded")
+	}
+	if data, err := s.GetVersion(name, seq1); err != nil || !bytes.Equal(data, bytes.Repeat([]byte("one "), 1000)) {
+		t.Errorf("GetVersion of replaced version: %d bytes, %v", len(data), err)
+	}
+	// Once it drops out of the history, the flag is forgotten.
+	ref3, _ := put("three ")
+	if compressed(ref1) {
+		t.Error("flag kept for data dropped from history")
+	}
+	if !compressed(ref2) || !compressed(ref3) {
+		t.Error("flag forgotten for data still kept")
+	}
+
+	// Deleting the file forgets its flags.
+	if _, err := s.Delete(name); err != nil {
+		t.Fatal(err)
+	}
+	if compressed(ref2) || compressed(ref3) {
+		t.Error("flag kept for deleted data")
+	}
+
+	// As does deleting the user.
+	ref4, _ := put("four ")
+	if err := s.DeleteUser(user); err != nil {
+		t.Fatal(err)
+	}
+	if compressed(ref4) {
+		t.Error("flag kept after DeleteUser")
+	}
+}
+
+func TestPutReaderCompressFailedPut(t *testing.T) {
+	config, dir := setup()
+	s := dir.(*server)
+	user := config.UserName()
+	name := upspin.PathName(user + "/file")
+	if _, err := s.PutReader(name, strings.NewReader("existing"), upspin.PlainPack, nil); err != nil {
+		t.Fatal(err)
+	}
+	// The name exists, so the put fails after the data is stored.
+	mustNotExist := upspin.Reference("")
+	opts := &PutOptions{Compress: true, IfMatchKey: &mustNotExist}
+	data := bytes.Repeat([]byte("compressible "), 1000)
+	if _, err := s.PutReader(name, bytes.NewReader(data), upspin.PlainPack, opts); !errors.Match(errors.E(errors.Exist), err) {
+		t.Fatalf("PutReader over existing name: err = %v; want Exist", err)
+	}
+	if n := len(s.db.compressed); n != 0 {
+		t.Errorf("%d compressed flags recorded for a failed put; want none", n)
+	}
+}
+
+func TestGetData(t *testing.T) {
+	config, dir := setup()
+	s := dir.(*server)
+	user := config.UserName()
+	dirName := upspin.PathName(user + "/dir")
+	fileName := dirName + "/file"
+	linkName := upspin.PathName(user + "/link")
+	const text = "some text"
+
+	if _, err := makeDirectory(dir, dirName); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dir.Put(storeData(t, config, []byte(text), fileName)); err != nil {
+		t.Fatal(err)
+	}
+	link, err := newDirEntry(config, upspin.PlainPack, linkName, nil, upspin.AttrLink, dirName, upspin.SeqIgnore)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dir.Put(link); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := s.GetData(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != text {
+		t.Errorf("GetData = %q; want %q", data, text)
+	}
+	if _, err := s.GetData(dirName); !errors.Match(errors.E(errors.IsDir), err) {
+		t.Errorf("GetData of directory: err = %v; want IsDir", err)
+	}
+	if _, err := s.GetData(linkName + "/file"); err != upspin.ErrFollowLink {
+		t.Errorf("GetData through link: err = %v; want ErrFollowLink", err)
+	}
+	if _, err := s.GetData(dirName + "/nothing"); !errors.Match(errors.E(errors.NotExist), err) {
+		t.Errorf("GetData of missing file: err = %v; want NotExist", err)
+	}
+}
+
+func TestPutReaderIfMatchKey(t *testing.T) {
+	config, dir := setup()
+	s := dir.(*server)
+	name := upspin.PathName(config.UserName() + "/file")
+	key := func(ref upspin.Reference) *PutOptions {
+		return &PutOptions{IfMatchKey: &ref}
+	}
+	put := func(data string, opts *PutOptions) (*upspin.DirEntry, error) {
+		return s.PutReader(name, strings.NewReader(data), upspin.PlainPack, opts)
+	}
+
+	if _, err := put("one", key("no such key")); !errors.Match(errors.E(errKeyMismatch), err) {
+		t.Fatalf("Put of new file with key: err = %v; want key mismatch", err)
+	}
+	first, err := put("one", key(""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := put("two", key("")); !errors.Match(errors.E(errors.Exist), err) {
+		t.Fatalf("create-if-absent of existing file: err = %v; want Exist", err)
+	}
+	firstKey := first.Blocks[0].Location.Reference
+	second, err := put("two", key(firstKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The first key is now stale.
+	if _, err := put("three", key(firstKey)); !errors.Match(errors.E(errKeyMismatch), err) {
+		t.Fatalf("Put with stale key: err = %v; want key mismatch", err)
+	}
+	if got, err := s.GetData(name); err != nil || string(got) != "two" {
+		t.Errorf("GetData = %q, %v; want %q", got, err, "two")
+	}
+	if _, err := put("three", key(second.Blocks[0].Location.Reference)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGetDataRange(t *testing.T) {
+	config, dir := setup()
+	s := dir.(*server)
+	user := config.UserName()
+	// Two and a half blocks.
+	data := make([]byte, upspin.BlockSize*5/2)
+	for i := range data {
+		data[i] = byte(i * 7)
+	}
+	plain := upspin.PathName(user + "/plain")
+	zipped := upspin.PathName(user + "/zipped")
+	if _, err := s.PutReader(plain, bytes.NewReader(data), upspin.PlainPack, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.PutReader(zipped, bytes.NewReader(data), upspin.PlainPack, &PutOptions{Compress: true}); err != nil {
+		t.Fatal(err)
+	}
+	n := int64(len(data))
+	bs := int64(upspin.BlockSize)
+	for _, name := range []upspin.PathName{plain, zipped} {
+		for _, test := range []struct {
+			offset, length int64
+			eof            bool
+		}{
+			{0, 10, false},
+			{bs - 5, 10, false},      // Across a block boundary.
+			{bs - 5, bs + 10, false}, // Across two boundaries.
+			{n - 10, 10, false},      // The very end.
+			{n - 10, 20, true},       // Past the end.
+			{n, 1, true},             // At the end.
+			{n + 1, 1, true},         // Beyond the end.
+			{100, 0, false},          // Empty.
+		} {
+			got, err := s.GetDataRange(name, test.offset, test.length)
+			if test.eof != (err == io.EOF) || (err != nil && err != io.EOF) {
+				t.Errorf("GetDataRange(%s, %d, %d): err = %v; want EOF %t", name, test.offset, test.length, err, test.eof)
+				continue
+			}
+			lo, hi := test.offset, test.offset+test.length
+			if lo > n {
+				lo = n
+			}
+			if hi > n {
+				hi = n
+			}
+			if !bytes.Equal(got, data[lo:hi]) {
+				t.Errorf("GetDataRange(%s, %d, %d) returned %d bytes; want %d", name, test.offset, test.length, len(got), hi-lo)
+			}
+		}
+	}
+	if _, err := s.GetDataRange(plain, -1, 1); !errors.Match(errors.E(errors.Invalid), err) {
+		t.Errorf("GetDataRange with negative offset: err = %v; want Invalid", err)
+	}
+	if _, err := s.GetDataRange(upspin.PathName(user+"/"), 0, 1); !errors.Match(errors.E(errors.IsDir), err) {
+		t.Errorf("GetDataRange of directory: err = %v; want IsDir", err)
+	}
+}
@@ -0,0 +1,118 @@
+// Copyright 2017 The Upspin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package inprocess
+
+import (
+	"sort"
+
+	"upspin.io/access"
+	"upspin.io/errors"
+	"upspin.io/path"
+	"upspin.io/upspin"
+)
+
+// DeleteGlob deletes the entries matching the pattern, as Glob finds
+// them, and returns the number deleted. As with Delete, a directory must
+// be empty, and a root cannot be deleted this way. The matches in each
+// directory are deleted together, so the directory and those above it
+// are rewritten once for all of them, and either all or none of them
+// go. If deleting the matches in a later directory fails, those in the
+// earlier directories, in name order, stay deleted, and the count
+// reports them. Nothing is deleted if the caller lacks delete rights
+// for any match or if a match is a non-empty directory. If the pattern
+// passes through a link, nothing is deleted and the error is
+// ErrFollowLink.
+func (s *server) DeleteGlob(pattern string) (int, error) {
+	const op = "dir/inprocess.DeleteGlob"
+	matches, err := s.Glob(pattern)
+	if err == upspin.ErrFollowLink {
+		return 0, err
+	}
+	if err != nil {
+		return 0, errors.E(op, err)
+	}
+
+	// Check every match before deleting any, grouping them by directory.
+	byDir := make(map[upspin.PathName][]path.Parsed)
+	for _, e := range matches {
+		parsed, err := s.parse(e.Name)
+		if err != nil {
+			return 0, errors.E(op, err)
+		}
+		if parsed.IsRoot() {
+			return 0, errors.E(op, e.Name, errors.Invalid, errors.Str("cannot delete a root"))
+		}
+		if e.IsDir() && !s.isEmptyDirectory(op, e) {
+			return 0, errors.E(op, e.Name, errors.NotEmpty)
+		}
+		canDelete, err := s.can(access.Delete, parsed)
+		if err != nil {
+			return 0, errors.E(op, err)
+		}
+		if !canDelete {
+			return 0, s.errPerm(op, parsed)
+		}
+		dir := parsed.Drop(1).Path()
+		byDir[dir] = append(byDir[dir], parsed)
+	}
+	dirs := make([]upspin.PathName, 0, len(byDir))
+	for dir := range byDir {
+		dirs = append(dirs, dir)
+	}
+	sort.Slice(dirs, func(i, j int) bool { return dirs[i] < dirs[j] })
+
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	if s.db.readOnly {
+		return 0, errors.E(op, upspin.PathName(pattern), errors.Permission, errReadOnly)
+	}
+	n := 0
+	for _, dir := range dirs {
+		deleted, err := s.deleteAll(op, byDir[dir])
+		if err != nil {
+			return n, err
+		}
+		n += deleted
+	}
+	return n, nil
+}
+
+// deleteAll deletes the named entries, which are all in one directory,
+// with a single rewrite of the directory. Entries that have gone since
+// they were matched are skipped. It returns the number deleted.
+// s.db.mu is held.
+func (s *server) deleteAll(op string, names []path.Parsed) (int, error) {
+	var entries []*upspin.DirEntry
+	for _, parsed := range names {
+		// The entry may have changed since we looked.
+		entry, err := s.lookupLocked(op, parsed, false)
+		if errors.Match(notExist, err) {
+			continue
+		}
+		if err != nil {
+			return 0, errors.E(op, err)
+		}
+		if entry.IsDir() && !s.isEmptyDirectory(op, entry) {
+			return 0, errors.E(op, entry.Name, errors.NotEmpty)
+		}
+		entries = append(entries, entry)
+	}
+	if len(entries) == 0 {
+		return 0, nil
+	}
+	if _, err := s.putAll(op, names[0].Drop(1), entries, true); err != nil {
+		return 0, err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			s.db.forgetDir(entry.Name)
+		}
+		s.db.eventMgr.newEvent <- upspin.Event{
+			Entry:  entry,
+			Delete: true,
+		}
+	}
+	return len(entries), nil
+}
@@ -0,0 +1,73 @@
+// Copyright 2017 The Upspin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package inprocess
+
+import (
+	"strings"
+	"testing"
+
+	"upspin.io/errors"
+	"upspin.io/upspin"
+)
+
+func TestDeleteGlob(t *testing.T) {
+	config, dir := globTree(t)
+	s := dir.(*server)
+	user := config.UserName()
+	for _, name := range []string{"/a/g1", "/a/g2", "/a/g3"} {
+		if _, err := dir.Put(storeData(t, config, []byte(name), upspin.PathName(user)+upspin.PathName(name))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tree := func() string {
+		return strings.Join(walkNames(t, s, user), " ")
+	}
+
+	// A non-empty directory among the matches stops the lot.
+	before := tree()
+	if n, err := s.DeleteGlob(string(user) + "/a/*"); n != 0 || !errors.Match(errors.E(errors.NotEmpty), err) {
+		t.Errorf("DeleteGlob with non-empty directory = %d, %v; want 0, NotEmpty", n, err)
+	}
+	// As does a link in the pattern.
+	if n, err := s.DeleteGlob(string(user) + "/*/f*"); n != 0 || err != upspin.ErrFollowLink {
+		t.Errorf("DeleteGlob through link = %d, %v; want 0, ErrFollowLink", n, err)
+	}
+	if got := tree(); got != before {
+		t.Fatalf("failed DeleteGlob changed the tree: %s; want %s", got, before)
+	}
+
+	// The three files in /a go with one rewrite of /a.
+	a, err := dir.Lookup(upspin.PathName(user + "/a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, err := s.DeleteGlob(string(user) + "/a/g*"); n != 3 || err != nil {
+		t.Fatalf("DeleteGlob = %d, %v; want 3, nil", n, err)
+	}
+	a2, err := dir.Lookup(upspin.PathName(user + "/a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a2.Sequence != a.Sequence+1 {
+		t.Errorf("/a sequence went from %d to %d; want one rewrite", a.Sequence, a2.Sequence)
+	}
+
+	// Matches in several directories, then the emptied directory.
+	if n, err := s.DeleteGlob(string(user) + "/[ac]/f*"); n != 2 || err != nil {
+		t.Fatalf("DeleteGlob = %d, %v; want 2, nil", n, err)
+	}
+	if n, err := s.DeleteGlob(string(user) + "/c/d/*"); n != 1 || err != nil {
+		t.Fatalf("DeleteGlob = %d, %v; want 1, nil", n, err)
+	}
+	if n, err := s.DeleteGlob(string(user) + "/c/?"); n != 1 || err != nil {
+		t.Fatalf("DeleteGlob of empty directory = %d, %v; want 1, nil", n, err)
+	}
+	if got, want := tree(), "/ /a /a/b /a/b/f3 /c /f1 /link"; got != want {
+		t.Errorf("tree = %s; want %s", got, want)
+	}
+	if n, err := s.DeleteGlob(string(user) + "/nothing*"); n != 0 || err != nil {
+		t.Errorf("DeleteGlob with no matches = %d, %v; want 0, nil", n, err)
+	}
+}
@@ -0,0 +1,129 @@
+// Copyright 2017 The Upspin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package inprocess
+
+import (
+	"sync"
+
+	"upspin.io/config"
+	"upspin.io/errors"
+	"upspin.io/upspin"
+)
+
+// When the deterministicKeys option is set, the server stores directories
+// so that the same tree always has the same references, which lets tests
+// compare trees, or follow how a sequence of puts changed one, by the
+// references of their directories. Ordinarily those differ each time a
+// directory is stored, even if nothing in it has changed, for four
+// reasons, each of which the option removes.
+//
+// The directory's data is encrypted, with a new key each time. With the
+// option it is stored with PlainPack, as cleartext, so the option is for
+// tests only.
+//
+// The directory's entry, which is stored in its parent, holds the time
+// it was stored. With the option it holds instead the latest time of the
+// entries in the directory, or zero if it is empty.
+//
+// The signature in that entry is made afresh, and signatures are random.
+// With the option a signature, once made, is reused for the same signed
+// data by the same key, by any server in the process.
+//
+// An entry put with SeqIgnore, and a directory made by the server, is
+// given a random sequence number. With the option it is given SeqBase.
+//
+// The references are then fixed only if the store makes them from the
+// data alone, as store/inprocess does. Dial fails if the option is set
+// and the store does not.
+
+// errNotContentAddressed reports that the deterministicKeys option is
+// set but the store does not make references from the data.
+var errNotContentAddressed = errors.Str("deterministicKeys requires a content-addressed store")
+
+// stableSigs holds the signatures made by stableSigner, keyed by the
+// public key and the hash signed.
+var stableSigs struct {
+	mu   sync.Mutex
+	sigs map[string]upspin.Signature
+}
+
+// stableSigner is a Factotum whose FileSign returns the same signature
+// each time it is asked to sign the same hash.
+type stableSigner struct {
+	upspin.Factotum
+}
+
+// FileSign implements upspin.Factotum.
+func (f stableSigner) FileSign(hash upspin.DEHash) (upspin.Signature, error) {
+	key := string(f.PublicKey()) + "\x00" + string(hash)
+	stableSigs.mu.Lock()
+	defer stableSigs.mu.Unlock()
+	if sig, ok := stableSigs.sigs[key]; ok {
+		return sig, nil
+	}
+	sig, err := f.Factotum.FileSign(hash)
+	if err != nil {
+		return sig, err
+	}
+	if stableSigs.sigs == nil {
+		stableSigs.sigs = make(map[string]upspin.Signature)
+	}
+	stableSigs.sigs[key] = sig
+	return sig, nil
+}
+
+// newSequence returns the sequence number for a new entry.
+func (s *server) newSequence() int64 {
+	if s.db.deterministicKeys {
+		return upspin.SeqBase
+	}
+	return upspin.NewSequence()
+}
+
+// dirPackingFor returns the config, packing and time with which to store
+// a directory holding the cleartext.
+func (s *server) dirPackingFor(cleartext []byte) (upspin.Config, upspin.Packing, upspin.Time, error) {
+	if !s.db.deterministicKeys {
+		return s.db.dirConfig, dirPacking, s.db.now(), nil
+	}
+	contents, err := parseDir(cleartext)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	var latest upspin.Time
+	for i := 0; i < contents.len(); i++ {
+		entry, err := contents.entry(i)
+		if err != nil {
+			return nil, 0, 0, err
+		}
+		if entry.Time > latest {
+			latest = entry.Time
+		}
+	}
+	cfg := config.SetFactotum(s.db.dirConfig, stableSigner{s.db.dirConfig.Factotum()})
+	return cfg, upspin.PlainPack, latest, nil
+}
+
+// checkContentAddressed returns an error if the store holding directories
+// does not return the same reference each time it stores the same data.
+func (s *server) checkContentAddressed() error {
+	store, err := s.storeServer(s.db.dirConfig.StoreEndpoint())
+	if err != nil {
+		return err
+	}
+	probe := []byte("dir/inprocess: deterministicKeys probe")
+	var refs [2]upspin.Reference
+	for i := range refs {
+		refdata, err := store.Put(probe)
+		if err != nil {
+			return err
+		}
+		refs[i] = refdata.Reference
+	}
+	if refs[0] != refs[1] {
+		return errors.E(errors.Invalid, errNotContentAddressed)
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+// Copyright 2017 The Upspin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package inprocess
+
+import (
+	"fmt"
+	"testing"
+
+	"upspin.io/errors"
+	"upspin.io/upspin"
+)
+
+// counterStore is a StoreServer held in memory that makes each reference
+// from a counter rather than from the data.
+type counterStore struct {
+	mockStore
+}
+
+func (c *counterStore) Put(data []byte) (*upspin.Refdata, error) {
+	c.puts++
+	ref := upspin.Reference(fmt.Sprint(c.puts))
+	c.data[ref] = data
+	return &upspin.Refdata{Reference: ref}, nil
+}
+
+func TestDeterministicKeys(t *testing.T) {
+	config, _, _, _ := newConfigAndServices(nextUser())
+	user := config.UserName()
+	root := upspin.PathName(user + "/")
+	var files []*upspin.DirEntry
+	for _, name := range []upspin.PathName{root + "a/one", root + "a/b/two", root + "three"} {
+		files = append(files, storeData(t, config, []byte(name), name))
+	}
+	// build makes the same tree in a new server and returns the
+	// references of its directories.
+	build := func(options ...string) []upspin.Reference {
+		s := New(config, options...).(*server)
+		for _, dir := range []upspin.PathName{root, root + "a", root + "a/b"} {
+			if _, err := makeDirectory(s, dir); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for _, file := range files {
+			if _, err := s.Put(file.Copy()); err != nil {
+				t.Fatal(err)
+			}
+		}
+		var refs []upspin.Reference
+		for _, dir := range []upspin.PathName{root, root + "a", root + "a/b"} {
+			entry, err := s.Lookup(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			refs = append(refs, entry.Blocks[0].Location.Reference)
+		}
+		return refs
+	}
+
+	a, b := build("deterministicKeys=true"), build("deterministicKeys=true")
+	for i := range a {
+		if a[i] != b[i] {
+			t.Errorf("directory %d: references %q and %q differ", i, a[i], b[i])
+		}
+	}
+	a, b = build(), build()
+	for i := range a {
+		if a[i] == b[i] {
+			t.Errorf("directory %d: reference %q is the same without the option", i, a[i])
+		}
+	}
+}
+
+func TestDeterministicKeysStore(t *testing.T) {
+	config, _, _, _ := newConfigAndServices(nextUser())
+	s := New(config, "deterministicKeys=true").(*server)
+	if _, err := s.Dial(config, s.Endpoint()); err != nil {
+		t.Fatalf("Dial with content-addressed store: %v", err)
+	}
+	store := &counterStore{mockStore{data: make(map[upspin.Reference][]byte)}}
+	s.SetStore(store, upspin.Endpoint{Transport: 99, NetAddr: "counter"})
+	if _, err := s.Dial(config, s.Endpoint()); !errors.Match(errors.E(errors.Invalid), err) {
+		t.Errorf("Dial with counter store: err = %v; want Invalid", err)
+	}
+}
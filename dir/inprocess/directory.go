@@ -6,14 +6,17 @@
 package inprocess // import "upspin.io/dir/inprocess"
 
 // The implementation uses a Merkle tree to represent the directory tree.
-// For simplicity, a directory's data is always stored as a single block.
+// A directory's data is stored as a single block unless the dirBlockSize
+// option splits it; see blocksize.go.
 // (The files it stores may have any number of blocks.)
 // Even empty directories contain a single zero-sized block.
 // For the purposes of the Merkle tree, the reference is stored in entry.Blocks[0].Location.
 
 import (
-	"strings"
+	"io"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"upspin.io/access"
 	"upspin.io/bind"
@@ -22,24 +25,53 @@ import (
 	"upspin.io/log"
 	"upspin.io/pack"
 	"upspin.io/path"
-	"upspin.io/serverutil"
 	"upspin.io/upspin"
 	"upspin.io/valid"
 
 	_ "upspin.io/pack/ee"
 )
 
-func New(config upspin.Config) upspin.DirServer {
-	return &server{
+// New returns a new, empty DirServer that stores its directory data
+// using the store server in config. The options, each of the form
+// "key=value", are described in options.go. New panics if an option
+// is invalid.
+//
+// Each call to New returns an independent server, sharing no users,
+// trees or settings with any other, so tests running in parallel can
+// each make their own. The package registers nothing with bind. Since
+// bind holds one DirServer per transport, a test that needs isolation
+// should use the server New returns, or one obtained from its Dial
+// method, rather than the one found through bind.
+func New(config upspin.Config, options ...string) upspin.DirServer {
+	const op = "dir/inprocess.New"
+	s := &server{
 		config: config,
 		db: &database{
-			dirConfig:  config,
-			root:       make(map[upspin.UserName]*upspin.DirEntry),
-			rootAccess: make(map[upspin.UserName]*access.Access),
-			access:     make(map[upspin.PathName]*access.Access),
-			eventMgr:   newEventManager(),
+			dirConfig:      config,
+			root:           make(map[upspin.UserName]*upspin.DirEntry),
+			rootAccess:     make(map[upspin.UserName]*access.Access),
+			access:         make(map[upspin.PathName]*access.Access),
+			refs:           make(map[upspin.Reference]int),
+			compressed:     make(map[upspin.Reference]bool),
+			checksums:      make(map[upspin.Reference]checksum),
+			expire:         make(map[upspin.PathName]expiry),
+			locked:         make(map[upspin.PathName]bool),
+			defaultPacking: make(map[upspin.PathName]upspin.Packing),
+			sticky:         make(map[upspin.PathName]bool),
+			aliases:        make(map[upspin.PathName]upspin.PathName),
+			history:        make(map[upspin.PathName][]*upspin.DirEntry),
+			contentType:    make(map[upspin.PathName]string),
+			userBytes:      make(map[upspin.UserName]int64),
+			eventMgr:       newEventManager(),
+			now:            upspin.Now,
 		},
 	}
+	for _, opt := range options {
+		if err := s.db.setOption(opt); err != nil {
+			panic(errors.E(op, err))
+		}
+	}
+	return s
 }
 
 // Used to store directory entries.
@@ -55,6 +87,60 @@ type server struct {
 	// config holds the config that created the call.
 	config upspin.Config
 	db     *database
+
+	// snapshot, if non-nil, is a root entry from which lookups in
+	// that user's tree start, in place of the current root. See view.go.
+	snapshot *upspin.DirEntry
+
+	// dirCache, if non-nil, holds the data read by readAll, keyed by
+	// reference. It is used only by copies of the server made for the
+	// duration of a single call; see LookupMany.
+	dirCache map[upspin.Reference][]byte
+
+	// ifMatch, if non-nil, is the key the entry being replaced must
+	// have. It is set only in copies of the server made for a single
+	// call; see PutOptions.IfMatchKey.
+	ifMatch *upspin.Reference
+
+	// lock and unlock specify that the entry being put be locked, or
+	// may replace a locked entry and is not itself locked unless lock
+	// is also set. They are set only in copies of the server made for
+	// a single call; see PutOptions.Lock and lock.go.
+	lock, unlock bool
+
+	// makeSticky specifies that the directory being made be sticky.
+	// It is set only in copies of the server made for a single call;
+	// see PutOptions.Sticky and sticky.go.
+	makeSticky bool
+
+	// compressed specifies that the data of the file being put was
+	// compressed by PutReader, which is recorded when the entry is
+	// installed. It is set only in copies of the server made for a
+	// single call; see PutOptions.Compress.
+	compressed bool
+
+	// checksum, if non-nil, is the checksum of the cleartext of the file
+	// being put, which is recorded when the entry is installed. It is
+	// set only in copies of the server made for a single call; see
+	// PutReader and checksum.go.
+	checksum *checksum
+
+	// expireAt, if non-zero, is the time after which the entry being put
+	// expires. It is set only in copies of the server made for a single
+	// call; see PutOptions.ExpireAt and expire.go.
+	expireAt upspin.Time
+
+	// replaceLink specifies that the entry being put may replace a link
+	// of the same name rather than follow it. It is set only in copies
+	// of the server made for a single call that updates an entry in
+	// place; see Swap and UpdateMeta.
+	replaceLink bool
+
+	// reaped holds the entries reaped by the put in progress, whose
+	// expiry records and references are dropped once the put has
+	// installed the new root. It is set only in copies of the server
+	// made for a single put; see preparePut and expire.go.
+	reaped []*upspin.DirEntry
 }
 
 var _ upspin.DirServer = (*server)(nil)
@@ -62,12 +148,19 @@ var _ upspin.DirServer = (*server)(nil)
 // database represents the shared state of the directory forest.
 type database struct {
 	dirConfig upspin.Config // For accessing store holding directory entries.
-	eventMgr  *eventManager // Handles Watch events.
+
+	// store, if non-nil, is the store server at the store endpoint
+	// of dirConfig, used in place of the one bind provides. It is set
+	// by SetStore.
+	store upspin.StoreServer
+
+	eventMgr *eventManager // Handles Watch events.
 
 	// mu is used to serialize access to the maps.
-	// It's also used to serialize all access to the store through the
+	// It's also used to serialize most access to the store through the
 	// exported API, for simple but slow safety. At least it's an RWMutex
-	// so it's not _too_ bad.
+	// so it's not _too_ bad, and Put stores its directories holding it
+	// only for reading; see putOne.
 	mu sync.RWMutex
 
 	// root stores the directory entry for each user's root.
@@ -80,6 +173,171 @@ type database struct {
 	// access stores the parsed contents of any Access file stored
 	// in this directory. Inherited rights are computed from this map.
 	access map[upspin.PathName]*access.Access
+
+	// refs counts the names that refer to each block of file data,
+	// so a block shared by hard links is live until its last name is deleted.
+	refs map[upspin.Reference]int
+
+	// compressed records the first block of each file whose data
+	// was compressed by PutReader before packing.
+	compressed map[upspin.Reference]bool
+
+	// checksums records the checksum of the cleartext of each file
+	// stored by PutReader, keyed by its first block. See checksum.go.
+	checksums map[upspin.Reference]checksum
+
+	// foldLocal specifies that the local part of user names, not just
+	// the domain, is lower-cased when normalizing. See normalize.go.
+	foldLocal bool
+
+	// caseInsensitive specifies that a directory may not hold two
+	// entries whose names differ only in case.
+	caseInsensitive bool
+
+	// idempotentMkdir specifies that MakeDirectory succeeds, returning
+	// the existing entry, if the directory already exists.
+	idempotentMkdir bool
+
+	// readOnly specifies that the server rejects all modifications.
+	// It is set by SetReadOnly.
+	readOnly bool
+
+	// maxEntries, if positive, is the most entries a directory may hold.
+	maxEntries int
+
+	// maxTotalBytes, if positive, is the most data, counted as by
+	// totalBytes, the trees may hold. See space.go.
+	maxTotalBytes int
+
+	// totalBytes is the total size of the files in all the trees,
+	// counting each name separately. It is kept by ref and unref.
+	totalBytes int64
+
+	// maxUserBytes, if positive, is the most data, counted as by
+	// userBytes, each user's tree may hold. See space.go.
+	maxUserBytes int
+
+	// userBytes holds the part of totalBytes in each user's tree.
+	// It is kept by ref and unref.
+	userBytes map[upspin.UserName]int64
+
+	// validateSize specifies that PutReader check the length of the
+	// data against PutOptions.Size.
+	validateSize bool
+
+	// indirectSize, if positive, is the size above which a directory's
+	// data is stored indirectly. See indirect.go.
+	indirectSize int
+
+	// deterministicKeys specifies that the same tree always be stored
+	// under the same references. See deterministic.go.
+	deterministicKeys bool
+
+	// dirBlockSize, if positive, is the largest block in which a
+	// directory's data is stored. See blocksize.go.
+	dirBlockSize int
+
+	// expire holds the expiration times of entries, keyed by name.
+	// See expire.go.
+	expire map[upspin.PathName]expiry
+
+	// locked holds the names of the locked entries. See lock.go.
+	locked map[upspin.PathName]bool
+
+	// defaultPacking holds the default packings set on directories,
+	// keyed by directory name. See packing.go.
+	defaultPacking map[upspin.PathName]upspin.Packing
+
+	// sticky holds the names of the sticky directories. See sticky.go.
+	sticky map[upspin.PathName]bool
+
+	// aliases holds the targets of the aliases, keyed by alias name.
+	// See alias.go.
+	aliases map[upspin.PathName]upspin.PathName
+
+	// historySize, if positive, is the most replaced entries kept for
+	// each file in history, keyed by name. See history.go.
+	historySize int
+	history     map[upspin.PathName][]*upspin.DirEntry
+
+	// contentType holds the content types set by SetContentType,
+	// keyed by file name. See contenttype.go.
+	contentType map[upspin.PathName]string
+
+	// failAfter, if positive, is one more than the number of
+	// directories that may be stored before the next store fails.
+	// It is guarded by faultMu rather than mu, as directories are
+	// stored with and without mu held. See FailAfter.
+	faultMu   sync.Mutex
+	failAfter int
+
+	// trackAccess specifies that accessStats be kept.
+	trackAccess bool
+
+	// accessStats holds the number and time of Lookups of each name.
+	// It has its own lock. See stats.go.
+	accessStats accessStats
+
+	// cacheLookups specifies that lookupCache be kept.
+	cacheLookups bool
+
+	// lookupCache holds the entries found by lookups.
+	// It has its own lock. See lookupcache.go.
+	lookupCache lookupCache
+
+	// idempotency holds the results of recent PutReader calls that
+	// carried an IdempotencyKey. See idempotency.go.
+	idempotency idempotencyCache
+
+	// scanner is the background consistency checker. See scan.go.
+	scanner scanner
+
+	// tracer holds the Tracer set by SetTracer, if any. See trace.go.
+	tracer atomic.Value
+
+	// counterMu serializes calls to WithStoreCounter. See trace.go.
+	counterMu sync.Mutex
+
+	// canonicalize holds the CanonicalizeFunc set by
+	// SetCanonicalizeFunc, if any. See normalize.go.
+	canonicalize atomic.Value
+
+	// retry holds the RetryPolicy set by SetRetryPolicy, if any.
+	// See retry.go.
+	retry atomic.Value
+
+	// mutationLog, if non-nil, receives a record of each change to a root.
+	// It is set by SetMutationLog. See wal.go.
+	mutationLog io.Writer
+
+	// transports holds the transports other than InProcess that Dial
+	// accepts. See transport.go.
+	transports map[upspin.Transport]bool
+
+	// subscribers holds the channels created by Subscribe, keyed by
+	// subscription ID. See subscribe.go.
+	subscribers    map[int]*subscriber
+	nextSubscriber int
+
+	// unixHidden specifies that glob patterns match names beginning
+	// with a period only explicitly, as in the shell.
+	unixHidden bool
+
+	// reportNotDir specifies that a lookup through a file, rather than
+	// a directory, fail with ErrNotDirectory instead of NotExist.
+	reportNotDir bool
+
+	// readTimeout, if positive, bounds the wait for the read lock in
+	// lookup and glob. See rlock.go.
+	readTimeout time.Duration
+
+	// globMatch, if non-nil, replaces path.Match when matching
+	// glob pattern elements. It is set by SetGlobMatchFunc.
+	globMatch GlobMatchFunc
+
+	// now returns the time now. It's usually just upspin.Now but is
+	// overridden for tests.
+	now func() upspin.Time
 }
 
 var _ upspin.DirServer = (*server)(nil)
@@ -87,11 +345,23 @@ var _ upspin.DirServer = (*server)(nil)
 // newDirEntry returns a new DirEntry holding the provided directory data (cleartext).
 // This is the general form of the method that follows, used in the tests.
 func newDirEntry(config upspin.Config, packing upspin.Packing, name upspin.PathName, cleartext []byte, attr upspin.Attribute, link upspin.PathName, seq int64) (*upspin.DirEntry, error) {
+	return newDirEntryAt(config, packing, name, cleartext, attr, link, seq, upspin.Now())
+}
+
+// newDirEntryAt is newDirEntry with an explicit time for the entry.
+func newDirEntryAt(config upspin.Config, packing upspin.Packing, name upspin.PathName, cleartext []byte, attr upspin.Attribute, link upspin.PathName, seq int64, time upspin.Time) (*upspin.DirEntry, error) {
+	return newDirEntryIn(config, nil, packing, name, cleartext, attr, link, seq, time, 0)
+}
+
+// newDirEntryIn is newDirEntryAt that stores the data in the given store,
+// which is bound from the config if nil, in blocks of at most blockSize
+// bytes, or in one block if blockSize is not positive.
+func newDirEntryIn(config upspin.Config, store upspin.StoreServer, packing upspin.Packing, name upspin.PathName, cleartext []byte, attr upspin.Attribute, link upspin.PathName, seq int64, time upspin.Time, blockSize int) (*upspin.DirEntry, error) {
 	entry := &upspin.DirEntry{
 		Name:       name,
 		SignedName: name, // TODO: snapshots.
 		Packing:    packing,
-		Time:       upspin.Now(),
+		Time:       time,
 		Attr:       attr,
 		Link:       link,
 		Sequence:   seq,
@@ -112,24 +382,28 @@ func newDirEntry(config upspin.Config, packing upspin.Packing, name upspin.PathN
 	if err != nil {
 		return nil, err
 	}
-	ciphertext, err := bp.Pack(cleartext)
-	if err != nil {
-		return nil, err
-	}
-	store, err := bind.StoreServer(config, config.StoreEndpoint())
-	if err != nil {
-		return nil, err
+	if store == nil {
+		store, err = bind.StoreServer(config, config.StoreEndpoint())
+		if err != nil {
+			return nil, err
+		}
 	}
-	refdata, err := store.Put(ciphertext)
-	if err != nil {
-		return nil, err
+	for _, chunk := range splitBlocks(cleartext, blockSize) {
+		ciphertext, err := bp.Pack(chunk)
+		if err != nil {
+			return nil, err
+		}
+		refdata, err := store.Put(ciphertext)
+		if err != nil {
+			return nil, err
+		}
+		bp.SetLocation(
+			upspin.Location{
+				Endpoint:  config.StoreEndpoint(),
+				Reference: refdata.Reference,
+			},
+		)
 	}
-	bp.SetLocation(
-		upspin.Location{
-			Endpoint:  config.StoreEndpoint(),
-			Reference: refdata.Reference,
-		},
-	)
 	if err := bp.Close(); err != nil {
 		return nil, err
 	}
@@ -138,8 +412,34 @@ func newDirEntry(config upspin.Config, packing upspin.Packing, name upspin.PathN
 
 // newDirEntry returns a new DirEntry holding the provided directory data (cleartext).
 // It is called for directories only.
+// Large directories may be split across several blocks; see blocksize.go.
+// They may also be stored indirectly; see indirect.go.
 func (s *server) newDirEntry(name upspin.PathName, cleartext []byte, seq int64) (*upspin.DirEntry, error) {
-	return newDirEntry(s.db.dirConfig, dirPacking, name, cleartext, upspin.AttrDirectory, "", seq)
+	if span := s.startSpan("Store.Put", name); span != nil {
+		defer span.End()
+	}
+	if s.db.injectFailure() {
+		return nil, errors.E(name, errors.IO, errInjected)
+	}
+	cfg, packing, when, err := s.dirPackingFor(cleartext)
+	if err != nil {
+		return nil, errors.E(name, err)
+	}
+	var entry *upspin.DirEntry
+	err = s.retry(func() error {
+		var err error
+		entry, err = newDirEntryIn(cfg, s.db.store, packing, name, cleartext, upspin.AttrDirectory, "", seq, when, s.db.dirBlockSize)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if s.db.indirectSize > 0 && len(cleartext) > s.db.indirectSize {
+		if err := s.indirect(entry); err != nil {
+			return nil, err
+		}
+	}
+	return entry, nil
 }
 
 // dirBlock constructs an upspin.DirBlock with the appropriate fields.
@@ -166,10 +466,13 @@ func (s *server) makeRoot(parsed path.Parsed) (*upspin.DirEntry, error) {
 	}
 	// We will have a zero-sized block here, which is odd but necessary to have
 	// a place to store the directory's Reference.
-	entry, err := s.newDirEntry(upspin.PathName(parsed.User()+"/"), nil, upspin.NewSequence())
+	entry, err := s.newDirEntry(upspin.PathName(parsed.User()+"/"), nil, s.newSequence())
 	if err != nil {
 		return nil, err
 	}
+	if err := s.recordMutation("makeRoot", entry.Name, nil, entry); err != nil {
+		return nil, err
+	}
 	s.db.root[parsed.User()] = entry
 	return entry, nil
 }
@@ -177,20 +480,97 @@ func (s *server) makeRoot(parsed path.Parsed) (*upspin.DirEntry, error) {
 // Put implements upspin.DirServer.Put.
 func (s *server) Put(entry *upspin.DirEntry) (*upspin.DirEntry, error) {
 	const op = "dir/inprocess.Put"
+	e, err := s.putEntry(op, entry)
+	if err != nil {
+		return e, err
+	}
+	// Successful Put returns no entry.
+	return nil, nil
+}
+
+// PutEntry is like Put but on success returns the entry as installed,
+// which holds the sequence number the Put assigned, so that a client
+// can make its next Put of the name conditional on it without a
+// Lookup. If the error is ErrFollowLink, the returned entry is the link.
+func (s *server) PutEntry(entry *upspin.DirEntry) (*upspin.DirEntry, error) {
+	const op = "dir/inprocess.Put"
+	e, err := s.putEntry(op, entry)
+	if err != nil {
+		return e, err
+	}
+	return e.Copy(), nil
+}
+
+// MakeDirectory creates a directory with the given name.
+// Like Put, it returns no entry unless the error is ErrFollowLink.
+func (s *server) MakeDirectory(name upspin.PathName) (*upspin.DirEntry, error) {
+	e, err := s.MakeDirectoryEntry(name)
+	if err != nil {
+		return e, err
+	}
+	return nil, nil
+}
+
+// MakeDirectoryEntry creates a directory with the given name and
+// returns the entry installed for it, saving a subsequent Lookup.
+// If the error is ErrFollowLink, the returned entry is the link.
+func (s *server) MakeDirectoryEntry(name upspin.PathName) (*upspin.DirEntry, error) {
+	return s.MakeDirectoryOptions(name, nil)
+}
+
+// MakeDirectoryOptions is MakeDirectoryEntry with options. Of the
+// PutOptions, only Sticky applies. A nil opts is equivalent to a zero
+// PutOptions.
+func (s *server) MakeDirectoryOptions(name upspin.PathName, opts *PutOptions) (*upspin.DirEntry, error) {
+	const op = "dir/inprocess.MakeDirectory"
+	if opts != nil && opts.Sticky {
+		parsed, err := s.parse(name)
+		if err != nil {
+			return nil, errors.E(op, err)
+		}
+		if normalizeUser(s.config.UserName(), s.db.foldLocal) != parsed.User() {
+			return nil, errors.E(op, name, errors.Permission, errors.Str("only the owner may make a sticky directory"))
+		}
+		c := *s // Make a copy.
+		c.makeSticky = true
+		s = &c
+	}
+	entry := &upspin.DirEntry{
+		Name:       name,
+		SignedName: name,
+		Attr:       upspin.AttrDirectory,
+	}
+	e, err := s.putEntry(op, entry)
+	if err != nil && s.db.idempotentMkdir && errors.Match(errExist, err) {
+		existing, lookupErr := s.Lookup(name)
+		if lookupErr == nil && existing.IsDir() {
+			return existing, nil
+		}
+	}
+	return e, err
+}
+
+// putEntry is the implementation of Put and MakeDirectory. On success it
+// returns the entry as installed in the tree. Otherwise it returns a nil
+// entry unless the error is ErrFollowLink.
+func (s *server) putEntry(op string, entry *upspin.DirEntry) (*upspin.DirEntry, error) {
 	if err := valid.DirEntry(entry); err != nil {
 		return nil, errors.E(op, err)
 	}
-	parsed, err := path.Parse(entry.Name)
+	parsed, err := s.parse(entry.Name)
 	if err != nil {
 		return nil, errors.E(op, err) // Can't happen but be sure.
 	}
+	if parsed.Path() != entry.Name {
+		// The user name was normalized.
+		entry = entry.Copy()
+		entry.Name = parsed.Path()
+	}
 	e, err := s.canPut(op, parsed, entry.IsDir())
 	if err != nil {
 		return s.errLink(op, e, err)
 	}
 
-	s.db.mu.Lock()
-	defer s.db.mu.Unlock()
 	isAccess := access.IsAccessFile(entry.Name)
 	isGroup := access.IsGroupFile(entry.Name)
 	if isAccess || isGroup {
@@ -208,26 +588,92 @@ func (s *server) Put(entry *upspin.DirEntry) (*upspin.DirEntry, error) {
 
 	if entry.IsDir() && parsed.IsRoot() {
 		// Making a root.
+		s.db.mu.Lock()
+		defer s.db.mu.Unlock()
+		if s.db.readOnly {
+			return nil, errors.E(op, entry.Name, errors.Permission, errReadOnly)
+		}
 		entry, err = s.makeRoot(parsed)
-	} else if !entry.IsDir() {
-		// Making a new regular entry.
-		entry, err = s.put(op, entry, parsed, false)
-	} else {
-		// Making a new directory.
+		if err != nil {
+			return nil, err
+		}
+		if s.makeSticky {
+			s.db.sticky[entry.Name] = true
+		}
+		s.db.eventMgr.newEvent <- upspin.Event{
+			Entry: entry,
+		}
+		return entry, nil
+	}
+	if entry.IsDir() {
+		// Making a new directory. Its data does not depend on the
+		// tree, so it can be stored now.
 		entry, err = s.newDirEntry(entry.Name, []byte(""), entry.Sequence)
 		if err != nil {
 			return nil, err
 		}
-		entry, err = s.put(op, entry, parsed, false)
 	}
-	if err != nil {
-		return nil, err
+	return s.putOne(op, parsed, entry)
+}
+
+// putOne installs the entry, which is not a root. The new tree is
+// prepared with s.db.mu held only for reading, so that lookups may
+// proceed while the directories are stored, and the write lock is taken
+// just to install the new root. If the root has changed in the meantime
+// the new tree is prepared again; the directories stored for the first
+// attempt are left unreferenced.
+func (s *server) putOne(op string, parsed path.Parsed, entry *upspin.DirEntry) (*upspin.DirEntry, error) {
+	dir := parsed.Drop(1)
+	seq := entry.Sequence
+	for {
+		// Installing the entry sets its sequence number, so each
+		// attempt starts from the one given.
+		entry.Sequence = seq
+		link, p, err := s.preparePutEntry(op, parsed, entry)
+		if err != nil {
+			return link, err
+		}
+
+		s.db.mu.Lock()
+		if s.db.readOnly {
+			s.db.mu.Unlock()
+			return nil, errors.E(op, entry.Name, errors.Permission, errReadOnly)
+		}
+		if s.db.root[parsed.User()] != p.base {
+			// Another change came first.
+			s.db.mu.Unlock()
+			continue
+		}
+		err = s.commitPut(op, dir, []*upspin.DirEntry{entry}, false, p)
+		if err == nil {
+			s.db.eventMgr.newEvent <- upspin.Event{
+				Entry: entry,
+			}
+		}
+		s.db.mu.Unlock()
+		if err != nil {
+			return nil, err
+		}
+		return entry, nil
 	}
-	s.db.eventMgr.newEvent <- upspin.Event{
-		Entry: entry,
+}
+
+// preparePutEntry is preparePut for putOne, with s.db.mu held for reading.
+func (s *server) preparePutEntry(op string, parsed path.Parsed, entry *upspin.DirEntry) (*upspin.DirEntry, *pendingPut, error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+	if s.db.readOnly {
+		return nil, nil, errors.E(op, entry.Name, errors.Permission, errReadOnly)
 	}
-	// Successful Put returns no entry.
-	return nil, nil
+	if entry.IsDir() {
+		// canPut checked for an existing directory before we held the lock,
+		// so check again in case another Put created it in the meantime.
+		existing, err := s.lookupLocked(op, parsed, true)
+		if err == nil && existing.IsDir() {
+			return nil, nil, errors.E(op, entry.Name, errors.Exist)
+		}
+	}
+	return s.preparePut(op, parsed.Drop(1), []*upspin.DirEntry{entry}, false)
 }
 
 // canPut verifies that the name is permitted to be written.
@@ -249,7 +695,7 @@ func (s *server) canPut(op string, parsed path.Parsed, makeDirectory bool) (*ups
 			return parent, err // Probably ErrFollowLink or NotExist.
 		}
 		if !parent.IsDir() {
-			return nil, errors.E(op, parent.Name, errors.NotDir)
+			return nil, errors.E(op, parent.Name, errors.NotDir, ErrNotDirectory)
 		}
 	}
 	// The child might exist.
@@ -287,90 +733,255 @@ func (s *server) canPut(op string, parsed path.Parsed, makeDirectory bool) (*ups
 // put is the underlying implementation of Put, including making links and directories..
 // If deleting, we expect the entry to already be present and skip it on the rewrite.
 func (s *server) put(op string, entry *upspin.DirEntry, parsed path.Parsed, deleting bool) (*upspin.DirEntry, error) {
-	pathName := parsed.Path()
 	if parsed.IsRoot() {
 		// Should not be here.
-		return nil, errors.E(op, pathName, errors.Internal, errors.Str("cannot create root with s.put"))
+		return nil, errors.E(op, parsed.Path(), errors.Internal, errors.Str("cannot create root with s.put"))
 	}
-	rootEntry, ok := s.db.root[parsed.User()]
-	if !ok {
-		// Cannot create user root with Put.
-		return nil, errors.E(op, upspin.PathName(parsed.User()), errors.Str("no such user root"))
+	link, err := s.putAll(op, parsed.Drop(1), []*upspin.DirEntry{entry}, deleting)
+	if err != nil {
+		return link, err
 	}
-	// Iterate along the path up to but not past the last element.
+	return entry, nil
+}
+
+// putAll is put for several entries in the directory dir, which is
+// rewritten once with all of them, as is each directory above it.
+// If the path to an entry holds a link, putAll returns the link and
+// ErrFollowLink. s.db.mu is held.
+func (s *server) putAll(op string, dir path.Parsed, newEntries []*upspin.DirEntry, deleting bool) (*upspin.DirEntry, error) {
+	link, p, err := s.preparePut(op, dir, newEntries, deleting)
+	if err != nil {
+		return link, err
+	}
+	return nil, s.commitPut(op, dir, newEntries, deleting, p)
+}
+
+// pendingPut is a change to a user's tree, prepared by preparePut, whose
+// directories are stored but whose new root is not yet installed.
+type pendingPut struct {
+	base        *upspin.DirEntry   // The root from which the change was made.
+	root        *upspin.DirEntry   // The new root.
+	prevs       []*upspin.DirEntry // The entries replaced or deleted.
+	accessFiles []*access.Access   // The new Access files, parsed.
+	reaped      []*upspin.DirEntry // The expired entries reaped.
+}
+
+// preparePut does the work of putAll up to the installation of the new
+// root: it stores the changed directories and reads any new Access files.
+// It changes nothing but the store, so s.db.mu need only be held for
+// reading, and if the change is not committed the tree is as it was.
+func (s *server) preparePut(op string, dir path.Parsed, newEntries []*upspin.DirEntry, deleting bool) (*upspin.DirEntry, *pendingPut, error) {
+	c := *s // Make a copy to collect the reaped entries.
+	c.reaped = nil
 	// We remember the entries as we descend for fast(er) overwrite of the Merkle tree.
-	// Invariant: dirRef refers to a directory.
-	entries := make([]*upspin.DirEntry, 0, 10) // 0th entry is the root.
-	entries = append(entries, rootEntry)
-	for i := 0; i < parsed.NElem()-1; i++ {
-		e, err := s.fetchEntry(op, rootEntry, parsed.Elem(i))
-		if err != nil {
-			return nil, err
-		}
-		if e.IsLink() {
-			return e, upspin.ErrFollowLink
-		}
-		if !e.IsDir() {
-			return nil, errors.E(op, parsed.First(i+1).Path(), errors.NotDir)
-		}
-		entries = append(entries, e)
-		rootEntry = e
+	link, entries, err := c.pathEntries(op, dir)
+	if err != nil {
+		return link, nil, err
 	}
-	rootEntry, dirBlob, err := s.installEntry(op, path.DropPath(pathName, 1), rootEntry, entry, deleting, false)
+	rootEntry, _, prevs, err := c.installEntries(op, dir.Path(), entries[len(entries)-1], newEntries, deleting, false)
 	if err != nil {
-		return nil, err
+		return rootEntry, nil, err
 	}
-	// Rewrite the tree up to the root.
-	// Invariant: dirRef identifies the directory that has just been updated,
-	// and its payload is in dirBlob.
-	// i indicates the directory that needs to be updated to store the new dirRef.
-	for i := len(entries) - 2; i >= 0; i-- {
-		// Install into the ith directory the (i+1)th entry.
-		rootEntry, err = s.newDirEntry(entries[i+1].Name, dirBlob, entries[i+1].Sequence)
+	rootEntry, err = c.rewritePath(op, dir, entries, rootEntry)
+	if err != nil {
+		return nil, nil, err
+	}
+	// Read any new Access files now, so that a bad one also leaves the
+	// tree as it was.
+	accessFiles := make([]*access.Access, len(newEntries))
+	for i, entry := range newEntries {
+		if access.IsGroupFile(entry.Name) && entry.IsLink() {
+			return nil, nil, errors.E(op, errors.Internal, entry.Name, "Group file cannot be a link")
+		}
+		if !access.IsAccessFile(entry.Name) {
+			continue
+		}
+		if entry.IsLink() {
+			return nil, nil, errors.E(op, errors.Internal, entry.Name, "Access file cannot be a link")
+		}
+		if deleting {
+			continue
+		}
+		data, err := s.readAll(entry)
 		if err != nil {
-			return nil, err
+			return nil, nil, errors.E(op, err)
 		}
-		rootEntry, dirBlob, err = s.installEntry(op, parsed.First(i).Path(), entries[i], rootEntry, false, true)
+		accessFiles[i], err = access.Parse(entry.Name, data)
 		if err != nil {
-			// TODO: System is now inconsistent.
-			return nil, err
+			return nil, nil, errors.E(op, err)
 		}
 	}
-	// Update the root.
-	s.db.root[parsed.User()] = rootEntry
-	if access.IsGroupFile(entry.Name) {
-		if entry.IsLink() {
-			return nil, errors.E(op, errors.Internal, entry.Name, "Group file cannot be a link")
+	return nil, &pendingPut{
+		base:        entries[0],
+		root:        rootEntry,
+		prevs:       prevs,
+		accessFiles: accessFiles,
+		reaped:      c.reaped,
+	}, nil
+}
+
+// commitPut installs the new root of the change prepared by preparePut
+// and updates the records kept for the entries. The caller must have
+// checked that the root has not changed since. s.db.mu is held.
+func (s *server) commitPut(op string, dir path.Parsed, newEntries []*upspin.DirEntry, deleting bool, p *pendingPut) error {
+	if err := s.checkSpace(newEntries, p.prevs, deleting); err != nil {
+		return errors.E(op, err)
+	}
+	if err := s.logPut(op, newEntries, deleting, p); err != nil {
+		return err
+	}
+	s.installPut(dir, newEntries, deleting, p)
+	return nil
+}
+
+// putLogOp returns the name under which a put or delete is logged.
+func putLogOp(deleting bool) string {
+	if deleting {
+		return "delete"
+	}
+	return "put"
+}
+
+// logPut writes the mutations of the prepared change to the log.
+// s.db.mu is held.
+func (s *server) logPut(op string, newEntries []*upspin.DirEntry, deleting bool, p *pendingPut) error {
+	for _, entry := range newEntries {
+		if err := s.logMutation(putLogOp(deleting), entry.Name, entry, p.root); err != nil {
+			return errors.E(op, err)
 		}
-		// Group files are loaded on demand but we must wipe the cache.
-		access.RemoveGroup(entry.Name)
-	} else if access.IsAccessFile(entry.Name) {
-		if entry.IsLink() {
-			return nil, errors.E(op, errors.Internal, entry.Name, "Access file cannot be a link")
+	}
+	return nil
+}
+
+// installPut installs the new root of the prepared change, updates the
+// records kept for the entries and notifies watchers. It cannot fail.
+// s.db.mu is held.
+func (s *server) installPut(dir path.Parsed, newEntries []*upspin.DirEntry, deleting bool, p *pendingPut) {
+	// Update the root. Nothing but the store and the log has changed
+	// until here.
+	s.db.root[dir.User()] = p.root
+	s.db.dropReaped(p.reaped)
+	for i, entry := range newEntries {
+		s.notify(putLogOp(deleting), entry.Name, p.root)
+		if s.expireAt != 0 && !deleting {
+			s.db.expire[entry.Name] = expiry{seq: entry.Sequence, at: s.expireAt}
+		} else {
+			delete(s.db.expire, entry.Name)
 		}
-		var accessFile *access.Access
-		if !deleting {
-			data, err := s.readAll(entry)
-			if err != nil {
-				return nil, errors.E(op, err)
+		delete(s.db.aliases, entry.Name)
+		if s.lock && !deleting {
+			s.db.locked[entry.Name] = true
+		} else {
+			delete(s.db.locked, entry.Name)
+		}
+		if s.makeSticky && !deleting && entry.IsDir() {
+			s.db.sticky[entry.Name] = true
+		}
+		// Compressed data is never empty, so it always has a first block.
+		if !deleting && len(entry.Blocks) > 0 {
+			ref := entry.Blocks[0].Location.Reference
+			if s.compressed {
+				s.db.compressed[ref] = true
 			}
-			accessFile, err = access.Parse(entry.Name, data)
-			if err != nil {
-				return nil, errors.E(op, err)
+			if s.checksum != nil {
+				s.db.checksums[ref] = *s.checksum
 			}
 		}
-		s.db.access[path.DropPath(entry.Name, 1)] = accessFile
+		// The replaced entry joins the history before it is unreferenced,
+		// so what is recorded about its data is kept with it.
+		if deleting {
+			s.db.dropHistory(entry.Name)
+			delete(s.db.contentType, entry.Name)
+		} else {
+			s.db.remember(p.prevs[i])
+			s.db.ref(entry)
+		}
+		s.db.unref(p.prevs[i])
+		if access.IsGroupFile(entry.Name) {
+			// Group files are loaded on demand but we must wipe the cache.
+			access.RemoveGroup(entry.Name)
+		} else if access.IsAccessFile(entry.Name) {
+			s.db.access[path.DropPath(entry.Name, 1)] = p.accessFiles[i]
+		}
 	}
+}
 
-	return entry, nil
+// change is one of the changes made together by putChanges: the entries
+// to put in, or delete from, the directory dir, as by putAll.
+type change struct {
+	dir      path.Parsed
+	entries  []*upspin.DirEntry
+	deleting bool
+}
+
+// putChanges makes the changes, which may be to different directories
+// and to different users' trees, as one. Each is prepared as by putAll
+// on the tree left by those before it, and the new roots are installed
+// only once all are ready, so if any step fails every tree is as it was.
+// If the path to an entry holds a link, putChanges returns the link and
+// ErrFollowLink. s.db.mu is held.
+func (s *server) putChanges(op string, changes []change) (*upspin.DirEntry, error) {
+	// Each change is prepared on the roots left by those before it,
+	// which are installed provisionally, unseen by others as we hold
+	// the lock, and then the original roots are restored.
+	saved := make(map[upspin.UserName]*upspin.DirEntry)
+	for _, c := range changes {
+		user := c.dir.User()
+		if _, ok := saved[user]; !ok {
+			saved[user] = s.db.root[user]
+		}
+	}
+	restore := func() {
+		for user, root := range saved {
+			s.db.root[user] = root
+		}
+	}
+	pending := make([]*pendingPut, len(changes))
+	for i, c := range changes {
+		link, p, err := s.preparePut(op, c.dir, c.entries, c.deleting)
+		if err != nil {
+			restore()
+			return link, err
+		}
+		s.db.root[c.dir.User()] = p.root
+		pending[i] = p
+	}
+	restore()
+
+	for i, c := range changes {
+		if err := s.checkSpace(c.entries, pending[i].prevs, c.deleting); err != nil {
+			return nil, errors.E(op, err)
+		}
+	}
+	for i, c := range changes {
+		if err := s.logPut(op, c.entries, c.deleting, pending[i]); err != nil {
+			return nil, err
+		}
+	}
+	for i, c := range changes {
+		s.installPut(c.dir, c.entries, c.deleting, pending[i])
+	}
+	return nil, nil
 }
 
-var notExist = errors.E(errors.NotExist)
+var (
+	notExist  = errors.E(errors.NotExist)
+	errExist  = errors.E(errors.Exist)
+	errNotDir = errors.E(errors.NotDir, ErrNotDirectory)
+)
+
+// ErrNotDirectory is the underlying error, of kind NotDir, when an
+// element in the middle of a path names a file rather than a directory.
+// The error's path is that of the file. Put and the other mutations
+// report it when the file is the parent of the name being changed; a
+// file further up the path, like any file met by Lookup and the other
+// reads, gives NotExist unless the reportNotDir option is set.
+var ErrNotDirectory = errors.Str("path element is not a directory")
 
 // WhichAccess implements upspin.DirServer.WhichAccess.
 func (s *server) WhichAccess(pathName upspin.PathName) (*upspin.DirEntry, error) {
 	const op = "dir/inprocess.WhichAccess"
-	parsed, err := path.Parse(pathName)
+	parsed, err := s.parse(pathName)
 	if err != nil {
 		return nil, errors.E(op, err)
 	}
@@ -434,7 +1045,7 @@ func (s *server) whichAccess(parsed path.Parsed) *access.Access {
 // Watch implements upspin.DirServer.Watch.
 func (s *server) Watch(name upspin.PathName, order int64, done <-chan struct{}) (<-chan upspin.Event, error) {
 	const op = "dir/inprocess.Watch"
-	parsed, err := path.Parse(name)
+	parsed, err := s.parse(name)
 	if err != nil {
 		return nil, errors.E(op, err)
 	}
@@ -449,13 +1060,37 @@ func (s *server) Watch(name upspin.PathName, order int64, done <-chan struct{})
 
 // readAll retrieves the data for the entry.
 func (s *server) readAll(entry *upspin.DirEntry) ([]byte, error) {
-	return clientutil.ReadAll(s.db.dirConfig, entry)
+	// Data in the store never changes, so it can be cached by reference.
+	var ref upspin.Reference
+	if s.dirCache != nil && len(entry.Blocks) == 1 {
+		ref = entry.Blocks[0].Location.Reference
+		if data, ok := s.dirCache[ref]; ok {
+			return data, nil
+		}
+	}
+	if span := s.startSpan("Store.Get", entry.Name); span != nil {
+		defer span.End()
+	}
+	var data []byte
+	err := s.retry(func() error {
+		var err error
+		if s.db.store != nil {
+			data, err = s.readStore(entry)
+		} else {
+			data, err = clientutil.ReadAll(s.db.dirConfig, entry)
+		}
+		return err
+	})
+	if err == nil && ref != "" {
+		s.dirCache[ref] = data
+	}
+	return data, err
 }
 
 // Delete implements upspin.DirServer.Delete.
 func (s *server) Delete(pathName upspin.PathName) (*upspin.DirEntry, error) {
 	const op = "dir/inprocess.Delete"
-	parsed, err := path.Parse(pathName)
+	parsed, err := s.parse(pathName)
 	if err != nil {
 		return nil, errors.E(op, err)
 	}
@@ -475,19 +1110,29 @@ func (s *server) Delete(pathName upspin.PathName) (*upspin.DirEntry, error) {
 
 	s.db.mu.Lock()
 	defer s.db.mu.Unlock()
+	if s.db.readOnly {
+		return nil, errors.E(op, pathName, errors.Permission, errReadOnly)
+	}
 	// If it is a directory, it must be empty.
 	if entry.IsDir() {
 		if !s.isEmptyDirectory(op, entry) {
 			return nil, errors.E(op, pathName, errors.NotEmpty)
 		}
 		if parsed.IsRoot() {
+			if err := s.recordMutation("deleteRoot", entry.Name, entry, nil); err != nil {
+				return nil, errors.E(op, err)
+			}
 			delete(s.db.root, parsed.User())
+			s.db.forgetDir(entry.Name)
 			return nil, nil // Nothing else to do.
 		}
 	}
 
 	entry, err = s.put(op, entry, parsed, true)
-	if err != nil {
+	if err == nil {
+		if entry.IsDir() {
+			s.db.forgetDir(entry.Name)
+		}
 		s.db.eventMgr.newEvent <- upspin.Event{
 			Entry:  entry,
 			Delete: true,
@@ -496,6 +1141,19 @@ func (s *server) Delete(pathName upspin.PathName) (*upspin.DirEntry, error) {
 	return entry, err
 }
 
+// forgetDir drops the records kept by name for the deleted, and hence
+// empty, directory: its default packing, its stickiness and its aliases.
+// s.db.mu is held.
+func (db *database) forgetDir(dirName upspin.PathName) {
+	delete(db.defaultPacking, dirName)
+	delete(db.sticky, dirName)
+	for alias := range db.aliases {
+		if path.DropPath(alias, 1) == dirName {
+			delete(db.aliases, alias)
+		}
+	}
+}
+
 func (s *server) isEmptyDirectory(op string, entry *upspin.DirEntry) bool {
 	if !entry.IsDir() {
 		return false
@@ -511,13 +1169,18 @@ func (s *server) isEmptyDirectory(op string, entry *upspin.DirEntry) bool {
 func (s *server) Lookup(pathName upspin.PathName) (*upspin.DirEntry, error) {
 	const op = "dir/inprocess.Lookup"
 	log.Debug.Println("Lookup", pathName)
-	parsed, err := path.Parse(pathName)
+	parsed, err := s.parse(pathName)
 	if err != nil {
 		return nil, errors.E(op, err)
 	}
 	entry, err := s.lookup(op, parsed, true)
+	if errors.Match(notExist, err) {
+		if target, ok := s.db.aliasTarget(parsed.Path()); ok {
+			return s.Lookup(target)
+		}
+	}
 	if err != nil {
-		if errors.Match(notExist, err) {
+		if errors.Match(notExist, err) || errors.Match(errNotDir, err) {
 			if canAny, err := s.can(access.AnyRight, parsed); err != nil {
 				return nil, err
 			} else if !canAny {
@@ -541,20 +1204,49 @@ func (s *server) Lookup(pathName upspin.PathName) (*upspin.DirEntry, error) {
 		}
 		entry.MarkIncomplete()
 	}
+	if s.db.trackAccess {
+		s.db.accessStats.record(parsed.Path(), s.db.now())
+	}
 	return entry, nil
 }
 
 // lookup is the internal version of lookup; it does not do any Access checks.
 func (s *server) lookup(op string, parsed path.Parsed, followFinal bool) (*upspin.DirEntry, error) {
-	s.db.mu.RLock()
+	if err := s.db.rlock(op); err != nil {
+		return nil, err
+	}
 	defer s.db.mu.RUnlock()
+	return s.lookupLocked(op, parsed, followFinal)
+}
+
+// lookupLocked is lookup for callers that already hold s.db.mu.
+func (s *server) lookupLocked(op string, parsed path.Parsed, followFinal bool) (*upspin.DirEntry, error) {
 	dirEntry, ok := s.db.root[parsed.User()]
+	if s.snapshot != nil && s.snapshot.Name == upspin.PathName(parsed.User()+"/") {
+		dirEntry, ok = s.snapshot, true
+	}
 	if !ok {
 		return nil, errors.E(upspin.PathName(parsed.User()), errors.NotExist, errors.Str("no such user"))
 	}
 	if parsed.IsRoot() {
 		return dirEntry, nil
 	}
+	if !s.db.cacheLookups || dirEntry == s.snapshot || len(s.db.expire) > 0 {
+		return s.walkPath(op, dirEntry, parsed, followFinal)
+	}
+	if entry, ok := s.db.lookupCache.get(parsed.User(), dirEntry, parsed.Path()); ok {
+		return entry, nil
+	}
+	entry, err := s.walkPath(op, dirEntry, parsed, followFinal)
+	if err == nil && !entry.IsLink() {
+		s.db.lookupCache.add(parsed.User(), dirEntry, parsed.Path(), entry)
+	}
+	return entry, err
+}
+
+// walkPath is lookupLocked below the root, which is dirEntry; it walks
+// the tree to find the entry. See lookupcache.go. s.db.mu is held.
+func (s *server) walkPath(op string, dirEntry *upspin.DirEntry, parsed path.Parsed, followFinal bool) (*upspin.DirEntry, error) {
 	// Iterate along the path up to but not past the last element.
 	// Invariant: dirRef refers to a directory.
 	for i := 0; i < parsed.NElem()-1; i++ {
@@ -566,6 +1258,9 @@ func (s *server) lookup(op string, parsed path.Parsed, followFinal bool) (*upspi
 			return entry, upspin.ErrFollowLink
 		}
 		if !entry.IsDir() {
+			if s.db.reportNotDir {
+				return nil, errors.E(op, parsed.First(i+1).Path(), errors.NotDir, ErrNotDirectory)
+			}
 			return nil, errors.E(op, parsed.Path(), errors.NotExist)
 		}
 		dirEntry = entry
@@ -576,31 +1271,24 @@ func (s *server) lookup(op string, parsed path.Parsed, followFinal bool) (*upspi
 	if err != nil {
 		return nil, err
 	}
+	if s.db.expiredLocked(entry) {
+		return nil, errors.E(op, parsed.Path(), errors.NotExist)
+	}
 	if entry.IsLink() && followFinal {
 		return entry, upspin.ErrFollowLink
 	}
 	return entry, nil
 }
 
-// Glob implements upspin.DirServer.Glob.
-func (s *server) Glob(pattern string) ([]*upspin.DirEntry, error) {
-	const op = "dir/inprocess.Glob"
-	log.Debug.Print(pattern)
-
-	entries, err := serverutil.Glob(pattern, s.Lookup, s.listDir)
-	if err != nil && err != upspin.ErrFollowLink {
-		err = errors.E(op, err)
-	}
-	return entries, err
-}
-
-func isGlobPattern(elem string) bool {
-	return strings.ContainsAny(elem, `*?[]`)
-}
-
 // listDir implements serverutil.ListFunc.
 // dirName should always be a directory.
 func (s *server) listDir(dirName upspin.PathName) ([]*upspin.DirEntry, error) {
+	return s.listDirFilter(dirName, nil)
+}
+
+// listDirFilter is listDir but, if keep is non-nil, returns only the
+// entries for which keep returns true.
+func (s *server) listDirFilter(dirName upspin.PathName, keep func(*upspin.DirEntry) bool) ([]*upspin.DirEntry, error) {
 	const op = "dir/inprocess.Glob" // The only (indirect) caller of this function.
 	log.Debug.Println("listDir", dirName)
 
@@ -636,18 +1324,27 @@ func (s *server) listDir(dirName upspin.PathName) ([]*upspin.DirEntry, error) {
 	if err != nil {
 		return nil, errors.E(op, dir.Name, errors.Internal, errors.Str("invalid reference: "+err.Error()))
 	}
+	contents, err := parseDir(payload)
+	if err != nil {
+		return nil, errors.E(op, dir.Name, err)
+	}
 	canRead, _ := s.can(access.Read, parsed)
 	var results []*upspin.DirEntry
-	for len(payload) > 0 {
-		var e upspin.DirEntry
-		payload, err = e.Unmarshal(payload)
+	for i := 0; i < contents.len(); i++ {
+		e, err := contents.entry(i)
 		if err != nil {
 			return nil, errors.E(op, dir.Name, err)
 		}
+		if s.db.expired(e) {
+			continue
+		}
 		if !canRead {
 			e.MarkIncomplete()
 		}
-		results = append(results, &e)
+		if keep != nil && !keep(e) {
+			continue
+		}
+		results = append(results, e)
 	}
 	return results, nil
 }
@@ -736,6 +1433,9 @@ func (s *server) rootAccessFile(parsed path.Parsed) *access.Access {
 // fetchEntry returns the reference for the named elem within the directory referenced by dirEntry.
 // It reads the whole directory, so avoid calling it repeatedly.
 func (s *server) fetchEntry(op string, entry *upspin.DirEntry, elem string) (*upspin.DirEntry, error) {
+	if span := s.startSpan("fetchDir", entry.Name); span != nil {
+		defer span.End()
+	}
 	payload, err := s.readAll(entry)
 	if err != nil {
 		return nil, err
@@ -744,116 +1444,253 @@ func (s *server) fetchEntry(op string, entry *upspin.DirEntry, elem string) (*up
 }
 
 // dirEntLookup returns the ref for the entry in the named directory whose contents are given in the payload.
-// The boolean is true if the entry itself describes a directory.
+// It uses the directory's index to binary search for the entry; see dirformat.go.
 func (s *server) dirEntLookup(op string, pathName upspin.PathName, payload []byte, elem string) (*upspin.DirEntry, error) {
 	if len(elem) == 0 {
 		return nil, errors.E(op, pathName, errors.E("empty path name element"))
 	}
 	fileName := path.Join(pathName, elem)
-	var entry upspin.DirEntry
-Loop:
-	for len(payload) > 0 {
-		remaining, err := entry.Unmarshal(payload)
+	contents, err := parseDir(payload)
+	if err != nil {
+		return nil, errors.E(op, pathName, err)
+	}
+	i, found, err := contents.search(fileName)
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+	if !found {
+		return nil, errors.E(op, fileName, errors.NotExist)
+	}
+	entry, err := contents.entry(i)
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+	return entry, nil
+}
+
+var (
+	errSeq         = errors.Str("sequence mismatch")
+	errDirFull     = errors.Str("directory full")
+	errKeyMismatch = errors.Str("key mismatch")
+)
+
+// pathEntries returns the entries for the directories along the path to
+// dir, starting with the root. If the path holds a link, it returns the
+// link and ErrFollowLink. s.db.mu is held.
+func (s *server) pathEntries(op string, dir path.Parsed) (link *upspin.DirEntry, entries []*upspin.DirEntry, err error) {
+	rootEntry, ok := s.db.root[dir.User()]
+	if !ok {
+		// Cannot create user root with Put.
+		return nil, nil, errors.E(op, upspin.PathName(dir.User()), errors.Str("no such user root"))
+	}
+	// Invariant: rootEntry refers to a directory.
+	entries = make([]*upspin.DirEntry, 0, 10) // 0th entry is the root.
+	entries = append(entries, rootEntry)
+	for i := 0; i < dir.NElem(); i++ {
+		e, err := s.fetchEntry(op, rootEntry, dir.Elem(i))
 		if err != nil {
-			return nil, errors.E(op, err)
+			return nil, nil, err
 		}
-		payload = remaining
-		if fileName != entry.Name {
-			continue Loop
+		if e.IsLink() {
+			return e, nil, upspin.ErrFollowLink
 		}
-		return &entry, nil
+		if !e.IsDir() {
+			return nil, nil, errors.E(op, dir.First(i+1).Path(), errors.NotDir, ErrNotDirectory)
+		}
+		entries = append(entries, e)
+		rootEntry = e
 	}
-	return nil, errors.E(op, fileName, errors.NotExist)
+	return nil, entries, nil
 }
 
-var errSeq = errors.Str("sequence mismatch")
+// rewritePath installs dirEntry, the new entry for the directory dir,
+// whose data is already stored, in the directories above it, whose
+// current entries, as returned by pathEntries, are in entries. It returns
+// the new root, which the caller must install. s.db.mu is held.
+func (s *server) rewritePath(op string, dir path.Parsed, entries []*upspin.DirEntry, dirEntry *upspin.DirEntry) (*upspin.DirEntry, error) {
+	// Invariant: dirEntry is the entry for the directory that has just
+	// been updated, and its data is already stored.
+	// i indicates the directory that needs to be updated to hold dirEntry.
+	for i := len(entries) - 2; i >= 0; i-- {
+		// Install into the ith directory the (i+1)th entry. The
+		// sequence number is not covered by the signature, so it can
+		// be set to the one installEntry expects to replace, and the
+		// directory need not be stored again.
+		dirEntry.Sequence = entries[i+1].Sequence
+		var err error
+		dirEntry, _, _, err = s.installEntry(op, dir.First(i).Path(), entries[i], dirEntry, false, true)
+		if err != nil {
+			// The directories stored so far are unreferenced, and
+			// the tree is as it was.
+			return nil, err
+		}
+	}
+	return dirEntry, nil
+}
+
+// installEntry installs the new entry in the directory referenced by the dirEntry, inserting it in name order
+// or overwriting the existing entry as required. It returns the entry updated directory, the blob itself, and the entry that was
+// replaced or deleted, if any.
+func (s *server) installEntry(op string, dirName upspin.PathName, dirEntry *upspin.DirEntry, newEntry *upspin.DirEntry, deleting, dirOverwriteOK bool) (*upspin.DirEntry, []byte, *upspin.DirEntry, error) {
+	entry, dirData, prevs, err := s.installEntries(op, dirName, dirEntry, []*upspin.DirEntry{newEntry}, deleting, dirOverwriteOK)
+	if err != nil {
+		return entry, nil, nil, err
+	}
+	return entry, dirData, prevs[0], nil
+}
 
-// installEntry installs the new entry in the directory referenced by the dirEntry, appending or overwriting the
-// entry as required. It returns the entry updated directory and the blob itself.
-func (s *server) installEntry(op string, dirName upspin.PathName, dirEntry *upspin.DirEntry, newEntry *upspin.DirEntry, deleting, dirOverwriteOK bool) (*upspin.DirEntry, []byte, error) {
+// installEntries is installEntry for several entries in the same directory,
+// which is rewritten once with all of them. The entries replaced or deleted,
+// if any, are returned in parallel with newEntries.
+func (s *server) installEntries(op string, dirName upspin.PathName, dirEntry *upspin.DirEntry, newEntries []*upspin.DirEntry, deleting, dirOverwriteOK bool) (*upspin.DirEntry, []byte, []*upspin.DirEntry, error) {
 	dirData, err := s.readAll(dirEntry)
 	if err != nil {
-		return nil, nil, err
+		return nil, nil, nil, err
+	}
+	contents, err := parseDir(dirData)
+	if err != nil {
+		return nil, nil, nil, errors.E(op, dirName, err)
 	}
-	found := false
-	var nextEntry upspin.DirEntry
-	for payload := dirData; len(payload) > 0; {
-		// Remember where this entry starts.
-		start := len(dirData) - len(payload)
-		remaining, err := nextEntry.Unmarshal(payload)
+	contents, err = s.reap(dirName, contents)
+	if err != nil {
+		return nil, nil, nil, errors.E(op, dirName, err)
+	}
+	prevs := make([]*upspin.DirEntry, len(newEntries))
+	for j, newEntry := range newEntries {
+		if j > 0 {
+			// Search the directory as updated by the entries so far.
+			contents, err = parseDir(dirData)
+			if err != nil {
+				return nil, nil, nil, errors.E(op, dirName, err)
+			}
+		}
+		var link *upspin.DirEntry
+		link, dirData, prevs[j], err = s.installRecord(op, dirName, contents, newEntry, deleting, dirOverwriteOK)
 		if err != nil {
-			return nil, nil, errors.E(op, err)
+			return link, nil, nil, err
 		}
-		length := len(payload) - len(remaining)
-		payload = remaining
-		if nextEntry.Name != newEntry.Name {
-			continue
+	}
+	entry, err := s.newDirEntry(dirName, dirData, upspin.SeqNext(dirEntry.Sequence))
+	if err != nil {
+		return nil, nil, nil, errors.E(op, err)
+	}
+	return entry, dirData, prevs, nil
+}
+
+// installRecord installs the new entry in the directory contents and
+// returns the resulting directory data, which is not yet stored, and
+// the entry replaced or deleted, if any. If the name is a link, it
+// returns the link and ErrFollowLink, unless the link is being deleted
+// or, if s.replaceLink is set, replaced.
+func (s *server) installRecord(op string, dirName upspin.PathName, contents *dirData, newEntry *upspin.DirEntry, deleting, dirOverwriteOK bool) (*upspin.DirEntry, []byte, *upspin.DirEntry, error) {
+	i, found, err := contents.search(newEntry.Name)
+	if err != nil {
+		return nil, nil, nil, errors.E(op, err)
+	}
+	if !found && !deleting && s.db.maxEntries > 0 && contents.len() >= s.db.maxEntries {
+		return nil, nil, nil, errors.E(op, newEntry.Name, errors.Invalid, errDirFull)
+	}
+	if !found && !deleting && s.db.caseInsensitive {
+		if err := caseConflict(contents, newEntry.Name); err != nil {
+			return nil, nil, nil, errors.E(op, err)
 		}
+	}
+	var prev *upspin.DirEntry
+	if found {
 		// We found the item with that name.
-		// If it is a link, we error out unless we are deleting it.
-		if nextEntry.IsLink() && !deleting {
-			return &nextEntry, nil, upspin.ErrFollowLink
+		nextEntry, err := contents.entry(i)
+		if err != nil {
+			return nil, nil, nil, errors.E(op, err)
+		}
+		// If it is a link, we error out unless we are deleting it
+		// or have been asked to replace it.
+		replacing := s.replaceLink && !dirOverwriteOK
+		if nextEntry.IsLink() && !deleting && !replacing {
+			return nextEntry, nil, nil, upspin.ErrFollowLink
+		}
+		prev = nextEntry
+		if !dirOverwriteOK && s.db.locked[prev.Name] && !s.unlock {
+			return nil, nil, nil, errors.E(op, newEntry.Name, errors.Permission, errLocked)
+		}
+		if deleting {
+			if err := s.checkSticky(op, dirName, prev); err != nil {
+				return nil, nil, nil, err
+			}
 		}
-		found = true
 		if !deleting {
 			// If it's already there and the sequence number is SeqNotExist, this is an error.
 			if newEntry.Sequence == upspin.SeqNotExist {
-				return nil, nil, errors.E(op, newEntry.Name, errors.Exist)
+				return nil, nil, nil, errors.E(op, newEntry.Name, errors.Exist)
 			}
 			// If it's already there and is not expected to be a directory, this is an error.
 			if nextEntry.IsDir() && !dirOverwriteOK {
-				return nil, nil, errors.E(op, errors.IsDir, dirName, errors.Str("cannot overwrite directory"))
+				return nil, nil, nil, errors.E(op, errors.IsDir, dirName, errors.Str("cannot overwrite directory"))
 			}
-		}
-		// Drop this entry so we can append the updated one (or skip it, if we're deleting).
-		// It may have changed length because of the metadata being unpredictable,
-		// so we cannot overwrite it in place.
-		copy(dirData[start:], remaining)
-		dirData = dirData[:len(dirData)-length]
-		if !deleting {
 			// We want nextEntry's sequence (previous value+1) but everything else from newEntry.
 			if newEntry.Sequence != upspin.SeqIgnore {
 				if newEntry.Sequence != nextEntry.Sequence {
-					return nil, nil, errors.E(op, newEntry.Name, errSeq)
+					return nil, nil, nil, errors.E(op, newEntry.Name, errSeq)
 				}
 			}
 			newEntry.Sequence = upspin.SeqNext(nextEntry.Sequence)
 		}
-		break
 	}
+	if s.ifMatch != nil && !deleting && !dirOverwriteOK {
+		// This is the entry being put, not a directory above it.
+		switch {
+		case *s.ifMatch == "" && prev != nil:
+			return nil, nil, nil, errors.E(op, newEntry.Name, errors.Exist)
+		case *s.ifMatch != "" && (prev == nil || len(prev.Blocks) == 0 || prev.Blocks[0].Location.Reference != *s.ifMatch):
+			return nil, nil, nil, errors.E(op, newEntry.Name, errKeyMismatch)
+		}
+	}
+	records := contents.records()
 	if deleting {
 		// Must exist.
 		if !found {
-			return nil, nil, errors.E(op, newEntry.Name, errors.NotExist)
+			return nil, nil, nil, errors.E(op, newEntry.Name, errors.NotExist)
 		}
+		records = append(records[:i], records[i+1:]...)
 	} else {
-		// Add new entry to directory.
+		// Add new entry to directory, replacing the old one if any.
+		// It may have changed length because of the metadata being
+		// unpredictable, so the directory must be rebuilt.
 		if newEntry.Sequence == upspin.SeqIgnore {
-			newEntry.Sequence = upspin.NewSequence()
+			newEntry.Sequence = s.newSequence()
 		}
 		data, err := newEntry.Marshal()
 		if err != nil {
-			return nil, nil, errors.E(op, err)
+			return nil, nil, nil, errors.E(op, err)
+		}
+		if found {
+			records[i] = data
+		} else {
+			records = append(records, nil)
+			copy(records[i+1:], records[i:])
+			records[i] = data
 		}
-		dirData = append(dirData, data...)
-	}
-	entry, err := s.newDirEntry(dirName, dirData, upspin.SeqNext(dirEntry.Sequence))
-	if err != nil {
-		return nil, nil, errors.E(op, err)
 	}
-	return entry, dirData, nil
+	return nil, formatDir(records), prev, nil
 }
 
 // Methods to implement upspin.Dialer.
 
-// Dial always returns the same instance, so there is only one instance of the service
-// running in the address space. It ignores the address within the endpoint but
-// requires that the transport be InProcess.
+// Dial returns a view of the same server, so all the servers dialed from one made by
+// New share its tree, acting for the user in the given config. It ignores the address
+// within the endpoint but requires that the transport be InProcess or one added by
+// RegisterTransport.
+// Dial modifies neither s nor the shared tree, so it may be called
+// concurrently, including with other methods.
 func (s *server) Dial(config upspin.Config, e upspin.Endpoint) (upspin.Service, error) {
 	const op = "dir/inprocess.Dial"
-	if e.Transport != upspin.InProcess {
+	if !s.db.acceptsTransport(e.Transport) {
 		return nil, errors.E(op, errors.Invalid, errors.Str("unrecognized transport"))
 	}
+	if s.db.deterministicKeys {
+		if err := s.checkContentAddressed(); err != nil {
+			return nil, errors.E(op, err)
+		}
+	}
 	this := *s // Make a copy.
 	this.config = config
 	return &this, nil
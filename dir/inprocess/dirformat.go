@@ -0,0 +1,150 @@
+// Copyright 2017 The Upspin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package inprocess
+
+// Directory format.
+//
+// The data of a directory is the marshaled DirEntries of its contents,
+// sorted by Name, followed by an index. The index holds the offset of
+// each entry within the data, in the same order, as a 4-byte big-endian
+// integer, and then the number of entries, also as a 4-byte big-endian
+// integer. An empty directory has empty data, with no index.
+//
+// Marshaled DirEntries vary in length, so without the index the only
+// way to find an entry is to unmarshal every entry before it. With it,
+// dirEntLookup can binary search the directory, unmarshaling only
+// O(log n) entries.
+//
+// The order is always by name, never by insertion: installEntry puts a
+// new entry in its sorted place and an updated one where the old one
+// was. A directory's data therefore does not depend on the order in
+// which it was built, and listDir returns entries already sorted.
+
+import (
+	"encoding/binary"
+	"sort"
+
+	"upspin.io/errors"
+	"upspin.io/upspin"
+)
+
+var errCorruptDir = errors.Str("corrupt directory index")
+
+// dirData is the parsed data of a directory.
+type dirData struct {
+	entries []byte // The marshaled entries, without the index.
+	offsets []int  // Offset within entries of each entry, sorted by name.
+}
+
+// parseDir splits the data of a directory into its entries and index.
+func parseDir(data []byte) (*dirData, error) {
+	d := new(dirData)
+	if len(data) == 0 {
+		return d, nil
+	}
+	if len(data) < 4 {
+		return nil, errors.E(errors.Internal, errCorruptDir)
+	}
+	n := int(binary.BigEndian.Uint32(data[len(data)-4:]))
+	end := len(data) - 4 - 4*n
+	if n == 0 || end <= 0 {
+		return nil, errors.E(errors.Internal, errCorruptDir)
+	}
+	d.entries = data[:end]
+	d.offsets = make([]int, n)
+	index := data[end:]
+	for i := range d.offsets {
+		off := int(binary.BigEndian.Uint32(index[4*i:]))
+		if i == 0 && off != 0 || i > 0 && off <= d.offsets[i-1] || off >= end {
+			return nil, errors.E(errors.Internal, errCorruptDir)
+		}
+		d.offsets[i] = off
+	}
+	return d, nil
+}
+
+// formatDir returns the data of a directory holding the given marshaled
+// entries, which must be sorted by name.
+func formatDir(records [][]byte) []byte {
+	if len(records) == 0 {
+		return nil
+	}
+	size := 4 + 4*len(records)
+	for _, r := range records {
+		size += len(r)
+	}
+	data := make([]byte, 0, size)
+	for _, r := range records {
+		data = append(data, r...)
+	}
+	var buf [4]byte
+	off := 0
+	for _, r := range records {
+		binary.BigEndian.PutUint32(buf[:], uint32(off))
+		data = append(data, buf[:]...)
+		off += len(r)
+	}
+	binary.BigEndian.PutUint32(buf[:], uint32(len(records)))
+	return append(data, buf[:]...)
+}
+
+// len returns the number of entries in the directory.
+func (d *dirData) len() int {
+	return len(d.offsets)
+}
+
+// record returns the marshaled form of the i'th entry.
+func (d *dirData) record(i int) []byte {
+	if i == len(d.offsets)-1 {
+		return d.entries[d.offsets[i]:]
+	}
+	return d.entries[d.offsets[i]:d.offsets[i+1]]
+}
+
+// records returns the marshaled entries, in order.
+func (d *dirData) records() [][]byte {
+	records := make([][]byte, d.len())
+	for i := range records {
+		records[i] = d.record(i)
+	}
+	return records
+}
+
+// entry returns the i'th entry.
+func (d *dirData) entry(i int) (*upspin.DirEntry, error) {
+	var entry upspin.DirEntry
+	if _, err := entry.Unmarshal(d.record(i)); err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
+
+// search returns the index of the entry with the given name and true
+// if there is one, or else the index at which it would be inserted and
+// false.
+func (d *dirData) search(name upspin.PathName) (int, bool, error) {
+	var err error
+	var found *upspin.DirEntry
+	i := sort.Search(d.len(), func(i int) bool {
+		if err != nil {
+			return true
+		}
+		var e *upspin.DirEntry
+		e, err = d.entry(i)
+		if err != nil {
+			return true
+		}
+		if e.Name >= name {
+			found = e
+			return true
+		}
+		return false
+	})
+	if err != nil {
+		return 0, false, err
+	}
+	// The last probe that succeeded was of entry i.
+	return i, found != nil && found.Name == name, nil
+}
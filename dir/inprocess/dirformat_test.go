@@ -0,0 +1,115 @@
+// Copyright 2017 The Upspin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package inprocess
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"upspin.io/errors"
+	"upspin.io/upspin"
+)
+
+func TestDirFormat(t *testing.T) {
+	var records [][]byte
+	for _, name := range []upspin.PathName{"u@x.com/a", "u@x.com/b", "u@x.com/c"} {
+		e := &upspin.DirEntry{Name: name, SignedName: name}
+		data, err := e.Marshal()
+		if err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, data)
+	}
+	d, err := parseDir(formatDir(records))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.len() != 3 {
+		t.Fatalf("len = %d; want 3", d.len())
+	}
+	for _, test := range []struct {
+		name  upspin.PathName
+		i     int
+		found bool
+	}{
+		{"u@x.com/0", 0, false},
+		{"u@x.com/a", 0, true},
+		{"u@x.com/b", 1, true},
+		{"u@x.com/bb", 2, false},
+		{"u@x.com/c", 2, true},
+		{"u@x.com/d", 3, false},
+	} {
+		i, found, err := d.search(test.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if i != test.i || found != test.found {
+			t.Errorf("search(%q) = %d, %t; want %d, %t", test.name, i, found, test.i, test.found)
+		}
+	}
+	if formatDir(nil) != nil {
+		t.Error("empty directory has data")
+	}
+	if _, err := parseDir([]byte{0, 0, 0, 1}); !errors.Match(errors.E(errors.Internal), err) {
+		t.Errorf("parseDir of bad index: err = %v; want Internal", err)
+	}
+}
+
+func TestSortedDirectory(t *testing.T) {
+	config, dir := setup()
+	user := config.UserName()
+	const n = 50
+	for _, i := range rand.Perm(n) {
+		name := upspin.PathName(fmt.Sprintf("%s/file%02d", user, i))
+		if _, err := dir.Put(storeData(t, config, []byte(name), name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < n; i++ {
+		name := upspin.PathName(fmt.Sprintf("%s/file%02d", user, i))
+		entry, err := dir.Lookup(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if entry.Name != name {
+			t.Fatalf("Lookup(%q) returned %q", name, entry.Name)
+		}
+	}
+	// Overwrite some entries; they must stay in place.
+	for _, i := range []int{0, n / 2, n - 1} {
+		name := upspin.PathName(fmt.Sprintf("%s/file%02d", user, i))
+		if _, err := dir.Put(storeData(t, config, []byte("again"), name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Glob sorts its results, so check the stored order with listDir.
+	entries, err := dir.(*server).listDir(upspin.PathName(user + "/"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != n {
+		t.Fatalf("listDir returned %d entries; want %d", len(entries), n)
+	}
+	for i, e := range entries {
+		if want := upspin.PathName(fmt.Sprintf("%s/file%02d", user, i)); e.Name != want {
+			t.Errorf("entry %d is %q; want %q", i, e.Name, want)
+		}
+	}
+
+	// Deleting every entry leaves an empty directory.
+	for _, i := range rand.Perm(n) {
+		if _, err := dir.Delete(upspin.PathName(fmt.Sprintf("%s/file%02d", user, i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	root, err := dir.Lookup(upspin.PathName(user + "/"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !dir.(*server).isEmptyDirectory("test", root) {
+		t.Error("root not empty after deleting all entries")
+	}
+}
@@ -0,0 +1,95 @@
+// Copyright 2017 The Upspin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package inprocess
+
+import (
+	"upspin.io/path"
+	"upspin.io/upspin"
+)
+
+// Entries may be given an expiration time by PutReader. Once it has
+// passed, Lookup, Glob and the other read methods treat the entry as
+// absent. Nothing runs in the background to remove it; instead it is
+// reaped, without an event being sent, the next time the directory
+// holding it is written.
+//
+// The expiration time is not part of the DirEntry, so it is recorded
+// in db.expire, keyed by name, along with the sequence number of the
+// entry it applies to. It is recorded as the put installs the entry, so
+// the entry is never visible without it. Any later Put or Delete of the
+// name removes the record.
+
+// expiry records when the entry with the given sequence number expires.
+type expiry struct {
+	seq int64
+	at  upspin.Time
+}
+
+// expired reports whether the entry has expired.
+// s.db.mu is _not_ held.
+func (db *database) expired(entry *upspin.DirEntry) bool {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return db.expiredLocked(entry)
+}
+
+// expiredLocked is expired for callers that already hold s.db.mu.
+func (db *database) expiredLocked(entry *upspin.DirEntry) bool {
+	x, ok := db.expire[entry.Name]
+	return ok && x.seq == entry.Sequence && x.at <= db.now()
+}
+
+// reap removes the expired entries from the contents of the named
+// directory and returns the remaining contents. The entries removed are
+// added to s.reaped, for dropReaped to forget once the put that reaped
+// them is complete; until then they remain in the installed tree.
+// s.db.mu is held, perhaps only for reading.
+func (s *server) reap(dirName upspin.PathName, contents *dirData) (*dirData, error) {
+	db := s.db
+	records := contents.records()
+	reaped := false
+	for name, x := range db.expire {
+		if path.DropPath(name, 1) != dirName || x.at > db.now() {
+			continue
+		}
+		i, found, err := contents.search(name)
+		if err != nil {
+			return nil, err
+		}
+		if !found {
+			continue
+		}
+		entry, err := contents.entry(i)
+		if err != nil {
+			return nil, err
+		}
+		if entry.Sequence != x.seq {
+			// A stale record, which expiredLocked also ignores.
+			continue
+		}
+		s.reaped = append(s.reaped, entry)
+		records[i] = nil
+		reaped = true
+	}
+	if !reaped {
+		return contents, nil
+	}
+	kept := records[:0]
+	for _, r := range records {
+		if r != nil {
+			kept = append(kept, r)
+		}
+	}
+	return parseDir(formatDir(kept))
+}
+
+// dropReaped forgets the reaped entries, now that they are gone from
+// the tree. s.db.mu is held.
+func (db *database) dropReaped(reaped []*upspin.DirEntry) {
+	for _, entry := range reaped {
+		delete(db.expire, entry.Name)
+		db.unref(entry)
+	}
+}
@@ -0,0 +1,115 @@
+// Copyright 2017 The Upspin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package inprocess
+
+import (
+	"strings"
+	"testing"
+
+	"upspin.io/errors"
+	"upspin.io/upspin"
+)
+
+func TestExpire(t *testing.T) {
+	config, dir := setup()
+	s := dir.(*server)
+	user := config.UserName()
+	now := upspin.Now()
+	s.db.now = func() upspin.Time { return now }
+
+	name := upspin.PathName(user + "/cached")
+	entry, err := s.PutReader(name, strings.NewReader("ephemeral"), upspin.PlainPack, &PutOptions{ExpireAt: now + 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ref := entry.Blocks[0].Location.Reference
+	if _, err := s.PutReader(upspin.PathName(user+"/kept"), strings.NewReader("durable"), upspin.PlainPack, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dir.Lookup(name); err != nil {
+		t.Fatalf("Lookup before expiry: %v", err)
+	}
+
+	now += 10
+	if _, err := dir.Lookup(name); !errors.Match(errors.E(errors.NotExist), err) {
+		t.Errorf("Lookup after expiry: err = %v; want NotExist", err)
+	}
+	entries, err := dir.Glob(string(user) + "/*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Name != upspin.PathName(user+"/kept") {
+		t.Errorf("Glob after expiry returned %v; want only %s/kept", entries, user)
+	}
+	// Not yet reaped.
+	if s.db.orphaned(ref) {
+		t.Error("expired entry reaped before a write")
+	}
+
+	// A write to the directory reaps the entry.
+	if _, err := makeDirectory(dir, upspin.PathName(user+"/dir")); err != nil {
+		t.Fatal(err)
+	}
+	if !s.db.orphaned(ref) {
+		t.Error("expired entry not reaped by a write")
+	}
+	if len(s.db.expire) != 0 {
+		t.Errorf("expiry records remain: %v", s.db.expire)
+	}
+
+	// The name is free again.
+	newEntry := storeData(t, config, []byte("again"), name)
+	newEntry.Sequence = upspin.SeqNotExist
+	if _, err := dir.Put(newEntry); err != nil {
+		t.Fatal(err)
+	}
+	now += 1000
+	if _, err := dir.Lookup(name); err != nil {
+		t.Errorf("entry without expiry expired: %v", err)
+	}
+}
+
+func TestExpireInstalledWithEntry(t *testing.T) {
+	config, dir := setup()
+	s := dir.(*server)
+	user := config.UserName()
+	now := upspin.Now()
+	s.db.now = func() upspin.Time { return now }
+	name := upspin.PathName(user + "/gone")
+
+	// An entry put already expired must never be visible, even to a
+	// Lookup made while the put is completing.
+	stop := make(chan struct{})
+	seen := make(chan int)
+	go func() {
+		n := 0
+		for {
+			select {
+			case <-stop:
+				seen <- n
+				return
+			default:
+			}
+			if _, err := dir.Lookup(name); err == nil {
+				n++
+			}
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		if _, err := s.PutReader(name, strings.NewReader("gone"), upspin.PlainPack, &PutOptions{ExpireAt: now}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	close(stop)
+	if n := <-seen; n != 0 {
+		t.Errorf("expired entry seen by %d Lookups", n)
+	}
+	s.db.mu.RLock()
+	x, ok := s.db.expire[name]
+	s.db.mu.RUnlock()
+	if !ok || x.at != now {
+		t.Errorf("expiry record %+v, %t; want expiry at %d", x, ok, now)
+	}
+}
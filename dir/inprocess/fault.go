@@ -0,0 +1,45 @@
+// Copyright 2017 The Upspin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package inprocess
+
+import "upspin.io/errors"
+
+var errInjected = errors.Str("injected store failure")
+
+// FailAfter arranges, for testing, that after n more directories are
+// written to the store the next write fails with an IO error. The
+// failure happens once; after it, writes succeed again. If n is
+// negative, any pending failure is cancelled. Data written by PutReader
+// is not counted.
+//
+// A put stores the changed directory and each one above it in turn and
+// only then installs the new root, so a failure at any step leaves the
+// tree as it was, with nothing of the put visible. The directories
+// already stored are left unreferenced in the store. Operations that
+// change several directories, such as Swap, Transfer and MoveTree,
+// prepare each change in turn and install all the new roots together,
+// so they too are done whole or not at all.
+// The setting applies to all users of the server.
+func (s *server) FailAfter(n int) {
+	s.db.faultMu.Lock()
+	defer s.db.faultMu.Unlock()
+	if n < 0 {
+		s.db.failAfter = 0
+		return
+	}
+	s.db.failAfter = n + 1
+}
+
+// injectFailure reports whether the directory store about to be made
+// should fail, as arranged by FailAfter.
+func (db *database) injectFailure() bool {
+	db.faultMu.Lock()
+	defer db.faultMu.Unlock()
+	if db.failAfter == 0 {
+		return false
+	}
+	db.failAfter--
+	return db.failAfter == 0
+}
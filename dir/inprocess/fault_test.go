@@ -0,0 +1,129 @@
+// Copyright 2017 The Upspin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package inprocess
+
+import (
+	"strings"
+	"testing"
+
+	"upspin.io/errors"
+	"upspin.io/upspin"
+)
+
+func TestFailAfter(t *testing.T) {
+	config, dir := setup()
+	s := dir.(*server)
+	user := config.UserName()
+	now := upspin.Now()
+	s.db.now = func() upspin.Time { return now }
+	for _, d := range []string{"/a", "/a/b", "/a/b/c"} {
+		if _, err := makeDirectory(dir, upspin.PathName(user+upspin.UserName(d))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	kept := upspin.PathName(user + "/a/b/c/kept")
+	if _, err := s.PutReader(kept, strings.NewReader("kept"), upspin.PlainPack, nil); err != nil {
+		t.Fatal(err)
+	}
+	expired := upspin.PathName(user + "/a/b/c/expired")
+	entry, err := s.PutReader(expired, strings.NewReader("gone"), upspin.PlainPack, &PutOptions{ExpireAt: now + 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expiredRef := entry.Blocks[0].Location.Reference
+	now += 1
+
+	newName := upspin.PathName(user + "/a/b/c/new")
+	newEntry := storeData(t, config, []byte("new"), newName)
+	rootRef := s.db.root[user].Blocks[0].Location.Reference
+
+	// A put here stores c, b, a and the root. Fail at each step.
+	for n := 0; n < 4; n++ {
+		for _, fn := range []func() error{
+			func() error { _, err := dir.Put(newEntry); return err },
+			func() error { _, err := dir.Delete(kept); return err },
+		} {
+			s.FailAfter(n)
+			err := fn()
+			s.FailAfter(-1)
+			if !errors.Match(errors.E(errors.IO), err) {
+				t.Fatalf("failing after %d stores: err = %v; want injected failure", n, err)
+			}
+			if got := s.db.root[user].Blocks[0].Location.Reference; got != rootRef {
+				t.Fatalf("failing after %d stores: root changed", n)
+			}
+			if _, err := dir.Lookup(newName); !errors.Match(errors.E(errors.NotExist), err) {
+				t.Errorf("failing after %d stores: new entry visible: %v", n, err)
+			}
+			data, err := s.GetData(kept)
+			if err != nil || string(data) != "kept" {
+				t.Errorf("failing after %d stores: read %q, %v", n, data, err)
+			}
+			if _, err := dir.Lookup(expired); !errors.Match(errors.E(errors.NotExist), err) {
+				t.Errorf("failing after %d stores: expired entry visible: %v", n, err)
+			}
+			if s.db.orphaned(expiredRef) {
+				t.Errorf("failing after %d stores: expired entry reaped", n)
+			}
+			if errs := s.checkUser(user); len(errs) != 0 {
+				t.Errorf("failing after %d stores: tree is inconsistent: %v", n, errs)
+			}
+		}
+	}
+
+	// With the failure cancelled, the put goes through.
+	s.FailAfter(10)
+	s.FailAfter(-1)
+	if _, err := dir.Put(newEntry); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dir.Lookup(newName); err != nil {
+		t.Error(err)
+	}
+	if !s.db.orphaned(expiredRef) {
+		t.Error("expired entry not reaped by a successful put")
+	}
+}
+
+// failEachStore runs fn with a failure injected after 0, 1, 2... stores
+// until it succeeds. Each failed run must report the injected failure and
+// leave the users' roots, the space used and the trees as they were; check,
+// if not nil, verifies anything else. It returns the stores fn made.
+func failEachStore(t *testing.T, s *server, users []upspin.UserName, fn func() error, check func(n int)) int {
+	t.Helper()
+	roots := make(map[upspin.UserName]upspin.Reference)
+	for _, user := range users {
+		roots[user] = s.db.root[user].Blocks[0].Location.Reference
+	}
+	total := s.TotalBytes()
+	for n := 0; ; n++ {
+		s.FailAfter(n)
+		err := fn()
+		s.FailAfter(-1)
+		if err == nil {
+			if n == 0 {
+				t.Fatal("no stores to fail")
+			}
+			return n
+		}
+		if !errors.Match(errors.E(errors.IO), err) {
+			t.Fatalf("failing after %d stores: err = %v; want injected failure", n, err)
+		}
+		for _, user := range users {
+			if got := s.db.root[user].Blocks[0].Location.Reference; got != roots[user] {
+				t.Fatalf("failing after %d stores: root of %s changed", n, user)
+			}
+			if errs := s.checkUser(user); len(errs) != 0 {
+				t.Errorf("failing after %d stores: tree of %s is inconsistent: %v", n, user, errs)
+			}
+		}
+		if got := s.TotalBytes(); got != total {
+			t.Errorf("failing after %d stores: %d bytes used; want %d", n, got, total)
+		}
+		if check != nil {
+			check(n)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+// Copyright 2017 The Upspin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package inprocess
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"strings"
+
+	"upspin.io/errors"
+	"upspin.io/upspin"
+)
+
+// Fingerprint returns a hash of the shape and contents of the user's tree,
+// for tests to compare a tree against an expected one in a single value.
+// It covers, for each entry in name order, the entry's path relative to
+// the root, its type, its size, its link target and the references of its
+// data, but not times, writers, sequence numbers or the references of
+// directories, which change with every write. Two trees built by putting
+// the same data under the same names thus have the same fingerprint
+// whatever the order of the puts. Link targets within the user's tree are
+// taken relative to the root too, so trees of different users can match.
+// Data packed with a packing that encrypts has different references each
+// time it is packed.
+// Only the user may do this.
+func (s *server) Fingerprint(userName upspin.UserName) (string, error) {
+	const op = "dir/inprocess.Fingerprint"
+	userName = normalizeUser(userName, s.db.foldLocal)
+	if normalizeUser(s.config.UserName(), s.db.foldLocal) != userName {
+		return "", errors.E(op, userName, errors.Permission)
+	}
+
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+	root, ok := s.db.root[userName]
+	if !ok {
+		return "", errors.E(op, userName, errors.NotExist, errors.Str("no such user"))
+	}
+	prefix := string(userName)
+	h := sha256.New()
+	var record []byte
+	err := s.walkTree(root, func(entry *upspin.DirEntry) error {
+		kind := "f"
+		switch {
+		case entry.IsDir():
+			kind = "d"
+		case entry.IsLink():
+			kind = "l"
+		}
+		var size int64
+		var refs []string
+		if !entry.IsDir() {
+			for _, b := range entry.Blocks {
+				size += b.Size
+				refs = append(refs, string(b.Location.Reference))
+			}
+		}
+		link := string(entry.Link)
+		if strings.HasPrefix(link, prefix+"/") {
+			link = strings.TrimPrefix(link, prefix)
+		}
+		record = record[:0]
+		for _, field := range []string{
+			strings.TrimPrefix(string(entry.Name), prefix),
+			kind,
+			strconv.FormatInt(size, 10),
+			link,
+			strings.Join(refs, " "),
+		} {
+			record = appendField(record, []byte(field))
+		}
+		h.Write(record)
+		return nil
+	})
+	if err != nil {
+		return "", errors.E(op, err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,79 @@
+// Copyright 2017 The Upspin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package inprocess
+
+import (
+	"strings"
+	"testing"
+
+	"upspin.io/errors"
+	"upspin.io/upspin"
+)
+
+func TestFingerprint(t *testing.T) {
+	// build makes a tree in a new server, in the given order, with
+	// the plain packing so equal data has equal references.
+	build := func(order []string) (*server, upspin.UserName) {
+		config, dir := setup()
+		s := dir.(*server)
+		user := config.UserName()
+		for _, name := range order {
+			pathName := upspin.PathName(string(user) + name)
+			var err error
+			switch {
+			case strings.HasSuffix(name, "/"):
+				_, err = makeDirectory(dir, pathName[:len(pathName)-1])
+			case strings.HasSuffix(name, "@"):
+				_, err = dir.Put(&upspin.DirEntry{
+					Name:       pathName[:len(pathName)-1],
+					SignedName: pathName[:len(pathName)-1],
+					Attr:       upspin.AttrLink,
+					Link:       upspin.PathName(user + "/a"),
+					Packing:    upspin.PlainPack,
+					Writer:     user,
+				})
+			default:
+				_, err = s.PutReader(pathName, strings.NewReader("data of "+name), upspin.PlainPack, nil)
+			}
+			if err != nil {
+				t.Fatalf("%s: %v", pathName, err)
+			}
+		}
+		return s, user
+	}
+	fingerprint := func(s *server, user upspin.UserName) string {
+		fp, err := s.Fingerprint(user)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return fp
+	}
+
+	s1, u1 := build([]string{"/a/", "/a/f1", "/b/", "/b/f2", "/l@"})
+	s2, u2 := build([]string{"/b/", "/a/", "/l@", "/b/f2", "/a/f1"})
+	want := fingerprint(s1, u1)
+	if got := fingerprint(s2, u2); got != want {
+		t.Errorf("trees built in different orders: fingerprints %s and %s differ", got, want)
+	}
+
+	// Any change to the tree changes the fingerprint.
+	if _, err := s2.PutReader(upspin.PathName(u2+"/a/f1"), strings.NewReader("new data"), upspin.PlainPack, nil); err != nil {
+		t.Fatal(err)
+	}
+	changed := fingerprint(s2, u2)
+	if changed == want {
+		t.Error("fingerprint unchanged after new data")
+	}
+	if _, err := makeDirectory(s2, upspin.PathName(u2+"/a/f1x")); err != nil {
+		t.Fatal(err)
+	}
+	if fingerprint(s2, u2) == changed {
+		t.Error("fingerprint unchanged after MakeDirectory")
+	}
+
+	if _, err := s1.Fingerprint(u2); !errors.Match(errors.E(errors.Permission), err) {
+		t.Errorf("Fingerprint of another user: err = %v; want Permission", err)
+	}
+}
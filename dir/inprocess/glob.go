@@ -0,0 +1,651 @@
+// Copyright 2017 The Upspin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package inprocess
+
+import (
+	"context"
+	goPath "path"
+	"sort"
+	"strings"
+
+	"upspin.io/access"
+	"upspin.io/errors"
+	"upspin.io/log"
+	"upspin.io/path"
+	"upspin.io/upspin"
+)
+
+var (
+	errPrivate    = errors.E(errors.Private)
+	errPermission = errors.E(errors.Permission)
+)
+
+// Glob implements upspin.DirServer.Glob.
+func (s *server) Glob(pattern string) ([]*upspin.DirEntry, error) {
+	const op = "dir/inprocess.Glob"
+	log.Debug.Print(pattern)
+
+	var entries []*upspin.DirEntry
+	err := s.glob(pattern, func(e *upspin.DirEntry) error {
+		entries = append(entries, e)
+		return nil
+	})
+	if err != nil && err != upspin.ErrFollowLink {
+		return nil, errors.E(op, err)
+	}
+	upspin.SortDirEntries(entries, false)
+	return entries, err
+}
+
+// GlobUnsorted is like Glob but returns the entries in the order they are
+// found, which is unspecified, saving the sort for callers that do not
+// need it.
+func (s *server) GlobUnsorted(pattern string) ([]*upspin.DirEntry, error) {
+	const op = "dir/inprocess.GlobUnsorted"
+	var entries []*upspin.DirEntry
+	err := s.glob(pattern, func(e *upspin.DirEntry) error {
+		entries = append(entries, e)
+		return nil
+	})
+	if err != nil && err != upspin.ErrFollowLink {
+		return nil, errors.E(op, err)
+	}
+	return entries, err
+}
+
+// GlobCount returns the number of entries Glob would return for the
+// pattern, without collecting or sorting them. As with Glob, the error
+// may be ErrFollowLink, in which case the count includes the links.
+func (s *server) GlobCount(pattern string) (int, error) {
+	const op = "dir/inprocess.GlobCount"
+	n := 0
+	err := s.glob(pattern, func(*upspin.DirEntry) error {
+		n++
+		return nil
+	})
+	if err != nil && err != upspin.ErrFollowLink {
+		return 0, errors.E(op, err)
+	}
+	return n, err
+}
+
+// GlobBySize is like Glob but returns only the entries whose size, the
+// total length of their blocks, is at least minBytes and, unless maxBytes
+// is zero, at most maxBytes. Links, which have no size, are returned
+// regardless, so that the caller can follow them when the error is
+// ErrFollowLink. Incomplete entries, for which the caller has no read
+// rights, have no blocks and so have size zero.
+func (s *server) GlobBySize(pattern string, minBytes, maxBytes uint64) ([]*upspin.DirEntry, error) {
+	const op = "dir/inprocess.GlobBySize"
+	log.Debug.Print(pattern)
+
+	var entries []*upspin.DirEntry
+	err := s.glob(pattern, func(e *upspin.DirEntry) error {
+		if !e.IsLink() {
+			size, err := e.Size()
+			if err != nil {
+				return errors.E(e.Name, errors.Invalid, err)
+			}
+			if uint64(size) < minBytes || maxBytes != 0 && uint64(size) > maxBytes {
+				return nil
+			}
+		}
+		entries = append(entries, e)
+		return nil
+	})
+	if err != nil && err != upspin.ErrFollowLink {
+		return nil, errors.E(op, err)
+	}
+	upspin.SortDirEntries(entries, false)
+	return entries, err
+}
+
+// EntryType selects the entries returned by GlobType.
+type EntryType int
+
+const (
+	AnyType  EntryType = iota // Any entry.
+	DirType                   // Directories.
+	FileType                  // Regular files.
+)
+
+// GlobType is like Glob but returns only the entries of the given type,
+// as "ls -d" lists only directories. Links are returned regardless, so
+// that the caller can follow them when the error is ErrFollowLink.
+func (s *server) GlobType(pattern string, want EntryType) ([]*upspin.DirEntry, error) {
+	const op = "dir/inprocess.GlobType"
+	log.Debug.Print(pattern)
+	return s.globFilter(op, pattern, func(e *upspin.DirEntry) bool {
+		switch {
+		case want == DirType && !e.IsDir(), want == FileType && !e.IsRegular():
+			return false
+		}
+		return true
+	})
+}
+
+// GlobFilter is like Glob but returns only the entries for which pred
+// returns true, so the caller can select on any field of the entry in the
+// same pass that matches the pattern. Pred is called once for each match,
+// as it is found, and not for the directories traversed to reach it.
+// Links are returned regardless, so that the caller can follow them when
+// the error is ErrFollowLink. Entries for which the caller has no read
+// rights are incomplete when pred sees them.
+func (s *server) GlobFilter(pattern string, pred func(*upspin.DirEntry) bool) ([]*upspin.DirEntry, error) {
+	const op = "dir/inprocess.GlobFilter"
+	log.Debug.Print(pattern)
+	return s.globFilter(op, pattern, pred)
+}
+
+// globFilter implements GlobFilter, reporting errors as op.
+func (s *server) globFilter(op string, pattern string, pred func(*upspin.DirEntry) bool) ([]*upspin.DirEntry, error) {
+	var entries []*upspin.DirEntry
+	err := s.glob(pattern, func(e *upspin.DirEntry) error {
+		if e.IsLink() || pred(e) {
+			entries = append(entries, e)
+		}
+		return nil
+	})
+	if err != nil && err != upspin.ErrFollowLink {
+		return nil, errors.E(op, err)
+	}
+	upspin.SortDirEntries(entries, false)
+	return entries, err
+}
+
+// GlobChildCounts is like Glob but also returns, for each matching
+// directory the caller may list, the number of entries in it, so that a
+// tree view can tell which directories to offer to expand without
+// listing each one. Directories the caller may not list have no count.
+func (s *server) GlobChildCounts(pattern string) ([]*upspin.DirEntry, map[upspin.PathName]int, error) {
+	const op = "dir/inprocess.GlobChildCounts"
+	log.Debug.Print(pattern)
+
+	var entries []*upspin.DirEntry
+	counts := make(map[upspin.PathName]int)
+	err := s.glob(pattern, func(e *upspin.DirEntry) error {
+		entries = append(entries, e)
+		if !e.IsDir() {
+			return nil
+		}
+		n, ok, err := s.childCount(e)
+		if err != nil {
+			return err
+		}
+		if ok {
+			counts[e.Name] = n
+		}
+		return nil
+	})
+	if err != nil && err != upspin.ErrFollowLink {
+		return nil, nil, errors.E(op, err)
+	}
+	upspin.SortDirEntries(entries, false)
+	return entries, counts, err
+}
+
+// childCount returns the number of live entries in the directory and
+// true, or false if the caller may not list it. The directory's own
+// data is read unless the entry is incomplete, in which case it is
+// looked up again.
+func (s *server) childCount(dir *upspin.DirEntry) (int, bool, error) {
+	parsed, err := path.Parse(dir.Name)
+	if err != nil {
+		return 0, false, err
+	}
+	canList, err := s.can(access.List, parsed)
+	if err != nil || !canList {
+		return 0, false, err
+	}
+	if dir.IsIncomplete() {
+		entries, err := s.listDir(dir.Name)
+		return len(entries), err == nil, err
+	}
+	payload, err := s.readAll(dir)
+	if err != nil {
+		return 0, false, errors.E(dir.Name, err)
+	}
+	contents, err := parseDir(payload)
+	if err != nil {
+		return 0, false, errors.E(dir.Name, err)
+	}
+	n := 0
+	for i := 0; i < contents.len(); i++ {
+		e, err := contents.entry(i)
+		if err != nil {
+			return 0, false, errors.E(dir.Name, err)
+		}
+		if !s.db.expired(e) {
+			n++
+		}
+	}
+	return n, true, nil
+}
+
+// GlobResult is a value sent by GlobStream: either a matching entry or
+// the error that ended the walk.
+type GlobResult struct {
+	Entry *upspin.DirEntry
+	Err   error
+}
+
+// GlobStream is like Glob but sends each match on the returned channel
+// as the walk finds it, so a caller may use the first matches before the
+// walk is done. The matches are not sorted. If the walk fails, or meets
+// a link as Glob would with ErrFollowLink, the last value sent holds the
+// error; as with Glob, entries sent before an error other than
+// ErrFollowLink should be discarded. The channel is closed when the walk
+// is done or when ctx is canceled, whichever comes first, so a caller
+// that stops reading early should cancel ctx.
+func (s *server) GlobStream(ctx context.Context, pattern string) (<-chan GlobResult, error) {
+	const op = "dir/inprocess.GlobStream"
+	log.Debug.Print(pattern)
+
+	if _, err := s.parse(upspin.PathName(pattern)); err != nil {
+		return nil, errors.E(op, err)
+	}
+	ch := make(chan GlobResult)
+	go func() {
+		defer close(ch)
+		err := s.glob(pattern, func(e *upspin.DirEntry) error {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			select {
+			case ch <- GlobResult{Entry: e}:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		})
+		if err == nil || err == ctx.Err() {
+			return
+		}
+		if err != upspin.ErrFollowLink {
+			err = errors.E(op, err)
+		}
+		select {
+		case ch <- GlobResult{Err: err}:
+		case <-ctx.Done():
+		}
+	}()
+	return ch, nil
+}
+
+// GlobUsers is like Glob but matches the pattern in the trees of several
+// users, returning the matches keyed by user. The user name in the
+// pattern may itself be a pattern, such as "*@example.com/*.jpg"; it is
+// matched against each of the given users or, if users is empty, each
+// user with a root on the server. Users whose trees the caller may not
+// search are omitted, as are users with no matches. As with Glob, the
+// error may be ErrFollowLink, in which case the results include the
+// links.
+func (s *server) GlobUsers(users []upspin.UserName, pattern string) (map[upspin.UserName][]*upspin.DirEntry, error) {
+	const op = "dir/inprocess.GlobUsers"
+	userPattern, rest := pattern, ""
+	if i := strings.IndexByte(pattern, '/'); i >= 0 {
+		userPattern, rest = pattern[:i], pattern[i:]
+	}
+	if _, err := goPath.Match(userPattern, ""); err != nil {
+		return nil, errors.E(op, errors.Invalid, errors.Errorf("bad user pattern %q: %v", userPattern, err))
+	}
+	if len(users) == 0 {
+		s.db.mu.RLock()
+		for userName := range s.db.root {
+			users = append(users, userName)
+		}
+		s.db.mu.RUnlock()
+	}
+	matches := make(map[upspin.UserName][]*upspin.DirEntry)
+	var followLink error
+	for _, userName := range users {
+		if ok, _ := goPath.Match(userPattern, string(userName)); !ok {
+			continue
+		}
+		entries, err := s.Glob(string(userName) + rest)
+		switch {
+		case err == upspin.ErrFollowLink:
+			followLink = err
+		case errors.Match(notExist, err), errors.Match(errPrivate, err), errors.Match(errPermission, err):
+			continue
+		case err != nil:
+			return nil, errors.E(op, err)
+		}
+		if len(entries) > 0 {
+			matches[userName] = entries
+		}
+	}
+	return matches, followLink
+}
+
+// Filter returns the entries in the named directory for which pred
+// returns true, sorted by name. The directory is read once and pred is
+// called for each entry as it is unpacked. As with Glob, the caller needs
+// list rights for the directory, and without read rights the entries are
+// incomplete. If the directory name includes a link, Filter returns the
+// link and ErrFollowLink.
+func (s *server) Filter(dirName upspin.PathName, pred func(*upspin.DirEntry) bool) ([]*upspin.DirEntry, error) {
+	const op = "dir/inprocess.Filter"
+	parsed, err := s.parse(dirName)
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+	entries, err := s.listDirFilter(parsed.Path(), pred)
+	if err == upspin.ErrFollowLink {
+		return entries, err
+	}
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+	return entries, nil
+}
+
+// ReadDir returns the entries of the named directory, one level only,
+// sorted by name. It reads the directory once, with the access checks
+// of Filter, and is the common case of Glob(dir+"/*") without building
+// or matching a pattern. It is an error if dirName names a file or does
+// not exist.
+func (s *server) ReadDir(dirName upspin.PathName) ([]*upspin.DirEntry, error) {
+	const op = "dir/inprocess.ReadDir"
+	parsed, err := s.parse(dirName)
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+	entries, err := s.listDir(parsed.Path())
+	if err == upspin.ErrFollowLink {
+		return entries, err
+	}
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+	return entries, nil
+}
+
+// SortKey selects the order of the entries returned by ReadDirSorted.
+type SortKey int
+
+const (
+	SortByName SortKey = iota // By Name.
+	SortByTime                // By Time, the time of the last change.
+	SortBySize                // By the size of the data.
+)
+
+// ReadDirSorted is like ReadDir but returns the entries sorted by the key,
+// in descending order if desc is set. Entries with equal keys are sorted
+// by name, ascending. The sizes of incomplete entries, for which the
+// caller has no read rights, are unknown and sorted as zero.
+func (s *server) ReadDirSorted(dirName upspin.PathName, by SortKey, desc bool) ([]*upspin.DirEntry, error) {
+	const op = "dir/inprocess.ReadDirSorted"
+	entries, err := s.ReadDir(dirName)
+	if err != nil {
+		return entries, err
+	}
+	keys := make(map[*upspin.DirEntry]int64, len(entries))
+	for _, e := range entries {
+		switch by {
+		case SortByName:
+			// Already in order.
+		case SortByTime:
+			keys[e] = int64(e.Time)
+		case SortBySize:
+			size, err := e.Size()
+			if err != nil {
+				return nil, errors.E(op, e.Name, errors.Invalid, err)
+			}
+			keys[e] = size
+		default:
+			return nil, errors.E(op, dirName, errors.Invalid, errors.Errorf("unknown sort key %d", by))
+		}
+	}
+	if by == SortByName {
+		if desc {
+			sort.Slice(entries, func(i, j int) bool { return entries[i].Name > entries[j].Name })
+		}
+		return entries, nil
+	}
+	// The entries are in name order, so a stable sort keeps ties in it.
+	sort.SliceStable(entries, func(i, j int) bool {
+		ki, kj := keys[entries[i]], keys[entries[j]]
+		if desc {
+			return ki > kj
+		}
+		return ki < kj
+	})
+	return entries, nil
+}
+
+// GlobMatchFunc reports whether name, a single path element, matches
+// pattern, a single element of a glob pattern. Its contract is that of
+// path.Match.
+type GlobMatchFunc func(pattern, name string) (bool, error)
+
+// SetGlobMatchFunc sets the function Glob and GlobCount use to match each
+// element of a pattern against the names in a directory. If match is nil,
+// path.Match is used, which is the default.
+// The function is only called for elements containing metacharacters;
+// other elements must match exactly. The setting applies to all users of
+// the server.
+func (s *server) SetGlobMatchFunc(match GlobMatchFunc) {
+	s.db.mu.Lock()
+	s.db.globMatch = match
+	s.db.mu.Unlock()
+}
+
+// globMatchFunc returns the function to use for matching pattern elements.
+func (s *server) globMatchFunc() GlobMatchFunc {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+	if s.db.globMatch == nil {
+		return goPath.Match
+	}
+	return s.db.globMatch
+}
+
+// isGlobPattern reports whether elem contains a metacharacter that is
+// not escaped with a backslash.
+func isGlobPattern(elem string) bool {
+	for i := 0; i < len(elem); i++ {
+		switch elem[i] {
+		case '\\':
+			i++
+		case '*', '?', '[', ']':
+			return true
+		}
+	}
+	return false
+}
+
+// unescapeGlob removes the backslashes from elem, which contains no
+// unescaped metacharacters, leaving the literal name it matches.
+func unescapeGlob(elem string) string {
+	if !strings.Contains(elem, `\`) {
+		return elem
+	}
+	b := make([]byte, 0, len(elem))
+	for i := 0; i < len(elem); i++ {
+		if elem[i] == '\\' && i+1 < len(elem) {
+			i++
+		}
+		b = append(b, elem[i])
+	}
+	return string(b)
+}
+
+// escapeGlob escapes the metacharacters and backslashes in the path
+// elements of name, so that as a pattern it matches only name itself.
+// The user name is always taken literally and is not escaped.
+func escapeGlob(name upspin.PathName) string {
+	s := string(name)
+	slash := strings.IndexByte(s, '/')
+	const special = `*?[]\`
+	if slash < 0 || !strings.ContainsAny(s[slash:], special) {
+		return s
+	}
+	b := []byte(s[:slash])
+	for i := slash; i < len(s); i++ {
+		if strings.IndexByte(special, s[i]) >= 0 {
+			b = append(b, '\\')
+		}
+		b = append(b, s[i])
+	}
+	return string(b)
+}
+
+// literalPath returns the path name made of the user and the first n
+// elements of p, which contain no unescaped metacharacters, unescaped.
+func literalPath(p path.Parsed, n int) upspin.PathName {
+	elems := make([]string, n)
+	for i := range elems {
+		elems[i] = unescapeGlob(p.Elem(i))
+	}
+	return path.Join(upspin.PathName(p.User()+"/"), elems...)
+}
+
+// glob is the implementation of Glob. It follows serverutil.Glob, walking
+// the tree breadth first from the longest prefix of the pattern without
+// metacharacters, but rather than collecting and sorting the matches it
+// calls emit for each one in the order found. A metacharacter preceded
+// by a backslash matches itself; an element with no unescaped
+// metacharacters is taken literally, without calling the match function.
+// If glob returns an error other than ErrFollowLink, the entries
+// already emitted must be discarded. If emit returns an error, glob
+// stops and returns it.
+func (s *server) glob(pattern string, emit func(*upspin.DirEntry) error) error {
+	// Wait for any writer before starting, within the read timeout.
+	if err := s.db.rlock("dir/inprocess.Glob"); err != nil {
+		return err
+	}
+	s.db.mu.RUnlock()
+	p, err := s.parse(upspin.PathName(pattern))
+	if err != nil {
+		return err
+	}
+
+	// Check every element of the pattern before touching the store, so a
+	// malformed pattern fails at once rather than part way through the
+	// walk. Matching against the empty string reports any syntax error.
+	matchElem := s.globMatchFunc()
+	for i := 0; i < p.NElem(); i++ {
+		elem := p.Elem(i)
+		if !isGlobPattern(elem) {
+			continue
+		}
+		if _, err := matchElem(elem, ""); err != nil {
+			return errors.E(errors.Invalid, errors.Errorf("bad pattern element %q: %v", elem, err))
+		}
+	}
+
+	// If there are no glob meta-characters in the pattern, just do a lookup.
+	if !isGlobPattern(p.FilePath()) {
+		de, err := s.Lookup(literalPath(p, p.NElem()))
+		if de != nil {
+			if err := emit(de); err != nil {
+				return err
+			}
+		}
+		return err
+	}
+
+	// Look for the longest path prefix that does not contain a
+	// metacharacter, so we know which level we need to start listing.
+	firstMeta := 0
+	for i := 0; i < p.NElem(); i++ {
+		firstMeta = i
+		if isGlobPattern(p.Elem(i)) {
+			break
+		}
+	}
+
+	basePath := literalPath(p, firstMeta)          // Path without the meta component.
+	basePattern := p.First(firstMeta + 1).String() // Pattern including first meta component.
+	elemPattern := p.Elem(firstMeta)               // The meta component alone.
+	patternTail := strings.TrimPrefix(p.String(), basePattern)
+
+	entries, err := s.listDir(basePath)
+	if err == upspin.ErrFollowLink {
+		for _, e := range entries {
+			if err := emit(e); err != nil {
+				return err
+			}
+		}
+		return err
+	}
+	if err != nil {
+		return errors.E(basePath, err)
+	}
+
+	var errLink error
+	var toGlob []string // Additional patterns to glob.
+	skipHidden := s.db.unixHidden && !strings.HasPrefix(elemPattern, ".") && !strings.HasPrefix(elemPattern, `\.`)
+	// An entry also matches if one of its aliases does.
+	aliases := make(map[upspin.PathName][]string)
+	for alias, target := range s.db.aliasesIn(basePath) {
+		aliases[target] = append(aliases[target], lastElem(alias))
+	}
+	for _, e := range entries {
+		// Match the last element of the entry name against the meta
+		// component; the entries are those of the directory before it,
+		// so the rest of the name already matches.
+		match := false
+		for _, elem := range append([]string{lastElem(e.Name)}, aliases[e.Name]...) {
+			if skipHidden && strings.HasPrefix(elem, ".") {
+				continue
+			}
+			match, err = matchElem(elemPattern, elem)
+			if err != nil {
+				return errors.E(errors.Invalid, err)
+			}
+			if match {
+				break
+			}
+		}
+		if !match {
+			continue
+		}
+		if patternTail != "" {
+			// If we haven't reached the end of the pattern...
+			if e.IsDir() {
+				// ...and this is a directory, then append the
+				// pattern tail to this name, escaped so it is
+				// taken literally, and add it to the list of
+				// globs yet to try.
+				toGlob = append(toGlob, string(path.Join(upspin.PathName(escapeGlob(e.Name)), patternTail)))
+				continue
+			}
+			if !e.IsLink() {
+				// ...and this is not a directory or link,
+				// then it's only a partial match of the full
+				// pattern, so we skip it.
+				continue
+			}
+			// ...and this is a link, we want to emit it as a
+			// result but also return a 'must follow link' error.
+			errLink = upspin.ErrFollowLink
+		}
+		if err := emit(e); err != nil {
+			return err
+		}
+	}
+
+	// Perform any additional glob operations recursively.
+	// A recursive glob can only fail with a restricted access error
+	// before it has emitted anything, so its matches can be passed
+	// straight through.
+	for _, pattern := range toGlob {
+		err := s.glob(pattern, emit)
+		if errors.Match(errPrivate, err) ||
+			errors.Match(errPermission, err) ||
+			errors.Match(notExist, err) {
+			// Ignore paths when access is restricted.
+			continue
+		}
+		if err == upspin.ErrFollowLink {
+			errLink = err
+		} else if err != nil {
+			return err
+		}
+	}
+	return errLink
+}
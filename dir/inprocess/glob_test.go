@@ -0,0 +1,643 @@
+// Copyright 2017 The Upspin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package inprocess
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"upspin.io/errors"
+	"upspin.io/upspin"
+)
+
+// globTree builds a small tree for the glob tests and returns the user name.
+func globTree(t *testing.T) (upspin.Config, upspin.DirServer) {
+	config, dir := setup()
+	user := config.UserName()
+	for _, name := range []string{"/a", "/a/b", "/c", "/c/d"} {
+		if _, err := makeDirectory(dir, upspin.PathName(user)+upspin.PathName(name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, name := range []string{"/f1", "/a/f2", "/a/b/f3", "/c/f4", "/c/d/f5"} {
+		entry := storeData(t, config, []byte(name), upspin.PathName(user)+upspin.PathName(name))
+		if _, err := dir.Put(entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+	link, err := newDirEntry(config, upspin.PlainPack, upspin.PathName(user+"/link"), nil, upspin.AttrLink, upspin.PathName(user+"/a"), upspin.SeqIgnore)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dir.Put(link); err != nil {
+		t.Fatal(err)
+	}
+	return config, dir
+}
+
+func TestGlobCount(t *testing.T) {
+	config, dir := globTree(t)
+	s := dir.(*server)
+	user := string(config.UserName())
+	for _, pattern := range []string{
+		"/*", "/*/*", "/*/*/*", "/a/*", "/?", "/f*", "/*/f*", "/a/b/f3", "/nothing", "/*/nothing",
+	} {
+		entries, globErr := dir.Glob(user + pattern)
+		n, countErr := s.GlobCount(user + pattern)
+		if (globErr == nil) != (countErr == nil) {
+			t.Errorf("%s: Glob error %v; GlobCount error %v", pattern, globErr, countErr)
+			continue
+		}
+		if n != len(entries) {
+			t.Errorf("%s: GlobCount = %d; len(Glob) = %d", pattern, n, len(entries))
+		}
+	}
+}
+
+func TestGlobUnsorted(t *testing.T) {
+	config, dir := globTree(t)
+	s := dir.(*server)
+	user := string(config.UserName())
+	for _, pattern := range []string{"/*", "/*/*", "/*/*/*", "/*/f*", "/a/b/f3", "/nothing"} {
+		sorted, sortedErr := dir.Glob(user + pattern)
+		unsorted, unsortedErr := s.GlobUnsorted(user + pattern)
+		if (sortedErr == nil) != (unsortedErr == nil) {
+			t.Errorf("%s: Glob error %v; GlobUnsorted error %v", pattern, sortedErr, unsortedErr)
+			continue
+		}
+		upspin.SortDirEntries(unsorted, false)
+		if !reflect.DeepEqual(entryNames(sorted), entryNames(unsorted)) {
+			t.Errorf("%s: GlobUnsorted found %q; Glob found %q", pattern, entryNames(unsorted), entryNames(sorted))
+		}
+	}
+}
+
+func TestGlobMatchFunc(t *testing.T) {
+	config, dir := globTree(t)
+	s := dir.(*server)
+	user := string(config.UserName())
+
+	// A matcher that treats "~x*" as "contains x".
+	var calls []string
+	s.SetGlobMatchFunc(func(pattern, name string) (bool, error) {
+		calls = append(calls, pattern+" "+name)
+		return strings.Contains(name, strings.Trim(pattern, "~*")), nil
+	})
+	// The ~ is not a metacharacter, so the "*" makes this element a pattern.
+	entries, err := dir.Glob(user + "/a/~f*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Name != upspin.PathName(user+"/a/f2") {
+		t.Errorf("Glob returned %v; want only %s/a/f2", entries, user)
+	}
+	for _, c := range calls {
+		if strings.Contains(c, "/") {
+			t.Errorf("match called with %q; want single elements", c)
+		}
+	}
+
+	// Resetting restores path.Match.
+	s.SetGlobMatchFunc(nil)
+	entries, err = dir.Glob(user + "/a/~f*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("Glob with default match returned %v; want nothing", entries)
+	}
+}
+
+func TestGlobEscaped(t *testing.T) {
+	config, dir := setup()
+	user := string(config.UserName())
+	for _, name := range []string{"/a*b", "/axb", "/[x]", "/x", "/d?r", "/d?r/f", "/dxr", "/dxr/f"} {
+		pathName := upspin.PathName(user + name)
+		var err error
+		if strings.HasPrefix(name, "/d") && !strings.Contains(name[1:], "/") {
+			_, err = makeDirectory(dir, pathName)
+		} else {
+			_, err = dir.Put(storeData(t, config, []byte(name), pathName))
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, test := range []struct {
+		pattern string
+		want    []string
+	}{
+		{`/a*b`, []string{"/a*b", "/axb"}},
+		{`/a\*b`, []string{"/a*b"}},
+		{`/\[x\]`, []string{"/[x]"}},
+		{`/[x]`, []string{"/x"}},
+		{`/d\?r/*`, []string{"/d?r/f"}},
+		{`/d?r/*`, []string{"/d?r/f", "/dxr/f"}},
+		{`/*/f`, []string{"/d?r/f", "/dxr/f"}},
+	} {
+		entries, err := dir.Glob(user + test.pattern)
+		if err != nil {
+			t.Errorf("%s: %v", test.pattern, err)
+			continue
+		}
+		var got []string
+		for _, e := range entries {
+			got = append(got, strings.TrimPrefix(string(e.Name), user))
+		}
+		if strings.Join(got, " ") != strings.Join(test.want, " ") {
+			t.Errorf("Glob(%s) = %q; want %q", test.pattern, got, test.want)
+		}
+	}
+}
+
+func TestFilter(t *testing.T) {
+	config, dir := globTree(t)
+	s := dir.(*server)
+	user := string(config.UserName())
+
+	entries, err := s.Filter(upspin.PathName(user+"/"), (*upspin.DirEntry).IsDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, e := range entries {
+		got = append(got, strings.TrimPrefix(string(e.Name), user))
+	}
+	if want := "/a /c"; strings.Join(got, " ") != want {
+		t.Errorf("Filter(IsDir) = %q; want %q", got, want)
+	}
+
+	entries, err = s.Filter(upspin.PathName(user+"/a"), func(e *upspin.DirEntry) bool { return false })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("Filter(false) = %v; want nothing", entries)
+	}
+
+	entries, err = s.Filter(upspin.PathName(user+"/link/b"), func(*upspin.DirEntry) bool { return true })
+	if err != upspin.ErrFollowLink {
+		t.Fatalf("Filter through link: err = %v; want ErrFollowLink", err)
+	}
+	if len(entries) != 1 || !entries[0].IsLink() {
+		t.Errorf("Filter through link returned %v; want the link", entries)
+	}
+}
+
+func TestGlobStream(t *testing.T) {
+	config, dir := globTree(t)
+	s := dir.(*server)
+	user := string(config.UserName())
+
+	for _, pattern := range []string{"/*", "/[ac]/*", "/[ac]/*/f*", "/[ac]/nothing", "/a/f2"} {
+		want, err := dir.Glob(user + pattern)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ch, err := s.GlobStream(context.Background(), user+pattern)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []*upspin.DirEntry
+		for r := range ch {
+			if r.Err != nil {
+				t.Fatalf("%s: %v", pattern, r.Err)
+			}
+			got = append(got, r.Entry)
+		}
+		upspin.SortDirEntries(got, false)
+		if len(got) != len(want) {
+			t.Errorf("%s: GlobStream sent %d entries; Glob returned %d", pattern, len(got), len(want))
+			continue
+		}
+		for i := range got {
+			if got[i].Name != want[i].Name {
+				t.Errorf("%s: entry %d is %s; want %s", pattern, i, got[i].Name, want[i].Name)
+			}
+		}
+	}
+
+	// A link in the way ends the stream with ErrFollowLink.
+	ch, err := s.GlobStream(context.Background(), user+"/link/*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var last GlobResult
+	n := 0
+	for r := range ch {
+		last = r
+		n++
+	}
+	if n != 2 || last.Err != upspin.ErrFollowLink {
+		t.Errorf("GlobStream through link: sent %d values, last error %v; want 2 and ErrFollowLink", n, last.Err)
+	}
+
+	// Canceling stops the walk and closes the channel.
+	ctx, cancel := context.WithCancel(context.Background())
+	ch, err = s.GlobStream(ctx, user+"/[ac]/*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r := <-ch; r.Entry == nil {
+		t.Fatalf("first value is %v; want an entry", r)
+	}
+	cancel()
+	for range ch {
+	}
+
+	if _, err := s.GlobStream(context.Background(), "not-a-user/*"); err == nil {
+		t.Error("GlobStream of bad pattern succeeded")
+	}
+}
+
+func TestReadDir(t *testing.T) {
+	config, dir := globTree(t)
+	s := dir.(*server)
+	user := string(config.UserName())
+
+	for _, test := range []struct {
+		dir  string
+		want string
+	}{
+		{"/", "/a /c /f1 /link"},
+		{"/a", "/a/b /a/f2"},
+		{"/a/b", "/a/b/f3"},
+	} {
+		entries, err := s.ReadDir(upspin.PathName(user + test.dir))
+		if err != nil {
+			t.Errorf("ReadDir(%s): %v", test.dir, err)
+			continue
+		}
+		var got []string
+		for _, e := range entries {
+			got = append(got, strings.TrimPrefix(string(e.Name), user))
+		}
+		if strings.Join(got, " ") != test.want {
+			t.Errorf("ReadDir(%s) = %q; want %q", test.dir, got, test.want)
+		}
+	}
+
+	if _, err := s.ReadDir(upspin.PathName(user + "/f1")); !errors.Match(errors.E(errors.NotDir), err) {
+		t.Errorf("ReadDir of file: err = %v; want NotDir", err)
+	}
+	if _, err := s.ReadDir(upspin.PathName(user + "/nothing")); !errors.Match(errors.E(errors.NotExist), err) {
+		t.Errorf("ReadDir of missing directory: err = %v; want NotExist", err)
+	}
+	if _, err := s.ReadDir(upspin.PathName(user + "/link")); err != upspin.ErrFollowLink {
+		t.Errorf("ReadDir of link: err = %v; want ErrFollowLink", err)
+	}
+}
+
+func TestGlobUnixHidden(t *testing.T) {
+	config, dir := setup()
+	s := dir.(*server)
+	user := string(config.UserName())
+	if _, err := makeDirectory(dir, upspin.PathName(user+"/.dir")); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"/.hidden", "/visible", "/.dir/f", "/.dir/.g"} {
+		if _, err := dir.Put(storeData(t, config, []byte(name), upspin.PathName(user+name))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, test := range []struct {
+		pattern     string
+		off, hidden string
+	}{
+		{"/*", "/.dir /.hidden /visible", "/visible"},
+		{"/?hidden", "/.hidden", ""},
+		{"/.*", "/.dir /.hidden", "/.dir /.hidden"},
+		{`/\.h*`, "/.hidden", "/.hidden"},
+		{"/.dir/*", "/.dir/.g /.dir/f", "/.dir/f"},
+		{"/*/f", "/.dir/f", ""},
+		{"/.hidden", "/.hidden", "/.hidden"},
+	} {
+		for _, unixHidden := range []bool{false, true} {
+			s.db.unixHidden = unixHidden
+			want := test.off
+			if unixHidden {
+				want = test.hidden
+			}
+			entries, err := dir.Glob(user + test.pattern)
+			if err != nil {
+				t.Errorf("%s: %v", test.pattern, err)
+				continue
+			}
+			var got []string
+			for _, e := range entries {
+				got = append(got, strings.TrimPrefix(string(e.Name), user))
+			}
+			if strings.Join(got, " ") != want {
+				t.Errorf("unixHidden=%t: Glob(%s) = %q; want %q", unixHidden, test.pattern, got, want)
+			}
+		}
+	}
+}
+
+func TestReadDirSorted(t *testing.T) {
+	config, dir := setup()
+	s := dir.(*server)
+	user := string(config.UserName())
+	// Files with sizes and times in different orders from their names.
+	for i, f := range []struct {
+		name string
+		size int
+		time upspin.Time
+	}{
+		{"/a", 30, 200},
+		{"/b", 10, 300},
+		{"/c", 20, 100},
+		{"/d", 10, 100},
+	} {
+		name := upspin.PathName(user + f.name)
+		entry, err := newDirEntryAt(config, upspin.PlainPack, name, []byte(strings.Repeat("x", f.size)), upspin.AttrNone, "", upspin.SeqIgnore, f.time)
+		if err != nil {
+			t.Fatal(i, err)
+		}
+		if _, err := dir.Put(entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, test := range []struct {
+		by   SortKey
+		desc bool
+		want string
+	}{
+		{SortByName, false, "/a /b /c /d"},
+		{SortByName, true, "/d /c /b /a"},
+		{SortByTime, false, "/c /d /a /b"},
+		{SortByTime, true, "/b /a /c /d"},
+		{SortBySize, false, "/b /d /c /a"},
+		{SortBySize, true, "/a /c /b /d"},
+	} {
+		entries, err := s.ReadDirSorted(upspin.PathName(user+"/"), test.by, test.desc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, e := range entries {
+			got = append(got, strings.TrimPrefix(string(e.Name), user))
+		}
+		if strings.Join(got, " ") != test.want {
+			t.Errorf("ReadDirSorted(%d, %t) = %q; want %q", test.by, test.desc, got, test.want)
+		}
+	}
+	if _, err := s.ReadDirSorted(upspin.PathName(user+"/"), SortKey(99), false); !errors.Match(errors.E(errors.Invalid), err) {
+		t.Errorf("ReadDirSorted with bad key: err = %v; want Invalid", err)
+	}
+}
+
+func TestGlobBySize(t *testing.T) {
+	config, dir := setup()
+	s := dir.(*server)
+	user := string(config.UserName())
+	if _, err := makeDirectory(dir, upspin.PathName(user+"/dir")); err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range []struct {
+		name string
+		size int
+	}{
+		{"/dir/tiny", 1},
+		{"/dir/small", 10},
+		{"/dir/medium", 100},
+		{"/dir/large", 1000},
+	} {
+		name := upspin.PathName(user + f.name)
+		entry, err := newDirEntry(config, upspin.PlainPack, name, []byte(strings.Repeat("x", f.size)), upspin.AttrNone, "", upspin.SeqIgnore)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := dir.Put(entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, test := range []struct {
+		min, max uint64
+		want     string
+	}{
+		{0, 0, "/dir/large /dir/medium /dir/small /dir/tiny"},
+		{10, 100, "/dir/medium /dir/small"},
+		{11, 0, "/dir/large /dir/medium"},
+		{2, 10, "/dir/small"},
+		{1, 10, "/dir/small /dir/tiny"},
+		{2000, 0, ""},
+	} {
+		entries, err := s.GlobBySize(user+"/dir/*", test.min, test.max)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, e := range entries {
+			got = append(got, strings.TrimPrefix(string(e.Name), user))
+		}
+		if strings.Join(got, " ") != test.want {
+			t.Errorf("GlobBySize(%d, %d) = %q; want %q", test.min, test.max, got, test.want)
+		}
+	}
+}
+
+func TestGlobUsers(t *testing.T) {
+	config, dir := setup()
+	s := dir.(*server)
+	user := config.UserName()
+	if _, err := dir.Put(storeData(t, config, []byte("mine"), upspin.PathName(user+"/a.txt"))); err != nil {
+		t.Fatal(err)
+	}
+	// Two more users served by the same directory, the first of whom
+	// grants us read and list rights.
+	var others [2]upspin.UserName
+	for i := range others {
+		other := nextUser()
+		otherConfig, key, _, _ := newConfigAndServices(other)
+		if err := key.Put(&upspin.User{
+			Name:      other,
+			Dirs:      []upspin.Endpoint{otherConfig.DirEndpoint()},
+			Stores:    []upspin.Endpoint{otherConfig.StoreEndpoint()},
+			PublicKey: otherConfig.Factotum().PublicKey(),
+		}); err != nil {
+			t.Fatal(err)
+		}
+		svc, err := s.Dial(otherConfig, s.Endpoint())
+		if err != nil {
+			t.Fatal(err)
+		}
+		otherDir := svc.(upspin.DirServer)
+		otherRoot := upspin.PathName(other + "/")
+		if _, err := makeDirectory(otherDir, otherRoot); err != nil {
+			t.Fatal(err)
+		}
+		if i == 0 {
+			accessFile := storePlainWithIntegrity(t, otherConfig, []byte(fmt.Sprintf("*: %s\nread, list: %s\n", other, user)), otherRoot+"Access")
+			if _, err := otherDir.Put(accessFile); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if _, err := otherDir.Put(storeData(t, otherConfig, []byte("theirs"), otherRoot+"b.txt")); err != nil {
+			t.Fatal(err)
+		}
+		others[i] = other
+	}
+
+	names := func(m map[upspin.UserName][]*upspin.DirEntry) map[upspin.UserName]string {
+		got := make(map[upspin.UserName]string)
+		for u, entries := range m {
+			for _, e := range entries {
+				got[u] += strings.TrimPrefix(string(e.Name), string(u))
+			}
+		}
+		return got
+	}
+	for _, test := range []struct {
+		users   []upspin.UserName
+		pattern string
+		want    map[upspin.UserName]string
+	}{
+		{nil, "*@google.com/*.txt", map[upspin.UserName]string{user: "/a.txt", others[0]: "/b.txt"}},
+		{nil, "*@google.com/a*", map[upspin.UserName]string{user: "/a.txt"}},
+		{[]upspin.UserName{others[0], others[1]}, "*/*.txt", map[upspin.UserName]string{others[0]: "/b.txt"}},
+		{nil, string(others[0]) + "/*", map[upspin.UserName]string{others[0]: "/Access/b.txt"}},
+	} {
+		m, err := s.GlobUsers(test.users, test.pattern)
+		if err != nil {
+			t.Errorf("GlobUsers(%v, %q): %v", test.users, test.pattern, err)
+			continue
+		}
+		if got := names(m); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("GlobUsers(%v, %q) = %v; want %v", test.users, test.pattern, got, test.want)
+		}
+	}
+	if _, err := s.GlobUsers(nil, "[/*"); !errors.Match(errors.E(errors.Invalid), err) {
+		t.Errorf("GlobUsers with bad user pattern: err = %v; want Invalid", err)
+	}
+}
+
+func TestGlobType(t *testing.T) {
+	config, dir := globTree(t)
+	s := dir.(*server)
+	user := string(config.UserName())
+	for _, test := range []struct {
+		pattern string
+		want    EntryType
+		names   string
+	}{
+		{"/*", AnyType, "/a /c /f1 /link"},
+		{"/*", DirType, "/a /c /link"},
+		{"/*", FileType, "/f1 /link"},
+		{"/[ac]/*", DirType, "/a/b /c/d"},
+		{"/[ac]/*", FileType, "/a/f2 /c/f4"},
+	} {
+		entries, err := s.GlobType(user+test.pattern, test.want)
+		if err != nil && err != upspin.ErrFollowLink {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, e := range entries {
+			got = append(got, strings.TrimPrefix(string(e.Name), user))
+		}
+		if strings.Join(got, " ") != test.names {
+			t.Errorf("GlobType(%q, %d) = %q; want %q", test.pattern, test.want, got, test.names)
+		}
+	}
+}
+
+func TestGlobFilter(t *testing.T) {
+	config, dir := globTree(t)
+	s := dir.(*server)
+	user := string(config.UserName())
+	none := func(*upspin.DirEntry) bool { return false }
+	digit := func(e *upspin.DirEntry) bool {
+		last := e.Name[len(e.Name)-1]
+		return '0' <= last && last <= '9'
+	}
+	for _, test := range []struct {
+		pattern string
+		pred    func(*upspin.DirEntry) bool
+		names   string
+	}{
+		{"/*", none, "/link"},
+		{"/*", digit, "/f1 /link"},
+		{"/[ac]/*", digit, "/a/f2 /c/f4"},
+		{"/[ac]/*", (*upspin.DirEntry).IsDir, "/a/b /c/d"},
+	} {
+		entries, err := s.GlobFilter(user+test.pattern, test.pred)
+		if err != nil && err != upspin.ErrFollowLink {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, e := range entries {
+			got = append(got, strings.TrimPrefix(string(e.Name), user))
+		}
+		if strings.Join(got, " ") != test.names {
+			t.Errorf("GlobFilter(%q) = %q; want %q", test.pattern, got, test.names)
+		}
+	}
+	// The predicate sees only the matches.
+	calls := 0
+	if _, err := s.GlobFilter(user+"/[ac]/*", func(*upspin.DirEntry) bool { calls++; return true }); err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := s.GlobCount(user + "/[ac]/*"); calls != n {
+		t.Errorf("predicate called %d times; want %d", calls, n)
+	}
+}
+
+func TestGlobBadPattern(t *testing.T) {
+	config, dir := globTree(t)
+	s := dir.(*server)
+	user := string(config.UserName())
+	for _, pattern := range []string{"/[a-", "/*/[a-", "/a/*/x[", "/c/d/f[]"} {
+		var err error
+		n := s.WithStoreCounter(func() {
+			_, err = dir.Glob(user + pattern)
+		})
+		if !errors.Match(errors.E(errors.Invalid), err) {
+			t.Errorf("Glob(%q): err = %v; want Invalid", pattern, err)
+		}
+		if n != 0 {
+			t.Errorf("Glob(%q): %d store operations before failing; want 0", pattern, n)
+		}
+	}
+}
+
+func TestGlobChildCounts(t *testing.T) {
+	config, dir := globTree(t)
+	s := dir.(*server)
+	user := string(config.UserName())
+	if _, err := makeDirectory(dir, upspin.PathName(user+"/a/empty")); err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		pattern string
+		counts  map[string]int
+	}{
+		{"/[ac]", map[string]int{"/a": 3, "/c": 2}},
+		{"/a/*", map[string]int{"/a/b": 1, "/a/empty": 0}},
+		{"/c/d/*", map[string]int{}},
+	} {
+		entries, counts, err := s.GlobChildCounts(user + test.pattern)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want, err := dir.Glob(user + test.pattern)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(entries) != len(want) {
+			t.Errorf("GlobChildCounts(%q) found %v; want %v", test.pattern, entryNames(entries), entryNames(want))
+		}
+		if len(counts) != len(test.counts) {
+			t.Errorf("GlobChildCounts(%q) counts = %v; want %v", test.pattern, counts, test.counts)
+		}
+		for name, n := range test.counts {
+			if got, ok := counts[upspin.PathName(user+name)]; !ok || got != n {
+				t.Errorf("GlobChildCounts(%q): count for %s = %d, %t; want %d", test.pattern, name, got, ok, n)
+			}
+		}
+	}
+}
@@ -0,0 +1,84 @@
+// Copyright 2017 The Upspin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package inprocess
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	goPath "path"
+	"strings"
+
+	"upspin.io/errors"
+	"upspin.io/path"
+	"upspin.io/upspin"
+)
+
+// GraphViz writes the shape of the user's tree to w in the DOT language
+// of Graphviz, for debugging. Each directory is a box labeled with its
+// name and the reference under which its data is stored, which changes
+// whenever anything below it does; each file is an ellipse and each link
+// a dashed ellipse naming its target. An edge joins each directory to
+// each of its entries. The tree is read under one read lock, so the
+// graph shows it at a single moment. Only the user may do this.
+func (s *server) GraphViz(userName upspin.UserName, w io.Writer) error {
+	const op = "dir/inprocess.GraphViz"
+	userName = normalizeUser(userName, s.db.foldLocal)
+	if normalizeUser(s.config.UserName(), s.db.foldLocal) != userName {
+		return errors.E(op, userName, errors.Permission)
+	}
+
+	if err := s.db.rlock(op); err != nil {
+		return err
+	}
+	defer s.db.mu.RUnlock()
+	root, ok := s.db.root[userName]
+	if !ok {
+		return errors.E(op, userName, errors.NotExist, errors.Str("no such user"))
+	}
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "digraph %s {\n", dotQuote(string(userName)))
+	dotNode(bw, root, string(root.Name))
+	err := s.walkTree(root, func(entry *upspin.DirEntry) error {
+		dotNode(bw, entry, goPath.Base(string(entry.Name)))
+		fmt.Fprintf(bw, "\t%s -> %s;\n", dotQuote(string(path.DropPath(entry.Name, 1))), dotQuote(string(entry.Name)))
+		return nil
+	})
+	if err != nil {
+		return errors.E(op, err)
+	}
+	fmt.Fprintf(bw, "}\n")
+	if err := bw.Flush(); err != nil {
+		return errors.E(op, userName, errors.IO, err)
+	}
+	return nil
+}
+
+// dotNode writes the DOT statement for the entry's node, labeled with
+// the given text. The node's ID is the entry's name.
+func dotNode(w io.Writer, entry *upspin.DirEntry, label string) {
+	attrs := "shape=ellipse"
+	switch {
+	case entry.IsDir():
+		attrs = "shape=box"
+		var ref upspin.Reference
+		if len(entry.Blocks) > 0 {
+			ref = entry.Blocks[0].Location.Reference
+		}
+		label += "\n" + string(ref)
+	case entry.IsLink():
+		attrs = "shape=ellipse, style=dashed"
+		label += " -> " + string(entry.Link)
+	}
+	fmt.Fprintf(w, "\t%s [%s, label=%s];\n", dotQuote(string(entry.Name)), attrs, dotQuote(label))
+}
+
+// dotQuoter escapes the characters that are special in a DOT string.
+var dotQuoter = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// dotQuote returns s as a quoted DOT string.
+func dotQuote(s string) string {
+	return `"` + dotQuoter.Replace(s) + `"`
+}
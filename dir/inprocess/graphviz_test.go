@@ -0,0 +1,65 @@
+// Copyright 2017 The Upspin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package inprocess
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"upspin.io/errors"
+	"upspin.io/upspin"
+)
+
+func TestGraphViz(t *testing.T) {
+	config, dir := setup()
+	s := dir.(*server)
+	user := config.UserName()
+	root := upspin.PathName(user + "/")
+	if _, err := makeDirectory(dir, root+"dir"); err != nil {
+		t.Fatal(err)
+	}
+	quoted := root + `dir/say "hi"`
+	if _, err := dir.Put(storeData(t, config, []byte("hi"), quoted)); err != nil {
+		t.Fatal(err)
+	}
+	link, err := newDirEntry(config, upspin.PlainPack, root+"link", nil, upspin.AttrLink, root+"dir", upspin.SeqIgnore)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dir.Put(link); err != nil {
+		t.Fatal(err)
+	}
+	dirEntry, err := dir.Lookup(root + "dir")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := s.GraphViz(user, &buf); err != nil {
+		t.Fatal(err)
+	}
+	got := buf.String()
+	for _, want := range []string{
+		`digraph "` + string(user) + `" {`,
+		`"` + string(root) + `dir" [shape=box, label="dir\n` + string(dirEntry.Blocks[0].Location.Reference) + `"];`,
+		`"` + string(root) + `dir/say \"hi\"" [shape=ellipse, label="say \"hi\""];`,
+		`"` + string(root) + `link" [shape=ellipse, style=dashed, label="link -> ` + string(root) + `dir"];`,
+		`"` + string(root) + `" -> "` + string(root) + `dir";`,
+		`"` + string(root) + `dir" -> "` + string(root) + `dir/say \"hi\"";`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("graph lacks %s:\n%s", want, got)
+		}
+	}
+	if !strings.HasSuffix(got, "}\n") {
+		t.Errorf("graph is not closed:\n%s", got)
+	}
+
+	other := upspin.UserName("other@example.com")
+	if err := s.GraphViz(other, &buf); !errors.Match(errors.E(errors.Permission), err) {
+		t.Errorf("GraphViz of another user: err = %v; want Permission", err)
+	}
+}
@@ -0,0 +1,118 @@
+// Copyright 2017 The Upspin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package inprocess
+
+import (
+	"upspin.io/access"
+	"upspin.io/errors"
+	"upspin.io/upspin"
+)
+
+// PutHardLink installs at newName a new entry that refers to the same
+// blocks, packing and metadata as the existing file at existingName.
+// The data is then live until every name referring to it has been deleted.
+// Unlike a link, the new entry is a regular file and is not evaluated.
+// newName must not already exist.
+func (s *server) PutHardLink(newName, existingName upspin.PathName) error {
+	const op = "dir/inprocess.PutHardLink"
+	existingParsed, err := s.parse(existingName)
+	if err != nil {
+		return errors.E(op, err)
+	}
+	parsed, err := s.parse(newName)
+	if err != nil {
+		return errors.E(op, err)
+	}
+	existing, err := s.lookup(op, existingParsed, true)
+	if err != nil {
+		_, err = s.errLink(op, existing, err)
+		return err
+	}
+	if existing.IsDir() {
+		return errors.E(op, existingName, errors.IsDir)
+	}
+	canRead, err := s.can(access.Read, existingParsed)
+	if err != nil {
+		return errors.E(op, err)
+	}
+	if !canRead {
+		return s.errPerm(op, existingParsed)
+	}
+	if access.IsAccessFile(parsed.Path()) || access.IsGroupFile(parsed.Path()) {
+		return errors.E(op, newName, errors.Invalid, errors.Str("cannot hard link an Access or Group file"))
+	}
+	if e, err := s.canPut(op, parsed, false); err != nil {
+		_, err = s.errLink(op, e, err)
+		return err
+	}
+
+	// The signature covers SignedName, so keep it and change only Name.
+	entry := existing.Copy()
+	entry.Name = parsed.Path()
+	entry.Sequence = upspin.SeqNotExist
+
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	if s.db.readOnly {
+		return errors.E(op, newName, errors.Permission, errReadOnly)
+	}
+	entry, err = s.put(op, entry, parsed, false)
+	if err != nil {
+		return err
+	}
+	s.db.eventMgr.newEvent <- upspin.Event{
+		Entry: entry,
+	}
+	return nil
+}
+
+// ref records a new name referring to the blocks of the entry, and adds
+// its size to the totals. s.db.mu is held.
+func (db *database) ref(entry *upspin.DirEntry) {
+	if entry == nil || !entry.IsRegular() {
+		return
+	}
+	for _, b := range entry.Blocks {
+		db.refs[b.Location.Reference]++
+	}
+	db.addBytes(entry.Name, dataSize(entry))
+}
+
+// unref removes a name referring to the blocks of the entry. A block whose
+// count drops to zero is forgotten, as is what is recorded about its data
+// unless a kept history entry refers to it; it is then orphaned in the
+// store. The entry's size is taken from the totals. s.db.mu is held.
+func (db *database) unref(entry *upspin.DirEntry) {
+	if entry == nil || !entry.IsRegular() {
+		return
+	}
+	db.addBytes(entry.Name, -dataSize(entry))
+	for _, b := range entry.Blocks {
+		ref := b.Location.Reference
+		db.refs[ref]--
+		if db.refs[ref] <= 0 {
+			delete(db.refs, ref)
+			db.forgetData(ref)
+		}
+	}
+}
+
+// forgetData forgets what is recorded about the data whose first block
+// is ref, whether it is compressed and its checksum, unless a name or a
+// kept history entry still refers to it. s.db.mu is held.
+func (db *database) forgetData(ref upspin.Reference) {
+	if db.refs[ref] > 0 || db.inHistory(ref) {
+		return
+	}
+	delete(db.compressed, ref)
+	delete(db.checksums, ref)
+}
+
+// orphaned reports whether no name refers to the reference.
+func (db *database) orphaned(ref upspin.Reference) bool {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return db.refs[ref] == 0
+}
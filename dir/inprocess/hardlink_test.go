@@ -0,0 +1,68 @@
+// Copyright 2017 The Upspin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package inprocess
+
+import (
+	"testing"
+
+	"upspin.io/errors"
+	"upspin.io/upspin"
+)
+
+func TestPutHardLink(t *testing.T) {
+	config, dir := setup()
+	s := dir.(*server)
+	user := config.UserName()
+	fileName := upspin.PathName(user + "/file")
+	linkName := upspin.PathName(user + "/hardlink")
+	const text = "shared data"
+
+	entry := storeData(t, config, []byte(text), fileName)
+	if _, err := dir.Put(entry); err != nil {
+		t.Fatal(err)
+	}
+	ref := entry.Blocks[0].Location.Reference
+	if err := s.PutHardLink(linkName, fileName); err != nil {
+		t.Fatal(err)
+	}
+	if got := s.db.refs[ref]; got != 2 {
+		t.Fatalf("refcount = %d; want 2", got)
+	}
+	// A second hard link to the same name must fail.
+	if err := s.PutHardLink(linkName, fileName); !errors.Match(errors.E(errors.Exist), err) {
+		t.Fatalf("second PutHardLink: err = %v; want Exist", err)
+	}
+
+	linkEntry, err := dir.Lookup(linkName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if linkEntry.Blocks[0].Location != entry.Blocks[0].Location {
+		t.Fatalf("hard link location = %v; want %v", linkEntry.Blocks[0].Location, entry.Blocks[0].Location)
+	}
+
+	// Deleting the original leaves the data live through the link.
+	if _, err := dir.Delete(fileName); err != nil {
+		t.Fatal(err)
+	}
+	if s.db.orphaned(ref) {
+		t.Fatal("block orphaned while a hard link remains")
+	}
+	data, err := readAll(config, linkEntry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != text {
+		t.Fatalf("hard link data = %q; want %q", data, text)
+	}
+
+	// Deleting the last name orphans it.
+	if _, err := dir.Delete(linkName); err != nil {
+		t.Fatal(err)
+	}
+	if !s.db.orphaned(ref) {
+		t.Fatal("block not orphaned after last name deleted")
+	}
+}
@@ -0,0 +1,172 @@
+// Copyright 2017 The Upspin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package inprocess
+
+// File history.
+//
+// The store never forgets data, so the data of a file that has been
+// replaced can still be read through the replaced entry. If the history
+// option is set, the server keeps up to that many replaced entries for
+// each file in db.history, keyed by name, oldest first, and History and
+// GetVersion give access to them. The history of a file is dropped when
+// the file is deleted, including when it is renamed, and moves with
+// MoveTree. The data of the kept entries counts as unreferenced; see
+// hardlink.go.
+
+import (
+	"strings"
+
+	"upspin.io/errors"
+	"upspin.io/upspin"
+)
+
+// VersionInfo describes a version of a file.
+type VersionInfo struct {
+	Sequence int64            // The entry's sequence number.
+	Time     upspin.Time      // The entry's time.
+	Key      upspin.Reference // The reference of the first block, if any.
+	Size     int64            // The size of the data.
+}
+
+// History returns the versions of the named file, oldest first, ending
+// with the current one. Without the history option it returns just the
+// current version. The caller needs read rights for the file.
+func (s *server) History(name upspin.PathName) ([]VersionInfo, error) {
+	const op = "dir/inprocess.History"
+	entry, err := s.readableFile(op, name)
+	if err != nil {
+		return nil, err
+	}
+	s.db.mu.RLock()
+	entries := append(append([]*upspin.DirEntry(nil), s.db.history[entry.Name]...), entry)
+	s.db.mu.RUnlock()
+	versions := make([]VersionInfo, len(entries))
+	for i, e := range entries {
+		size, err := e.Size()
+		if err != nil {
+			return nil, errors.E(op, e.Name, err)
+		}
+		versions[i] = VersionInfo{Sequence: e.Sequence, Time: e.Time, Size: size}
+		if len(e.Blocks) > 0 {
+			versions[i].Key = e.Blocks[0].Location.Reference
+		}
+	}
+	return versions, nil
+}
+
+// GetVersion is like GetData but returns the data of the version of the
+// named file with the given sequence number, which may be the current
+// one or one listed by History.
+func (s *server) GetVersion(name upspin.PathName, sequence int64) ([]byte, error) {
+	const op = "dir/inprocess.GetVersion"
+	entry, err := s.readableFile(op, name)
+	if err != nil {
+		return nil, err
+	}
+	if entry.Sequence != sequence {
+		s.db.mu.RLock()
+		found := false
+		for _, e := range s.db.history[entry.Name] {
+			if e.Sequence == sequence {
+				entry, found = e, true
+				break
+			}
+		}
+		s.db.mu.RUnlock()
+		if !found {
+			return nil, errors.E(op, name, errors.NotExist, errors.Errorf("no version %d", sequence))
+		}
+	}
+	data, err := s.readData(entry)
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+	return data, nil
+}
+
+// readableFile returns the entry for the named file, which the caller
+// must be able to read.
+func (s *server) readableFile(op string, name upspin.PathName) (*upspin.DirEntry, error) {
+	entry, err := s.Lookup(name)
+	if err == upspin.ErrFollowLink {
+		return nil, err
+	}
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+	if entry.IsDir() {
+		return nil, errors.E(op, entry.Name, errors.IsDir)
+	}
+	if entry.IsIncomplete() {
+		return nil, errors.E(op, entry.Name, errors.Permission)
+	}
+	return entry, nil
+}
+
+// remember adds the replaced entry to the history of its name, if the
+// history option is set. s.db.mu is held.
+func (db *database) remember(prev *upspin.DirEntry) {
+	if db.historySize <= 0 || prev == nil || !prev.IsRegular() {
+		return
+	}
+	h := append(db.history[prev.Name], prev)
+	var dropped []*upspin.DirEntry
+	if len(h) > db.historySize {
+		dropped = h[:len(h)-db.historySize]
+		h = append(h[:0:0], h[len(h)-db.historySize:]...)
+	}
+	db.history[prev.Name] = h
+	db.forgetEntries(dropped)
+}
+
+// dropHistory drops the history of the named file. s.db.mu is held.
+func (db *database) dropHistory(name upspin.PathName) {
+	h := db.history[name]
+	delete(db.history, name)
+	db.forgetEntries(h)
+}
+
+// forgetEntries forgets what is recorded about the data of the entries,
+// which are no longer kept, if nothing else refers to it. s.db.mu is held.
+func (db *database) forgetEntries(entries []*upspin.DirEntry) {
+	for _, e := range entries {
+		for _, b := range e.Blocks {
+			db.forgetData(b.Location.Reference)
+		}
+	}
+}
+
+// inHistory reports whether a kept history entry refers to the
+// reference. s.db.mu is held.
+func (db *database) inHistory(ref upspin.Reference) bool {
+	for _, h := range db.history {
+		for _, e := range h {
+			for _, b := range e.Blocks {
+				if b.Location.Reference == ref {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// moveHistory moves the histories of the files under oldPrefix to be
+// under newPrefix. s.db.mu is held.
+func (db *database) moveHistory(oldPrefix, newPrefix string) {
+	for name, h := range db.history {
+		if !strings.HasPrefix(string(name), oldPrefix) {
+			continue
+		}
+		delete(db.history, name)
+		newName := upspin.PathName(newPrefix + strings.TrimPrefix(string(name), oldPrefix))
+		moved := make([]*upspin.DirEntry, len(h))
+		for i, e := range h {
+			moved[i] = e.Copy()
+			moved[i].Name = newName
+		}
+		db.history[newName] = moved
+	}
+}
@@ -0,0 +1,93 @@
+// Copyright 2017 The Upspin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package inprocess
+
+import (
+	"strings"
+	"testing"
+
+	"upspin.io/errors"
+	"upspin.io/upspin"
+)
+
+func TestHistory(t *testing.T) {
+	config, dir := setup()
+	s := dir.(*server)
+	user := config.UserName()
+	if err := s.db.setOption("history=2"); err != nil {
+		t.Fatal(err)
+	}
+	name := upspin.PathName(user + "/file")
+	var seqs []int64
+	for _, data := range []string{"one", "two", "three", "four"} {
+		entry, err := s.PutReader(name, strings.NewReader(data), upspin.PlainPack, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		seqs = append(seqs, entry.Sequence)
+	}
+
+	versions, err := s.History(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Two replaced versions are kept, along with the current one.
+	if len(versions) != 3 {
+		t.Fatalf("History returned %d versions; want 3", len(versions))
+	}
+	for i, v := range versions {
+		if want := seqs[i+1]; v.Sequence != want {
+			t.Errorf("version %d has sequence %d; want %d", i, v.Sequence, want)
+		}
+	}
+	if v := versions[0]; v.Size != 3 || v.Key == "" {
+		t.Errorf("oldest version is %+v; want size 3 and a key", v)
+	}
+	for i, want := range []string{"two", "three", "four"} {
+		data, err := s.GetVersion(name, seqs[i+1])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != want {
+			t.Errorf("version %d holds %q; want %q", i, data, want)
+		}
+	}
+	if _, err := s.GetVersion(name, seqs[0]); !errors.Match(errors.E(errors.NotExist), err) {
+		t.Errorf("GetVersion of a dropped version: err = %v; want NotExist", err)
+	}
+
+	// Deleting the file drops its history.
+	if _, err := dir.Delete(name); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.PutReader(name, strings.NewReader("five"), upspin.PlainPack, nil); err != nil {
+		t.Fatal(err)
+	}
+	if versions, err := s.History(name); err != nil || len(versions) != 1 {
+		t.Errorf("History after delete = %v, %v; want just the current version", versions, err)
+	}
+
+	if _, err := s.History(upspin.PathName(user + "/")); !errors.Match(errors.E(errors.IsDir), err) {
+		t.Errorf("History of a directory: err = %v; want IsDir", err)
+	}
+}
+
+func TestHistoryOff(t *testing.T) {
+	config, dir := setup()
+	s := dir.(*server)
+	name := upspin.PathName(config.UserName() + "/file")
+	for _, data := range []string{"one", "two"} {
+		if _, err := s.PutReader(name, strings.NewReader(data), upspin.PlainPack, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	versions, err := s.History(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != 1 {
+		t.Errorf("History without the option returned %d versions; want 1", len(versions))
+	}
+}
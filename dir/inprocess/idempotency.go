@@ -0,0 +1,134 @@
+// Copyright 2017 The Upspin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package inprocess
+
+import (
+	"upspin.io/errors"
+	"upspin.io/upspin"
+)
+
+// A client that times out waiting for PutReader cannot tell whether the
+// data was stored, and retrying could store it twice. If it passes an
+// IdempotencyKey in the PutOptions, the server remembers the resulting
+// entry and returns it, without reading or storing anything, when a call
+// with the same key is repeated; a call repeated while the first is still
+// in progress waits for it. Keys are per user and are remembered for
+// idempotencyWindow seconds, for at most idempotencyMax keys at a time.
+
+const (
+	idempotencyWindow = 10 * 60
+	idempotencyMax    = 1000
+)
+
+// idempotencyKey identifies a request.
+type idempotencyKey struct {
+	user upspin.UserName
+	key  string
+}
+
+// idempotencyResult is the result of a request. While the request is
+// in progress entry is nil, and done is closed when it finishes.
+type idempotencyResult struct {
+	name  upspin.PathName
+	entry *upspin.DirEntry
+	time  upspin.Time
+	done  chan struct{}
+}
+
+// idempotencyCache remembers the results of recent requests.
+// It is protected by s.db.mu.
+type idempotencyCache struct {
+	results map[idempotencyKey]idempotencyResult
+	order   []idempotencyKey // Oldest first.
+}
+
+// idempotentResult returns the entry stored by an earlier PutReader
+// of the name with the same key, or nil if there was none. It is an
+// error to use the same key for a different name. If it returns nil,
+// the key is reserved for the caller, which must then call either
+// rememberResult or forgetResult; until it does, calls with the same
+// key wait for it, so a retry made while the first call is still in
+// progress does not store the data again.
+func (s *server) idempotentResult(name upspin.PathName, key string) (*upspin.DirEntry, error) {
+	k := idempotencyKey{s.config.UserName(), key}
+	for {
+		s.db.mu.Lock()
+		c := &s.db.idempotency
+		c.expire(s.db.now())
+		r, ok := c.results[k]
+		if !ok {
+			c.add(k, idempotencyResult{name: name, time: s.db.now(), done: make(chan struct{})})
+			s.db.mu.Unlock()
+			return nil, nil
+		}
+		s.db.mu.Unlock()
+		if r.name != name {
+			return nil, errors.E(name, errors.Invalid, errors.Errorf("idempotency key %q already used for %s", key, r.name))
+		}
+		if r.entry != nil {
+			return r.entry.Copy(), nil
+		}
+		// Wait for the call in progress and look again: if it failed,
+		// the key is free.
+		<-r.done
+	}
+}
+
+// rememberResult records the entry stored by PutReader with the key
+// reserved by idempotentResult.
+func (s *server) rememberResult(key string, entry *upspin.DirEntry) {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	c := &s.db.idempotency
+	k := idempotencyKey{s.config.UserName(), key}
+	r := c.results[k]
+	c.results[k] = idempotencyResult{name: entry.Name, entry: entry.Copy(), time: s.db.now()}
+	close(r.done)
+}
+
+// forgetResult releases the key reserved by idempotentResult for a
+// PutReader that failed, so that it may be retried.
+func (s *server) forgetResult(key string) {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	c := &s.db.idempotency
+	k := idempotencyKey{s.config.UserName(), key}
+	r := c.results[k]
+	delete(c.results, k)
+	for i, o := range c.order {
+		if o == k {
+			c.order = append(c.order[:i], c.order[i+1:]...)
+			break
+		}
+	}
+	close(r.done)
+}
+
+// add records the result of a new request, forgetting the oldest
+// finished ones if there are too many.
+func (c *idempotencyCache) add(k idempotencyKey, r idempotencyResult) {
+	if c.results == nil {
+		c.results = make(map[idempotencyKey]idempotencyResult)
+	}
+	c.results[k] = r
+	c.order = append(c.order, k)
+	for len(c.order) > idempotencyMax && c.results[c.order[0]].entry != nil {
+		delete(c.results, c.order[0])
+		c.order = c.order[1:]
+	}
+}
+
+// expire forgets the finished results older than the window. A request
+// in progress is kept, and with it those after it.
+func (c *idempotencyCache) expire(now upspin.Time) {
+	for len(c.order) > 0 {
+		r := c.results[c.order[0]]
+		if r.entry == nil || r.time+idempotencyWindow > now {
+			break
+		}
+		delete(c.results, c.order[0])
+		c.order = c.order[1:]
+	}
+}
@@ -0,0 +1,156 @@
+// Copyright 2017 The Upspin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package inprocess
+
+import (
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"upspin.io/errors"
+	"upspin.io/upspin"
+)
+
+func TestIdempotencyKey(t *testing.T) {
+	config, dir := setup()
+	s := dir.(*server)
+	user := config.UserName()
+	now := upspin.Now()
+	s.db.now = func() upspin.Time { return now }
+	name := upspin.PathName(user + "/file")
+	opts := &PutOptions{IdempotencyKey: "req-1"}
+
+	first, err := s.PutReader(name, strings.NewReader("first"), upspin.PlainPack, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The retry returns the first result and stores nothing.
+	retry, err := s.PutReader(name, strings.NewReader("retry"), upspin.PlainPack, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if retry.Sequence != first.Sequence {
+		t.Errorf("retry sequence %d; want %d", retry.Sequence, first.Sequence)
+	}
+	data, err := s.GetData(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "first" {
+		t.Errorf("data = %q; want %q", data, "first")
+	}
+
+	// The key can't be used for another name.
+	if _, err := s.PutReader(name+"2", strings.NewReader("x"), upspin.PlainPack, opts); !errors.Match(errors.E(errors.Invalid), err) {
+		t.Errorf("key reused for other name: err = %v; want Invalid", err)
+	}
+
+	// After the window the key is forgotten.
+	now += idempotencyWindow
+	if _, err := s.PutReader(name, strings.NewReader("later"), upspin.PlainPack, opts); err != nil {
+		t.Fatal(err)
+	}
+	data, err = s.GetData(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "later" {
+		t.Errorf("data = %q; want %q", data, "later")
+	}
+}
+
+// blockingReader closes started when first read and then returns its
+// data once release is closed.
+type blockingReader struct {
+	started, release chan struct{}
+	read             bool
+	r                io.Reader
+}
+
+func (b *blockingReader) Read(p []byte) (int, error) {
+	if !b.read {
+		b.read = true
+		close(b.started)
+		<-b.release
+	}
+	return b.r.Read(p)
+}
+
+// errReader fails every read.
+type errReader struct{}
+
+func (errReader) Read([]byte) (int, error) { return 0, errors.Str("read failed") }
+
+func TestIdempotencyKeyConcurrent(t *testing.T) {
+	config, dir := setup()
+	s := dir.(*server)
+	user := config.UserName()
+	name := upspin.PathName(user + "/file")
+	opts := &PutOptions{IdempotencyKey: "req-1"}
+
+	// The first call stalls reading its data.
+	started, release := make(chan struct{}), make(chan struct{})
+	first := &blockingReader{started: started, release: release, r: strings.NewReader("first")}
+	type result struct {
+		entry *upspin.DirEntry
+		err   error
+	}
+	firstDone := make(chan result)
+	go func() {
+		entry, err := s.PutReader(name, first, upspin.PlainPack, opts)
+		firstDone <- result{entry, err}
+	}()
+	<-started
+
+	// A retry meanwhile waits for it rather than storing its own data.
+	retry := &countingReader{r: strings.NewReader("retry")}
+	retryDone := make(chan result)
+	go func() {
+		entry, err := s.PutReader(name, retry, upspin.PlainPack, opts)
+		retryDone <- result{entry, err}
+	}()
+	var r2 result
+	select {
+	case r2 = <-retryDone:
+		t.Fatalf("retry finished while first call in progress: %v", r2.err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+	r1 := <-firstDone
+	r2 = <-retryDone
+	if r1.err != nil || r2.err != nil {
+		t.Fatalf("errors %v, %v", r1.err, r2.err)
+	}
+	if r2.entry.Sequence != r1.entry.Sequence {
+		t.Errorf("retry sequence %d; want %d", r2.entry.Sequence, r1.entry.Sequence)
+	}
+	if retry.n != 0 {
+		t.Errorf("retry read %d bytes; want none", retry.n)
+	}
+	data, err := s.GetData(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "first" {
+		t.Errorf("data = %q; want %q", data, "first")
+	}
+
+	// A call that fails releases its key, so a retry does the work.
+	opts = &PutOptions{IdempotencyKey: "req-2"}
+	if _, err := s.PutReader(name, errReader{}, upspin.PlainPack, opts); err == nil {
+		t.Fatal("PutReader with failing reader succeeded")
+	}
+	if _, err := s.PutReader(name, strings.NewReader("second"), upspin.PlainPack, opts); err != nil {
+		t.Fatal(err)
+	}
+	data, err = s.GetData(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "second" {
+		t.Errorf("data = %q; want %q", data, "second")
+	}
+}
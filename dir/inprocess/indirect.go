@@ -0,0 +1,68 @@
+// Copyright 2017 The Upspin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package inprocess
+
+import (
+	"upspin.io/errors"
+	"upspin.io/upspin"
+)
+
+// When the indirectSize option is set, a directory whose data is larger
+// than that is stored indirectly: the data is stored as usual, but the
+// directory's block refers not to it but to a second reference for which
+// StoreServer.Get returns the location of the data instead of the data.
+// Readers, which use clientutil.ReadAll, follow the redirection.
+//
+// Only store servers that can create such references, such as
+// store/inprocess, support indirection. With other stores, directories
+// are always stored directly.
+
+// A redirector is a StoreServer that can create references that redirect
+// to other locations.
+type redirector interface {
+	PutRedirect(locs []upspin.Location) (*upspin.Refdata, error)
+}
+
+// indirect rewrites the blocks of the directory entry, whose data
+// has just been stored, to refer to it indirectly.
+func (s *server) indirect(entry *upspin.DirEntry) error {
+	const op = "dir/inprocess.indirect"
+	store, err := s.storeServer(s.db.dirConfig.StoreEndpoint())
+	if err != nil {
+		return errors.E(op, err)
+	}
+	r, ok := store.(redirector)
+	if !ok {
+		// Can't do it; store the directory directly.
+		return nil
+	}
+	for i := range entry.Blocks {
+		block := &entry.Blocks[i]
+		refdata, err := r.PutRedirect([]upspin.Location{block.Location})
+		if err != nil {
+			return errors.E(op, entry.Name, err)
+		}
+		// The location is not covered by the entry's signature.
+		block.Location.Reference = refdata.Reference
+	}
+	return nil
+}
+
+// RawGet returns what the server's store returns for the reference,
+// without following any redirection or unpacking the data: either the
+// data or, for a reference that redirects, the locations it redirects
+// to. It is intended for checking how a directory is stored.
+func (s *server) RawGet(ref upspin.Reference) ([]byte, []upspin.Location, error) {
+	const op = "dir/inprocess.RawGet"
+	store, err := s.storeServer(s.db.dirConfig.StoreEndpoint())
+	if err != nil {
+		return nil, nil, errors.E(op, err)
+	}
+	data, _, locs, err := store.Get(ref)
+	if err != nil {
+		return nil, nil, errors.E(op, err)
+	}
+	return data, locs, nil
+}
@@ -0,0 +1,68 @@
+// Copyright 2017 The Upspin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package inprocess
+
+import (
+	"fmt"
+	"testing"
+
+	"upspin.io/upspin"
+)
+
+func TestIndirectDirectory(t *testing.T) {
+	config, dir := setup()
+	s := dir.(*server)
+	s.db.indirectSize = 1000
+	user := config.UserName()
+	root := upspin.PathName(user + "/")
+	// isIndirect reports whether the named directory is stored indirectly.
+	isIndirect := func(name upspin.PathName) bool {
+		entry, err := dir.Lookup(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, locs, err := s.RawGet(entry.Blocks[0].Location.Reference)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if (data == nil) == (locs == nil) {
+			t.Fatalf("%s: RawGet returned %d bytes and %d locations", name, len(data), len(locs))
+		}
+		return locs != nil
+	}
+
+	if _, err := makeDirectory(dir, root+"dir"); err != nil {
+		t.Fatal(err)
+	}
+	if isIndirect(root) {
+		t.Fatal("small root is stored indirectly")
+	}
+	// Grow the directory until it is stored indirectly.
+	const n = 20
+	for i := 0; i < n; i++ {
+		name := upspin.PathName(fmt.Sprintf("%sdir/file%d", root, i))
+		if _, err := dir.Put(storeData(t, config, []byte(name), name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !isIndirect(root + "dir") {
+		t.Fatal("large directory is stored directly")
+	}
+	// Everything is still reachable through the redirection.
+	entries, err := dir.Glob(string(root + "dir/*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != n {
+		t.Fatalf("Glob found %d entries; want %d", len(entries), n)
+	}
+	data, err := s.GetData(root + "dir/file7")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := fmt.Sprintf("%sdir/file7", root); string(data) != want {
+		t.Fatalf("GetData = %q; want %q", data, want)
+	}
+}
@@ -0,0 +1,29 @@
+// Copyright 2017 The Upspin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package inprocess
+
+import (
+	"upspin.io/errors"
+	"upspin.io/upspin"
+)
+
+// IsDir reports whether the name refers to a directory, as for a
+// "test -d" in tooling. A user's root is a directory. It does a Lookup,
+// with the usual access checks, so a name that does not exist gives a
+// NotExist error, or a Private one if the caller may not know that. The
+// caller needs some right for the name, but not necessarily read. If the
+// name, or any element of its path, is a link, IsDir returns
+// ErrFollowLink; use Lookup to retrieve the link.
+func (s *server) IsDir(name upspin.PathName) (bool, error) {
+	const op = "dir/inprocess.IsDir"
+	entry, err := s.Lookup(name)
+	if err == upspin.ErrFollowLink {
+		return false, err
+	}
+	if err != nil {
+		return false, errors.E(op, err)
+	}
+	return entry.IsDir(), nil
+}
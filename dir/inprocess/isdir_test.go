@@ -0,0 +1,49 @@
+// Copyright 2017 The Upspin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package inprocess
+
+import (
+	"testing"
+
+	"upspin.io/errors"
+	"upspin.io/upspin"
+)
+
+func TestIsDir(t *testing.T) {
+	config, dir := globTree(t)
+	s := dir.(*server)
+	user := string(config.UserName())
+	for _, test := range []struct {
+		name string
+		want bool
+		err  error
+	}{
+		{"/", true, nil},
+		{"/a", true, nil},
+		{"/a/b", true, nil},
+		{"/f1", false, nil},
+		{"/a/b/f3", false, nil},
+		{"/nothing", false, errors.E(errors.NotExist)},
+		{"/link", false, upspin.ErrFollowLink},
+		{"/link/b", false, upspin.ErrFollowLink},
+	} {
+		got, err := s.IsDir(upspin.PathName(user + test.name))
+		switch {
+		case test.err == upspin.ErrFollowLink:
+			if err != test.err {
+				t.Errorf("IsDir(%s): err = %v; want ErrFollowLink", test.name, err)
+			}
+		case test.err != nil:
+			if !errors.Match(test.err, err) {
+				t.Errorf("IsDir(%s): err = %v; want %v", test.name, err, test.err)
+			}
+		case err != nil:
+			t.Errorf("IsDir(%s): %v", test.name, err)
+		}
+		if got != test.want {
+			t.Errorf("IsDir(%s) = %t; want %t", test.name, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+// Copyright 2017 The Upspin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package inprocess
+
+import (
+	"encoding/json"
+	"io"
+
+	"upspin.io/errors"
+	"upspin.io/upspin"
+)
+
+// JSONEntry is the form in which GlobJSON writes an entry.
+type JSONEntry struct {
+	Name      upspin.PathName `json:"name"`
+	IsDir     bool            `json:"isDir"`
+	Link      upspin.PathName `json:"link,omitempty"`
+	Size      int64           `json:"size"`
+	Time      upspin.Time     `json:"time"` // Seconds since the Unix epoch.
+	Locations []JSONLocation  `json:"locations,omitempty"`
+}
+
+// JSONLocation is the form in which GlobJSON writes the location of one
+// block of an entry.
+type JSONLocation struct {
+	Endpoint  string           `json:"endpoint"`
+	Reference upspin.Reference `json:"reference"`
+}
+
+// GlobJSON runs Glob and writes the results to w as a JSON array of
+// JSONEntry objects, one per line, in the order Glob returns them. As
+// with Glob, the error may be ErrFollowLink, in which case the array,
+// which is still complete, includes the links.
+func (s *server) GlobJSON(pattern string, w io.Writer) error {
+	const op = "dir/inprocess.GlobJSON"
+	entries, globErr := s.Glob(pattern)
+	if globErr != nil && globErr != upspin.ErrFollowLink {
+		return globErr
+	}
+	sep := "[\n"
+	for _, e := range entries {
+		size, err := e.Size()
+		if err != nil {
+			return errors.E(op, e.Name, errors.Invalid, err)
+		}
+		j := JSONEntry{
+			Name:  e.Name,
+			IsDir: e.IsDir(),
+			Link:  e.Link,
+			Size:  size,
+			Time:  e.Time,
+		}
+		for _, b := range e.Blocks {
+			j.Locations = append(j.Locations, JSONLocation{
+				Endpoint:  b.Location.Endpoint.String(),
+				Reference: b.Location.Reference,
+			})
+		}
+		data, err := json.Marshal(j)
+		if err != nil {
+			return errors.E(op, e.Name, err)
+		}
+		if _, err := io.WriteString(w, sep+string(data)); err != nil {
+			return errors.E(op, errors.IO, err)
+		}
+		sep = ",\n"
+	}
+	end := "\n]\n"
+	if len(entries) == 0 {
+		end = "[]\n"
+	}
+	if _, err := io.WriteString(w, end); err != nil {
+		return errors.E(op, errors.IO, err)
+	}
+	return globErr
+}
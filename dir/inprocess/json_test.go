@@ -0,0 +1,66 @@
+// Copyright 2017 The Upspin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package inprocess
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"upspin.io/upspin"
+)
+
+func TestGlobJSON(t *testing.T) {
+	config, dir := globTree(t)
+	s := dir.(*server)
+	user := string(config.UserName())
+
+	var buf bytes.Buffer
+	if err := s.GlobJSON(user+"/*", &buf); err != nil {
+		t.Fatal(err)
+	}
+	var got []JSONEntry
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("output does not parse: %v\n%s", err, buf.Bytes())
+	}
+	want, _ := dir.Glob(user + "/*")
+	if len(got) != len(want) {
+		t.Fatalf("got %d entries; want %d", len(got), len(want))
+	}
+	for i, j := range got {
+		e := want[i]
+		size, _ := e.Size()
+		if j.Name != e.Name || j.IsDir != e.IsDir() || j.Link != e.Link || j.Size != size || j.Time != e.Time || len(j.Locations) != len(e.Blocks) {
+			t.Errorf("entry %d = %+v; want %+v", i, j, e)
+			continue
+		}
+		for k, l := range j.Locations {
+			if l.Reference != e.Blocks[k].Location.Reference || l.Endpoint != e.Blocks[k].Location.Endpoint.String() {
+				t.Errorf("%s block %d at %+v; want %v", j.Name, k, l, e.Blocks[k].Location)
+			}
+		}
+	}
+	if got[0].Name != upspin.PathName(user+"/a") || !got[0].IsDir {
+		t.Errorf("first entry %+v; want directory %s/a", got[0], user)
+	}
+
+	// A link in the way still gives a complete array.
+	buf.Reset()
+	if err := s.GlobJSON(user+"/*/f*", &buf); err != upspin.ErrFollowLink {
+		t.Fatalf("GlobJSON through link: err = %v; want ErrFollowLink", err)
+	}
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("output through link does not parse: %v\n%s", err, buf.Bytes())
+	}
+
+	// No matches is an empty array.
+	buf.Reset()
+	if err := s.GlobJSON(user+"/nothing*", &buf); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "[]\n" {
+		t.Errorf("no matches: output %q; want %q", buf.String(), "[]\n")
+	}
+}
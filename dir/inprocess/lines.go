@@ -0,0 +1,39 @@
+// Copyright 2017 The Upspin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package inprocess
+
+import (
+	"io"
+	"strings"
+
+	"upspin.io/errors"
+	"upspin.io/upspin"
+)
+
+// GlobLines runs Glob and writes the names of the results to w, one per
+// line and in the order Glob returns them, for tools that read lines.
+// The name of a directory ends in a slash, as that of a root does. Each
+// line is written to w as soon as it is formatted, so a reader sees the
+// listing as it is written; names that hold a newline, which Upspin
+// allows, will split across lines. As with Glob, the error may be
+// ErrFollowLink, in which case the listing, which is still complete,
+// includes the links.
+func (s *server) GlobLines(pattern string, w io.Writer) error {
+	const op = "dir/inprocess.GlobLines"
+	entries, globErr := s.Glob(pattern)
+	if globErr != nil && globErr != upspin.ErrFollowLink {
+		return globErr
+	}
+	for _, e := range entries {
+		line := string(e.Name)
+		if e.IsDir() && !strings.HasSuffix(line, "/") {
+			line += "/"
+		}
+		if _, err := io.WriteString(w, line+"\n"); err != nil {
+			return errors.E(op, errors.IO, err)
+		}
+	}
+	return globErr
+}
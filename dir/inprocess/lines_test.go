@@ -0,0 +1,66 @@
+// Copyright 2017 The Upspin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package inprocess
+
+import (
+	"bytes"
+	"testing"
+
+	"upspin.io/errors"
+)
+
+// countingWriter is a bytes.Buffer that counts the calls to Write and
+// WriteString.
+type countingWriter struct {
+	bytes.Buffer
+	writes int
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	c.writes++
+	return c.Buffer.Write(p)
+}
+
+func (c *countingWriter) WriteString(s string) (int, error) {
+	c.writes++
+	return c.Buffer.WriteString(s)
+}
+
+func TestGlobLines(t *testing.T) {
+	config, dir := globTree(t)
+	s := dir.(*server)
+	user := string(config.UserName())
+	for _, test := range []struct {
+		pattern string
+		want    string
+		lines   int
+	}{
+		{"/", "/\n", 1},
+		{"/*", "/a/\n/c/\n/f1\n/link\n", 4},
+		{"/[ac]/*", "/a/b/\n/a/f2\n/c/d/\n/c/f4\n", 4},
+		{"/nothing*", "", 0},
+	} {
+		var w countingWriter
+		if err := s.GlobLines(user+test.pattern, &w); err != nil {
+			t.Fatal(err)
+		}
+		want := ""
+		for _, line := range bytes.SplitAfter([]byte(test.want), []byte("\n")) {
+			if len(line) > 0 {
+				want += user + string(line)
+			}
+		}
+		if got := w.String(); got != want {
+			t.Errorf("GlobLines(%q) = %q; want %q", test.pattern, got, want)
+		}
+		// Each line is written as it is formatted.
+		if w.writes != test.lines {
+			t.Errorf("GlobLines(%q) made %d writes; want %d", test.pattern, w.writes, test.lines)
+		}
+	}
+	if err := s.GlobLines(user+"/[a-", new(bytes.Buffer)); !errors.Match(errors.E(errors.Invalid), err) {
+		t.Errorf("GlobLines with bad pattern: err = %v; want Invalid", err)
+	}
+}
@@ -0,0 +1,67 @@
+// Copyright 2017 The Upspin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package inprocess
+
+// Locked entries.
+//
+// An entry stored by PutReader with PutOptions.Lock set is locked: until
+// it is unlocked, any change that would replace or remove it, including
+// Put, UpdateMeta, Delete, RenameBackup, Swap and Transfer, fails with a
+// Permission error wrapping errLocked. PutReader may replace a locked
+// entry if PutOptions.Unlock is set, and Unlock removes the lock. Rebuild,
+// Replay and the methods that remove a whole tree ignore locks, and
+// drop those in the tree.
+//
+// A lock is not part of the DirEntry, so it is recorded in db.locked,
+// keyed by name, and set or cleared only when the put that makes the
+// change installs its new root.
+
+import (
+	"upspin.io/access"
+	"upspin.io/errors"
+	"upspin.io/path"
+	"upspin.io/upspin"
+)
+
+var errLocked = errors.Str("entry is locked")
+
+// Unlock removes the lock, if any, from the named entry, so it may again
+// be changed. The caller needs write rights for the name.
+func (s *server) Unlock(name upspin.PathName) error {
+	const op = "dir/inprocess.Unlock"
+	parsed, err := s.parse(name)
+	if err != nil {
+		return errors.E(op, err)
+	}
+	entry, err := s.lookup(op, parsed, false)
+	if err != nil {
+		_, err = s.errLink(op, entry, err)
+		return err
+	}
+	can, err := s.can(access.Write, parsed)
+	if err != nil {
+		return errors.E(op, err)
+	}
+	if !can {
+		return s.errPerm(op, parsed)
+	}
+
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	if s.db.readOnly {
+		return errors.E(op, name, errors.Permission, errReadOnly)
+	}
+	delete(s.db.locked, parsed.Path())
+	return nil
+}
+
+// checkUnlocked returns an error if the entry at the parsed name is
+// locked and the server is not a copy made to unlock it. s.db.mu is held.
+func (s *server) checkUnlocked(op string, parsed path.Parsed) error {
+	if s.db.locked[parsed.Path()] && !s.unlock {
+		return errors.E(op, parsed.Path(), errors.Permission, errLocked)
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+// Copyright 2017 The Upspin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package inprocess
+
+import (
+	"strings"
+	"testing"
+
+	"upspin.io/errors"
+	"upspin.io/upspin"
+)
+
+func TestLock(t *testing.T) {
+	config, dir := setup()
+	s := dir.(*server)
+	user := config.UserName()
+	name := upspin.PathName(user + "/worm")
+	other := upspin.PathName(user + "/other")
+	for _, n := range []upspin.PathName{name, other} {
+		if _, err := s.PutReader(n, strings.NewReader("v1"), upspin.PlainPack, &PutOptions{Lock: n == name}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	locked := errors.E(name, errors.Permission, errLocked)
+	for _, test := range []struct {
+		what string
+		fn   func() error
+	}{
+		{"Put", func() error { _, err := dir.Put(storeData(t, config, []byte("v2"), name)); return err }},
+		{"PutReader", func() error {
+			_, err := s.PutReader(name, strings.NewReader("v2"), upspin.PlainPack, nil)
+			return err
+		}},
+		{"UpdateMeta", func() error { return s.UpdateMeta(name, func(e *upspin.DirEntry) { e.Time++ }) }},
+		{"Delete", func() error { _, err := dir.Delete(name); return err }},
+		{"RenameBackup from", func() error { _, err := s.RenameBackup(name, upspin.PathName(user+"/new")); return err }},
+		{"RenameBackup to", func() error { _, err := s.RenameBackup(other, name); return err }},
+		{"Swap", func() error { return s.Swap(other, name) }},
+	} {
+		if err := test.fn(); !errors.Match(locked, err) {
+			t.Errorf("%s of locked entry: err = %v; want %v", test.what, err, locked)
+		}
+	}
+	// Nothing changed, not even the entry moved aside by RenameBackup.
+	if data, err := s.GetData(name); err != nil || string(data) != "v1" {
+		t.Errorf("locked entry holds %q, %v; want v1", data, err)
+	}
+	entries, err := dir.Glob(string(user) + "/*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Errorf("tree has %d entries; want 2", len(entries))
+	}
+
+	// Unlock lets PutReader replace the entry, and the new one is
+	// unlocked unless Lock is set too.
+	if _, err := s.PutReader(name, strings.NewReader("v2"), upspin.PlainPack, &PutOptions{Unlock: true, Lock: true}); err != nil {
+		t.Fatalf("PutReader with Unlock: %v", err)
+	}
+	if _, err := dir.Delete(name); !errors.Match(locked, err) {
+		t.Errorf("Delete after relocking: err = %v; want %v", err, locked)
+	}
+	if err := s.Unlock(name); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.UpdateMeta(name, func(e *upspin.DirEntry) { e.Time++ }); err != nil {
+		t.Errorf("UpdateMeta after Unlock: %v", err)
+	}
+	if _, err := dir.Delete(name); err != nil {
+		t.Errorf("Delete after Unlock: %v", err)
+	}
+	if len(s.db.locked) != 0 {
+		t.Errorf("locks remain: %v", s.db.locked)
+	}
+}
@@ -0,0 +1,89 @@
+// Copyright 2017 The Upspin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package inprocess
+
+import (
+	"sync"
+
+	"upspin.io/upspin"
+)
+
+// When the lookupCache option is set, the server remembers the entries
+// found by walking the tree, keyed by path name and by the reference of
+// the root they were found under. Every change to a tree installs a new
+// root, so after any change, wherever in the tree it is, the remembered
+// entries no longer match and are looked up afresh; there is nothing to
+// invalidate path by path. The entries remembered for a user are dropped
+// as soon as a lookup sees a newer root, so the cache never holds more
+// than the entries of each user's current tree.
+//
+// Links are not remembered, as the result of looking one up depends on
+// whether the link is followed. Nor is anything remembered while any
+// entry has an expiration time, since whether a lookup finds an entry
+// then depends on the clock as well as the tree.
+
+// lookupCache holds the entries remembered for each user.
+type lookupCache struct {
+	mu     sync.Mutex
+	users  map[upspin.UserName]*userLookups
+	hits   int
+	misses int
+}
+
+// userLookups holds the entries remembered under one root.
+type userLookups struct {
+	root    upspin.Reference
+	entries map[upspin.PathName]*upspin.DirEntry
+}
+
+// rootRef returns the reference that identifies the tree under the root.
+func rootRef(root *upspin.DirEntry) upspin.Reference {
+	if len(root.Blocks) == 0 {
+		return ""
+	}
+	return root.Blocks[0].Location.Reference
+}
+
+// get returns a copy of the entry remembered for the name under the
+// user's root, and counts the hit or miss.
+func (c *lookupCache) get(user upspin.UserName, root *upspin.DirEntry, name upspin.PathName) (*upspin.DirEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	u := c.users[user]
+	if u == nil || u.root != rootRef(root) || u.entries[name] == nil {
+		c.misses++
+		return nil, false
+	}
+	c.hits++
+	return u.entries[name].Copy(), true
+}
+
+// add remembers a copy of the entry found for the name under the user's
+// root, forgetting any entries remembered under an older root.
+func (c *lookupCache) add(user upspin.UserName, root *upspin.DirEntry, name upspin.PathName, entry *upspin.DirEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ref := rootRef(root)
+	u := c.users[user]
+	if u == nil || u.root != ref {
+		if c.users == nil {
+			c.users = make(map[upspin.UserName]*userLookups)
+		}
+		u = &userLookups{root: ref, entries: make(map[upspin.PathName]*upspin.DirEntry)}
+		c.users[user] = u
+	}
+	u.entries[name] = entry.Copy()
+}
+
+// LookupCacheStats returns the number of lookups that found their entry
+// in the cache kept by the lookupCache option and the number that had
+// to walk the tree. Both are zero if the option is not set.
+// The counts apply to all users of the server.
+func (s *server) LookupCacheStats() (hits, misses int) {
+	c := &s.db.lookupCache
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.hits, c.misses
+}
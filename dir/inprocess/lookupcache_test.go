@@ -0,0 +1,79 @@
+// Copyright 2017 The Upspin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package inprocess
+
+import (
+	"testing"
+
+	"upspin.io/upspin"
+)
+
+func TestLookupCache(t *testing.T) {
+	config, dir := setup()
+	s := dir.(*server)
+	user := config.UserName()
+	name := upspin.PathName(user + "/dir/file")
+	if _, err := makeDirectory(dir, upspin.PathName(user+"/dir")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dir.Put(storeData(t, config, []byte("first"), name)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dir.Lookup(name); err != nil {
+		t.Fatal(err)
+	}
+	if hits, misses := s.LookupCacheStats(); hits != 0 || misses != 0 {
+		t.Errorf("LookupCacheStats = %d, %d without the option; want 0, 0", hits, misses)
+	}
+
+	if err := s.db.setOption("lookupCache=true"); err != nil {
+		t.Fatal(err)
+	}
+	lookup := func() *upspin.DirEntry {
+		entry, err := dir.Lookup(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return entry
+	}
+	lookup()
+	var entry *upspin.DirEntry
+	if ops := s.WithStoreCounter(func() { entry = lookup() }); ops != 0 {
+		t.Errorf("cached Lookup did %d store operations; want 0", ops)
+	}
+	if hits, misses := s.LookupCacheStats(); hits != 1 || misses != 1 {
+		t.Errorf("LookupCacheStats = %d, %d; want 1, 1", hits, misses)
+	}
+	// Changing the entry returned does not change the cache.
+	entry.Sequence = 1000
+	if seq := lookup().Sequence; seq == 1000 {
+		t.Error("cached entry was changed through the result of Lookup")
+	}
+
+	// A change anywhere in the tree installs a new root, so the
+	// entry is looked up afresh.
+	if _, err := dir.Put(storeData(t, config, []byte("other"), upspin.PathName(user+"/other"))); err != nil {
+		t.Fatal(err)
+	}
+	hits0, misses0 := s.LookupCacheStats()
+	lookup()
+	if hits, misses := s.LookupCacheStats(); hits != hits0 || misses != misses0+1 {
+		t.Errorf("after change elsewhere, Lookup counted %d hits, %d misses; want 0, 1", hits-hits0, misses-misses0)
+	}
+	// A replaced or deleted entry is not found in the cache.
+	before := lookup().Sequence
+	if _, err := dir.Put(storeData(t, config, []byte("second"), name)); err != nil {
+		t.Fatal(err)
+	}
+	if got := lookup().Sequence; got <= before {
+		t.Errorf("Lookup of replaced entry found sequence %d; want more than %d", got, before)
+	}
+	if _, err := dir.Delete(name); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dir.Lookup(name); err == nil {
+		t.Error("Lookup found deleted entry")
+	}
+}
@@ -0,0 +1,25 @@
+// Copyright 2017 The Upspin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package inprocess
+
+import "upspin.io/upspin"
+
+// LookupMany looks up each of the names as Lookup would and returns the
+// results in slices parallel to names: for each name, either the entry
+// or the error from Lookup. The directories read are remembered for the
+// duration of the call, so names that share ancestors cost little more
+// than one Lookup. Each name is looked up separately, however, so a
+// concurrent change to the tree may be seen by some lookups and not by
+// others.
+func (s *server) LookupMany(names []upspin.PathName) ([]*upspin.DirEntry, []error) {
+	c := *s // Make a copy.
+	c.dirCache = make(map[upspin.Reference][]byte)
+	entries := make([]*upspin.DirEntry, len(names))
+	errs := make([]error, len(names))
+	for i, name := range names {
+		entries[i], errs[i] = c.Lookup(name)
+	}
+	return entries, errs
+}
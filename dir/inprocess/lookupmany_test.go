@@ -0,0 +1,58 @@
+// Copyright 2017 The Upspin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package inprocess
+
+import (
+	"testing"
+
+	"upspin.io/errors"
+	"upspin.io/upspin"
+)
+
+func TestLookupMany(t *testing.T) {
+	config, dir := globTree(t)
+	s := dir.(*server)
+	user := string(config.UserName())
+	tracer := &testTracer{
+		started: make(map[string]int),
+		ended:   make(map[string]int),
+	}
+
+	names := []upspin.PathName{
+		upspin.PathName(user + "/c/d/f5"),
+		upspin.PathName(user + "/c/f4"),
+		upspin.PathName(user + "/c/nothing"),
+		upspin.PathName(user + "/c/d"),
+	}
+	// Count the reads with individual Lookups and with LookupMany.
+	s.SetTracer(tracer)
+	for _, name := range names {
+		dir.Lookup(name)
+	}
+	single := tracer.started["Store.Get"]
+	tracer.started["Store.Get"] = 0
+	entries, errs := s.LookupMany(names)
+	many := tracer.started["Store.Get"]
+	s.SetTracer(nil)
+	if many >= single {
+		t.Errorf("LookupMany read %d blocks; separate Lookups read %d", many, single)
+	}
+
+	for i, name := range names {
+		if i == 2 {
+			if !errors.Match(errors.E(errors.NotExist), errs[i]) {
+				t.Errorf("%s: err = %v; want NotExist", name, errs[i])
+			}
+			continue
+		}
+		if errs[i] != nil {
+			t.Errorf("%s: %v", name, errs[i])
+			continue
+		}
+		if entries[i].Name != name {
+			t.Errorf("entry %d is %s; want %s", i, entries[i].Name, name)
+		}
+	}
+}
@@ -0,0 +1,166 @@
+// Copyright 2017 The Upspin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package inprocess
+
+import (
+	"strings"
+
+	"upspin.io/access"
+	"upspin.io/errors"
+	"upspin.io/path"
+	"upspin.io/upspin"
+)
+
+// MergeStrategy selects how Merge resolves a name that holds a file or
+// link in both trees.
+type MergeStrategy int
+
+const (
+	SkipExisting MergeStrategy = iota // Keep the destination's entry.
+	Overwrite                         // Take the source's entry.
+	KeepNewest                        // Take the entry with the later Time, the destination's if equal.
+)
+
+// MergeConflict records a name that Merge found in both trees and how it
+// was resolved.
+type MergeConflict struct {
+	Name       upspin.PathName // The name in the destination tree.
+	TookSource bool            // The source's entry replaced the destination's.
+}
+
+// Merge overlays the tree of srcUser onto that of dstUser, whose root
+// must exist. Each directory, file and link in the source is installed
+// at the same place in the destination unless the name is already
+// taken; the new entries refer to the same data in the store. A name
+// holding a file or link in both trees is resolved by the strategy. A
+// name holding a directory in one tree and not the other, or reached
+// through a link in the destination, keeps the destination's entry, and
+// the source's entries below it are not merged. Every such name is
+// returned as a conflict, in the order the source is walked. Directories
+// that exist in both are merged without conflict.
+//
+// The source is read with the caller's rights, as by Walk; files the
+// caller cannot read are skipped, as are Access and Group files, so the
+// destination's rights are unchanged. The caller needs create and write
+// rights for each name in the destination, and only dstUser may do this.
+// The changes are made under a single lock, but if one fails those
+// already made remain.
+func (s *server) Merge(dstUser, srcUser upspin.UserName, strategy MergeStrategy) ([]MergeConflict, error) {
+	const op = "dir/inprocess.Merge"
+	dstUser = normalizeUser(dstUser, s.db.foldLocal)
+	srcUser = normalizeUser(srcUser, s.db.foldLocal)
+	if normalizeUser(s.config.UserName(), s.db.foldLocal) != dstUser {
+		return nil, errors.E(op, dstUser, errors.Permission)
+	}
+	if strategy < SkipExisting || strategy > KeepNewest {
+		return nil, errors.E(op, errors.Invalid, errors.Errorf("unknown merge strategy %d", strategy))
+	}
+	if srcUser == dstUser {
+		return nil, errors.E(op, srcUser, errors.Invalid, errors.Str("cannot merge a tree into itself"))
+	}
+	srcRoot := string(srcUser) + "/"
+	dstRoot := string(dstUser) + "/"
+
+	// Collect the source entries, checking our rights as we go.
+	var entries []*upspin.DirEntry
+	err := s.Walk(upspin.PathName(srcRoot), func(e *upspin.DirEntry) error {
+		if e.Name == upspin.PathName(srcRoot) {
+			return nil
+		}
+		if access.IsAccessFile(e.Name) || access.IsGroupFile(e.Name) || !e.IsDir() && e.IsIncomplete() {
+			return nil
+		}
+		dst, err := path.Parse(upspin.PathName(dstRoot + strings.TrimPrefix(string(e.Name), srcRoot)))
+		if err != nil {
+			return err
+		}
+		for _, right := range []access.Right{access.Create, access.Write} {
+			can, err := s.can(right, dst)
+			if err != nil {
+				return errors.E(op, err)
+			}
+			if !can {
+				return s.errPerm(op, dst)
+			}
+		}
+		entries = append(entries, e)
+		return nil
+	})
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	if s.db.readOnly {
+		return nil, errors.E(op, dstUser, errors.Permission, errReadOnly)
+	}
+	if _, ok := s.db.root[dstUser]; !ok {
+		return nil, errors.E(op, dstUser, errors.NotExist, errors.Str("no such user"))
+	}
+	var conflicts []MergeConflict
+	skip := "" // Prefix of destination names not to merge.
+	for _, e := range entries {
+		dst, err := path.Parse(upspin.PathName(dstRoot + strings.TrimPrefix(string(e.Name), srcRoot)))
+		if err != nil {
+			return conflicts, errors.E(op, err)
+		}
+		if skip != "" && strings.HasPrefix(string(dst.Path()), skip) {
+			continue
+		}
+		existing, err := s.lookupLocked(op, dst, false)
+		switch {
+		case errors.Match(notExist, err):
+			err = s.mergeEntry(op, e, dst)
+		case err == upspin.ErrFollowLink, err == nil && existing.IsDir() != e.IsDir():
+			conflicts = append(conflicts, MergeConflict{Name: dst.Path()})
+			if e.IsDir() {
+				skip = string(dst.Path()) + "/"
+			}
+			err = nil
+		case err != nil:
+			err = errors.E(op, err)
+		case existing.IsDir():
+			// Already there.
+		default:
+			take := strategy == Overwrite || strategy == KeepNewest && e.Time > existing.Time
+			conflicts = append(conflicts, MergeConflict{Name: dst.Path(), TookSource: take})
+			if take {
+				err = s.mergeEntry(op, e, dst)
+			}
+		}
+		if err != nil {
+			return conflicts, err
+		}
+	}
+	return conflicts, nil
+}
+
+// mergeEntry installs the source entry e at dst for Merge: a new empty
+// directory for a directory, and otherwise a copy of the entry referring
+// to the same data. s.db.mu is held.
+func (s *server) mergeEntry(op string, e *upspin.DirEntry, dst path.Parsed) error {
+	var entry *upspin.DirEntry
+	if e.IsDir() {
+		var err error
+		entry, err = s.newDirEntry(dst.Path(), []byte(""), upspin.SeqIgnore)
+		if err != nil {
+			return errors.E(op, err)
+		}
+	} else {
+		// The signature covers SignedName, so keep it and change only Name.
+		entry = e.Copy()
+		entry.Name = dst.Path()
+		entry.Sequence = upspin.SeqIgnore
+	}
+	entry, err := s.put(op, entry, dst, false)
+	if err != nil {
+		return err
+	}
+	s.db.eventMgr.newEvent <- upspin.Event{
+		Entry: entry,
+	}
+	return nil
+}
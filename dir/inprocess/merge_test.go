@@ -0,0 +1,125 @@
+// Copyright 2017 The Upspin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package inprocess
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"upspin.io/errors"
+	"upspin.io/upspin"
+)
+
+// mergeTrees returns the server of a new user, dst, and the name of a
+// second user, src, on the same server who lets dst read their tree.
+// The trees hold
+//
+//	src: /a/ /a/new /a/same /b/ /b/x /same /time
+//	dst: /a/ /a/same /b /same /time
+//
+// where dst's /b is a file, /same is newer in src, and /time is newer
+// in dst.
+func mergeTrees(t *testing.T) (dst *server, dstUser, srcUser upspin.UserName) {
+	dstConfig, dir := setup()
+	dst = dir.(*server)
+	dstUser = dstConfig.UserName()
+
+	srcUser = nextUser()
+	srcConfig, key, _, _ := newConfigAndServices(srcUser)
+	if err := key.Put(&upspin.User{
+		Name:      srcUser,
+		Dirs:      []upspin.Endpoint{srcConfig.DirEndpoint()},
+		Stores:    []upspin.Endpoint{srcConfig.StoreEndpoint()},
+		PublicKey: srcConfig.Factotum().PublicKey(),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	svc, err := dst.Dial(srcConfig, dst.Endpoint())
+	if err != nil {
+		t.Fatal(err)
+	}
+	src := svc.(*server)
+
+	build := func(s *server, config upspin.Config, user upspin.UserName, dirs, files []string, time upspin.Time) {
+		for _, name := range dirs {
+			if _, err := makeDirectory(s, upspin.PathName(user)+upspin.PathName(name)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for _, name := range files {
+			p := upspin.PathName(user) + upspin.PathName(name)
+			entry, err := newDirEntryAt(config, upspin.PlainPack, p, []byte(string(user)+name), upspin.AttrNone, "", upspin.SeqIgnore, time)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := s.Put(entry); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if _, err := makeDirectory(src, upspin.PathName(srcUser+"/")); err != nil {
+		t.Fatal(err)
+	}
+	access := storePlainWithIntegrity(t, srcConfig, []byte(fmt.Sprintf("*: %s\nread, list: %s\n", srcUser, dstUser)), upspin.PathName(srcUser+"/Access"))
+	if _, err := src.Put(access); err != nil {
+		t.Fatal(err)
+	}
+	build(src, srcConfig, srcUser, []string{"/a", "/b"}, []string{"/a/new", "/a/same", "/b/x"}, 2000)
+	build(src, srcConfig, srcUser, nil, []string{"/same"}, 2500)
+	build(src, srcConfig, srcUser, nil, []string{"/time"}, 1000)
+	build(dst, dstConfig, dstUser, []string{"/a"}, []string{"/a/same", "/b", "/same"}, 2000)
+	build(dst, dstConfig, dstUser, nil, []string{"/time"}, 3000)
+	return dst, dstUser, srcUser
+}
+
+func TestMerge(t *testing.T) {
+	for _, test := range []struct {
+		strategy  MergeStrategy
+		conflicts string
+		fromSrc   string // Files in dst with src's data afterwards.
+	}{
+		{SkipExisting, "/a/same:false /b:false /same:false /time:false", "/a/new"},
+		{Overwrite, "/a/same:true /b:false /same:true /time:true", "/a/new /a/same /same /time"},
+		{KeepNewest, "/a/same:false /b:false /same:true /time:false", "/a/new /same"},
+	} {
+		dst, dstUser, srcUser := mergeTrees(t)
+		conflicts, err := dst.Merge(dstUser, srcUser, test.strategy)
+		if err != nil {
+			t.Fatalf("strategy %d: %v", test.strategy, err)
+		}
+		var got []string
+		for _, c := range conflicts {
+			got = append(got, fmt.Sprintf("%s:%t", strings.TrimPrefix(string(c.Name), string(dstUser)), c.TookSource))
+		}
+		if strings.Join(got, " ") != test.conflicts {
+			t.Errorf("strategy %d: conflicts %q; want %q", test.strategy, got, test.conflicts)
+		}
+		if got, want := strings.Join(walkNames(t, dst, dstUser), " "), "/ /a /a/new /a/same /b /same /time"; got != want {
+			t.Errorf("strategy %d: tree %s; want %s", test.strategy, got, want)
+		}
+		var fromSrc []string
+		for _, name := range []string{"/a/new", "/a/same", "/same", "/time"} {
+			data, err := dst.GetData(upspin.PathName(dstUser) + upspin.PathName(name))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(data) == string(srcUser)+name {
+				fromSrc = append(fromSrc, name)
+			}
+		}
+		if got := strings.Join(fromSrc, " "); got != test.fromSrc {
+			t.Errorf("strategy %d: files from source %q; want %q", test.strategy, got, test.fromSrc)
+		}
+	}
+
+	dst, dstUser, srcUser := mergeTrees(t)
+	if _, err := dst.Merge(srcUser, dstUser, Overwrite); !errors.Match(errors.E(errors.Permission), err) {
+		t.Errorf("Merge into another user's tree: err = %v; want Permission", err)
+	}
+	if _, err := dst.Merge(dstUser, srcUser, MergeStrategy(99)); !errors.Match(errors.E(errors.Invalid), err) {
+		t.Errorf("Merge with bad strategy: err = %v; want Invalid", err)
+	}
+}
@@ -0,0 +1,110 @@
+// Copyright 2017 The Upspin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package inprocess
+
+import (
+	"bytes"
+
+	"upspin.io/access"
+	"upspin.io/errors"
+	"upspin.io/upspin"
+)
+
+// UpdateMeta changes the metadata of an existing file or link without
+// storing its data again. It calls fn with a copy of the entry, which fn
+// may modify, and installs the result in place of the entry with the next
+// sequence number. Only the Time, Writer, Attr and Packdata fields may
+// change; fn must not touch the entry's name, data, packing or link, nor
+// make it a directory, link or file if it was not one already. The
+// packings sign the Time and Attr fields, so a change to either needs a
+// new signature in Packdata for clients to accept the entry. The caller
+// needs write rights for the name. Directories cannot be updated.
+func (s *server) UpdateMeta(name upspin.PathName, fn func(*upspin.DirEntry)) error {
+	const op = "dir/inprocess.UpdateMeta"
+	return s.updateMeta(op, name, fn)
+}
+
+// updateMeta is the implementation of UpdateMeta and Touch.
+func (s *server) updateMeta(op string, name upspin.PathName, fn func(*upspin.DirEntry)) error {
+	parsed, err := s.parse(name)
+	if err != nil {
+		return errors.E(op, err)
+	}
+	entry, err := s.lookup(op, parsed, false)
+	if err != nil {
+		_, err = s.errLink(op, entry, err)
+		return err
+	}
+	if entry.IsDir() {
+		return errors.E(op, parsed.Path(), errors.IsDir)
+	}
+	can, err := s.can(access.Write, parsed)
+	if err != nil {
+		return errors.E(op, err)
+	}
+	if !can {
+		return s.errPerm(op, parsed)
+	}
+
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	if s.db.readOnly {
+		return errors.E(op, name, errors.Permission, errReadOnly)
+	}
+	// The entry may have changed since we looked.
+	entry, err = s.lookupLocked(op, parsed, false)
+	if err != nil {
+		return errors.E(op, err)
+	}
+	if entry.IsDir() {
+		return errors.E(op, parsed.Path(), errors.IsDir)
+	}
+	newEntry := entry.Copy()
+	fn(newEntry)
+	if field := fixedFieldChanged(entry, newEntry); field != "" {
+		return errors.E(op, parsed.Path(), errors.Invalid, errors.Errorf("cannot change %s", field))
+	}
+	// The entry is updated in place, even if it is a link.
+	c := *s // Make a copy.
+	c.replaceLink = true
+	newEntry, err = c.put(op, newEntry, parsed, false)
+	if err != nil {
+		return err
+	}
+	s.db.eventMgr.newEvent <- upspin.Event{
+		Entry: newEntry,
+	}
+	return nil
+}
+
+// fixedFieldChanged returns the name of the first field that UpdateMeta
+// does not allow to change and that differs between the old and new
+// entries, or the empty string if there is none.
+func fixedFieldChanged(old, new *upspin.DirEntry) string {
+	const typeBits = upspin.AttrDirectory | upspin.AttrLink
+	switch {
+	case new.Name != old.Name:
+		return "Name"
+	case new.SignedName != old.SignedName:
+		return "SignedName"
+	case new.Packing != old.Packing:
+		return "Packing"
+	case new.Link != old.Link:
+		return "Link"
+	case new.Sequence != old.Sequence:
+		return "Sequence"
+	case new.Attr&typeBits != old.Attr&typeBits:
+		return "the entry's type"
+	case len(new.Blocks) != len(old.Blocks):
+		return "Blocks"
+	}
+	for i, b := range new.Blocks {
+		o := old.Blocks[i]
+		if b.Location != o.Location || b.Offset != o.Offset || b.Size != o.Size || !bytes.Equal(b.Packdata, o.Packdata) {
+			return "Blocks"
+		}
+	}
+	return ""
+}
@@ -0,0 +1,113 @@
+// Copyright 2017 The Upspin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package inprocess
+
+import (
+	"reflect"
+	"testing"
+
+	"upspin.io/errors"
+	"upspin.io/upspin"
+)
+
+func TestUpdateMeta(t *testing.T) {
+	config, dir := setup()
+	s := dir.(*server)
+	user := config.UserName()
+	if _, err := makeDirectory(dir, upspin.PathName(user+"/a")); err != nil {
+		t.Fatal(err)
+	}
+	name := upspin.PathName(user + "/a/f")
+	if _, err := dir.Put(storeData(t, config, []byte("data"), name)); err != nil {
+		t.Fatal(err)
+	}
+	old, err := dir.Lookup(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const writer = "other@example.com"
+	tracer := &testTracer{started: make(map[string]int), ended: make(map[string]int)}
+	s.SetTracer(tracer)
+	err = s.UpdateMeta(name, func(e *upspin.DirEntry) {
+		e.Writer = writer
+		e.Time++
+	})
+	s.SetTracer(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Only /a and the root are stored; the data is not.
+	if got, want := tracer.started["Store.Put"], 2; got != want {
+		t.Errorf("UpdateMeta did %d Store.Puts; want %d", got, want)
+	}
+	entry, err := dir.Lookup(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.Writer != writer || entry.Time != old.Time+1 {
+		t.Errorf("metadata not updated: Writer %s, Time %d", entry.Writer, entry.Time)
+	}
+	if entry.Sequence != upspin.SeqNext(old.Sequence) {
+		t.Errorf("Sequence = %d; want %d", entry.Sequence, upspin.SeqNext(old.Sequence))
+	}
+	if !reflect.DeepEqual(entry.Blocks, old.Blocks) {
+		t.Errorf("Blocks changed: %v; was %v", entry.Blocks, old.Blocks)
+	}
+
+	for _, fn := range []func(*upspin.DirEntry){
+		func(e *upspin.DirEntry) { e.Name += "x" },
+		func(e *upspin.DirEntry) { e.Blocks[0].Size++ },
+		func(e *upspin.DirEntry) { e.Attr = upspin.AttrLink },
+		func(e *upspin.DirEntry) { e.Sequence++ },
+	} {
+		if err := s.UpdateMeta(name, fn); !errors.Match(errors.E(name, errors.Invalid), err) {
+			t.Errorf("UpdateMeta of fixed field: err = %v; want Invalid", err)
+		}
+	}
+	if err := s.UpdateMeta(upspin.PathName(user+"/a"), func(*upspin.DirEntry) {}); !errors.Match(errors.E(errors.IsDir), err) {
+		t.Errorf("UpdateMeta of directory: err = %v; want IsDir", err)
+	}
+	if errs := s.checkUser(user); len(errs) != 0 {
+		t.Errorf("tree is inconsistent: %v", errs)
+	}
+}
+
+func TestUpdateMetaLink(t *testing.T) {
+	config, dir := globTree(t)
+	s := dir.(*server)
+	user := config.UserName()
+	name := upspin.PathName(user + "/link")
+	old, err := dir.Lookup(name)
+	if err != upspin.ErrFollowLink {
+		t.Fatalf("Lookup(%s): err = %v; want %v", name, err, upspin.ErrFollowLink)
+	}
+
+	const writer = "other@example.com"
+	if err := s.UpdateMeta(name, func(e *upspin.DirEntry) { e.Writer = writer }); err != nil {
+		t.Fatal(err)
+	}
+	entry, err := dir.Lookup(name)
+	if err != upspin.ErrFollowLink {
+		t.Fatalf("Lookup(%s) after UpdateMeta: err = %v; want %v", name, err, upspin.ErrFollowLink)
+	}
+	if entry.Writer != writer {
+		t.Errorf("Writer = %s; want %s", entry.Writer, writer)
+	}
+	if entry.Link != old.Link {
+		t.Errorf("Link = %s; want %s", entry.Link, old.Link)
+	}
+	if entry.Sequence != upspin.SeqNext(old.Sequence) {
+		t.Errorf("Sequence = %d; want %d", entry.Sequence, upspin.SeqNext(old.Sequence))
+	}
+	// The link target is untouched.
+	target, err := dir.Lookup(old.Link)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if target.Writer == writer {
+		t.Error("UpdateMeta followed the link")
+	}
+}
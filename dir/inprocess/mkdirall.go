@@ -0,0 +1,102 @@
+// Copyright 2017 The Upspin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package inprocess
+
+import (
+	"upspin.io/access"
+	"upspin.io/errors"
+	"upspin.io/upspin"
+)
+
+// MakeDirectoryAll creates the named directory along with any missing
+// directories above it, like "mkdir -p", and returns the entry for the
+// named directory. If it already exists, MakeDirectoryAll returns it.
+// The caller needs create rights in the deepest directory that exists.
+//
+// Making the directories one at a time with MakeDirectory would store
+// each new directory and then rewrite it, and every directory above it,
+// as each one below it is made. Instead the new directories are built
+// from the bottom up in memory, each stored once already holding the one
+// below, and the topmost is installed with a single put, so a path of n
+// new directories under m existing ones costs n+m stores rather than
+// about n*(m+n/2).
+func (s *server) MakeDirectoryAll(name upspin.PathName) (*upspin.DirEntry, error) {
+	const op = "dir/inprocess.MakeDirectoryAll"
+	parsed, err := s.parse(name)
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+
+	// Find the deepest directory that exists.
+	n := parsed.NElem()
+	for ; ; n-- {
+		e, err := s.lookup(op, parsed.First(n), true)
+		if err == upspin.ErrFollowLink {
+			return s.errLink(op, e, err)
+		}
+		if err == nil {
+			if !e.IsDir() {
+				return nil, errors.E(op, e.Name, errors.NotDir)
+			}
+			if n == parsed.NElem() {
+				return e, nil
+			}
+			break
+		}
+		if !errors.Match(notExist, err) || n == 0 {
+			return nil, err
+		}
+	}
+	top := parsed.First(n + 1)
+	for i := n + 1; i <= parsed.NElem(); i++ {
+		if access.IsAccessFile(parsed.First(i).Path()) {
+			return nil, errors.E(op, parsed.First(i).Path(), errors.Invalid, errors.Str("cannot create a directory named Access"))
+		}
+	}
+	if e, err := s.canPut(op, top, true); err != nil {
+		return s.errLink(op, e, err)
+	}
+
+	// Build the new directories from the bottom up.
+	created := make([]*upspin.DirEntry, 0, parsed.NElem()-n)
+	var child *upspin.DirEntry
+	for i := parsed.NElem(); i > n; i-- {
+		var data []byte
+		if child != nil {
+			record, err := child.Marshal()
+			if err != nil {
+				return nil, errors.E(op, err)
+			}
+			data = formatDir([][]byte{record})
+		}
+		child, err = s.newDirEntry(parsed.First(i).Path(), data, s.newSequence())
+		if err != nil {
+			return nil, errors.E(op, err)
+		}
+		created = append(created, child)
+	}
+
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	if s.db.readOnly {
+		return nil, errors.E(op, parsed.Path(), errors.Permission, errReadOnly)
+	}
+	// Someone may have made the top directory since we looked.
+	if _, err := s.lookupLocked(op, top, true); !errors.Match(notExist, err) {
+		if err == nil {
+			err = errors.E(op, top.Path(), errors.Exist)
+		}
+		return nil, err
+	}
+	if _, err := s.put(op, child, top, false); err != nil {
+		return nil, err
+	}
+	for i := len(created) - 1; i >= 0; i-- {
+		s.db.eventMgr.newEvent <- upspin.Event{
+			Entry: created[i],
+		}
+	}
+	return created[0], nil
+}
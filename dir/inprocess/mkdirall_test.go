@@ -0,0 +1,70 @@
+// Copyright 2017 The Upspin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package inprocess
+
+import (
+	"reflect"
+	"testing"
+
+	"upspin.io/errors"
+	"upspin.io/upspin"
+)
+
+func TestMakeDirectoryAll(t *testing.T) {
+	config, dir := setup()
+	s := dir.(*server)
+	user := config.UserName()
+	if _, err := makeDirectory(dir, upspin.PathName(user+"/a")); err != nil {
+		t.Fatal(err)
+	}
+	tracer := &testTracer{started: make(map[string]int), ended: make(map[string]int)}
+	s.SetTracer(tracer)
+	entry, err := s.MakeDirectoryAll(upspin.PathName(user + "/a/b/c/d"))
+	s.SetTracer(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.Name != upspin.PathName(user+"/a/b/c/d") || !entry.IsDir() {
+		t.Errorf("MakeDirectoryAll returned %v", entry)
+	}
+	// Three new directories, stored once each, then /a and the root.
+	if got, want := tracer.started["Store.Put"], 5; got != want {
+		t.Errorf("MakeDirectoryAll stored %d directories; want %d", got, want)
+	}
+	if errs := s.checkUser(user); len(errs) != 0 {
+		t.Errorf("tree is inconsistent: %v", errs)
+	}
+
+	// The tree is the same as one made a directory at a time.
+	otherConfig, other := setup()
+	otherUser := otherConfig.UserName()
+	for _, name := range []string{"/a", "/a/b", "/a/b/c", "/a/b/c/d"} {
+		if _, err := makeDirectory(other, upspin.PathName(otherUser)+upspin.PathName(name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got, want := walkNames(t, s, user), walkNames(t, other.(*server), otherUser); !reflect.DeepEqual(got, want) {
+		t.Errorf("MakeDirectoryAll made %q; want %q", got, want)
+	}
+
+	// An existing directory is returned as is.
+	again, err := s.MakeDirectoryAll(upspin.PathName(user + "/a/b"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.Name != upspin.PathName(user+"/a/b") {
+		t.Errorf("MakeDirectoryAll of existing directory returned %s", again.Name)
+	}
+
+	if _, err := dir.Put(storeData(t, config, []byte("f"), upspin.PathName(user+"/f"))); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.MakeDirectoryAll(upspin.PathName(user + "/f/x/y")); !errors.Match(errors.E(errors.NotDir), err) {
+		t.Errorf("MakeDirectoryAll below a file: err = %v; want NotDir", err)
+	}
+	if _, err := s.MakeDirectoryAll(upspin.PathName(user + "/x/Access/y")); !errors.Match(errors.E(errors.Invalid), err) {
+		t.Errorf("MakeDirectoryAll through Access: err = %v; want Invalid", err)
+	}
+}
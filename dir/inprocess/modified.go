@@ -0,0 +1,45 @@
+// Copyright 2017 The Upspin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package inprocess
+
+import (
+	"upspin.io/errors"
+	"upspin.io/upspin"
+)
+
+// ModifiedSince returns the files and links in the user's tree whose Time
+// is after since, sorted by name, for incremental backup. Directories are
+// not returned, as their times record only when they were last rewritten
+// by the server, not when their contents changed; the names of the
+// entries returned imply the directories holding them. The Time of an
+// entry is set by its writer, so a client whose clock is behind may
+// write entries that seem older than they are.
+// Only the user may do this.
+func (s *server) ModifiedSince(userName upspin.UserName, since upspin.Time) ([]*upspin.DirEntry, error) {
+	const op = "dir/inprocess.ModifiedSince"
+	userName = normalizeUser(userName, s.db.foldLocal)
+	if normalizeUser(s.config.UserName(), s.db.foldLocal) != userName {
+		return nil, errors.E(op, userName, errors.Permission)
+	}
+
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+	root, ok := s.db.root[userName]
+	if !ok {
+		return nil, errors.E(op, userName, errors.NotExist, errors.Str("no such user"))
+	}
+	var entries []*upspin.DirEntry
+	err := s.walkTree(root, func(entry *upspin.DirEntry) error {
+		if !entry.IsDir() && entry.Time > since && !s.db.expiredLocked(entry) {
+			entries = append(entries, entry)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+	upspin.SortDirEntries(entries, false)
+	return entries, nil
+}
@@ -0,0 +1,55 @@
+// Copyright 2017 The Upspin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package inprocess
+
+import (
+	"strings"
+	"testing"
+
+	"upspin.io/errors"
+	"upspin.io/upspin"
+)
+
+func TestModifiedSince(t *testing.T) {
+	config, dir := setup()
+	s := dir.(*server)
+	user := config.UserName()
+	if _, err := makeDirectory(dir, upspin.PathName(user+"/d")); err != nil {
+		t.Fatal(err)
+	}
+	const base = upspin.Time(1000)
+	for _, f := range []struct {
+		name string
+		time upspin.Time
+	}{
+		{"/old", base - 1},
+		{"/d/same", base},
+		{"/d/new", base + 1},
+		{"/newer", base + 2},
+	} {
+		entry, err := newDirEntryAt(config, upspin.PlainPack, upspin.PathName(string(user)+f.name), []byte(f.name), upspin.AttrNone, "", upspin.SeqIgnore, f.time)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := dir.Put(entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+	entries, err := s.ModifiedSince(user, base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, e := range entries {
+		got = append(got, strings.TrimPrefix(string(e.Name), string(user)))
+	}
+	if want := "/d/new /newer"; strings.Join(got, " ") != want {
+		t.Errorf("ModifiedSince = %q; want %q", got, want)
+	}
+	_, other := setup()
+	if _, err := other.(*server).ModifiedSince(user, 0); !errors.Match(errors.E(errors.Permission), err) {
+		t.Errorf("ModifiedSince by another user: err = %v; want Permission", err)
+	}
+}
@@ -0,0 +1,217 @@
+// Copyright 2017 The Upspin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package inprocess
+
+import (
+	"strings"
+
+	"upspin.io/access"
+	"upspin.io/errors"
+	"upspin.io/path"
+	"upspin.io/upspin"
+)
+
+// MoveTree moves the directory src, with everything below it, into the
+// existing directory dstParent, keeping its last element, so that for
+// instance moving "u@x.com/a/b" into "u@x.com/c" makes "u@x.com/c/b".
+// The destination must not exist and must not be within src. Since each
+// entry holds its full name, every directory in the subtree is stored
+// again with the new names; files and links keep referring to the same
+// data. Access files in the subtree move with it and govern the same
+// entries as before, but the entries now fall under the Access files
+// above dstParent rather than those above src, so only the owner of the
+// tree may do this. Default packings, sticky directories and aliases in
+// the subtree move with it.
+// The subtree must hold no locked entries.
+func (s *server) MoveTree(src, dstParent upspin.PathName) error {
+	const op = "dir/inprocess.MoveTree"
+	srcParsed, err := s.parse(src)
+	if err != nil {
+		return errors.E(op, err)
+	}
+	parentParsed, err := s.parse(dstParent)
+	if err != nil {
+		return errors.E(op, err)
+	}
+	if parentParsed.User() != srcParsed.User() {
+		return errors.E(op, dstParent, errors.Invalid, errors.Errorf("not in tree of %s", srcParsed.User()))
+	}
+	if normalizeUser(s.config.UserName(), s.db.foldLocal) != srcParsed.User() {
+		return errors.E(op, src, errors.Permission)
+	}
+	if srcParsed.IsRoot() {
+		return errors.E(op, src, errors.Invalid, errors.Str("cannot move a root"))
+	}
+	dstParsed, err := path.Parse(path.Join(parentParsed.Path(), srcParsed.Elem(srcParsed.NElem()-1)))
+	if err != nil {
+		return errors.E(op, err)
+	}
+	if parentParsed.HasPrefix(srcParsed) {
+		return errors.E(op, dstParent, errors.Invalid, errors.Str("cannot move a directory into itself"))
+	}
+	// Report links in either path before taking the lock.
+	for _, p := range []path.Parsed{srcParsed, parentParsed} {
+		entry, err := s.lookup(op, p, true)
+		if err != nil {
+			_, err = s.errLink(op, entry, err)
+			return err
+		}
+	}
+
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	if s.db.readOnly {
+		return errors.E(op, src, errors.Permission, errReadOnly)
+	}
+	entry, err := s.lookupLocked(op, srcParsed, false)
+	if err != nil {
+		return errors.E(op, err)
+	}
+	if !entry.IsDir() {
+		return errors.E(op, src, errors.NotDir)
+	}
+	parent, err := s.lookupLocked(op, parentParsed, true)
+	if err != nil {
+		return errors.E(op, err)
+	}
+	if !parent.IsDir() {
+		return errors.E(op, dstParent, errors.NotDir)
+	}
+	if _, err := s.lookupLocked(op, dstParsed, false); !errors.Match(notExist, err) {
+		if err == nil {
+			return errors.E(op, dstParsed.Path(), errors.Exist)
+		}
+		return errors.E(op, err)
+	}
+	oldPrefix := string(srcParsed.Path()) + "/"
+	for name := range s.db.locked {
+		if strings.HasPrefix(string(name), oldPrefix) {
+			return errors.E(op, name, errors.Permission, errLocked)
+		}
+	}
+
+	// Store the renamed subtree, reading its Access files as we go.
+	accessFiles := make(map[upspin.PathName]*access.Access)
+	newEntry, err := s.renameTree(op, entry, dstParsed.Path(), accessFiles)
+	if err != nil {
+		return err
+	}
+	// Install it and remove the original together, so if either fails
+	// neither is made and the records below stay as they are.
+	if _, err := s.putChanges(op, []change{
+		{dir: dstParsed.Drop(1), entries: []*upspin.DirEntry{newEntry}},
+		{dir: srcParsed.Drop(1), entries: []*upspin.DirEntry{entry}, deleting: true},
+	}); err != nil {
+		return err
+	}
+
+	// Move the records kept by name.
+	newPrefix := string(dstParsed.Path()) + "/"
+	for dir := range s.db.access {
+		if strings.HasPrefix(string(dir)+"/", oldPrefix) {
+			delete(s.db.access, dir)
+		}
+	}
+	for dir, a := range accessFiles {
+		s.db.access[dir] = a
+	}
+	for name, x := range s.db.expire {
+		if strings.HasPrefix(string(name), oldPrefix) {
+			delete(s.db.expire, name)
+			s.db.expire[upspin.PathName(newPrefix+strings.TrimPrefix(string(name), oldPrefix))] = x
+		}
+	}
+	for dir, packing := range s.db.defaultPacking {
+		if strings.HasPrefix(string(dir)+"/", oldPrefix) {
+			delete(s.db.defaultPacking, dir)
+			s.db.defaultPacking[dstParsed.Path()+dir[len(srcParsed.Path()):]] = packing
+		}
+	}
+	for dir := range s.db.sticky {
+		if strings.HasPrefix(string(dir)+"/", oldPrefix) {
+			delete(s.db.sticky, dir)
+			s.db.sticky[dstParsed.Path()+dir[len(srcParsed.Path()):]] = true
+		}
+	}
+	s.db.moveHistory(oldPrefix, newPrefix)
+	for name, ctype := range s.db.contentType {
+		if strings.HasPrefix(string(name), oldPrefix) {
+			delete(s.db.contentType, name)
+			s.db.contentType[upspin.PathName(newPrefix+strings.TrimPrefix(string(name), oldPrefix))] = ctype
+		}
+	}
+	for alias, target := range s.db.aliases {
+		if strings.HasPrefix(string(alias), oldPrefix) {
+			delete(s.db.aliases, alias)
+			s.db.aliases[dstParsed.Path()+alias[len(srcParsed.Path()):]] = dstParsed.Path() + target[len(srcParsed.Path()):]
+		}
+	}
+	s.db.eventMgr.newEvent <- upspin.Event{
+		Entry: newEntry,
+	}
+	s.db.eventMgr.newEvent <- upspin.Event{
+		Entry:  entry,
+		Delete: true,
+	}
+	return nil
+}
+
+// renameTree stores a copy of the directory and everything below it
+// with the directory renamed to newName, and returns the entry for the
+// copy. Files and links keep their data; only directories are stored.
+// It adds the Access files in the copy, parsed, to accessFiles, and
+// forgets any Group files in the original. s.db.mu is held.
+func (s *server) renameTree(op string, dir *upspin.DirEntry, newName upspin.PathName, accessFiles map[upspin.PathName]*access.Access) (*upspin.DirEntry, error) {
+	payload, err := s.readAll(dir)
+	if err != nil {
+		return nil, errors.E(op, dir.Name, err)
+	}
+	contents, err := parseDir(payload)
+	if err != nil {
+		return nil, errors.E(op, dir.Name, err)
+	}
+	records := make([][]byte, contents.len())
+	for i := range records {
+		entry, err := contents.entry(i)
+		if err != nil {
+			return nil, errors.E(op, dir.Name, err)
+		}
+		oldName := entry.Name
+		name := path.Join(newName, strings.TrimPrefix(string(oldName), string(dir.Name)+"/"))
+		if entry.IsDir() {
+			entry, err = s.renameTree(op, entry, name, accessFiles)
+			if err != nil {
+				return nil, err
+			}
+		} else {
+			// SignedName is untouched, as the signature covers it.
+			entry = entry.Copy()
+			entry.Name = name
+		}
+		switch {
+		case access.IsAccessFile(name) && !entry.IsLink():
+			data, err := s.readAll(entry)
+			if err != nil {
+				return nil, errors.E(op, err)
+			}
+			a, err := access.Parse(name, data)
+			if err != nil {
+				return nil, errors.E(op, err)
+			}
+			accessFiles[newName] = a
+		case access.IsGroupFile(oldName):
+			access.RemoveGroup(oldName)
+		}
+		records[i], err = entry.Marshal()
+		if err != nil {
+			return nil, errors.E(op, err)
+		}
+	}
+	entry, err := s.newDirEntry(newName, formatDir(records), s.newSequence())
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+	return entry, nil
+}
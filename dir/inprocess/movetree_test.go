@@ -0,0 +1,113 @@
+// Copyright 2017 The Upspin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package inprocess
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"upspin.io/errors"
+	"upspin.io/upspin"
+)
+
+func TestMoveTree(t *testing.T) {
+	config, dir := globTree(t)
+	s := dir.(*server)
+	user := config.UserName()
+	p := func(name string) upspin.PathName { return upspin.PathName(string(user) + name) }
+	accessFile := storePlainWithIntegrity(t, config, []byte(fmt.Sprintf("*: %s\n", user)), p("/a/b/Access"))
+	if _, err := dir.Put(accessFile); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.MoveTree(p("/a"), p("/c/d")); err != nil {
+		t.Fatal(err)
+	}
+	got := walkNames(t, s, user)
+	want := []string{"/", "/c", "/c/d", "/c/d/a", "/c/d/a/b", "/c/d/a/b/Access", "/c/d/a/b/f3", "/c/d/a/f2", "/c/d/f5", "/c/f4", "/f1", "/link"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("tree after MoveTree:\n\t%q\nwant\n\t%q", got, want)
+	}
+	data, err := s.GetData(p("/c/d/a/b/f3"))
+	if err != nil || string(data) != "/a/b/f3" {
+		t.Errorf("moved file holds %q, %v", data, err)
+	}
+	if _, ok := s.db.access[p("/c/d/a/b")]; !ok {
+		t.Error("moved Access file not in force")
+	}
+	if _, ok := s.db.access[p("/a/b")]; ok {
+		t.Error("Access file still in force at old name")
+	}
+	if errs := s.checkUser(user); len(errs) != 0 {
+		t.Errorf("tree is inconsistent: %v", errs)
+	}
+	// The moved tree may be changed like any other.
+	if _, err := s.Delete(p("/c/d/a/f2")); err != nil {
+		t.Errorf("Delete in moved tree: %v", err)
+	}
+
+	for _, test := range []struct {
+		src, dst string
+		kind     errors.Kind
+	}{
+		{"/c", "/c/d/a", errors.Invalid}, // Into itself.
+		{"/c/d/a", "/c/d", errors.Exist}, // Already there.
+		{"/f1", "/c", errors.NotDir},     // Not a directory.
+		{"/c/d", "/f1", errors.NotDir},   // Nor is the parent.
+		{"/c/d", "/nowhere", errors.NotExist},
+		{"/", "/c", errors.Invalid},
+	} {
+		if err := s.MoveTree(p(test.src), p(test.dst)); !errors.Match(errors.E(test.kind), err) {
+			t.Errorf("MoveTree(%s, %s): err = %v; want %v", test.src, test.dst, err, test.kind)
+		}
+	}
+	_, other := setup()
+	if err := other.(*server).MoveTree(p("/c/d"), p("/")); !errors.Match(errors.E(errors.Permission), err) {
+		t.Errorf("MoveTree by another user: err = %v; want Permission", err)
+	}
+}
+
+func TestMoveTreeFailAfter(t *testing.T) {
+	config, dir := globTree(t)
+	s := dir.(*server)
+	user := config.UserName()
+	p := func(name string) upspin.PathName { return upspin.PathName(string(user) + name) }
+	accessFile := storePlainWithIntegrity(t, config, []byte(fmt.Sprintf("*: %s\n", user)), p("/a/b/Access"))
+	if _, err := dir.Put(accessFile); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetSticky(p("/a/b"), true); err != nil {
+		t.Fatal(err)
+	}
+	before := walkNames(t, s, user)
+	// The renamed a/b and a, then c/d, c and the root for the new
+	// tree, then the root for the removal of the old one.
+	stores := failEachStore(t, s, []upspin.UserName{user}, func() error {
+		return s.MoveTree(p("/a"), p("/c/d"))
+	}, func(n int) {
+		if got := walkNames(t, s, user); !reflect.DeepEqual(got, before) {
+			t.Errorf("failing after %d stores: tree is\n\t%q\nwant\n\t%q", n, got, before)
+		}
+		if _, ok := s.db.access[p("/a/b")]; !ok {
+			t.Errorf("failing after %d stores: Access file not in force", n)
+		}
+		if _, ok := s.db.access[p("/c/d/a/b")]; ok {
+			t.Errorf("failing after %d stores: Access file in force at new name", n)
+		}
+		if !s.db.sticky[p("/a/b")] || s.db.sticky[p("/c/d/a/b")] {
+			t.Errorf("failing after %d stores: sticky record moved", n)
+		}
+	})
+	if stores != 6 {
+		t.Errorf("MoveTree made %d stores; want 6", stores)
+	}
+	if _, ok := s.db.access[p("/c/d/a/b")]; !ok {
+		t.Error("moved Access file not in force")
+	}
+	if !s.db.sticky[p("/c/d/a/b")] {
+		t.Error("sticky record not moved")
+	}
+}
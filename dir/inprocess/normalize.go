@@ -0,0 +1,166 @@
+// Copyright 2017 The Upspin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package inprocess
+
+import (
+	"sort"
+	"strings"
+
+	"upspin.io/errors"
+	"upspin.io/path"
+	"upspin.io/upspin"
+	"upspin.io/user"
+)
+
+// The user name in every path presented to the server is normalized before
+// it is used as a key for the user's root, so that spellings of a user name
+// that differ only in case key the same tree.
+//
+// The domain part of the name is always lower-cased, as user.Clean does.
+// The local part is preserved as is, since it is case-sensitive, unless
+// the foldLocal option is set, in which case it is lower-cased too.
+// Thus by default "Gopher@Google.com" and "Gopher@google.com" name the
+// same root, but "gopher@google.com" names a different one.
+
+// normalizeUser returns the normalized form of the user name.
+// If the name is invalid it is returned unchanged; it will be rejected
+// when the path is parsed.
+func normalizeUser(userName upspin.UserName, foldLocal bool) upspin.UserName {
+	clean, err := user.Clean(userName)
+	if err != nil {
+		return userName
+	}
+	if foldLocal {
+		clean = upspin.UserName(strings.ToLower(string(clean)))
+	}
+	return clean
+}
+
+// normalizePath returns the path name with its user name normalized.
+func normalizePath(name upspin.PathName, foldLocal bool) upspin.PathName {
+	str := string(name)
+	userName, rest := str, ""
+	if slash := strings.IndexByte(str, '/'); slash >= 0 {
+		userName, rest = str[:slash], str[slash:]
+	}
+	norm := normalizeUser(upspin.UserName(userName), foldLocal)
+	if string(norm) == userName {
+		return name
+	}
+	return upspin.PathName(string(norm) + rest)
+}
+
+// parse parses the path name after normalizing its user name, then
+// applies the CanonicalizeFunc, if any, to the clean result.
+func (s *server) parse(name upspin.PathName) (path.Parsed, error) {
+	parsed, err := path.Parse(normalizePath(name, s.db.foldLocal))
+	if err != nil {
+		return parsed, err
+	}
+	canon := s.canonicalizeFunc()
+	if canon == nil {
+		return parsed, nil
+	}
+	return path.Parse(canon(parsed.Path()))
+}
+
+// CanonicalizeFunc returns the canonical form of a path name, such as
+// one whose elements are in Unicode normalization form C, so that names
+// that differ only in spelling refer to the same entry. It is given a
+// clean path with its user name normalized and should not change the
+// user name.
+type CanonicalizeFunc func(upspin.PathName) upspin.PathName
+
+// SetCanonicalizeFunc sets the function applied to every path name
+// presented to the server, including those of entries stored by Put and
+// MakeDirectory, before it is used. If canon is nil, names are used as
+// parsed, which is the default. The setting applies to all users of the
+// server and should be made before any names are stored, as names
+// already in the tree are not rewritten.
+func (s *server) SetCanonicalizeFunc(canon CanonicalizeFunc) {
+	s.db.canonicalize.Store(canonicalizeValue{canon})
+}
+
+// canonicalizeValue is the type stored in db.canonicalize; see tracerValue.
+type canonicalizeValue struct {
+	CanonicalizeFunc
+}
+
+// canonicalizeFunc returns the CanonicalizeFunc, or nil if none is set.
+// It is held in an atomic.Value so that it may be read with or without
+// s.db.mu held.
+func (s *server) canonicalizeFunc() CanonicalizeFunc {
+	c, _ := s.db.canonicalize.Load().(canonicalizeValue)
+	return c.CanonicalizeFunc
+}
+
+// caseConflict returns an Exist error if the directory contents hold an
+// entry whose name differs from name only in case. Such an entry could
+// be anywhere in the directory, so every entry is examined.
+func caseConflict(contents *dirData, name upspin.PathName) error {
+	for i := 0; i < contents.len(); i++ {
+		entry, err := contents.entry(i)
+		if err != nil {
+			return err
+		}
+		if strings.EqualFold(string(entry.Name), string(name)) {
+			return errors.E(name, errors.Exist, errors.Errorf("conflicts with %s", entry.Name))
+		}
+	}
+	return nil
+}
+
+// CaseCollisions returns the names in the user's tree that differ only
+// in case from another name in the same directory, as would be rejected
+// with the caseInsensitive option set. The names are grouped by
+// directory, directories in order, and within a directory each set of
+// colliding names is together, in order. Only the user may do this.
+func (s *server) CaseCollisions(userName upspin.UserName) ([]upspin.PathName, error) {
+	const op = "dir/inprocess.CaseCollisions"
+	userName = normalizeUser(userName, s.db.foldLocal)
+	if normalizeUser(s.config.UserName(), s.db.foldLocal) != userName {
+		return nil, errors.E(op, userName, errors.Permission)
+	}
+
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+	root, ok := s.db.root[userName]
+	if !ok {
+		return nil, errors.E(op, userName, errors.NotExist, errors.Str("no such user"))
+	}
+	type key struct {
+		dir  upspin.PathName
+		elem string // Lower-cased.
+	}
+	names := make(map[key][]upspin.PathName)
+	err := s.walkTree(root, func(entry *upspin.DirEntry) error {
+		name := string(entry.Name)
+		elem := name[strings.LastIndexByte(name, '/')+1:]
+		k := key{path.DropPath(entry.Name, 1), strings.ToLower(elem)}
+		names[k] = append(names[k], entry.Name)
+		return nil
+	})
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+	var keys []key
+	for k, n := range names {
+		if len(n) > 1 {
+			keys = append(keys, k)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].dir != keys[j].dir {
+			return keys[i].dir < keys[j].dir
+		}
+		return keys[i].elem < keys[j].elem
+	})
+	var collisions []upspin.PathName
+	for _, k := range keys {
+		// Each directory is walked in name order.
+		collisions = append(collisions, names[k]...)
+	}
+	return collisions, nil
+}
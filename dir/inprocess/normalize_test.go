@@ -0,0 +1,191 @@
+// Copyright 2017 The Upspin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package inprocess
+
+import (
+	"strings"
+	"testing"
+
+	"upspin.io/errors"
+	"upspin.io/upspin"
+)
+
+func TestNormalizePath(t *testing.T) {
+	tests := []struct {
+		name      upspin.PathName
+		foldLocal bool
+		want      upspin.PathName
+	}{
+		{"gopher@google.com/a/B", false, "gopher@google.com/a/B"},
+		{"Gopher@Google.com/a/B", false, "Gopher@google.com/a/B"},
+		{"Gopher@GOOGLE.COM", false, "Gopher@google.com"},
+		{"Gopher@Google.com/a/B", true, "gopher@google.com/a/B"},
+		{"gopher@google.com/", true, "gopher@google.com/"},
+		{"not a user/x", false, "not a user/x"}, // Invalid names are left alone.
+	}
+	for _, test := range tests {
+		got := normalizePath(test.name, test.foldLocal)
+		if got != test.want {
+			t.Errorf("normalizePath(%q, %t) = %q; want %q", test.name, test.foldLocal, got, test.want)
+		}
+	}
+}
+
+func TestNormalizeUserInPaths(t *testing.T) {
+	config, dir := setup()
+	s := dir.(*server)
+	user := string(config.UserName())
+	at := strings.IndexByte(user, '@')
+	upperDomain := upspin.PathName(user[:at] + "@" + strings.ToUpper(user[at+1:]))
+	upperLocal := upspin.PathName(strings.ToUpper(user[:at]) + user[at:])
+
+	// Put using an upper-case domain; it must land in the user's tree.
+	if _, err := makeDirectory(dir, upperDomain+"/dir"); err != nil {
+		t.Fatal(err)
+	}
+	got, err := dir.Lookup(upspin.PathName(user + "/dir"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := upspin.PathName(user + "/dir"); got.Name != want {
+		t.Errorf("name = %q; want %q", got.Name, want)
+	}
+	if _, err := dir.Lookup(upperDomain + "/dir"); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := dir.Glob(string(upperDomain + "/*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("Glob found %d entries; want 1", len(entries))
+	}
+	if len(s.db.root) != 1 {
+		t.Fatalf("%d roots; want 1", len(s.db.root))
+	}
+
+	// The local part is significant unless folding is enabled.
+	if _, err := dir.Lookup(upperLocal + "/dir"); err == nil {
+		t.Fatal("Lookup with upper-case local part succeeded without folding")
+	}
+	s.db.foldLocal = true
+	if _, err := dir.Lookup(upperLocal + "/dir"); err != nil {
+		t.Fatalf("Lookup with upper-case local part and folding: %v", err)
+	}
+}
+
+func TestCaseInsensitive(t *testing.T) {
+	config, dir := setup()
+	s := dir.(*server)
+	user := config.UserName()
+	lower := upspin.PathName(user + "/foo")
+	upper := upspin.PathName(user + "/Foo")
+
+	// Without the option, names differing in case are distinct.
+	if _, err := makeDirectory(dir, lower); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := makeDirectory(dir, upper); err != nil {
+		t.Fatalf("MakeDirectory %s without caseInsensitive: %v", upper, err)
+	}
+
+	if err := s.db.setOption("caseInsensitive=true"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := makeDirectory(dir, upspin.PathName(user+"/foo/bar")); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"/FOO", "/foo/BAR"} {
+		if _, err := makeDirectory(dir, upspin.PathName(string(user)+name)); !errors.Match(errors.E(errors.Exist), err) {
+			t.Errorf("MakeDirectory %s: err = %v; want Exist", name, err)
+		}
+	}
+	// Files conflict too.
+	file := upspin.PathName(user + "/foo/Bar")
+	if _, err := dir.Put(storeData(t, config, []byte("x"), file)); !errors.Match(errors.E(errors.Exist), err) {
+		t.Errorf("Put %s: err = %v; want Exist", file, err)
+	}
+	// Distinct names are fine.
+	if _, err := makeDirectory(dir, upspin.PathName(user+"/other")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCaseCollisions(t *testing.T) {
+	config, dir := setup()
+	s := dir.(*server)
+	user := string(config.UserName())
+	for _, name := range []string{"/Dir", "/dir", "/dir/sub"} {
+		if _, err := makeDirectory(dir, upspin.PathName(user+name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, name := range []string{"/file", "/FILE", "/other", "/dir/a", "/dir/A", "/dir/b", "/dir/sub/x", "/Dir/x"} {
+		if _, err := dir.Put(storeData(t, config, []byte(name), upspin.PathName(user+name))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	got, err := s.CaseCollisions(config.UserName())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, name := range got {
+		names = append(names, strings.TrimPrefix(string(name), user))
+	}
+	if want := "/Dir /dir /FILE /file /dir/A /dir/a"; strings.Join(names, " ") != want {
+		t.Errorf("CaseCollisions = %q; want %q", names, want)
+	}
+
+	_, other := setup()
+	if _, err := other.(*server).CaseCollisions(config.UserName()); !errors.Match(errors.E(errors.Permission), err) {
+		t.Errorf("CaseCollisions by another user: err = %v; want Permission", err)
+	}
+}
+
+func TestCanonicalizeFunc(t *testing.T) {
+	config, dir := setup()
+	s := dir.(*server)
+	user := string(config.UserName())
+	// Compose a decomposed e-acute, a tiny stand-in for NFC.
+	const decomposed, composed = "e\u0301", "\u00e9"
+	s.SetCanonicalizeFunc(func(name upspin.PathName) upspin.PathName {
+		return upspin.PathName(strings.Replace(string(name), decomposed, composed, -1))
+	})
+
+	if _, err := makeDirectory(dir, upspin.PathName(user+"/caf"+decomposed)); err != nil {
+		t.Fatal(err)
+	}
+	entry := storeData(t, config, []byte("résumé"), upspin.PathName(user+"/caf"+decomposed+"/r"+decomposed+"sum"+decomposed))
+	if _, err := dir.Put(entry); err != nil {
+		t.Fatal(err)
+	}
+	want := upspin.PathName(user + "/caf" + composed + "/r" + composed + "sum" + composed)
+	for _, name := range []string{
+		user + "/caf" + composed + "/r" + composed + "sum" + composed,
+		user + "/caf" + decomposed + "/r" + composed + "sum" + decomposed,
+	} {
+		e, err := dir.Lookup(upspin.PathName(name))
+		if err != nil {
+			t.Fatalf("Lookup(%q): %v", name, err)
+		}
+		if e.Name != want {
+			t.Errorf("Lookup(%q).Name = %q; want %q", name, e.Name, want)
+		}
+	}
+	entries, err := dir.Glob(user + "/caf" + decomposed + "/*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Name != want {
+		t.Errorf("Glob found %v; want %q", entryNames(entries), want)
+	}
+
+	// Without the function the spellings are distinct.
+	s.SetCanonicalizeFunc(nil)
+	if _, err := dir.Lookup(upspin.PathName(user + "/caf" + decomposed)); !errors.Match(errors.E(errors.NotExist), err) {
+		t.Errorf("Lookup of decomposed name with no CanonicalizeFunc: err = %v; want NotExist", err)
+	}
+}
@@ -0,0 +1,165 @@
+// Copyright 2017 The Upspin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package inprocess
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"upspin.io/config"
+	"upspin.io/errors"
+	"upspin.io/upspin"
+)
+
+// setOption applies an option, of the form "key=value", to the database.
+// The options are:
+//
+//	foldLocal=<bool>
+//		Lower-case the local part of user names as well as the domain.
+//		See normalize.go.
+//	caseInsensitive=<bool>
+//		Reject a new entry whose name differs only in case from
+//		that of an entry already in the directory.
+//	idempotentMkdir=<bool>
+//		MakeDirectory returns the existing entry, rather than an
+//		Exist error, if the directory already exists.
+//	readOnly=<bool>
+//		Start the server read-only. See SetReadOnly.
+//	indirectSize=<bytes>
+//		Store directories larger than this indirectly, behind a
+//		reference that redirects to the data. See indirect.go.
+//	dirBlockSize=<bytes>
+//		Split the data of directories larger than this into several
+//		blocks of at most this size. See blocksize.go.
+//	deterministicKeys=<bool>
+//		Store directories so that the same tree always has the same
+//		references. Directories are then stored unencrypted, so it
+//		is for tests only. See deterministic.go.
+//	maxEntriesPerDir=<n>
+//		Limit the number of entries in a directory. Once a directory
+//		is full, creating a new entry in it fails; existing entries
+//		may still be replaced. Zero, the default, means no limit.
+//	maxTotalBytes=<bytes>
+//		Limit the total size of the files in all users' trees. A put
+//		that would take the total over the limit fails with a "no
+//		space" error, as a full disk would. See space.go.
+//	maxUserBytes=<bytes>
+//		Limit the total size of the files in each user's tree. A put
+//		that would take the user over the limit fails with a "quota
+//		exceeded" error. See space.go and QuotaStatus.
+//	history=<n>
+//		Keep up to n replaced versions of each file, for History
+//		and GetVersion. See history.go.
+//	validateSize=<bool>
+//		Make PutReader fail if the length of the data differs from
+//		PutOptions.Size, when that is set.
+//	accessStats=<bool>
+//		Count the Lookups of each name. See AccessStats.
+//	lookupCache=<bool>
+//		Remember the entries found by looking up names, keyed by
+//		the root they were found under. See lookupcache.go and
+//		LookupCacheStats.
+//	unixHidden=<bool>
+//		Make a glob pattern element match a name beginning with a
+//		period only if the element itself begins with one, as in
+//		the shell, so "*" does not match ".hidden".
+//	reportNotDir=<bool>
+//		Make a lookup of a path whose middle passes through a file
+//		fail with ErrNotDirectory, naming the file, rather than
+//		NotExist.
+//	readTimeout=<duration>
+//		Make Lookup and Glob, and the other operations that look up
+//		names, fail with a "timed out acquiring lock" error if they
+//		cannot take the read lock within the duration, such as
+//		"100ms", rather than waiting for a writer indefinitely.
+//		See rlock.go.
+//	dirStore=<endpoint>
+//		Store directory data in the store at the endpoint, such as
+//		"remote,store.example.com:443", rather than in the store of
+//		the config passed to New. File data written by PutReader
+//		still goes to the store of the caller's config.
+func (db *database) setOption(opt string) error {
+	o := strings.SplitN(opt, "=", 2)
+	if len(o) != 2 {
+		return errors.E(errors.Invalid, errors.Errorf("invalid option format: %q", opt))
+	}
+	k, v := o[0], o[1]
+	switch k {
+	case "foldLocal":
+		return boolOption(k, v, &db.foldLocal)
+	case "caseInsensitive":
+		return boolOption(k, v, &db.caseInsensitive)
+	case "idempotentMkdir":
+		return boolOption(k, v, &db.idempotentMkdir)
+	case "readOnly":
+		return boolOption(k, v, &db.readOnly)
+	case "indirectSize":
+		return intOption(k, v, &db.indirectSize)
+	case "dirBlockSize":
+		return intOption(k, v, &db.dirBlockSize)
+	case "deterministicKeys":
+		return boolOption(k, v, &db.deterministicKeys)
+	case "maxEntriesPerDir":
+		return intOption(k, v, &db.maxEntries)
+	case "maxTotalBytes":
+		return intOption(k, v, &db.maxTotalBytes)
+	case "maxUserBytes":
+		return intOption(k, v, &db.maxUserBytes)
+	case "history":
+		return intOption(k, v, &db.historySize)
+	case "validateSize":
+		return boolOption(k, v, &db.validateSize)
+	case "accessStats":
+		return boolOption(k, v, &db.trackAccess)
+	case "lookupCache":
+		return boolOption(k, v, &db.cacheLookups)
+	case "unixHidden":
+		return boolOption(k, v, &db.unixHidden)
+	case "reportNotDir":
+		return boolOption(k, v, &db.reportNotDir)
+	case "readTimeout":
+		return durationOption(k, v, &db.readTimeout)
+	case "dirStore":
+		ep, err := upspin.ParseEndpoint(v)
+		if err != nil {
+			return errors.E(errors.Invalid, errors.Errorf("invalid value %q for option %s: %v", v, k, err))
+		}
+		db.dirConfig = config.SetStoreEndpoint(db.dirConfig, *ep)
+		return nil
+	}
+	return errors.E(errors.Invalid, errors.Errorf("unknown option %q", k))
+}
+
+// boolOption parses the value of the boolean option k into b.
+func boolOption(k, v string, b *bool) error {
+	val, err := strconv.ParseBool(v)
+	if err != nil {
+		return errors.E(errors.Invalid, errors.Errorf("invalid value %q for option %s", v, k))
+	}
+	*b = val
+	return nil
+}
+
+// intOption parses the value of the non-negative integer option k into i.
+func intOption(k, v string, i *int) error {
+	val, err := strconv.Atoi(v)
+	if err != nil || val < 0 {
+		return errors.E(errors.Invalid, errors.Errorf("invalid value %q for option %s", v, k))
+	}
+	*i = val
+	return nil
+}
+
+// durationOption parses the value of the non-negative duration option k
+// into d.
+func durationOption(k, v string, d *time.Duration) error {
+	val, err := time.ParseDuration(v)
+	if err != nil || val < 0 {
+		return errors.E(errors.Invalid, errors.Errorf("invalid value %q for option %s", v, k))
+	}
+	*d = val
+	return nil
+}
@@ -0,0 +1,115 @@
+// Copyright 2017 The Upspin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package inprocess
+
+// Default packings.
+//
+// A directory may be given a default packing by SetDefaultPacking. When
+// PutReader is asked to store a file with upspin.UnassignedPack, it packs
+// the data with the default packing of the nearest directory at or above
+// the one holding the file that has one, much as the nearest Access file
+// governs an entry. If there is none the Put fails as before, as
+// UnassignedPack is not a packing. Put is unaffected, as its entries
+// arrive already packed.
+//
+// A default packing is not part of the DirEntry, so it is recorded in
+// db.defaultPacking, keyed by directory name. It is forgotten when the
+// directory is deleted and moves with the directory under MoveTree.
+
+import (
+	"upspin.io/access"
+	"upspin.io/errors"
+	"upspin.io/pack"
+	"upspin.io/path"
+	"upspin.io/upspin"
+)
+
+// SetDefaultPacking sets the default packing of the named directory,
+// which files stored by PutReader with upspin.UnassignedPack in it and
+// the directories below it inherit. Setting upspin.UnassignedPack removes
+// the default. The caller needs write rights for the directory.
+func (s *server) SetDefaultPacking(dirName upspin.PathName, packing upspin.Packing) error {
+	const op = "dir/inprocess.SetDefaultPacking"
+	if packing != upspin.UnassignedPack && pack.Lookup(packing) == nil {
+		return errors.E(op, dirName, errors.Invalid, errors.Errorf("no packing %#x registered", packing))
+	}
+	parsed, err := s.parse(dirName)
+	if err != nil {
+		return errors.E(op, err)
+	}
+	entry, err := s.lookup(op, parsed, true)
+	if err != nil {
+		_, err = s.errLink(op, entry, err)
+		return err
+	}
+	can, err := s.can(access.Write, parsed)
+	if err != nil {
+		return errors.E(op, err)
+	}
+	if !can {
+		return s.errPerm(op, parsed)
+	}
+
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	if s.db.readOnly {
+		return errors.E(op, dirName, errors.Permission, errReadOnly)
+	}
+	// Things may have changed since we looked.
+	entry, err = s.lookupLocked(op, parsed, false)
+	if err != nil {
+		return errors.E(op, err)
+	}
+	if !entry.IsDir() {
+		return errors.E(op, dirName, errors.NotDir)
+	}
+	if packing == upspin.UnassignedPack {
+		delete(s.db.defaultPacking, parsed.Path())
+	} else {
+		s.db.defaultPacking[parsed.Path()] = packing
+	}
+	return nil
+}
+
+// DefaultPacking returns the packing PutReader uses for a file stored
+// with upspin.UnassignedPack in the named directory: the default packing
+// of the directory or, if it has none, of its nearest ancestor that has
+// one. If no directory on the path has a default, it returns
+// upspin.UnassignedPack. The caller needs some right for the directory.
+func (s *server) DefaultPacking(dirName upspin.PathName) (upspin.Packing, error) {
+	const op = "dir/inprocess.DefaultPacking"
+	entry, err := s.Lookup(dirName)
+	if err == upspin.ErrFollowLink {
+		return upspin.UnassignedPack, err
+	}
+	if err != nil {
+		return upspin.UnassignedPack, errors.E(op, err)
+	}
+	if !entry.IsDir() {
+		return upspin.UnassignedPack, errors.E(op, entry.Name, errors.NotDir)
+	}
+	return s.db.defaultPackingFor(entry.Name), nil
+}
+
+// defaultPackingFor returns the default packing of the named directory
+// or its nearest ancestor that has one, or upspin.UnassignedPack.
+// s.db.mu is _not_ held.
+func (db *database) defaultPackingFor(dirName upspin.PathName) upspin.Packing {
+	parsed, err := path.Parse(dirName)
+	if err != nil {
+		return upspin.UnassignedPack
+	}
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	for {
+		if packing, ok := db.defaultPacking[parsed.Path()]; ok {
+			return packing
+		}
+		if parsed.IsRoot() {
+			return upspin.UnassignedPack
+		}
+		parsed = parsed.Drop(1)
+	}
+}
@@ -0,0 +1,119 @@
+// Copyright 2017 The Upspin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package inprocess
+
+import (
+	"strings"
+	"testing"
+
+	"upspin.io/errors"
+	"upspin.io/upspin"
+)
+
+func TestDefaultPacking(t *testing.T) {
+	config, dir := setup()
+	s := dir.(*server)
+	user := config.UserName()
+	for _, d := range []string{"/a", "/a/b", "/c"} {
+		if _, err := makeDirectory(dir, upspin.PathName(string(user)+d)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	name := func(n string) upspin.PathName { return upspin.PathName(string(user) + n) }
+
+	// With no defaults, UnassignedPack fails as before.
+	_, err := s.PutReader(name("/a/f"), strings.NewReader("data"), upspin.UnassignedPack, nil)
+	if !errors.Match(errors.E(errors.Invalid), err) {
+		t.Fatalf("PutReader with no default: got %v; want Invalid", err)
+	}
+
+	if err := s.SetDefaultPacking(name("/a"), upspin.PlainPack); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetDefaultPacking(name("/"), upspin.EEPack); err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		file string
+		want upspin.Packing
+	}{
+		{"/f", upspin.EEPack},
+		{"/a/f", upspin.PlainPack},
+		{"/a/b/f", upspin.PlainPack}, // Inherited from /a.
+		{"/c/f", upspin.EEPack},      // Inherited from the root.
+	} {
+		entry, err := s.PutReader(name(test.file), strings.NewReader("data"), upspin.UnassignedPack, nil)
+		if err != nil {
+			t.Fatalf("PutReader(%q): %v", test.file, err)
+		}
+		if entry.Packing != test.want {
+			t.Errorf("%q packed with %v; want %v", test.file, entry.Packing, test.want)
+		}
+		data, err := s.GetData(entry.Name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != "data" {
+			t.Errorf("%q holds %q; want %q", test.file, data, "data")
+		}
+	}
+
+	// An explicit packing overrides the default.
+	entry, err := s.PutReader(name("/a/g"), strings.NewReader("data"), upspin.EEPack, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.Packing != upspin.EEPack {
+		t.Errorf("packed with %v; want %v", entry.Packing, upspin.EEPack)
+	}
+
+	// Clearing a default exposes the one above.
+	if err := s.SetDefaultPacking(name("/a"), upspin.UnassignedPack); err != nil {
+		t.Fatal(err)
+	}
+	if p, err := s.DefaultPacking(name("/a/b")); err != nil || p != upspin.EEPack {
+		t.Errorf("DefaultPacking(/a/b) = %v, %v; want %v", p, err, upspin.EEPack)
+	}
+
+	// Errors.
+	if err := s.SetDefaultPacking(name("/f"), upspin.PlainPack); !errors.Match(errors.E(errors.NotDir), err) {
+		t.Errorf("SetDefaultPacking on a file: got %v; want NotDir", err)
+	}
+	if err := s.SetDefaultPacking(name("/a"), upspin.Packing(99)); !errors.Match(errors.E(errors.Invalid), err) {
+		t.Errorf("SetDefaultPacking with unknown packing: got %v; want Invalid", err)
+	}
+}
+
+func TestDefaultPackingMoveAndDelete(t *testing.T) {
+	config, dir := setup()
+	s := dir.(*server)
+	user := config.UserName()
+	name := func(n string) upspin.PathName { return upspin.PathName(string(user) + n) }
+	for _, d := range []string{"/a", "/a/b", "/c"} {
+		if _, err := makeDirectory(dir, name(d)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.SetDefaultPacking(name("/a/b"), upspin.PlainPack); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.MoveTree(name("/a"), name("/c")); err != nil {
+		t.Fatal(err)
+	}
+	if p, err := s.DefaultPacking(name("/c/a/b")); err != nil || p != upspin.PlainPack {
+		t.Errorf("after move, DefaultPacking = %v, %v; want %v", p, err, upspin.PlainPack)
+	}
+
+	// A directory made where a deleted one was does not inherit its default.
+	if _, err := s.Delete(name("/c/a/b")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := makeDirectory(dir, name("/c/a/b")); err != nil {
+		t.Fatal(err)
+	}
+	if p, err := s.DefaultPacking(name("/c/a/b")); err != nil || p != upspin.UnassignedPack {
+		t.Errorf("after delete, DefaultPacking = %v, %v; want %v", p, err, upspin.UnassignedPack)
+	}
+}
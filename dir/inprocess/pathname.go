@@ -0,0 +1,38 @@
+// Copyright 2017 The Upspin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package inprocess
+
+import (
+	"upspin.io/errors"
+	"upspin.io/upspin"
+)
+
+// Depth returns the number of elements in the path name after the user
+// name, so a user's root has depth 0. The name is parsed as the server
+// parses it but need not exist.
+func (s *server) Depth(name upspin.PathName) (int, error) {
+	const op = "dir/inprocess.Depth"
+	parsed, err := s.parse(name)
+	if err != nil {
+		return 0, errors.E(op, err)
+	}
+	return parsed.NElem(), nil
+}
+
+// Parent returns the canonical name of the directory holding the named
+// item, which need not exist. A user's root has no parent, and asking
+// for one is an Invalid error, so a loop climbing the tree ends at the
+// root rather than spinning there.
+func (s *server) Parent(name upspin.PathName) (upspin.PathName, error) {
+	const op = "dir/inprocess.Parent"
+	parsed, err := s.parse(name)
+	if err != nil {
+		return "", errors.E(op, err)
+	}
+	if parsed.IsRoot() {
+		return "", errors.E(op, parsed.Path(), errors.Invalid, errors.Str("root has no parent"))
+	}
+	return parsed.Drop(1).Path(), nil
+}
@@ -0,0 +1,57 @@
+// Copyright 2017 The Upspin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package inprocess
+
+import (
+	"testing"
+
+	"upspin.io/errors"
+	"upspin.io/upspin"
+)
+
+func TestDepthAndParent(t *testing.T) {
+	config, dir := setup()
+	s := dir.(*server)
+	user := string(config.UserName())
+	for _, test := range []struct {
+		name   string
+		depth  int
+		parent string
+	}{
+		{"/", 0, ""},
+		{"", 0, ""},
+		{"/a", 1, "/"},
+		{"/a/", 1, "/"},
+		{"//a//b/c", 3, "/a/b"},
+		{"/a/../b", 1, "/"},
+	} {
+		name := upspin.PathName(user + test.name)
+		depth, err := s.Depth(name)
+		if err != nil {
+			t.Errorf("Depth(%s): %v", name, err)
+		} else if depth != test.depth {
+			t.Errorf("Depth(%s) = %d; want %d", name, depth, test.depth)
+		}
+		parent, err := s.Parent(name)
+		if test.parent == "" {
+			if !errors.Match(errors.E(errors.Invalid), err) {
+				t.Errorf("Parent(%s): err = %v; want Invalid", name, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Parent(%s): %v", name, err)
+		} else if want := upspin.PathName(user + test.parent); parent != want {
+			t.Errorf("Parent(%s) = %s; want %s", name, parent, want)
+		}
+	}
+
+	if _, err := s.Depth("not-a-user/a"); err == nil {
+		t.Error("Depth of bad name succeeded")
+	}
+	if _, err := s.Parent("not-a-user/a"); err == nil {
+		t.Error("Parent of bad name succeeded")
+	}
+}
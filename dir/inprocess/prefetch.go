@@ -0,0 +1,45 @@
+// Copyright 2017 The Upspin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package inprocess
+
+import (
+	"upspin.io/access"
+	"upspin.io/errors"
+	"upspin.io/upspin"
+)
+
+// Prefetch reads every directory in the tree rooted at the named
+// directory, so that a later Glob or recursive operation over the tree
+// finds the data already loaded into any cache between the server and
+// its store. It returns the first error encountered, which makes it
+// useful for checking that the whole tree is readable. Links are not
+// followed. The caller needs list rights for the directory.
+func (s *server) Prefetch(dirName upspin.PathName) error {
+	const op = "dir/inprocess.Prefetch"
+	parsed, err := s.parse(dirName)
+	if err != nil {
+		return errors.E(op, err)
+	}
+	dir, err := s.lookup(op, parsed, true)
+	if err != nil {
+		_, err = s.errLink(op, dir, err)
+		return err
+	}
+	if !dir.IsDir() {
+		return errors.E(op, dirName, errors.NotDir)
+	}
+	canList, err := s.can(access.List, parsed)
+	if err != nil {
+		return errors.E(op, err)
+	}
+	if !canList {
+		return s.errPerm(op, parsed)
+	}
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+	// walkTree reads each directory as it descends into it;
+	// there is nothing more to do with the entries.
+	return s.walkTree(dir, func(*upspin.DirEntry) error { return nil })
+}
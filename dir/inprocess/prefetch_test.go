@@ -0,0 +1,45 @@
+// Copyright 2017 The Upspin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package inprocess
+
+import (
+	"testing"
+
+	"upspin.io/bind"
+	"upspin.io/errors"
+	"upspin.io/upspin"
+)
+
+func TestPrefetch(t *testing.T) {
+	config, dir := globTree(t)
+	s := dir.(*server)
+	user := string(config.UserName())
+
+	if err := s.Prefetch(upspin.PathName(user + "/")); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Prefetch(upspin.PathName(user + "/f1")); !errors.Match(errors.E(errors.NotDir), err) {
+		t.Errorf("Prefetch of file: err = %v; want NotDir", err)
+	}
+
+	// Remove a directory's data from the store; Prefetch must notice.
+	entry, err := dir.Lookup(upspin.PathName(user + "/c/d"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := bind.StoreServer(config, config.StoreEndpoint())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Delete(entry.Blocks[0].Location.Reference); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Prefetch(upspin.PathName(user + "/a")); err != nil {
+		t.Errorf("Prefetch of intact subtree: %v", err)
+	}
+	if err := s.Prefetch(upspin.PathName(user + "/")); err == nil {
+		t.Error("Prefetch of damaged tree succeeded")
+	}
+}
@@ -0,0 +1,60 @@
+// Copyright 2017 The Upspin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package inprocess
+
+import (
+	"upspin.io/errors"
+	"upspin.io/upspin"
+)
+
+// ReachableKeys returns the set of store references used by the user's
+// tree: the blocks of the root, of every directory and of every file.
+// For a directory stored indirectly (see indirect.go), both the
+// redirecting reference and the one it redirects to are included.
+// Comparing the set with the contents of the store identifies the
+// blocks no longer referenced. Only the user may do this.
+func (s *server) ReachableKeys(userName upspin.UserName) (map[upspin.Reference]bool, error) {
+	const op = "dir/inprocess.ReachableKeys"
+	userName = normalizeUser(userName, s.db.foldLocal)
+	if normalizeUser(s.config.UserName(), s.db.foldLocal) != userName {
+		return nil, errors.E(op, userName, errors.Permission)
+	}
+
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+	root, ok := s.db.root[userName]
+	if !ok {
+		return nil, errors.E(op, userName, errors.NotExist, errors.Str("no such user"))
+	}
+	keys := make(map[upspin.Reference]bool)
+	add := func(entry *upspin.DirEntry) error {
+		for _, b := range entry.Blocks {
+			keys[b.Location.Reference] = true
+			if !entry.IsDir() || s.db.indirectSize <= 0 {
+				continue
+			}
+			// The directory may be stored indirectly.
+			store, err := s.storeServer(b.Location.Endpoint)
+			if err != nil {
+				return err
+			}
+			_, _, locs, err := store.Get(b.Location.Reference)
+			if err != nil {
+				return errors.E(entry.Name, err)
+			}
+			for _, loc := range locs {
+				keys[loc.Reference] = true
+			}
+		}
+		return nil
+	}
+	if err := add(root); err != nil {
+		return nil, errors.E(op, err)
+	}
+	if err := s.walkTree(root, add); err != nil {
+		return nil, errors.E(op, err)
+	}
+	return keys, nil
+}
@@ -0,0 +1,71 @@
+// Copyright 2017 The Upspin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package inprocess
+
+import (
+	"testing"
+
+	"upspin.io/errors"
+	"upspin.io/upspin"
+)
+
+func TestReachableKeys(t *testing.T) {
+	config, dir := globTree(t)
+	s := dir.(*server)
+	user := config.UserName()
+
+	keys, err := s.ReachableKeys(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The root, four directories and five files.
+	if len(keys) != 10 {
+		t.Errorf("%d keys; want 10", len(keys))
+	}
+	for _, name := range []string{"/", "/a/b", "/c/d/f5"} {
+		entry, err := dir.Lookup(upspin.PathName(string(user) + name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !keys[entry.Blocks[0].Location.Reference] {
+			t.Errorf("%s: block not reachable", name)
+		}
+	}
+
+	// A replaced file's block is no longer reachable.
+	name := upspin.PathName(user + "/f1")
+	old, err := dir.Lookup(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dir.Put(storeData(t, config, []byte("new"), name)); err != nil {
+		t.Fatal(err)
+	}
+	keys, err = s.ReachableKeys(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keys[old.Blocks[0].Location.Reference] {
+		t.Error("replaced block still reachable")
+	}
+
+	// Directories stored indirectly contribute two references each.
+	s.db.indirectSize = 1
+	if _, err := dir.Put(storeData(t, config, []byte("rewrite"), upspin.PathName(user+"/c/d/f5"))); err != nil {
+		t.Fatal(err)
+	}
+	keys, err = s.ReachableKeys(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The root, c and d are rewritten.
+	if len(keys) != 13 {
+		t.Errorf("%d keys with indirection; want 13", len(keys))
+	}
+
+	if _, err := s.ReachableKeys(nextUser()); !errors.Match(errors.E(errors.Permission), err) {
+		t.Errorf("other user: err = %v; want Permission", err)
+	}
+}
@@ -0,0 +1,21 @@
+// Copyright 2017 The Upspin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package inprocess
+
+import "upspin.io/errors"
+
+var errReadOnly = errors.Str("directory is read-only")
+
+// SetReadOnly sets whether the server is read-only. While it is, every
+// method that would modify the tree, including Put, MakeDirectory and
+// Delete, fails with a Permission error, while Lookup, Glob and the other
+// methods that only read the tree work as usual. Modifications already in
+// progress when SetReadOnly is called are completed first.
+// The setting applies to all users of the server.
+func (s *server) SetReadOnly(readOnly bool) {
+	s.db.mu.Lock()
+	s.db.readOnly = readOnly
+	s.db.mu.Unlock()
+}
@@ -0,0 +1,53 @@
+// Copyright 2017 The Upspin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package inprocess
+
+import (
+	"bytes"
+	"testing"
+
+	"upspin.io/errors"
+	"upspin.io/upspin"
+)
+
+func TestReadOnly(t *testing.T) {
+	config, dir := setup()
+	s := dir.(*server)
+	user := config.UserName()
+	fileName := upspin.PathName(user + "/file")
+	if _, err := dir.Put(storeData(t, config, []byte("data"), fileName)); err != nil {
+		t.Fatal(err)
+	}
+
+	s.SetReadOnly(true)
+	isReadOnly := func(what string, err error) {
+		if !errors.Match(errors.E(errors.Permission, errReadOnly), err) {
+			t.Errorf("%s: err = %v; want read-only error", what, err)
+		}
+	}
+	_, err := dir.Put(storeData(t, config, []byte("more data"), fileName))
+	isReadOnly("Put", err)
+	_, err = s.MakeDirectory(upspin.PathName(user + "/dir"))
+	isReadOnly("MakeDirectory", err)
+	_, err = s.PutReader(upspin.PathName(user+"/other"), bytes.NewReader(nil), upspin.PlainPack, nil)
+	isReadOnly("PutReader", err)
+	isReadOnly("PutHardLink", s.PutHardLink(upspin.PathName(user+"/link"), fileName))
+	_, err = dir.Delete(fileName)
+	isReadOnly("Delete", err)
+	isReadOnly("DeleteUser", s.DeleteUser(user))
+
+	// Reads still work.
+	if _, err := dir.Lookup(fileName); err != nil {
+		t.Errorf("Lookup: %v", err)
+	}
+	if entries, err := dir.Glob(string(user + "/*")); err != nil || len(entries) != 1 {
+		t.Errorf("Glob: %d entries, err %v; want 1 entry", len(entries), err)
+	}
+
+	s.SetReadOnly(false)
+	if _, err := dir.Delete(fileName); err != nil {
+		t.Fatal(err)
+	}
+}
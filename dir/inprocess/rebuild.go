@@ -0,0 +1,164 @@
+// Copyright 2017 The Upspin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package inprocess
+
+import (
+	"sort"
+	"strings"
+
+	"upspin.io/access"
+	"upspin.io/errors"
+	"upspin.io/path"
+	"upspin.io/upspin"
+	"upspin.io/valid"
+)
+
+// Rebuild replaces the user's tree, if any, with a new one holding the
+// given entries, as when restoring a tree from a manifest. The entries
+// are files and links with full path names in the user's tree, whose
+// data is already in the store; the directories above them are created
+// as needed. An entry for a directory just makes sure the directory
+// exists. Only the directory data is written to the store, and the whole
+// tree is built before it replaces the old one, so readers see either
+// the old tree or the new one. No Watch events are sent.
+// Only the user may do this.
+func (s *server) Rebuild(userName upspin.UserName, entries []*upspin.DirEntry) error {
+	const op = "dir/inprocess.Rebuild"
+	userName = normalizeUser(userName, s.db.foldLocal)
+	if normalizeUser(s.config.UserName(), s.db.foldLocal) != userName {
+		return errors.E(op, userName, errors.Permission)
+	}
+	rootName := upspin.PathName(userName + "/")
+
+	// Sort the entries into their directories, noting every directory
+	// needed along the way.
+	dirs := map[upspin.PathName]bool{rootName: true}
+	children := make(map[upspin.PathName][]*upspin.DirEntry)
+	files := make(map[upspin.PathName]bool)
+	accessFiles := make(map[upspin.PathName]*access.Access)
+	for _, entry := range entries {
+		if err := valid.DirEntry(entry); err != nil {
+			return errors.E(op, err)
+		}
+		parsed, err := s.parse(entry.Name)
+		if err != nil {
+			return errors.E(op, err)
+		}
+		if parsed.User() != userName {
+			return errors.E(op, entry.Name, errors.Invalid, errors.Errorf("not in tree of %s", userName))
+		}
+		if parsed.IsRoot() {
+			return errors.E(op, entry.Name, errors.Invalid, errors.Str("cannot rebuild with a root entry"))
+		}
+		for i := 0; i < parsed.NElem(); i++ {
+			dirs[parsed.First(i).Path()] = true
+		}
+		if entry.IsDir() {
+			dirs[parsed.Path()] = true
+			continue
+		}
+		if files[parsed.Path()] {
+			return errors.E(op, parsed.Path(), errors.Invalid, errors.Str("duplicate entry"))
+		}
+		files[parsed.Path()] = true
+		entry = entry.Copy()
+		entry.Name = parsed.Path()
+		if entry.Sequence < upspin.SeqBase {
+			entry.Sequence = s.newSequence()
+		}
+		if isAccess, isGroup := access.IsAccessFile(entry.Name), access.IsGroupFile(entry.Name); isAccess || isGroup {
+			if entry.IsLink() {
+				return errors.E(op, entry.Name, errors.Invalid, errors.Str("cannot create a link named Access or Group"))
+			}
+			if entry.Packing != upspin.EEIntegrityPack {
+				return errors.E(op, entry.Name, errors.Invalid, errors.Str("Access or Group file must use integrity packing"))
+			}
+			if isAccess {
+				data, err := s.readAll(entry)
+				if err != nil {
+					return errors.E(op, err)
+				}
+				a, err := access.Parse(entry.Name, data)
+				if err != nil {
+					return errors.E(op, err)
+				}
+				accessFiles[path.DropPath(entry.Name, 1)] = a
+			}
+		}
+		dir := path.DropPath(entry.Name, 1)
+		children[dir] = append(children[dir], entry)
+	}
+	for name := range files {
+		if dirs[name] {
+			return errors.E(op, name, errors.Invalid, errors.Str("both a file and a directory"))
+		}
+	}
+
+	// Build the directories from the bottom up.
+	order := make([]upspin.PathName, 0, len(dirs))
+	for name := range dirs {
+		order = append(order, name)
+	}
+	depth := func(name upspin.PathName) int {
+		if name == rootName {
+			return 0
+		}
+		return strings.Count(string(name), "/")
+	}
+	sort.Slice(order, func(i, j int) bool { return depth(order[i]) > depth(order[j]) })
+	var root *upspin.DirEntry
+	for _, name := range order {
+		kids := children[name]
+		sort.Slice(kids, func(i, j int) bool { return kids[i].Name < kids[j].Name })
+		records := make([][]byte, len(kids))
+		for i, kid := range kids {
+			data, err := kid.Marshal()
+			if err != nil {
+				return errors.E(op, kid.Name, err)
+			}
+			records[i] = data
+		}
+		entry, err := s.newDirEntry(name, formatDir(records), s.newSequence())
+		if err != nil {
+			return errors.E(op, name, err)
+		}
+		if name == rootName {
+			root = entry
+			break // The root is last.
+		}
+		parent := path.DropPath(name, 1)
+		children[parent] = append(children[parent], entry)
+	}
+
+	// Replace the old tree.
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	if s.db.readOnly {
+		return errors.E(op, userName, errors.Permission, errReadOnly)
+	}
+	if err := s.recordMutation("rebuild", rootName, nil, root); err != nil {
+		return errors.E(op, err)
+	}
+	// Reference the new tree before dropping the old one, so what is
+	// recorded about the data they share is kept.
+	err := s.walkTree(root, func(entry *upspin.DirEntry) error {
+		s.db.ref(entry)
+		if access.IsGroupFile(entry.Name) {
+			access.RemoveGroup(entry.Name)
+		}
+		return nil
+	})
+	if err != nil {
+		return errors.E(op, err)
+	}
+	if err := s.dropTree(userName); err != nil {
+		return errors.E(op, err)
+	}
+	s.db.root[userName] = root
+	for dir, a := range accessFiles {
+		s.db.access[dir] = a
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+// Copyright 2017 The Upspin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package inprocess
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"upspin.io/errors"
+	"upspin.io/upspin"
+)
+
+func TestRebuild(t *testing.T) {
+	config, dir := globTree(t)
+	s := dir.(*server)
+	user := config.UserName()
+
+	// Collect the files and links of the tree as a manifest.
+	var manifest []*upspin.DirEntry
+	var want []string
+	err := s.Walk(upspin.PathName(user+"/"), func(e *upspin.DirEntry) error {
+		want = append(want, strings.TrimPrefix(string(e.Name), string(user)))
+		if !e.IsDir() {
+			manifest = append(manifest, e)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Change the tree, then rebuild it from the manifest.
+	if _, err := dir.Delete(upspin.PathName(user + "/a/f2")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := makeDirectory(dir, upspin.PathName(user+"/extra")); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Rebuild(user, manifest); err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	err = s.Walk(upspin.PathName(user+"/"), func(e *upspin.DirEntry) error {
+		got = append(got, strings.TrimPrefix(string(e.Name), string(user)))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("rebuilt tree has %q; want %q", got, want)
+	}
+	data, err := s.GetData(upspin.PathName(user + "/a/f2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "/a/f2" {
+		t.Errorf("data = %q; want %q", data, "/a/f2")
+	}
+	if errs := s.checkUser(user); len(errs) != 0 {
+		t.Errorf("rebuilt tree is inconsistent: %v", errs)
+	}
+
+	// A name can't be both a file and a directory.
+	bad := append(manifest, &upspin.DirEntry{
+		Name:       upspin.PathName(user + "/f1/x"),
+		SignedName: upspin.PathName(user + "/f1/x"),
+		Attr:       upspin.AttrDirectory,
+	})
+	if err := s.Rebuild(user, bad); !errors.Match(errors.E(errors.Invalid), err) {
+		t.Errorf("file and directory: err = %v; want Invalid", err)
+	}
+}
@@ -0,0 +1,98 @@
+// Copyright 2017 The Upspin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package inprocess
+
+import (
+	"upspin.io/access"
+	"upspin.io/client/clientutil"
+	"upspin.io/errors"
+	"upspin.io/upspin"
+)
+
+// Relocate copies the data of the named file to the store dst and
+// updates the file's entry to refer to the copy, as when moving a file
+// to another tier of storage. The blocks are copied as they are, still
+// packed; block locations are not covered by the entry's signature, so
+// the entry remains valid. The data in the old store is not deleted, and
+// directories always stay in the server's own store.
+// The caller needs write rights for the file.
+func (s *server) Relocate(name upspin.PathName, dst upspin.StoreServer) error {
+	const op = "dir/inprocess.Relocate"
+	parsed, err := s.parse(name)
+	if err != nil {
+		return errors.E(op, err)
+	}
+	entry, err := s.lookup(op, parsed, true)
+	if err != nil {
+		_, err = s.errLink(op, entry, err)
+		return err
+	}
+	if entry.IsDir() {
+		return errors.E(op, name, errors.IsDir)
+	}
+	canWrite, err := s.can(access.Write, parsed)
+	if err != nil {
+		return errors.E(op, err)
+	}
+	if !canWrite {
+		return s.errPerm(op, parsed)
+	}
+
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	if s.db.readOnly {
+		return errors.E(op, name, errors.Permission, errReadOnly)
+	}
+	// The file may have changed since we looked.
+	entry, err = s.lookupLocked(op, parsed, true)
+	if err != nil {
+		return errors.E(op, err)
+	}
+	if !entry.IsRegular() {
+		return errors.E(op, name, errors.Invalid, errors.Str("not a regular file"))
+	}
+	newEntry := entry.Copy()
+	for i := range newEntry.Blocks {
+		loc := &newEntry.Blocks[i].Location
+		data, err := clientutil.ReadLocation(s.config, *loc)
+		if err != nil {
+			return errors.E(op, name, err)
+		}
+		refdata, err := dst.Put(data)
+		if err != nil {
+			return errors.E(op, name, err)
+		}
+		*loc = upspin.Location{
+			Endpoint:  dst.Endpoint(),
+			Reference: refdata.Reference,
+		}
+	}
+	// What is recorded about the data moves with it. It is recorded for
+	// the copy now and forgotten for the original when the put releases
+	// the old entry; if the put fails, it is forgotten for the copy.
+	var newRef upspin.Reference
+	if len(entry.Blocks) > 0 {
+		oldRef := entry.Blocks[0].Location.Reference
+		newRef = newEntry.Blocks[0].Location.Reference
+		if s.db.compressed[oldRef] {
+			s.db.compressed[newRef] = true
+		}
+		if c, ok := s.db.checksums[oldRef]; ok {
+			s.db.checksums[newRef] = c
+		}
+	}
+	// The sequence number guards against a change since we looked.
+	newEntry, err = s.put(op, newEntry, parsed, false)
+	if err != nil {
+		if newRef != "" {
+			s.db.forgetData(newRef)
+		}
+		return err
+	}
+	s.db.eventMgr.newEvent <- upspin.Event{
+		Entry: newEntry,
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+// Copyright 2017 The Upspin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package inprocess
+
+import (
+	"bytes"
+	"testing"
+
+	"upspin.io/errors"
+	storeinprocess "upspin.io/store/inprocess"
+	"upspin.io/upspin"
+)
+
+func TestRelocate(t *testing.T) {
+	config, dir := setup()
+	s := dir.(*server)
+	user := config.UserName()
+	name := upspin.PathName(user + "/file")
+	data := bytes.Repeat([]byte("tiered "), upspin.BlockSize/4)
+	if _, err := s.PutReader(name, bytes.NewReader(data), upspin.PlainPack, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	dst := storeinprocess.New()
+	if err := s.Relocate(name, dst); err != nil {
+		t.Fatal(err)
+	}
+	entry, err := dir.Lookup(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entry.Blocks) != 2 {
+		t.Fatalf("%d blocks; want 2", len(entry.Blocks))
+	}
+	for i, b := range entry.Blocks {
+		if b.Location.Endpoint != dst.Endpoint() {
+			t.Errorf("block %d at %v; want %v", i, b.Location.Endpoint, dst.Endpoint())
+		}
+		if _, _, _, err := dst.Get(b.Location.Reference); err != nil {
+			t.Errorf("block %d not in destination: %v", i, err)
+		}
+	}
+	got, err := s.GetData(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Error("data changed by Relocate")
+	}
+
+	if err := s.Relocate(upspin.PathName(user+"/"), dst); !errors.Match(errors.E(errors.IsDir), err) {
+		t.Errorf("Relocate of directory: err = %v; want IsDir", err)
+	}
+}
+
+func TestRelocateCompressed(t *testing.T) {
+	config, dir := setup()
+	s := dir.(*server)
+	user := config.UserName()
+	name := upspin.PathName(user + "/file")
+	data := bytes.Repeat([]byte("tiered "), upspin.BlockSize/4)
+	if _, err := s.PutReader(name, bytes.NewReader(data), upspin.PlainPack, &PutOptions{Compress: true}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Relocate(name, storeinprocess.New()); err != nil {
+		t.Fatal(err)
+	}
+	got, err := s.GetData(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Error("compressed data changed by Relocate")
+	}
+	entry, err := dir.Lookup(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ref := entry.Blocks[0].Location.Reference
+	if _, err := s.Delete(name); err != nil {
+		t.Fatal(err)
+	}
+	if s.db.compressed[ref] {
+		t.Error("flag kept for deleted data")
+	}
+	if len(s.db.compressed) != 0 {
+		t.Errorf("%d flags kept; want none", len(s.db.compressed))
+	}
+}
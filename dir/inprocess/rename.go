@@ -0,0 +1,153 @@
+// Copyright 2017 The Upspin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package inprocess
+
+import (
+	"fmt"
+
+	"upspin.io/access"
+	"upspin.io/errors"
+	"upspin.io/path"
+	"upspin.io/upspin"
+)
+
+// RenameBackup moves the file or link oldName to newName, which must be
+// in the same user's tree. If newName already exists, it is first moved
+// aside to a backup name in the same directory formed by appending the
+// time, such as "file.20170615-150405", and the backup name is returned;
+// otherwise the returned name is empty. Both moves are done under a
+// single lock and installed together, so no intermediate state is
+// visible and if either fails neither is made. Entries keep referring
+// to the same data in the store.
+//
+// The caller needs read and delete rights for oldName and create rights
+// in the directory of newName, plus delete rights for newName if it
+// exists. Directories, Access files and Group files cannot be renamed.
+func (s *server) RenameBackup(oldName, newName upspin.PathName) (upspin.PathName, error) {
+	const op = "dir/inprocess.RenameBackup"
+	oldParsed, err := s.parse(oldName)
+	if err != nil {
+		return "", errors.E(op, err)
+	}
+	newParsed, err := s.parse(newName)
+	if err != nil {
+		return "", errors.E(op, err)
+	}
+	if oldParsed.User() != newParsed.User() {
+		return "", errors.E(op, newName, errors.Invalid, errors.Str("cannot rename to another user's tree"))
+	}
+	if oldParsed.Equal(newParsed) {
+		return "", errors.E(op, newName, errors.Invalid, errors.Str("cannot rename to itself"))
+	}
+	for _, p := range []path.Parsed{oldParsed, newParsed} {
+		if access.IsAccessFile(p.Path()) || access.IsGroupFile(p.Path()) {
+			return "", errors.E(op, p.Path(), errors.Invalid, errors.Str("cannot rename an Access or Group file"))
+		}
+	}
+	entry, err := s.lookup(op, oldParsed, false)
+	if err != nil {
+		_, err = s.errLink(op, entry, err)
+		return "", err
+	}
+	if entry.IsDir() {
+		return "", errors.E(op, oldName, errors.IsDir)
+	}
+	for _, right := range []access.Right{access.Read, access.Delete} {
+		can, err := s.can(right, oldParsed)
+		if err != nil {
+			return "", errors.E(op, err)
+		}
+		if !can {
+			return "", s.errPerm(op, oldParsed)
+		}
+	}
+	if e, err := s.canPut(op, newParsed, false); err != nil {
+		_, err = s.errLink(op, e, err)
+		return "", err
+	}
+	// The backup is in the same directory, so the same rights apply to it.
+	canCreate, err := s.can(access.Create, newParsed)
+	if err != nil {
+		return "", errors.E(op, err)
+	}
+	canDelete, err := s.can(access.Delete, newParsed)
+	if err != nil {
+		return "", errors.E(op, err)
+	}
+
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	if s.db.readOnly {
+		return "", errors.E(op, oldName, errors.Permission, errReadOnly)
+	}
+	// Things may have changed since we looked.
+	entry, err = s.lookupLocked(op, oldParsed, false)
+	if err != nil {
+		return "", errors.E(op, err)
+	}
+	if entry.IsDir() {
+		return "", errors.E(op, oldName, errors.IsDir)
+	}
+	existing, err := s.lookupLocked(op, newParsed, false)
+	if err != nil && !errors.Match(notExist, err) {
+		return "", errors.E(op, err)
+	}
+	if !canCreate || existing != nil && !canDelete {
+		return "", errors.E(op, newName, errors.Permission)
+	}
+	// Both moves are made as one, so if either fails neither is made.
+	var backup upspin.PathName
+	var changes []change
+	var backupEntry *upspin.DirEntry
+	if existing != nil {
+		if existing.IsDir() {
+			return "", errors.E(op, newName, errors.IsDir)
+		}
+		backupParsed, err := s.backupName(op, newParsed)
+		if err != nil {
+			return "", err
+		}
+		backupEntry, changes, err = s.moveChanges(op, existing, newParsed, backupParsed)
+		if err != nil {
+			return "", err
+		}
+		backup = backupParsed.Path()
+	}
+	newEntry, more, err := s.moveChanges(op, entry, oldParsed, newParsed)
+	if err != nil {
+		return "", err
+	}
+	if _, err := s.putChanges(op, append(changes, more...)); err != nil {
+		return "", err
+	}
+	if existing != nil {
+		s.moveEvents(existing, backupEntry)
+	}
+	s.moveEvents(entry, newEntry)
+	return backup, nil
+}
+
+// backupName returns an unused name in the same directory as the named
+// entry to which it can be moved aside. s.db.mu is held.
+func (s *server) backupName(op string, parsed path.Parsed) (path.Parsed, error) {
+	stamp := s.db.now().Go().UTC().Format("20060102-150405")
+	for i := 0; ; i++ {
+		name := fmt.Sprintf("%s.%s", parsed.Path(), stamp)
+		if i > 0 {
+			name = fmt.Sprintf("%s.%d", name, i)
+		}
+		p, err := path.Parse(upspin.PathName(name))
+		if err != nil {
+			return path.Parsed{}, errors.E(op, err)
+		}
+		_, err = s.lookupLocked(op, p, false)
+		if errors.Match(notExist, err) {
+			return p, nil
+		}
+		if err != nil {
+			return path.Parsed{}, errors.E(op, err)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+// Copyright 2017 The Upspin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package inprocess
+
+import (
+	"testing"
+	"time"
+
+	"upspin.io/errors"
+	"upspin.io/upspin"
+)
+
+func TestRenameBackup(t *testing.T) {
+	config, dir := setup()
+	s := dir.(*server)
+	user := config.UserName()
+	now := upspin.TimeFromGo(time.Date(2017, 6, 15, 15, 4, 5, 0, time.UTC))
+	s.db.now = func() upspin.Time { return now }
+
+	put := func(name upspin.PathName, text string) {
+		if _, err := dir.Put(storeData(t, config, []byte(text), name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	get := func(name upspin.PathName) string {
+		data, err := s.GetData(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(data)
+	}
+	file := upspin.PathName(user + "/file")
+	tmp := upspin.PathName(user + "/file.tmp")
+
+	// No existing file: no backup.
+	put(tmp, "one")
+	backup, err := s.RenameBackup(tmp, file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if backup != "" {
+		t.Errorf("backup = %q; want none", backup)
+	}
+	if got := get(file); got != "one" {
+		t.Errorf("file holds %q; want %q", got, "one")
+	}
+	if _, err := dir.Lookup(tmp); !errors.Match(errors.E(errors.NotExist), err) {
+		t.Errorf("Lookup of old name: err = %v; want NotExist", err)
+	}
+
+	// Overwrite twice in the same second: distinct backups.
+	for i, want := range []upspin.PathName{file + ".20170615-150405", file + ".20170615-150405.1"} {
+		put(tmp, "two")
+		backup, err := s.RenameBackup(tmp, file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if backup != want {
+			t.Errorf("%d: backup = %q; want %q", i, backup, want)
+		}
+	}
+	if got := get(file + ".20170615-150405"); got != "one" {
+		t.Errorf("backup holds %q; want %q", got, "one")
+	}
+	if got := get(file); got != "two" {
+		t.Errorf("file holds %q; want %q", got, "two")
+	}
+
+	if _, err := s.RenameBackup(file, file); !errors.Match(errors.E(errors.Invalid), err) {
+		t.Errorf("rename to itself: err = %v; want Invalid", err)
+	}
+	if _, err := makeDirectory(dir, upspin.PathName(user+"/dir")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.RenameBackup(file, upspin.PathName(user+"/dir")); !errors.Match(errors.E(errors.Exist), err) {
+		t.Errorf("rename over directory: err = %v; want Exist", err)
+	}
+}
+
+func TestRenameBackupFailAfter(t *testing.T) {
+	config, dir := setup()
+	s := dir.(*server)
+	user := config.UserName()
+	now := upspin.TimeFromGo(time.Date(2017, 6, 15, 15, 4, 5, 0, time.UTC))
+	s.db.now = func() upspin.Time { return now }
+	if _, err := makeDirectory(dir, upspin.PathName(user+"/dir")); err != nil {
+		t.Fatal(err)
+	}
+	file := upspin.PathName(user + "/file")
+	tmp := upspin.PathName(user + "/dir/tmp")
+	backup := file + ".20170615-150405"
+	for _, name := range []upspin.PathName{file, tmp} {
+		if _, err := dir.Put(storeData(t, config, []byte(name), name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	check := func(name, want upspin.PathName) {
+		t.Helper()
+		data, err := s.GetData(name)
+		if err != nil || upspin.PathName(data) != want {
+			t.Errorf("%s holds %q, %v; want %q", name, data, err, want)
+		}
+	}
+	// The root for the backup, the removal of file and the new file,
+	// then dir and the root for the removal of tmp.
+	stores := failEachStore(t, s, []upspin.UserName{user}, func() error {
+		_, err := s.RenameBackup(tmp, file)
+		return err
+	}, func(n int) {
+		check(file, file)
+		check(tmp, tmp)
+		if _, err := dir.Lookup(backup); !errors.Match(errors.E(errors.NotExist), err) {
+			t.Errorf("failing after %d stores: backup visible: %v", n, err)
+		}
+	})
+	if stores != 5 {
+		t.Errorf("RenameBackup made %d stores; want 5", stores)
+	}
+	check(file, tmp)
+	check(backup, file)
+}
@@ -0,0 +1,72 @@
+// Copyright 2017 The Upspin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package inprocess
+
+import (
+	"time"
+
+	"upspin.io/errors"
+)
+
+// RetryPolicy says how the server retries a store operation that fails:
+// reading or writing a directory, or writing a block for PutReader.
+// The zero value, the default, makes a single attempt.
+type RetryPolicy struct {
+	// MaxAttempts is the most times an operation is tried, including
+	// the first. A value less than 2 means there are no retries.
+	MaxAttempts int
+
+	// Backoff is the time to wait before the first retry. The wait
+	// doubles before each further retry, up to MaxBackoff if that is
+	// positive.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+
+	// Retryable reports whether a failed operation may be retried.
+	// If it is nil, errors of kind Transient are retried.
+	Retryable func(error) bool
+}
+
+// retryableDefault is the Retryable function used when none is set.
+func retryableDefault(err error) bool {
+	return errors.Match(errors.E(errors.Transient), err)
+}
+
+// retryPolicyValue is the type stored in db.retry; see tracerValue.
+type retryPolicyValue struct {
+	policy RetryPolicy
+}
+
+// SetRetryPolicy sets the policy for retrying failed store operations.
+// The setting applies to all users of the server.
+func (s *server) SetRetryPolicy(policy RetryPolicy) {
+	s.db.retry.Store(retryPolicyValue{policy})
+}
+
+// retry calls fn until it succeeds or the retry policy says to stop,
+// and returns the last error. Like the tracer, the policy is held in an
+// atomic.Value because store operations happen with and without s.db.mu
+// held. While waiting to retry, retry holds whatever locks its caller
+// does.
+func (s *server) retry(fn func() error) error {
+	v, _ := s.db.retry.Load().(retryPolicyValue)
+	p := v.policy
+	retryable := p.Retryable
+	if retryable == nil {
+		retryable = retryableDefault
+	}
+	backoff := p.Backoff
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || attempt >= p.MaxAttempts || !retryable(err) {
+			return err
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+		if p.MaxBackoff > 0 && backoff > p.MaxBackoff {
+			backoff = p.MaxBackoff
+		}
+	}
+}
@@ -0,0 +1,102 @@
+// Copyright 2017 The Upspin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package inprocess
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"upspin.io/bind"
+	"upspin.io/errors"
+	"upspin.io/upspin"
+
+	storeserver "upspin.io/store/inprocess"
+)
+
+// flakyStore is an in-process StoreServer, accepting the Remote
+// transport, whose Get and Put fail with a Transient error while
+// failures is positive.
+type flakyStore struct {
+	upspin.StoreServer
+	failures *int32
+}
+
+func (s flakyStore) Dial(config upspin.Config, e upspin.Endpoint) (upspin.Service, error) {
+	e.Transport = upspin.InProcess
+	svc, err := s.StoreServer.Dial(config, e)
+	if err != nil {
+		return nil, err
+	}
+	return flakyStore{svc.(upspin.StoreServer), s.failures}, nil
+}
+
+func (s flakyStore) fail() error {
+	if atomic.AddInt32(s.failures, -1) >= 0 {
+		return errors.E(errors.Transient, errors.Str("flaky store"))
+	}
+	atomic.StoreInt32(s.failures, 0)
+	return nil
+}
+
+func (s flakyStore) Get(ref upspin.Reference) ([]byte, *upspin.Refdata, []upspin.Location, error) {
+	if err := s.fail(); err != nil {
+		return nil, nil, nil, err
+	}
+	return s.StoreServer.Get(ref)
+}
+
+func (s flakyStore) Put(data []byte) (*upspin.Refdata, error) {
+	if err := s.fail(); err != nil {
+		return nil, err
+	}
+	return s.StoreServer.Put(data)
+}
+
+func TestRetryPolicy(t *testing.T) {
+	var failures int32
+	if err := bind.RegisterStoreServer(upspin.Remote, flakyStore{storeserver.New(), &failures}); err != nil {
+		t.Fatal(err)
+	}
+	config, dir := setup()
+	s := dir.(*server)
+	user := config.UserName()
+	if err := s.db.setOption("dirStore=remote,flaky"); err != nil {
+		t.Fatal(err)
+	}
+	name := upspin.PathName(user + "/dir")
+
+	// Without retries, a failure is returned.
+	atomic.StoreInt32(&failures, 1)
+	if _, err := makeDirectory(dir, name); !errors.Match(errors.E(errors.Transient), err) {
+		t.Fatalf("MakeDirectory with flaky store: err = %v; want Transient", err)
+	}
+
+	s.SetRetryPolicy(RetryPolicy{MaxAttempts: 3, Backoff: time.Millisecond})
+	atomic.StoreInt32(&failures, 2)
+	if _, err := makeDirectory(dir, name); err != nil {
+		t.Fatalf("MakeDirectory with retries: %v", err)
+	}
+	atomic.StoreInt32(&failures, 2)
+	if _, err := dir.Lookup(name + "/nothing"); !errors.Match(errors.E(errors.NotExist), err) {
+		t.Fatalf("Lookup with retries: err = %v; want NotExist", err)
+	}
+	// Too many failures.
+	atomic.StoreInt32(&failures, 3)
+	if _, err := dir.Lookup(name + "/nothing"); !errors.Match(errors.E(errors.Transient), err) {
+		t.Fatalf("Lookup with too many failures: err = %v; want Transient", err)
+	}
+
+	// Only retryable errors are retried.
+	s.SetRetryPolicy(RetryPolicy{
+		MaxAttempts: 3,
+		Retryable:   func(error) bool { return false },
+	})
+	atomic.StoreInt32(&failures, 1)
+	if _, err := dir.Lookup(name); !errors.Match(errors.E(errors.Transient), err) {
+		t.Fatalf("Lookup with nothing retryable: err = %v; want Transient", err)
+	}
+	atomic.StoreInt32(&failures, 0)
+}
@@ -0,0 +1,54 @@
+// Copyright 2017 The Upspin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package inprocess
+
+// When the readTimeout option is set, lookups give up rather than wait
+// indefinitely for a writer holding s.db.mu, so that a test can detect
+// lock contention or assert that reads finish in bounded time.
+// sync.RWMutex has no timed lock, so rlock polls with TryRLock, backing
+// off up to a limit. The bound applies to the wait for the lock in
+// lookup, through which Lookup and most other operations find entries,
+// and to a first wait at the start of Glob; a reader that gets past that
+// may still block later if a writer takes the lock in between.
+
+import (
+	"time"
+
+	"upspin.io/errors"
+)
+
+var errLockTimeout = errors.Str("timed out acquiring lock")
+
+// maxLockPoll is the longest rlock sleeps between attempts.
+const maxLockPoll = 10 * time.Millisecond
+
+// rlock takes db.mu for reading. If the readTimeout option is set and the
+// lock cannot be taken within it, rlock returns an error and the lock
+// is not held.
+func (db *database) rlock(op string) error {
+	if db.readTimeout <= 0 {
+		db.mu.RLock()
+		return nil
+	}
+	if db.mu.TryRLock() {
+		return nil
+	}
+	deadline := time.Now().Add(db.readTimeout)
+	for wait := 10 * time.Microsecond; ; wait *= 2 {
+		if wait > maxLockPoll {
+			wait = maxLockPoll
+		}
+		if left := time.Until(deadline); wait > left {
+			wait = left
+		}
+		time.Sleep(wait)
+		if db.mu.TryRLock() {
+			return nil
+		}
+		if !time.Now().Before(deadline) {
+			return errors.E(op, errors.IO, errLockTimeout)
+		}
+	}
+}
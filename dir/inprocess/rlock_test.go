@@ -0,0 +1,52 @@
+// Copyright 2017 The Upspin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package inprocess
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"upspin.io/errors"
+	"upspin.io/upspin"
+)
+
+func TestReadTimeout(t *testing.T) {
+	config, dir := globTree(t)
+	s := dir.(*server)
+	user := string(config.UserName())
+	if err := s.db.setOption("readTimeout=20ms"); err != nil {
+		t.Fatal(err)
+	}
+	name := upspin.PathName(user + "/a/f2")
+	if _, err := dir.Lookup(name); err != nil {
+		t.Fatalf("uncontended Lookup: %v", err)
+	}
+
+	// Hold the lock as a long Put would.
+	s.db.mu.Lock()
+	start := time.Now()
+	_, lookupErr := dir.Lookup(name)
+	_, globErr := dir.Glob(user + "/a/*")
+	elapsed := time.Since(start)
+	s.db.mu.Unlock()
+
+	for _, err := range []error{lookupErr, globErr} {
+		if !errors.Match(errors.E(errors.IO), err) || !strings.Contains(err.Error(), errLockTimeout.Error()) {
+			t.Errorf("err = %v; want %v", err, errLockTimeout)
+		}
+	}
+	if elapsed > 5*time.Second {
+		t.Errorf("timed-out reads took %v", elapsed)
+	}
+
+	// Once the writer is done, reads work again.
+	if _, err := dir.Lookup(name); err != nil {
+		t.Errorf("Lookup after unlock: %v", err)
+	}
+	if err := s.db.setOption("readTimeout=soon"); !errors.Match(errors.E(errors.Invalid), err) {
+		t.Errorf("bad readTimeout: err = %v; want Invalid", err)
+	}
+}
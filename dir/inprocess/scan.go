@@ -0,0 +1,154 @@
+// Copyright 2017 The Upspin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package inprocess
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"upspin.io/client/clientutil"
+	"upspin.io/errors"
+	"upspin.io/path"
+	"upspin.io/upspin"
+)
+
+// The scanner periodically checks the consistency of every user's tree,
+// looking for the damage an interrupted update could leave behind (see
+// the TODOs in put). Each user's tree is checked under the read lock,
+// one user at a time, so writers are held up for no longer than it
+// takes to check one tree.
+
+// scanner holds the state of the background scanner.
+type scanner struct {
+	mu   sync.Mutex    // Protects stop and done.
+	stop chan struct{} // Closed to ask the scanner to stop.
+	done chan struct{} // Closed by the scanner when it has stopped.
+}
+
+// StartScanner starts a goroutine that checks every user's tree each
+// interval and sends each inconsistency it finds, as an error naming
+// the entry, on the returned channel. Inconsistencies found while
+// nobody is receiving are dropped. If a scanner is already running it
+// is stopped first. The channel is closed when the scanner stops.
+func (s *server) StartScanner(interval time.Duration) <-chan error {
+	s.StopScanner()
+	sc := &s.db.scanner
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	sc.stop = make(chan struct{})
+	sc.done = make(chan struct{})
+	errs := make(chan error, 10)
+	go s.scan(interval, sc.stop, sc.done, errs)
+	return errs
+}
+
+// StopScanner stops the scanner started by StartScanner and waits for
+// it to finish. It does nothing if no scanner is running.
+func (s *server) StopScanner() {
+	sc := &s.db.scanner
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	if sc.stop == nil {
+		return
+	}
+	close(sc.stop)
+	<-sc.done
+	sc.stop, sc.done = nil, nil
+}
+
+// scan is the scanner goroutine.
+func (s *server) scan(interval time.Duration, stop, done chan struct{}, errs chan<- error) {
+	defer close(done)
+	defer close(errs)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		for _, user := range s.db.users() {
+			for _, err := range s.checkUser(user) {
+				select {
+				case <-stop:
+					return
+				case errs <- err:
+				default:
+				}
+			}
+		}
+	}
+}
+
+// users returns the names of the users with roots, sorted.
+func (db *database) users() []upspin.UserName {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	users := make([]upspin.UserName, 0, len(db.root))
+	for u := range db.root {
+		users = append(users, u)
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i] < users[j] })
+	return users
+}
+
+// checkUser checks the consistency of the user's tree and returns the
+// inconsistencies found. A user deleted since the list was made has
+// nothing to check.
+func (s *server) checkUser(user upspin.UserName) []error {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+	root, ok := s.db.root[user]
+	if !ok {
+		return nil
+	}
+	return s.checkDir(root, nil)
+}
+
+// checkDir checks the directory and everything below it, appending the
+// inconsistencies found to errs. It checks that each directory can be
+// read and parsed, that its entries are in order and belong in it, and
+// that the blocks of each file are present in the store.
+// s.db.mu is held.
+func (s *server) checkDir(dir *upspin.DirEntry, errs []error) []error {
+	const op = "dir/inprocess.checkDir"
+	payload, err := s.readAll(dir)
+	if err != nil {
+		return append(errs, errors.E(op, dir.Name, err))
+	}
+	contents, err := parseDir(payload)
+	if err != nil {
+		return append(errs, errors.E(op, dir.Name, err))
+	}
+	var prev upspin.PathName
+	for i := 0; i < contents.len(); i++ {
+		entry, err := contents.entry(i)
+		if err != nil {
+			errs = append(errs, errors.E(op, dir.Name, errors.Internal, err))
+			continue
+		}
+		if path.DropPath(entry.Name, 1) != dir.Name {
+			errs = append(errs, errors.E(op, entry.Name, errors.Internal, errors.Errorf("entry in directory %s", dir.Name)))
+		}
+		if entry.Name <= prev {
+			errs = append(errs, errors.E(op, entry.Name, errors.Internal, errors.Str("entry out of order")))
+		}
+		prev = entry.Name
+		switch {
+		case entry.IsDir():
+			errs = s.checkDir(entry, errs)
+		case entry.IsRegular():
+			for _, b := range entry.Blocks {
+				if _, err := clientutil.ReadLocation(s.db.dirConfig, b.Location); err != nil {
+					errs = append(errs, errors.E(op, entry.Name, err))
+					break
+				}
+			}
+		}
+	}
+	return errs
+}
@@ -0,0 +1,56 @@
+// Copyright 2017 The Upspin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package inprocess
+
+import (
+	"testing"
+	"time"
+
+	"upspin.io/bind"
+	"upspin.io/errors"
+	"upspin.io/upspin"
+)
+
+func TestScanner(t *testing.T) {
+	config, dir := globTree(t)
+	s := dir.(*server)
+	user := config.UserName()
+	if errs := s.checkUser(user); len(errs) != 0 {
+		t.Fatalf("healthy tree has errors: %v", errs)
+	}
+
+	// Lose the data of a file.
+	name := upspin.PathName(user + "/c/f4")
+	entry, err := dir.Lookup(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := bind.StoreServer(config, config.StoreEndpoint())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Delete(entry.Blocks[0].Location.Reference); err != nil {
+		t.Fatal(err)
+	}
+
+	errs := s.StartScanner(time.Millisecond)
+	timeout := time.After(10 * time.Second)
+Loop:
+	for {
+		select {
+		case err := <-errs:
+			if e, ok := err.(*errors.Error); ok && e.Path == name {
+				break Loop
+			}
+		case <-timeout:
+			t.Fatal("scanner did not report the missing data")
+		}
+	}
+	s.StopScanner()
+	for range errs {
+		// Drain; the channel must be closed.
+	}
+	s.StopScanner() // Does nothing.
+}
@@ -0,0 +1,172 @@
+// Copyright 2017 The Upspin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package inprocess
+
+// Sequence drift.
+//
+// Every entry in a tree should have a valid sequence number, at least
+// SeqBase, and a file's should be above those of the replaced versions of
+// it kept in history, since each replacement advances it. Not every entry
+// does: a new entry put with SeqNotExist, as RenameBackup and Transfer do,
+// keeps that number. CheckSequences reports such drift, and
+// RepairSequences gives each entry that has drifted the lowest number
+// that would be valid, storing again only the directories that hold one.
+// The sequence number is not covered by the entry's signature, so nothing
+// is signed again.
+
+import (
+	"upspin.io/errors"
+	"upspin.io/upspin"
+)
+
+// CheckSequences returns an error for each entry in the user's tree whose
+// sequence number has drifted, without changing anything. Only the user
+// may do this.
+func (s *server) CheckSequences(userName upspin.UserName) ([]error, error) {
+	const op = "dir/inprocess.CheckSequences"
+	userName = normalizeUser(userName, s.db.foldLocal)
+	if normalizeUser(s.config.UserName(), s.db.foldLocal) != userName {
+		return nil, errors.E(op, userName, errors.Permission)
+	}
+
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+	root, ok := s.db.root[userName]
+	if !ok {
+		return nil, errors.E(op, userName, errors.NotExist, errors.Str("no such user"))
+	}
+	var drift []error
+	check := func(entry *upspin.DirEntry) error {
+		if want, bad := s.db.sequenceDrift(entry); bad {
+			drift = append(drift, errors.E(op, entry.Name, errors.Internal, errors.Errorf("sequence %d; want at least %d", entry.Sequence, want)))
+		}
+		return nil
+	}
+	check(root)
+	if err := s.walkTree(root, check); err != nil {
+		return nil, errors.E(op, err)
+	}
+	return drift, nil
+}
+
+// RepairSequences gives each entry in the user's tree whose sequence
+// number has drifted the lowest valid one and returns the number of
+// entries repaired. The repaired tree replaces the old one at once and,
+// as with Rebuild, no Watch events are sent. Only the user may do this.
+func (s *server) RepairSequences(userName upspin.UserName) (int, error) {
+	const op = "dir/inprocess.RepairSequences"
+	userName = normalizeUser(userName, s.db.foldLocal)
+	if normalizeUser(s.config.UserName(), s.db.foldLocal) != userName {
+		return 0, errors.E(op, userName, errors.Permission)
+	}
+
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	if s.db.readOnly {
+		return 0, errors.E(op, userName, errors.Permission, errReadOnly)
+	}
+	root, ok := s.db.root[userName]
+	if !ok {
+		return 0, errors.E(op, userName, errors.NotExist, errors.Str("no such user"))
+	}
+	var fixes []seqFix
+	newRoot, err := s.repairDir(op, root, &fixes)
+	if err != nil {
+		return 0, err
+	}
+	if want, bad := s.db.sequenceDrift(newRoot); bad {
+		if newRoot == root {
+			newRoot = root.Copy()
+		}
+		newRoot.Sequence = want
+		fixes = append(fixes, seqFix{name: root.Name, old: root.Sequence, new: want})
+	}
+	if len(fixes) == 0 {
+		return 0, nil
+	}
+	if err := s.recordMutation("repairSequences", root.Name, nil, newRoot); err != nil {
+		return 0, errors.E(op, err)
+	}
+	s.db.root[userName] = newRoot
+	// An expiration applies to the entry with the old sequence number.
+	for _, fix := range fixes {
+		if x, ok := s.db.expire[fix.name]; ok && x.seq == fix.old {
+			x.seq = fix.new
+			s.db.expire[fix.name] = x
+		}
+	}
+	return len(fixes), nil
+}
+
+// seqFix records the repair of the sequence number of the named entry.
+type seqFix struct {
+	name     upspin.PathName
+	old, new int64
+}
+
+// repairDir returns the entry for the directory with the sequence numbers
+// of the entries below it repaired, appending the repairs to fixes. If
+// nothing needed repair, it returns dir itself; otherwise the directory
+// is stored again, with the next sequence number. s.db.mu is held.
+func (s *server) repairDir(op string, dir *upspin.DirEntry, fixes *[]seqFix) (*upspin.DirEntry, error) {
+	payload, err := s.readAll(dir)
+	if err != nil {
+		return nil, errors.E(op, dir.Name, err)
+	}
+	contents, err := parseDir(payload)
+	if err != nil {
+		return nil, errors.E(op, dir.Name, err)
+	}
+	records := contents.records()
+	changed := false
+	for i := range records {
+		entry, err := contents.entry(i)
+		if err != nil {
+			return nil, errors.E(op, dir.Name, err)
+		}
+		newEntry := entry
+		if entry.IsDir() {
+			newEntry, err = s.repairDir(op, entry, fixes)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if want, bad := s.db.sequenceDrift(newEntry); bad {
+			if newEntry == entry {
+				newEntry = entry.Copy()
+			}
+			newEntry.Sequence = want
+			*fixes = append(*fixes, seqFix{name: entry.Name, old: entry.Sequence, new: want})
+		}
+		if newEntry == entry {
+			continue
+		}
+		records[i], err = newEntry.Marshal()
+		if err != nil {
+			return nil, errors.E(op, err)
+		}
+		changed = true
+	}
+	if !changed {
+		return dir, nil
+	}
+	entry, err := s.newDirEntry(dir.Name, formatDir(records), upspin.SeqNext(dir.Sequence))
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+	return entry, nil
+}
+
+// sequenceDrift reports whether the entry's sequence number has drifted
+// and, if so, returns the lowest valid one. s.db.mu is held.
+func (db *database) sequenceDrift(entry *upspin.DirEntry) (int64, bool) {
+	want := int64(upspin.SeqBase)
+	for _, e := range db.history[entry.Name] {
+		if next := upspin.SeqNext(e.Sequence); next > want {
+			want = next
+		}
+	}
+	return want, entry.Sequence < want
+}
@@ -0,0 +1,98 @@
+// Copyright 2017 The Upspin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package inprocess
+
+import (
+	"testing"
+
+	"upspin.io/errors"
+	"upspin.io/upspin"
+)
+
+func TestRepairSequences(t *testing.T) {
+	config, dir := setup()
+	s := dir.(*server)
+	if err := s.db.setOption("history=3"); err != nil {
+		t.Fatal(err)
+	}
+	user := config.UserName()
+	root := upspin.PathName(user + "/")
+	if _, err := makeDirectory(dir, root+"dir"); err != nil {
+		t.Fatal(err)
+	}
+	// A new entry put with SeqNotExist keeps that number.
+	drifted := root + "dir/new"
+	entry := storeData(t, config, []byte("new"), drifted)
+	entry.Sequence = upspin.SeqNotExist
+	if _, err := dir.Put(entry); err != nil {
+		t.Fatal(err)
+	}
+	// A file whose history has got ahead of it.
+	historic := root + "historic"
+	for _, data := range []string{"v1", "v2"} {
+		if _, err := dir.Put(storeData(t, config, []byte(data), historic)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	current, err := dir.Lookup(historic)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.db.mu.Lock()
+	s.db.history[historic][0].Sequence = current.Sequence + 5
+	s.db.mu.Unlock()
+
+	drift, err := s.CheckSequences(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []upspin.PathName
+	for _, err := range drift {
+		names = append(names, err.(*errors.Error).Path)
+	}
+	if len(names) != 2 || names[0] != drifted || names[1] != historic {
+		t.Fatalf("CheckSequences reported %v; want %s and %s", drift, drifted, historic)
+	}
+
+	n, err := s.RepairSequences(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Errorf("RepairSequences repaired %d entries; want 2", n)
+	}
+	if drift, err := s.CheckSequences(user); err != nil || len(drift) != 0 {
+		t.Errorf("after repair, CheckSequences = %v, %v; want none", drift, err)
+	}
+	for name, want := range map[upspin.PathName]int64{
+		drifted:  upspin.SeqBase,
+		historic: current.Sequence + 6,
+	} {
+		e, err := dir.Lookup(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if e.Sequence != want {
+			t.Errorf("%s: sequence %d; want %d", name, e.Sequence, want)
+		}
+	}
+	if data, err := s.GetData(historic); err != nil || string(data) != "v2" {
+		t.Errorf("repaired file holds %q, %v; want v2", data, err)
+	}
+	if errs := s.checkUser(user); len(errs) != 0 {
+		t.Errorf("tree is inconsistent: %v", errs)
+	}
+	if n, err := s.RepairSequences(user); err != nil || n != 0 {
+		t.Errorf("second RepairSequences = %d, %v; want 0, nil", n, err)
+	}
+
+	_, other := setup()
+	if _, err := other.(*server).CheckSequences(user); !errors.Match(errors.E(errors.Permission), err) {
+		t.Errorf("CheckSequences by another user: err = %v; want Permission", err)
+	}
+	if _, err := other.(*server).RepairSequences(user); !errors.Match(errors.E(errors.Permission), err) {
+		t.Errorf("RepairSequences by another user: err = %v; want Permission", err)
+	}
+}
@@ -0,0 +1,176 @@
+// Copyright 2017 The Upspin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package inprocess
+
+import (
+	"strings"
+
+	"upspin.io/access"
+	"upspin.io/errors"
+	"upspin.io/upspin"
+)
+
+// SetRootToSubtree makes the directory subPath, which must be below the
+// user's root, the new root of the user's tree, discarding everything
+// outside it, as chroot does; "u@x.com/a/b/f" becomes "u@x.com/f". Since
+// each entry holds its full name, every directory in the subtree is
+// stored again with the new names, as by MoveTree, and files and links
+// keep referring to the same data. Access files, default packings,
+// aliases and the other records kept by name move with the subtree; those
+// outside it are forgotten. The subtree must hold no locked entries.
+// The new tree replaces the old one at once and, as with Rebuild, no
+// Watch events are sent. Only the user may do this.
+func (s *server) SetRootToSubtree(userName upspin.UserName, subPath upspin.PathName) error {
+	const op = "dir/inprocess.SetRootToSubtree"
+	userName = normalizeUser(userName, s.db.foldLocal)
+	if normalizeUser(s.config.UserName(), s.db.foldLocal) != userName {
+		return errors.E(op, userName, errors.Permission)
+	}
+	parsed, err := s.parse(subPath)
+	if err != nil {
+		return errors.E(op, err)
+	}
+	if parsed.User() != userName || parsed.IsRoot() {
+		return errors.E(op, subPath, errors.Invalid, errors.Str("not below the user's root"))
+	}
+	// Report a link in the path before taking the lock.
+	if entry, err := s.lookup(op, parsed, true); err != nil {
+		_, err = s.errLink(op, entry, err)
+		return err
+	}
+
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	if s.db.readOnly {
+		return errors.E(op, userName, errors.Permission, errReadOnly)
+	}
+	entry, err := s.lookupLocked(op, parsed, false)
+	if err != nil {
+		return errors.E(op, err)
+	}
+	if !entry.IsDir() {
+		return errors.E(op, subPath, errors.NotDir)
+	}
+	rootName := upspin.PathName(userName + "/")
+	oldPrefix := string(parsed.Path()) + "/"
+	// rebase returns the new name of a name in the subtree, and
+	// whether it is in the subtree.
+	rebase := func(name upspin.PathName) (upspin.PathName, bool) {
+		if name == parsed.Path() {
+			return rootName, true
+		}
+		if !strings.HasPrefix(string(name), oldPrefix) {
+			return "", false
+		}
+		return rootName + name[len(oldPrefix):], true
+	}
+	for name := range s.db.locked {
+		if _, in := rebase(name); in {
+			return errors.E(op, name, errors.Permission, errLocked)
+		}
+	}
+
+	// Store the renamed subtree, reading its Access files as we go.
+	accessFiles := make(map[upspin.PathName]*access.Access)
+	root, err := s.renameTree(op, entry, rootName, accessFiles)
+	if err != nil {
+		return err
+	}
+
+	// Note the records kept by name in the subtree, which dropTree
+	// forgets with the rest.
+	expire := make(map[upspin.PathName]expiry)
+	for name, x := range s.db.expire {
+		if newName, in := rebase(name); in {
+			expire[newName] = x
+		}
+	}
+	defaultPacking := make(map[upspin.PathName]upspin.Packing)
+	for dir, packing := range s.db.defaultPacking {
+		if newDir, in := rebase(dir); in {
+			defaultPacking[newDir] = packing
+		}
+	}
+	sticky := make(map[upspin.PathName]bool)
+	for dir := range s.db.sticky {
+		if newDir, in := rebase(dir); in {
+			sticky[newDir] = true
+		}
+	}
+	contentType := make(map[upspin.PathName]string)
+	for name, ctype := range s.db.contentType {
+		if newName, in := rebase(name); in {
+			contentType[newName] = ctype
+		}
+	}
+	aliases := make(map[upspin.PathName]upspin.PathName)
+	for alias, target := range s.db.aliases {
+		newAlias, aliasIn := rebase(alias)
+		newTarget, targetIn := rebase(target)
+		if aliasIn && targetIn {
+			aliases[newAlias] = newTarget
+		}
+	}
+	history := make(map[upspin.PathName][]*upspin.DirEntry)
+	for name, h := range s.db.history {
+		if newName, in := rebase(name); in {
+			moved := make([]*upspin.DirEntry, len(h))
+			for i, e := range h {
+				moved[i] = e.Copy()
+				moved[i].Name = newName
+			}
+			history[newName] = moved
+		}
+	}
+
+	// Replace the old tree.
+	if err := s.recordMutation("setRootToSubtree", rootName, nil, root); err != nil {
+		return errors.E(op, err)
+	}
+	// Reference the new tree, and for now the history kept with it,
+	// before dropping the old tree, so what is recorded about the data
+	// they share is kept.
+	err = s.walkTree(root, func(entry *upspin.DirEntry) error {
+		s.db.ref(entry)
+		return nil
+	})
+	if err != nil {
+		return errors.E(op, err)
+	}
+	for _, h := range history {
+		for _, e := range h {
+			s.db.ref(e)
+		}
+	}
+	if err := s.dropTree(userName); err != nil {
+		return errors.E(op, err)
+	}
+	s.db.root[userName] = root
+	for dir, a := range accessFiles {
+		s.db.access[dir] = a
+	}
+	for name, x := range expire {
+		s.db.expire[name] = x
+	}
+	for dir, packing := range defaultPacking {
+		s.db.defaultPacking[dir] = packing
+	}
+	for dir := range sticky {
+		s.db.sticky[dir] = true
+	}
+	for name, ctype := range contentType {
+		s.db.contentType[name] = ctype
+	}
+	for alias, target := range aliases {
+		s.db.aliases[alias] = target
+	}
+	for name, h := range history {
+		s.db.history[name] = h
+		for _, e := range h {
+			s.db.unref(e)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+// Copyright 2017 The Upspin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package inprocess
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"upspin.io/errors"
+	"upspin.io/upspin"
+)
+
+func TestSetRootToSubtree(t *testing.T) {
+	config, dir := globTree(t)
+	s := dir.(*server)
+	user := config.UserName()
+	p := func(name string) upspin.PathName { return upspin.PathName(string(user) + name) }
+	accessFile := storePlainWithIntegrity(t, config, []byte(fmt.Sprintf("*: %s\n", user)), p("/a/b/Access"))
+	if _, err := dir.Put(accessFile); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetContentType(p("/a/f2"), "text/plain"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetContentType(p("/f1"), "text/plain"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		sub  string
+		kind errors.Kind
+	}{
+		{"/", errors.Invalid},
+		{"/f1", errors.NotDir},
+		{"/nowhere", errors.NotExist},
+	} {
+		if err := s.SetRootToSubtree(user, p(test.sub)); !errors.Match(errors.E(test.kind), err) {
+			t.Errorf("SetRootToSubtree(%s): err = %v; want %v", test.sub, err, test.kind)
+		}
+	}
+	_, other := setup()
+	if err := other.(*server).SetRootToSubtree(user, p("/a")); !errors.Match(errors.E(errors.Permission), err) {
+		t.Errorf("SetRootToSubtree by another user: err = %v; want Permission", err)
+	}
+
+	if err := s.SetRootToSubtree(user, p("/a")); err != nil {
+		t.Fatal(err)
+	}
+	got := walkNames(t, s, user)
+	want := []string{"/", "/b", "/b/Access", "/b/f3", "/f2"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("tree after SetRootToSubtree:\n\t%q\nwant\n\t%q", got, want)
+	}
+	data, err := s.GetData(p("/b/f3"))
+	if err != nil || string(data) != "/a/b/f3" {
+		t.Errorf("moved file holds %q, %v", data, err)
+	}
+	if _, ok := s.db.access[p("/b")]; !ok {
+		t.Error("moved Access file not in force")
+	}
+	if _, ok := s.db.access[p("/a/b")]; ok {
+		t.Error("Access file still in force at old name")
+	}
+	if ctype, err := s.ContentType(p("/f2")); err != nil || ctype != "text/plain" {
+		t.Errorf("ContentType of moved file = %q, %v; want text/plain", ctype, err)
+	}
+	if _, ok := s.db.contentType[p("/f1")]; ok {
+		t.Error("content type kept for discarded file")
+	}
+	if errs := s.checkUser(user); len(errs) != 0 {
+		t.Errorf("tree is inconsistent: %v", errs)
+	}
+	// The new tree may be changed like any other.
+	if _, err := s.Delete(p("/f2")); err != nil {
+		t.Errorf("Delete in new tree: %v", err)
+	}
+}
@@ -0,0 +1,53 @@
+// Copyright 2017 The Upspin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package inprocess
+
+import (
+	"upspin.io/client/clientutil"
+	"upspin.io/errors"
+	"upspin.io/upspin"
+)
+
+// VerifySizes returns the names of the files in the user's tree whose
+// recorded size disagrees with the data in the store: for each block,
+// the length of the stored data must be the block's Size. This holds for
+// the packings in use, which do not change the length of the data.
+// A block that cannot be fetched is returned as an error. Only the user
+// may do this.
+func (s *server) VerifySizes(userName upspin.UserName) ([]upspin.PathName, error) {
+	const op = "dir/inprocess.VerifySizes"
+	userName = normalizeUser(userName, s.db.foldLocal)
+	if normalizeUser(s.config.UserName(), s.db.foldLocal) != userName {
+		return nil, errors.E(op, userName, errors.Permission)
+	}
+
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+	root, ok := s.db.root[userName]
+	if !ok {
+		return nil, errors.E(op, userName, errors.NotExist, errors.Str("no such user"))
+	}
+	var bad []upspin.PathName
+	err := s.walkTree(root, func(entry *upspin.DirEntry) error {
+		if !entry.IsRegular() {
+			return nil
+		}
+		for _, b := range entry.Blocks {
+			data, err := clientutil.ReadLocation(s.config, b.Location)
+			if err != nil {
+				return errors.E(entry.Name, err)
+			}
+			if int64(len(data)) != b.Size {
+				bad = append(bad, entry.Name)
+				break
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+	return bad, nil
+}
@@ -0,0 +1,76 @@
+// Copyright 2017 The Upspin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package inprocess
+
+import (
+	"strings"
+	"testing"
+
+	"upspin.io/errors"
+	"upspin.io/upspin"
+)
+
+func TestValidateSize(t *testing.T) {
+	config, dir := setup()
+	s := dir.(*server)
+	name := upspin.PathName(config.UserName() + "/file")
+	const text = "twelve bytes"
+
+	// By default the size is not checked.
+	if _, err := s.PutReader(name, strings.NewReader(text), upspin.PlainPack, &PutOptions{Size: 99}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.db.setOption("validateSize=true"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.PutReader(name, strings.NewReader(text), upspin.PlainPack, &PutOptions{Size: 99}); !errors.Match(errors.E(errors.Invalid), err) {
+		t.Errorf("wrong size: err = %v; want Invalid", err)
+	}
+	// The size is that of the data read, before compression.
+	for _, compress := range []bool{false, true} {
+		opts := &PutOptions{Size: int64(len(text)), Compress: compress}
+		if _, err := s.PutReader(name, strings.NewReader(text), upspin.PlainPack, opts); err != nil {
+			t.Errorf("compress=%t: %v", compress, err)
+		}
+	}
+}
+
+func TestVerifySizes(t *testing.T) {
+	config, dir := globTree(t)
+	s := dir.(*server)
+	user := config.UserName()
+	bad, err := s.VerifySizes(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(bad) != 0 {
+		t.Errorf("healthy tree has bad sizes: %v", bad)
+	}
+
+	// Record a wrong size for a file, bypassing Put's checks.
+	name := upspin.PathName(user + "/a/f2")
+	entry, err := dir.Lookup(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry.Blocks[0].Size++
+	parsed, err := s.parse(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.db.mu.Lock()
+	_, err = s.put("test", entry, parsed, false)
+	s.db.mu.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	bad, err = s.VerifySizes(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(bad) != 1 || bad[0] != name {
+		t.Errorf("VerifySizes = %v; want [%s]", bad, name)
+	}
+}
@@ -0,0 +1,117 @@
+// Copyright 2017 The Upspin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package inprocess
+
+// When the maxTotalBytes option is set, the server limits the total size
+// of the files in all users' trees, as if the disk holding them could
+// fill up. The size of a file is the total length of its blocks, and a
+// file with several names, such as one made by HardLink, counts once for
+// each. Directories and links do not count. The total is kept up to date
+// by ref and unref as entries enter and leave the trees, and put checks
+// it before installing a change that would increase it.
+//
+// The maxUserBytes option similarly limits the part of the total in each
+// user's tree.
+
+import (
+	"strings"
+
+	"upspin.io/errors"
+	"upspin.io/upspin"
+)
+
+var (
+	errNoSpace = errors.Str("no space left on device")
+	errQuota   = errors.Str("user quota exceeded")
+)
+
+// TotalBytes returns the total size of the files in all users' trees, as
+// limited by the maxTotalBytes option.
+// The total applies to all users of the server.
+func (s *server) TotalBytes() int64 {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+	return s.db.totalBytes
+}
+
+// QuotaStatus returns the total size of the files in the user's tree
+// and the limit set by the maxUserBytes option, zero if there is none.
+// Only the user may do this.
+func (s *server) QuotaStatus(userName upspin.UserName) (used, limit uint64, err error) {
+	const op = "dir/inprocess.QuotaStatus"
+	userName = normalizeUser(userName, s.db.foldLocal)
+	if normalizeUser(s.config.UserName(), s.db.foldLocal) != userName {
+		return 0, 0, errors.E(op, userName, errors.Permission)
+	}
+
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+	if _, ok := s.db.root[userName]; !ok {
+		return 0, 0, errors.E(op, userName, errors.NotExist, errors.Str("no such user"))
+	}
+	if s.db.maxUserBytes > 0 {
+		limit = uint64(s.db.maxUserBytes)
+	}
+	return uint64(s.db.userBytes[userName]), limit, nil
+}
+
+// checkSpace returns an error if replacing the previous entries with the
+// new ones would take the total size, or that of the user's tree, over
+// its limit. Changes that do not increase the total, such as deletions,
+// are always allowed. s.db.mu is held.
+func (s *server) checkSpace(newEntries, prevs []*upspin.DirEntry, deleting bool) error {
+	if s.db.maxTotalBytes <= 0 && s.db.maxUserBytes <= 0 || deleting {
+		return nil
+	}
+	var grow int64
+	for i, entry := range newEntries {
+		if entry.IsRegular() {
+			grow += dataSize(entry)
+		}
+		if prevs[i] != nil && prevs[i].IsRegular() {
+			grow -= dataSize(prevs[i])
+		}
+	}
+	if grow <= 0 {
+		return nil
+	}
+	if s.db.maxTotalBytes > 0 && s.db.totalBytes+grow > int64(s.db.maxTotalBytes) {
+		return errors.E(newEntries[0].Name, errors.IO, errNoSpace)
+	}
+	user := nameUser(newEntries[0].Name)
+	if s.db.maxUserBytes > 0 && s.db.userBytes[user]+grow > int64(s.db.maxUserBytes) {
+		return errors.E(newEntries[0].Name, errors.IO, errQuota)
+	}
+	return nil
+}
+
+// addBytes adds n, which may be negative, to the total size of the files
+// and to that of the tree holding the named file. s.db.mu is held.
+func (db *database) addBytes(name upspin.PathName, n int64) {
+	db.totalBytes += n
+	user := nameUser(name)
+	db.userBytes[user] += n
+	if db.userBytes[user] == 0 {
+		delete(db.userBytes, user)
+	}
+}
+
+// nameUser returns the user name of the path name, which is clean.
+func nameUser(name upspin.PathName) upspin.UserName {
+	str := string(name)
+	if slash := strings.IndexByte(str, '/'); slash >= 0 {
+		str = str[:slash]
+	}
+	return upspin.UserName(str)
+}
+
+// dataSize returns the total length of the entry's blocks.
+func dataSize(entry *upspin.DirEntry) int64 {
+	var size int64
+	for _, b := range entry.Blocks {
+		size += b.Size
+	}
+	return size
+}
@@ -0,0 +1,120 @@
+// Copyright 2017 The Upspin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package inprocess
+
+import (
+	"strings"
+	"testing"
+
+	"upspin.io/errors"
+	"upspin.io/upspin"
+)
+
+func TestMaxTotalBytes(t *testing.T) {
+	config, dir := setup()
+	s := dir.(*server)
+	user := config.UserName()
+	if err := s.db.setOption("maxTotalBytes=100"); err != nil {
+		t.Fatal(err)
+	}
+	put := func(name string, size int) error {
+		_, err := s.PutReader(upspin.PathName(user)+upspin.PathName(name), strings.NewReader(strings.Repeat("x", size)), upspin.PlainPack, nil)
+		return err
+	}
+	noSpace := errors.E(errors.IO, errNoSpace)
+
+	if err := put("/a", 60); err != nil {
+		t.Fatal(err)
+	}
+	if err := put("/b", 50); !errors.Match(errors.E(errors.IO), err) || !strings.Contains(err.Error(), errNoSpace.Error()) {
+		t.Errorf("put over the limit: err = %v; want %v", err, noSpace)
+	}
+	if _, err := dir.Lookup(upspin.PathName(user + "/b")); !errors.Match(errors.E(errors.NotExist), err) {
+		t.Errorf("failed put left an entry: %v", err)
+	}
+	if err := put("/b", 40); err != nil {
+		t.Errorf("put up to the limit: %v", err)
+	}
+	if got := s.TotalBytes(); got != 100 {
+		t.Errorf("TotalBytes = %d; want 100", got)
+	}
+	// Replacing a file with a smaller one is fine, as is deleting.
+	if err := put("/a", 10); err != nil {
+		t.Errorf("shrinking put: %v", err)
+	}
+	if _, err := dir.Delete(upspin.PathName(user + "/b")); err != nil {
+		t.Fatal(err)
+	}
+	if got := s.TotalBytes(); got != 10 {
+		t.Errorf("TotalBytes = %d; want 10", got)
+	}
+	if err := put("/c", 90); err != nil {
+		t.Errorf("put after freeing space: %v", err)
+	}
+}
+
+func TestQuotaStatus(t *testing.T) {
+	config, dir := setup()
+	s := dir.(*server)
+	user := config.UserName()
+	if err := s.db.setOption("maxUserBytes=100"); err != nil {
+		t.Fatal(err)
+	}
+	put := func(name string, size int) error {
+		_, err := s.PutReader(upspin.PathName(user)+upspin.PathName(name), strings.NewReader(strings.Repeat("x", size)), upspin.PlainPack, nil)
+		return err
+	}
+	check := func(wantUsed uint64) {
+		t.Helper()
+		used, limit, err := s.QuotaStatus(user)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if used != wantUsed || limit != 100 {
+			t.Errorf("QuotaStatus = %d, %d; want %d, 100", used, limit, wantUsed)
+		}
+	}
+
+	check(0)
+	if err := put("/a", 60); err != nil {
+		t.Fatal(err)
+	}
+	check(60)
+	if err := put("/b", 50); !errors.Match(errors.E(errors.IO), err) || !strings.Contains(err.Error(), errQuota.Error()) {
+		t.Errorf("put over the quota: err = %v; want %v", err, errQuota)
+	}
+	check(60)
+	if _, err := dir.Delete(upspin.PathName(user + "/a")); err != nil {
+		t.Fatal(err)
+	}
+	check(0)
+
+	// Another user's data does not count, and only the user may ask.
+	otherUser := nextUser()
+	otherConfig, key, _, _ := newConfigAndServices(otherUser)
+	if err := key.Put(&upspin.User{
+		Name:      otherUser,
+		Dirs:      []upspin.Endpoint{otherConfig.DirEndpoint()},
+		Stores:    []upspin.Endpoint{otherConfig.StoreEndpoint()},
+		PublicKey: otherConfig.Factotum().PublicKey(),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	svc, err := s.Dial(otherConfig, s.Endpoint())
+	if err != nil {
+		t.Fatal(err)
+	}
+	other := svc.(*server)
+	if _, err := makeDirectory(other, upspin.PathName(otherUser+"/")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := other.PutReader(upspin.PathName(otherUser+"/c"), strings.NewReader(strings.Repeat("x", 90)), upspin.PlainPack, nil); err != nil {
+		t.Fatal(err)
+	}
+	check(0)
+	if _, _, err := s.QuotaStatus(otherUser); !errors.Match(errors.E(errors.Permission), err) {
+		t.Errorf("QuotaStatus of another user: err = %v; want Permission", err)
+	}
+}
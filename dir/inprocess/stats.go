@@ -0,0 +1,63 @@
+// Copyright 2017 The Upspin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package inprocess
+
+import (
+	"sync"
+
+	"upspin.io/access"
+	"upspin.io/upspin"
+)
+
+// When the accessStats option is set, the server counts the successful
+// Lookups of each name and remembers when the last one happened. The
+// statistics are kept apart from the tree, so recording them rewrites
+// nothing, and under their own lock, so recording them does not contend
+// with writers for s.db.mu.
+
+// accessStats holds the access statistics for all names.
+type accessStats struct {
+	mu    sync.Mutex
+	stats map[upspin.PathName]accessStat
+}
+
+// accessStat holds the access statistics for one name.
+type accessStat struct {
+	count int
+	last  upspin.Time
+}
+
+// record counts an access to the name at the given time.
+func (a *accessStats) record(name upspin.PathName, now upspin.Time) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.stats == nil {
+		a.stats = make(map[upspin.PathName]accessStat)
+	}
+	st := a.stats[name]
+	st.count++
+	st.last = now
+	a.stats[name] = st
+}
+
+// AccessStats returns the number of times the named entry has been
+// looked up and the time of the last lookup. The boolean is false if
+// there are no statistics for the name, either because it has never
+// been looked up, because the accessStats option is not set, or because
+// the caller has no rights to the name.
+func (s *server) AccessStats(name upspin.PathName) (count int, last upspin.Time, ok bool) {
+	parsed, err := s.parse(name)
+	if err != nil {
+		return 0, 0, false
+	}
+	if canAny, err := s.can(access.AnyRight, parsed); err != nil || !canAny {
+		return 0, 0, false
+	}
+	a := &s.db.accessStats
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	st, ok := a.stats[parsed.Path()]
+	return st.count, st.last, ok
+}
@@ -0,0 +1,50 @@
+// Copyright 2017 The Upspin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package inprocess
+
+import (
+	"testing"
+
+	"upspin.io/upspin"
+)
+
+func TestAccessStats(t *testing.T) {
+	config, dir := setup()
+	s := dir.(*server)
+	user := config.UserName()
+	name := upspin.PathName(user + "/file")
+	if _, err := dir.Put(storeData(t, config, []byte("counted"), name)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dir.Lookup(name); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, ok := s.AccessStats(name); ok {
+		t.Error("stats kept without the accessStats option")
+	}
+
+	if err := s.db.setOption("accessStats=true"); err != nil {
+		t.Fatal(err)
+	}
+	now := upspin.Now()
+	s.db.now = func() upspin.Time { return now }
+	for i := 0; i < 3; i++ {
+		now++
+		if _, err := dir.Lookup(name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	count, last, ok := s.AccessStats(name)
+	if !ok || count != 3 || last != now {
+		t.Errorf("AccessStats = %d, %v, %t; want 3, %v, true", count, last, ok, now)
+	}
+	// Failed lookups are not counted.
+	if _, err := dir.Lookup(name + "x"); err == nil {
+		t.Fatal("Lookup of missing file succeeded")
+	}
+	if _, _, ok := s.AccessStats(name + "x"); ok {
+		t.Error("failed Lookup counted")
+	}
+}
@@ -0,0 +1,79 @@
+// Copyright 2017 The Upspin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package inprocess
+
+// Sticky directories.
+//
+// A directory may be made sticky when it is made, by MakeDirectoryOptions
+// with PutOptions.Sticky, or later by SetSticky, like a Unix directory
+// with the sticky bit. An entry in a sticky directory may then be removed,
+// whether by Delete, DeleteGlob, RenameBackup or Transfer, only by its
+// owner, the user named as its Writer, or by the owner of the tree, even
+// if the Access file grants others the right to delete it. Replacing the
+// entry is governed by the Access file as before. Directories are written
+// by the server, so only the owner of the tree may remove one from a
+// sticky directory.
+//
+// Stickiness is not part of the DirEntry, so it is recorded in db.sticky,
+// keyed by directory name. It is forgotten when the directory is deleted
+// and moves with the directory under MoveTree.
+
+import (
+	"upspin.io/errors"
+	"upspin.io/upspin"
+)
+
+var errSticky = errors.Str("directory is sticky")
+
+// SetSticky makes the named directory sticky, or not. Only the owner of
+// the tree may do this.
+func (s *server) SetSticky(dirName upspin.PathName, sticky bool) error {
+	const op = "dir/inprocess.SetSticky"
+	parsed, err := s.parse(dirName)
+	if err != nil {
+		return errors.E(op, err)
+	}
+	if normalizeUser(s.config.UserName(), s.db.foldLocal) != parsed.User() {
+		return errors.E(op, dirName, errors.Permission)
+	}
+	entry, err := s.lookup(op, parsed, true)
+	if err != nil {
+		_, err = s.errLink(op, entry, err)
+		return err
+	}
+
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	if s.db.readOnly {
+		return errors.E(op, dirName, errors.Permission, errReadOnly)
+	}
+	// Things may have changed since we looked.
+	entry, err = s.lookupLocked(op, parsed, false)
+	if err != nil {
+		return errors.E(op, err)
+	}
+	if !entry.IsDir() {
+		return errors.E(op, dirName, errors.NotDir)
+	}
+	if sticky {
+		s.db.sticky[parsed.Path()] = true
+	} else {
+		delete(s.db.sticky, parsed.Path())
+	}
+	return nil
+}
+
+// checkSticky returns an error if the entry is in a sticky directory and
+// the caller may not remove it. s.db.mu is held.
+func (s *server) checkSticky(op string, dirName upspin.PathName, entry *upspin.DirEntry) error {
+	if !s.db.sticky[dirName] {
+		return nil
+	}
+	caller := normalizeUser(s.config.UserName(), s.db.foldLocal)
+	if caller == nameUser(dirName) || caller == normalizeUser(entry.Writer, s.db.foldLocal) {
+		return nil
+	}
+	return errors.E(op, entry.Name, errors.Permission, errSticky)
+}
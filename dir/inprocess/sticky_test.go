@@ -0,0 +1,158 @@
+// Copyright 2017 The Upspin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package inprocess
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"upspin.io/errors"
+	"upspin.io/upspin"
+)
+
+// stickySetup makes a second user, served by the same directory, to whom
+// the Access file at the root of the first user's tree grants all rights.
+func stickySetup(t *testing.T) (upspin.Config, *server, upspin.Config, *server) {
+	config, dir := setup()
+	s := dir.(*server)
+	user := config.UserName()
+	root := upspin.PathName(user + "/")
+	other := nextUser()
+	otherConfig, key, _, _ := newConfigAndServices(other)
+	if err := key.Put(&upspin.User{
+		Name:      other,
+		Dirs:      []upspin.Endpoint{otherConfig.DirEndpoint()},
+		Stores:    []upspin.Endpoint{otherConfig.StoreEndpoint()},
+		PublicKey: otherConfig.Factotum().PublicKey(),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	svc, err := s.Dial(otherConfig, s.Endpoint())
+	if err != nil {
+		t.Fatal(err)
+	}
+	accessFile := storePlainWithIntegrity(t, config, []byte(fmt.Sprintf("*: %s, %s\n", user, other)), root+"Access")
+	if _, err := s.Put(accessFile); err != nil {
+		t.Fatal(err)
+	}
+	return config, s, otherConfig, svc.(*server)
+}
+
+func TestSticky(t *testing.T) {
+	config, s, otherConfig, otherDir := stickySetup(t)
+	root := upspin.PathName(config.UserName() + "/")
+	shared := root + "shared"
+	if _, err := makeDirectory(s, shared); err != nil {
+		t.Fatal(err)
+	}
+	put := func(d upspin.DirServer, cfg upspin.Config, name upspin.PathName) {
+		t.Helper()
+		if _, err := d.Put(storeData(t, cfg, []byte(name), name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	put(s, config, shared+"/mine")
+	put(s, config, shared+"/mine2")
+	put(otherDir, otherConfig, shared+"/theirs")
+	put(otherDir, otherConfig, shared+"/theirs2")
+
+	if err := otherDir.SetSticky(shared, true); !errors.Match(errors.E(errors.Permission), err) {
+		t.Errorf("SetSticky by another user: err = %v; want Permission", err)
+	}
+	if err := s.SetSticky(shared+"/mine", true); !errors.Match(errors.E(errors.NotDir), err) {
+		t.Errorf("SetSticky of a file: err = %v; want NotDir", err)
+	}
+	if err := s.SetSticky(shared, true); err != nil {
+		t.Fatal(err)
+	}
+
+	// The other user may remove only their own entries.
+	if _, err := otherDir.Delete(shared + "/mine"); !errors.Match(errors.E(errors.Permission), err) {
+		t.Errorf("Delete of owner's file: err = %v; want Permission", err)
+	}
+	if _, err := otherDir.DeleteGlob(string(shared + "/mine*")); !errors.Match(errors.E(errors.Permission), err) {
+		t.Errorf("DeleteGlob of owner's files: err = %v; want Permission", err)
+	}
+	if _, err := otherDir.RenameBackup(shared+"/mine", shared+"/moved"); !errors.Match(errors.E(errors.Permission), err) {
+		t.Errorf("RenameBackup of owner's file: err = %v; want Permission", err)
+	}
+	if _, err := s.Lookup(shared + "/moved"); !errors.Match(errors.E(errors.NotExist), err) {
+		t.Errorf("failed RenameBackup left new name: err = %v; want NotExist", err)
+	}
+	if _, err := otherDir.Delete(shared + "/theirs"); err != nil {
+		t.Errorf("Delete of own file: %v", err)
+	}
+	// The owner of the tree may remove anything.
+	if _, err := s.Delete(shared + "/theirs2"); err != nil {
+		t.Errorf("Delete by owner of tree: %v", err)
+	}
+	// Without the sticky bit, the Access file rules.
+	if err := s.SetSticky(shared, false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := otherDir.Delete(shared + "/mine"); err != nil {
+		t.Errorf("Delete after clearing sticky: %v", err)
+	}
+
+	// Stickiness is forgotten with the directory.
+	if err := s.SetSticky(shared, true); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Delete(shared + "/mine2"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Delete(shared); err != nil {
+		t.Fatal(err)
+	}
+	if s.db.sticky[shared] {
+		t.Error("deleted directory is still sticky")
+	}
+}
+
+func TestStickyMakeDirectory(t *testing.T) {
+	config, s, otherConfig, otherDir := stickySetup(t)
+	root := upspin.PathName(config.UserName() + "/")
+	shared := root + "shared"
+	if _, err := otherDir.MakeDirectoryOptions(shared, &PutOptions{Sticky: true}); !errors.Match(errors.E(errors.Permission), err) {
+		t.Errorf("sticky MakeDirectory by another user: err = %v; want Permission", err)
+	}
+	if _, err := s.PutReader(root+"file", strings.NewReader("data"), upspin.PlainPack, &PutOptions{Sticky: true}); !errors.Match(errors.E(errors.Invalid), err) {
+		t.Errorf("sticky PutReader: err = %v; want Invalid", err)
+	}
+	entry, err := s.MakeDirectoryOptions(shared, &PutOptions{Sticky: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !entry.IsDir() {
+		t.Fatalf("%s is not a directory", entry.Name)
+	}
+	if !s.db.sticky[shared] {
+		t.Fatal("directory not sticky")
+	}
+	mine := shared + "/mine"
+	if _, err := s.Put(storeData(t, config, []byte("mine"), mine)); err != nil {
+		t.Fatal(err)
+	}
+	theirs := shared + "/theirs"
+	if _, err := otherDir.Put(storeData(t, otherConfig, []byte("theirs"), theirs)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := otherDir.Delete(mine); !errors.Match(errors.E(errors.Permission), err) {
+		t.Errorf("Delete of owner's file: err = %v; want Permission", err)
+	}
+	if _, err := otherDir.Delete(theirs); err != nil {
+		t.Errorf("Delete of own file: %v", err)
+	}
+
+	// Directories made without the option are not sticky.
+	plain := root + "plain"
+	if _, err := s.MakeDirectoryOptions(plain, nil); err != nil {
+		t.Fatal(err)
+	}
+	if s.db.sticky[plain] {
+		t.Error("directory made without Sticky is sticky")
+	}
+}
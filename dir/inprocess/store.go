@@ -0,0 +1,101 @@
+// Copyright 2017 The Upspin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package inprocess
+
+import (
+	"upspin.io/bind"
+	"upspin.io/config"
+	"upspin.io/errors"
+	"upspin.io/pack"
+	"upspin.io/upspin"
+)
+
+// SetStore makes the server store its directory data in the given store
+// server, which it uses directly, without going through bind, for any
+// location with the given endpoint. It lets a test of the directory
+// logic substitute a store it controls, such as one that fails on the
+// third Put. Data at other endpoints, including the files written by
+// clients, is still read through bind. SetStore must be called before
+// the server is used, like the options to New, and it replaces any
+// dirStore option. The setting applies to all users of the server.
+func (s *server) SetStore(store upspin.StoreServer, endpoint upspin.Endpoint) {
+	s.db.dirConfig = config.SetStoreEndpoint(s.db.dirConfig, endpoint)
+	s.db.store = store
+}
+
+// storeServer returns the store server for the endpoint: the one set by
+// SetStore, if it is for that endpoint, or else the one from bind.
+func (s *server) storeServer(endpoint upspin.Endpoint) (upspin.StoreServer, error) {
+	if s.db.store != nil && endpoint == s.db.dirConfig.StoreEndpoint() {
+		return s.db.store, nil
+	}
+	return bind.StoreServer(s.db.dirConfig, endpoint)
+}
+
+// readStore is clientutil.ReadAll for use when SetStore has been called.
+// It reads and unpacks the entry's data, fetching each block with
+// readLocation.
+func (s *server) readStore(entry *upspin.DirEntry) ([]byte, error) {
+	packer := pack.Lookup(entry.Packing)
+	if packer == nil {
+		return nil, errors.E(entry.Name, errors.Errorf("unrecognized Packing %d", entry.Packing))
+	}
+	bu, err := packer.Unpack(s.db.dirConfig, entry)
+	if err != nil {
+		return nil, errors.E(entry.Name, err)
+	}
+	var data []byte
+	for {
+		block, ok := bu.NextBlock()
+		if !ok {
+			break
+		}
+		cipher, err := s.readLocation(block.Location)
+		if err != nil {
+			return nil, errors.E(entry.Name, err)
+		}
+		clear, err := bu.Unpack(cipher)
+		if err != nil {
+			return nil, errors.E(entry.Name, err)
+		}
+		data = append(data, clear...)
+	}
+	return data, nil
+}
+
+// readLocation is clientutil.ReadLocation using storeServer: it fetches
+// the data at the location, following any redirection.
+func (s *server) readLocation(loc upspin.Location) ([]byte, error) {
+	var firstErr error
+	seen := map[upspin.Location]bool{loc: true}
+	where := []upspin.Location{loc}
+	for i := 0; i < len(where); i++ { // Not range loop - where changes as we run.
+		store, err := s.storeServer(where[i].Endpoint)
+		var data []byte
+		var locs []upspin.Location
+		if err == nil {
+			data, _, locs, err = store.Get(where[i].Reference)
+		}
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		if locs == nil {
+			return data, nil
+		}
+		for _, l := range locs {
+			if !seen[l] {
+				seen[l] = true
+				where = append(where, l)
+			}
+		}
+	}
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	return nil, errors.E(errors.IO, errors.Errorf("data for location %v not found on any store server", loc))
+}
@@ -0,0 +1,77 @@
+// Copyright 2017 The Upspin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package inprocess
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"testing"
+
+	"upspin.io/errors"
+	"upspin.io/upspin"
+)
+
+// mockStore is a StoreServer held in memory that fails the Put whose
+// number, counting from one, is failOn.
+type mockStore struct {
+	upspin.StoreServer // Only for the methods not used.
+	data               map[upspin.Reference][]byte
+	puts, failOn       int
+}
+
+func (m *mockStore) Put(data []byte) (*upspin.Refdata, error) {
+	m.puts++
+	if m.puts == m.failOn {
+		return nil, errors.E(errors.IO, errors.Str("mock failure"))
+	}
+	ref := upspin.Reference(fmt.Sprintf("%x", sha256.Sum256(data)))
+	m.data[ref] = data
+	return &upspin.Refdata{Reference: ref}, nil
+}
+
+func (m *mockStore) Get(ref upspin.Reference) ([]byte, *upspin.Refdata, []upspin.Location, error) {
+	data, ok := m.data[ref]
+	if !ok {
+		return nil, nil, nil, errors.E(errors.NotExist)
+	}
+	return data, &upspin.Refdata{Reference: ref}, nil, nil
+}
+
+func TestSetStore(t *testing.T) {
+	config, _, _, _ := newConfigAndServices(nextUser())
+	user := config.UserName()
+	s := New(config).(*server)
+	mock := &mockStore{data: make(map[upspin.Reference][]byte)}
+	// A transport bind does not know.
+	s.SetStore(mock, upspin.Endpoint{Transport: 99, NetAddr: "mock"})
+
+	if _, err := makeDirectory(s, upspin.PathName(user+"/")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := makeDirectory(s, upspin.PathName(user+"/a")); err != nil {
+		t.Fatal(err)
+	}
+	if mock.puts != 3 {
+		t.Errorf("mock store saw %d Puts; want 3", mock.puts)
+	}
+	// The third Put from now, which stores the new root, fails.
+	mock.failOn = mock.puts + 2
+	if _, err := makeDirectory(s, upspin.PathName(user+"/a/b")); !errors.Match(errors.E(errors.IO), err) {
+		t.Errorf("MakeDirectory with failing store: err = %v; want IO", err)
+	}
+	entries, err := s.Glob(string(user) + "/*/*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("failed MakeDirectory left %v", entries)
+	}
+	if _, err := makeDirectory(s, upspin.PathName(user+"/a/b")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Lookup(upspin.PathName(user + "/a/b")); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,144 @@
+// Copyright 2017 The Upspin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package inprocess
+
+import (
+	"sync"
+
+	"upspin.io/errors"
+	"upspin.io/upspin"
+)
+
+// UserEvent describes a change to a user's tree, as delivered by Subscribe.
+type UserEvent struct {
+	// Op is the operation, as recorded in the mutation log: "put",
+	// "delete", "makeRoot", "deleteRoot", "deleteUser" or "rebuild".
+	Op string
+
+	// Name is the path name operated on.
+	Name upspin.PathName
+
+	// Root is the reference of the user's root directory after the
+	// change, or empty if the root was removed.
+	Root upspin.Reference
+}
+
+// A subscriber delivers the events for one subscription. Events are
+// queued without limit, so a slow reader never delays the server, and a
+// goroutine forwards them in order to the channel. Only that goroutine
+// sends on or closes the channel.
+type subscriber struct {
+	user upspin.UserName
+	out  chan UserEvent
+	wake chan struct{} // Signals new events; buffered.
+	done chan struct{} // Closed by Unsubscribe.
+
+	mu      sync.Mutex
+	pending []UserEvent
+}
+
+// Subscribe returns a channel on which the server delivers an event for
+// every change to the user's tree, in the order they are made, along
+// with an ID to pass to Unsubscribe. Unlike Watch, the events cover the
+// whole tree and name the new root rather than the entry changed. The
+// channel stays open, even if the user's root is deleted, until
+// Unsubscribe is called.
+// Only the user may do this.
+func (s *server) Subscribe(userName upspin.UserName) (int, <-chan UserEvent, error) {
+	const op = "dir/inprocess.Subscribe"
+	userName = normalizeUser(userName, s.db.foldLocal)
+	if normalizeUser(s.config.UserName(), s.db.foldLocal) != userName {
+		return 0, nil, errors.E(op, userName, errors.Permission)
+	}
+
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	if _, ok := s.db.root[userName]; !ok {
+		return 0, nil, errors.E(op, userName, errors.NotExist, errors.Str("no such user"))
+	}
+	sub := &subscriber{
+		user: userName,
+		out:  make(chan UserEvent),
+		wake: make(chan struct{}, 1),
+		done: make(chan struct{}),
+	}
+	if s.db.subscribers == nil {
+		s.db.subscribers = make(map[int]*subscriber)
+	}
+	s.db.nextSubscriber++
+	id := s.db.nextSubscriber
+	s.db.subscribers[id] = sub
+	go sub.run()
+	return id, sub.out, nil
+}
+
+// Unsubscribe ends the subscription with the given ID and closes its
+// channel. Events not yet received are discarded. It is a no-op if there
+// is no such subscription.
+func (s *server) Unsubscribe(id int) {
+	s.db.mu.Lock()
+	sub, ok := s.db.subscribers[id]
+	delete(s.db.subscribers, id)
+	s.db.mu.Unlock()
+	if ok {
+		close(sub.done)
+	}
+}
+
+// notify queues an event for each subscriber to the user whose tree
+// holds name. s.db.mu is held.
+func (s *server) notify(op string, name upspin.PathName, root *upspin.DirEntry) {
+	if len(s.db.subscribers) == 0 {
+		return
+	}
+	parsed, err := s.parse(name)
+	if err != nil {
+		return // Can't happen.
+	}
+	event := UserEvent{Op: op, Name: name}
+	if root != nil && len(root.Blocks) > 0 {
+		event.Root = root.Blocks[0].Location.Reference
+	}
+	for _, sub := range s.db.subscribers {
+		if sub.user == parsed.User() {
+			sub.post(event)
+		}
+	}
+}
+
+// post queues the event for delivery.
+func (sub *subscriber) post(event UserEvent) {
+	sub.mu.Lock()
+	sub.pending = append(sub.pending, event)
+	sub.mu.Unlock()
+	select {
+	case sub.wake <- struct{}{}:
+	default:
+		// Already signaled.
+	}
+}
+
+// run forwards queued events to the channel until the subscription ends.
+func (sub *subscriber) run() {
+	defer close(sub.out)
+	for {
+		sub.mu.Lock()
+		events := sub.pending
+		sub.pending = nil
+		sub.mu.Unlock()
+		for _, event := range events {
+			select {
+			case sub.out <- event:
+			case <-sub.done:
+				return
+			}
+		}
+		select {
+		case <-sub.wake:
+		case <-sub.done:
+			return
+		}
+	}
+}
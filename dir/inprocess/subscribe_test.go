@@ -0,0 +1,83 @@
+// Copyright 2017 The Upspin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package inprocess
+
+import (
+	"testing"
+	"time"
+
+	"upspin.io/errors"
+	"upspin.io/upspin"
+)
+
+func TestSubscribe(t *testing.T) {
+	config, dir := setup()
+	s := dir.(*server)
+	user := config.UserName()
+
+	id, ch, err := s.Subscribe(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A second user's changes are not delivered.
+	otherConfig, otherDir := setup()
+	if _, err := makeDirectory(otherDir, upspin.PathName(otherConfig.UserName()+"/x")); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := otherDir.(*server).Subscribe(user); !errors.Match(errors.E(errors.Permission), err) {
+		t.Errorf("Subscribe by another user: err = %v; want Permission", err)
+	}
+
+	// Make several changes without reading, so they queue.
+	a := upspin.PathName(user + "/a")
+	names := []upspin.PathName{a, a + "/f", a + "/f"}
+	if _, err := makeDirectory(dir, names[0]); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dir.Put(storeData(t, config, []byte("f"), names[1])); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dir.Delete(names[2]); err != nil {
+		t.Fatal(err)
+	}
+	root, err := dir.Lookup(upspin.PathName(user + "/"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var last UserEvent
+	for i, want := range []string{"put", "put", "delete"} {
+		select {
+		case event := <-ch:
+			if event.Op != want || event.Name != names[i] {
+				t.Errorf("event %d is %s %s; want %s %s", i, event.Op, event.Name, want, names[i])
+			}
+			last = event
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for event %d", i)
+		}
+	}
+	if want := root.Blocks[0].Location.Reference; last.Root != want {
+		t.Errorf("last event has root %q; want %q", last.Root, want)
+	}
+
+	// Unsubscribe closes the channel, even with events pending.
+	if _, err := makeDirectory(dir, upspin.PathName(user+"/b")); err != nil {
+		t.Fatal(err)
+	}
+	s.Unsubscribe(id)
+	s.Unsubscribe(id) // No-op.
+	timeout := time.After(5 * time.Second)
+	for open := true; open; {
+		select {
+		case _, open = <-ch:
+		case <-timeout:
+			t.Fatal("channel not closed after Unsubscribe")
+		}
+	}
+	if len(s.db.subscribers) != 0 {
+		t.Errorf("%d subscribers remain after Unsubscribe", len(s.db.subscribers))
+	}
+}
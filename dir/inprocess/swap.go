@@ -0,0 +1,112 @@
+// Copyright 2017 The Upspin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package inprocess
+
+import (
+	"upspin.io/access"
+	"upspin.io/errors"
+	"upspin.io/path"
+	"upspin.io/upspin"
+)
+
+// Swap exchanges the contents of two existing files or links in the same
+// user's tree, so that each name refers to what the other did: its data,
+// packing and other metadata. Both changes are made under a single lock
+// and installed together, so no one sees one without the other and if
+// either fails neither is made. If the two share a directory it is
+// rewritten once with both. The caller needs read and write rights
+// for both names. Directories, Access files and Group files cannot be
+// swapped.
+func (s *server) Swap(a, b upspin.PathName) error {
+	const op = "dir/inprocess.Swap"
+	var parsed [2]path.Parsed
+	for i, name := range []upspin.PathName{a, b} {
+		p, err := s.parse(name)
+		if err != nil {
+			return errors.E(op, err)
+		}
+		if access.IsAccessFile(p.Path()) || access.IsGroupFile(p.Path()) {
+			return errors.E(op, p.Path(), errors.Invalid, errors.Str("cannot swap an Access or Group file"))
+		}
+		parsed[i] = p
+	}
+	if parsed[0].User() != parsed[1].User() {
+		return errors.E(op, b, errors.Invalid, errors.Errorf("not in tree of %s", parsed[0].User()))
+	}
+	if parsed[0].Path() == parsed[1].Path() {
+		return errors.E(op, a, errors.Invalid, errors.Str("cannot swap an entry with itself"))
+	}
+	for _, p := range parsed {
+		entry, err := s.lookup(op, p, false)
+		if err != nil {
+			_, err = s.errLink(op, entry, err)
+			return err
+		}
+		if entry.IsDir() {
+			return errors.E(op, p.Path(), errors.IsDir)
+		}
+		for _, right := range []access.Right{access.Read, access.Write} {
+			can, err := s.can(right, p)
+			if err != nil {
+				return errors.E(op, err)
+			}
+			if !can {
+				return s.errPerm(op, p)
+			}
+		}
+	}
+
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	if s.db.readOnly {
+		return errors.E(op, a, errors.Permission, errReadOnly)
+	}
+	// The entries may have changed since we looked.
+	var entries [2]*upspin.DirEntry
+	for i, p := range parsed {
+		entry, err := s.lookupLocked(op, p, false)
+		if err != nil {
+			return errors.E(op, err)
+		}
+		if entry.IsDir() {
+			return errors.E(op, p.Path(), errors.IsDir)
+		}
+		if err := s.checkUnlocked(op, p); err != nil {
+			return err
+		}
+		entries[i] = entry
+	}
+	// Each name gets a copy of the other's entry. SignedName is
+	// untouched, as the signature covers it.
+	var swapped [2]*upspin.DirEntry
+	for i := range swapped {
+		swapped[i] = entries[1-i].Copy()
+		swapped[i].Name = parsed[i].Path()
+		swapped[i].Sequence = upspin.SeqIgnore
+	}
+	// Each name is updated in place, even if it holds a link.
+	c := *s // Make a copy.
+	c.replaceLink = true
+	dir := parsed[0].Drop(1)
+	if dir.Path() == parsed[1].Drop(1).Path() {
+		if _, err := c.putAll(op, dir, swapped[:], false); err != nil {
+			return err
+		}
+	} else {
+		var changes []change
+		for i, p := range parsed {
+			changes = append(changes, change{dir: p.Drop(1), entries: swapped[i : i+1]})
+		}
+		if _, err := c.putChanges(op, changes); err != nil {
+			return err
+		}
+	}
+	for _, entry := range swapped {
+		s.db.eventMgr.newEvent <- upspin.Event{
+			Entry: entry,
+		}
+	}
+	return nil
+}
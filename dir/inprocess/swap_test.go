@@ -0,0 +1,151 @@
+// Copyright 2017 The Upspin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package inprocess
+
+import (
+	"testing"
+
+	"upspin.io/errors"
+	"upspin.io/upspin"
+)
+
+func TestSwap(t *testing.T) {
+	config, dir := globTree(t)
+	s := dir.(*server)
+	user := config.UserName()
+	name := func(elem string) upspin.PathName {
+		return upspin.PathName(user) + upspin.PathName(elem)
+	}
+	check := func(elem, want string) {
+		t.Helper()
+		got, err := s.GetData(name(elem))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != want {
+			t.Errorf("%s holds %q; want %q", elem, got, want)
+		}
+	}
+
+	// Same directory: the directory is rewritten once.
+	before, err := dir.Lookup(name("/c"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dir.Put(storeData(t, config, []byte("/c/f6"), name("/c/f6"))); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Swap(name("/c/f4"), name("/c/f6")); err != nil {
+		t.Fatal(err)
+	}
+	check("/c/f4", "/c/f6")
+	check("/c/f6", "/c/f4")
+	after, err := dir.Lookup(name("/c"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// One rewrite for the Put and one for the Swap.
+	if got, want := after.Sequence, upspin.SeqNext(upspin.SeqNext(before.Sequence)); got != want {
+		t.Errorf("/c has sequence %d after Swap; want %d", got, want)
+	}
+
+	// Different directories.
+	if err := s.Swap(name("/f1"), name("/a/b/f3")); err != nil {
+		t.Fatal(err)
+	}
+	check("/f1", "/a/b/f3")
+	check("/a/b/f3", "/f1")
+	if errs := s.checkUser(user); len(errs) != 0 {
+		t.Errorf("tree is inconsistent after Swap: %v", errs)
+	}
+
+	for _, test := range []struct {
+		a, b string
+		kind errors.Kind
+	}{
+		{"/f1", "/nothing", errors.NotExist},
+		{"/f1", "/a", errors.IsDir},
+		{"/f1", "/f1", errors.Invalid},
+	} {
+		if err := s.Swap(name(test.a), name(test.b)); !errors.Match(errors.E(test.kind), err) {
+			t.Errorf("Swap(%s, %s): err = %v; want %v", test.a, test.b, err, test.kind)
+		}
+	}
+}
+
+func TestSwapLink(t *testing.T) {
+	config, dir := globTree(t)
+	s := dir.(*server)
+	user := config.UserName()
+	name := func(elem string) upspin.PathName {
+		return upspin.PathName(user) + upspin.PathName(elem)
+	}
+	checkLink := func(elem string) {
+		t.Helper()
+		entry, err := dir.Lookup(name(elem))
+		if err != upspin.ErrFollowLink {
+			t.Fatalf("Lookup(%s): err = %v; want %v", elem, err, upspin.ErrFollowLink)
+		}
+		if entry.Link != name("/a") {
+			t.Errorf("%s links to %s; want %s", elem, entry.Link, name("/a"))
+		}
+	}
+	checkData := func(elem, want string) {
+		t.Helper()
+		got, err := s.GetData(name(elem))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != want {
+			t.Errorf("%s holds %q; want %q", elem, got, want)
+		}
+	}
+
+	// Same directory: /link and /f1 are both in the root.
+	if err := s.Swap(name("/link"), name("/f1")); err != nil {
+		t.Fatal(err)
+	}
+	checkLink("/f1")
+	checkData("/link", "/f1")
+
+	// Different directories, with the link as the second name.
+	if err := s.Swap(name("/c/f4"), name("/f1")); err != nil {
+		t.Fatal(err)
+	}
+	checkLink("/c/f4")
+	checkData("/f1", "/c/f4")
+	if errs := s.checkUser(user); len(errs) != 0 {
+		t.Errorf("tree is inconsistent after Swap: %v", errs)
+	}
+}
+
+func TestSwapFailAfter(t *testing.T) {
+	config, dir := globTree(t)
+	s := dir.(*server)
+	user := config.UserName()
+	a := upspin.PathName(user + "/f1")
+	b := upspin.PathName(user + "/a/b/f3")
+	check := func(name upspin.PathName, want string) {
+		t.Helper()
+		got, err := s.GetData(name)
+		if err != nil || string(got) != want {
+			t.Errorf("%s holds %q, %v; want %q", name, got, err, want)
+		}
+	}
+	// The two names are in different directories, so each is put
+	// separately but the new roots are installed together.
+	stores := failEachStore(t, s, []upspin.UserName{user}, func() error {
+		return s.Swap(a, b)
+	}, func(n int) {
+		check(a, "/f1")
+		check(b, "/a/b/f3")
+	})
+	// The root for /f1, then /a/b, /a and the root for /a/b/f3.
+	if stores != 4 {
+		t.Errorf("Swap made %d stores; want 4", stores)
+	}
+	check(a, "/a/b/f3")
+	check(b, "/f1")
+}
@@ -0,0 +1,200 @@
+// Copyright 2017 The Upspin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package inprocess
+
+import (
+	"archive/tar"
+	"io"
+	goPath "path"
+	"strings"
+
+	"upspin.io/errors"
+	"upspin.io/upspin"
+)
+
+// ExportTar writes the user's tree to w as a tar archive, for inspection
+// with other tools. The root is the root of the archive, and each entry
+// below it becomes a directory, a regular file holding the unpacked data,
+// or a symbolic link, named by its path relative to the root and with the
+// entry's time as its modification time. A link to a name in the tree is
+// made relative, so it resolves within the extracted archive; other links
+// keep their full target. The tree is read as by Walk, so changes made
+// while the archive is written may or may not be seen.
+// Only the user may do this.
+func (s *server) ExportTar(userName upspin.UserName, w io.Writer) error {
+	const op = "dir/inprocess.ExportTar"
+	userName = normalizeUser(userName, s.db.foldLocal)
+	if normalizeUser(s.config.UserName(), s.db.foldLocal) != userName {
+		return errors.E(op, userName, errors.Permission)
+	}
+	prefix := string(userName) + "/"
+	tw := tar.NewWriter(w)
+	err := s.Walk(upspin.PathName(prefix), func(entry *upspin.DirEntry) error {
+		name := strings.TrimPrefix(string(entry.Name), prefix)
+		if name == "" {
+			return nil // The root.
+		}
+		hdr := &tar.Header{
+			Name:    name,
+			ModTime: entry.Time.Go(),
+		}
+		var data []byte
+		switch {
+		case entry.IsDir():
+			hdr.Typeflag = tar.TypeDir
+			hdr.Name += "/"
+			hdr.Mode = 0755
+		case entry.IsLink():
+			hdr.Typeflag = tar.TypeSymlink
+			hdr.Linkname = tarLink(name, string(entry.Link), prefix)
+			hdr.Mode = 0777
+		default:
+			var err error
+			data, err = s.readData(entry)
+			if err != nil {
+				return err
+			}
+			hdr.Typeflag = tar.TypeReg
+			hdr.Mode = 0644
+			hdr.Size = int64(len(data))
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return errors.E(entry.Name, errors.IO, err)
+		}
+		if _, err := tw.Write(data); err != nil {
+			return errors.E(entry.Name, errors.IO, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return errors.E(op, err)
+	}
+	if err := tw.Close(); err != nil {
+		return errors.E(op, userName, errors.IO, err)
+	}
+	return nil
+}
+
+// tarLink returns the target of the link at name, relative to the root,
+// for a tar archive of the tree whose names begin with prefix: relative
+// to the link's directory if the target is in the tree, otherwise as is.
+func tarLink(name, target, prefix string) string {
+	if !strings.HasPrefix(target, prefix) {
+		return target
+	}
+	from := strings.Split(name, "/")
+	from = from[:len(from)-1] // The link's directory.
+	to := strings.Split(strings.TrimPrefix(target, prefix), "/")
+	i := 0
+	for i < len(from) && i < len(to)-1 && from[i] == to[i] {
+		i++
+	}
+	rel := strings.Repeat("../", len(from)-i) + strings.Join(to[i:], "/")
+	if rel == "" {
+		return "."
+	}
+	return rel
+}
+
+// ImportTar reads a tar archive from r and makes its directories, files
+// and symbolic links in the user's tree, with the archive's root as the
+// user's root, which is made if it does not exist. Directories above an
+// entry that the archive does not hold are made as needed, and existing
+// directories are kept. File data is packed with the given packing. A
+// symbolic link whose target is relative, as ExportTar writes them,
+// must resolve within the tree. Other kinds of entry, such as hard links,
+// are an error. The entries take the time they are made, not that in the
+// archive. Only the user may do this.
+func (s *server) ImportTar(userName upspin.UserName, r io.Reader, packing upspin.Packing) error {
+	const op = "dir/inprocess.ImportTar"
+	userName = normalizeUser(userName, s.db.foldLocal)
+	if normalizeUser(s.config.UserName(), s.db.foldLocal) != userName {
+		return errors.E(op, userName, errors.Permission)
+	}
+	root := upspin.PathName(userName + "/")
+	if _, err := s.Lookup(root); errors.Match(notExist, err) {
+		if _, err := s.MakeDirectory(root); err != nil {
+			return errors.E(op, err)
+		}
+	} else if err != nil {
+		return errors.E(op, err)
+	}
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return errors.E(op, userName, errors.IO, err)
+		}
+		rel := goPath.Clean(hdr.Name)
+		if rel == "." || rel == "/" {
+			continue // The root.
+		}
+		if strings.HasPrefix(rel, "/") || rel == ".." || strings.HasPrefix(rel, "../") {
+			return errors.E(op, userName, errors.Invalid, errors.Errorf("tar entry %q is outside the tree", hdr.Name))
+		}
+		if err := s.importTarEntry(hdr, tr, root, rel, packing); err != nil {
+			return errors.E(op, err)
+		}
+	}
+}
+
+// importTarEntry makes the entry for the tar header, whose name relative
+// to the root is rel, making the directories above it as needed. A file's
+// data is read from tr.
+func (s *server) importTarEntry(hdr *tar.Header, tr *tar.Reader, root upspin.PathName, rel string, packing upspin.Packing) error {
+	name := root + upspin.PathName(rel)
+	if hdr.Typeflag == tar.TypeDir {
+		_, err := s.MakeDirectoryAll(name)
+		return err
+	}
+	var link upspin.PathName
+	switch hdr.Typeflag {
+	case tar.TypeReg:
+	case tar.TypeSymlink:
+		target, err := tarTarget(rel, hdr.Linkname, string(root))
+		if err != nil {
+			return errors.E(name, errors.Invalid, err)
+		}
+		link = upspin.PathName(target)
+	default:
+		return errors.E(name, errors.Invalid, errors.Errorf("unsupported tar entry type %q", hdr.Typeflag))
+	}
+	if _, err := s.MakeDirectoryAll(upspin.PathName(goPath.Dir(string(name)))); err != nil {
+		return err
+	}
+	if link == "" {
+		_, err := s.PutReader(name, tr, packing, nil)
+		return err
+	}
+	entry, err := newDirEntryAt(s.config, packing, name, nil, upspin.AttrLink, link, upspin.SeqIgnore, s.db.now())
+	if err != nil {
+		return errors.E(name, err)
+	}
+	_, err = s.Put(entry)
+	return err
+}
+
+// tarTarget is the inverse of tarLink: it returns the full target of the
+// symbolic link at name, relative to the root, in an archive of the tree
+// whose names begin with prefix.
+func tarTarget(name, linkname, prefix string) (string, error) {
+	if user := strings.SplitN(linkname, "/", 2)[0]; strings.Contains(user, "@") {
+		return linkname, nil // A full path name, beginning with a user.
+	}
+	if strings.HasPrefix(linkname, "/") {
+		return "", errors.Errorf("link target %q is absolute", linkname)
+	}
+	rel := goPath.Clean(goPath.Join(goPath.Dir(name), linkname))
+	if rel == ".." || strings.HasPrefix(rel, "../") {
+		return "", errors.Errorf("link target %q is outside the tree", linkname)
+	}
+	if rel == "." {
+		return prefix, nil
+	}
+	return prefix + rel, nil
+}
@@ -0,0 +1,170 @@
+// Copyright 2017 The Upspin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package inprocess
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"testing"
+
+	"upspin.io/errors"
+	"upspin.io/upspin"
+)
+
+func TestExportTar(t *testing.T) {
+	config, dir := globTree(t)
+	s := dir.(*server)
+	user := config.UserName()
+
+	var buf bytes.Buffer
+	if err := s.ExportTar(user, &buf); err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	tr := tar.NewReader(&buf)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, hdr.Name)
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+		case tar.TypeSymlink:
+			if hdr.Linkname != "a" {
+				t.Errorf("%s links to %q; want %q", hdr.Name, hdr.Linkname, "a")
+			}
+		case tar.TypeReg:
+			// globTree stores each file's own name as its data.
+			data, err := ioutil.ReadAll(tr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := "/" + hdr.Name; string(data) != want {
+				t.Errorf("%s holds %q; want %q", hdr.Name, data, want)
+			}
+			if hdr.Size != int64(len(data)) {
+				t.Errorf("%s has size %d; want %d", hdr.Name, hdr.Size, len(data))
+			}
+		default:
+			t.Errorf("%s has type %c", hdr.Name, hdr.Typeflag)
+		}
+		entry, err := dir.Lookup(upspin.PathName(string(user) + "/" + hdr.Name))
+		if err != nil && err != upspin.ErrFollowLink {
+			t.Fatal(err)
+		}
+		if !hdr.ModTime.Equal(entry.Time.Go()) {
+			t.Errorf("%s has time %v; want %v", hdr.Name, hdr.ModTime, entry.Time.Go())
+		}
+	}
+	want := []string{"a/", "a/b/", "a/b/f3", "a/f2", "c/", "c/d/", "c/d/f5", "c/f4", "f1", "link"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("archive holds\n\t%q\nwant\n\t%q", got, want)
+	}
+
+	_, other := setup()
+	if err := other.(*server).ExportTar(user, ioutil.Discard); !errors.Match(errors.E(errors.Permission), err) {
+		t.Errorf("ExportTar by another user: err = %v; want Permission", err)
+	}
+}
+
+func TestTarLink(t *testing.T) {
+	const prefix = "u@x.com/"
+	for _, test := range []struct {
+		name, target, want string
+	}{
+		{"link", "u@x.com/a", "a"},
+		{"a/link", "u@x.com/a/f", "f"},
+		{"a/b/link", "u@x.com/c/f", "../../c/f"},
+		{"a/link", "u@x.com/a", "../a"},
+		{"link", "u@x.com/", "."},
+		{"link", "v@x.com/a", "v@x.com/a"},
+	} {
+		if got := tarLink(test.name, test.target, prefix); got != test.want {
+			t.Errorf("tarLink(%q, %q) = %q; want %q", test.name, test.target, got, test.want)
+		}
+	}
+}
+
+func TestImportTar(t *testing.T) {
+	config, dir := globTree(t)
+	s := dir.(*server)
+	var buf bytes.Buffer
+	if err := s.ExportTar(config.UserName(), &buf); err != nil {
+		t.Fatal(err)
+	}
+
+	// Import into a new user, who has no root yet.
+	config2, dir2 := setup()
+	s2 := dir2.(*server)
+	if err := s2.ImportTar(config2.UserName(), &buf, upspin.PlainPack); err != nil {
+		t.Fatal(err)
+	}
+	user, user2 := config.UserName(), config2.UserName()
+	if got, want := walkNames(t, s2, user2), walkNames(t, s, user); !reflect.DeepEqual(got, want) {
+		t.Errorf("imported tree holds\n\t%q\nwant\n\t%q", got, want)
+	}
+	for _, name := range []string{"/f1", "/a/b/f3", "/c/d/f5"} {
+		data, err := s2.GetData(upspin.PathName(string(user2) + name))
+		if err != nil || string(data) != name {
+			t.Errorf("%s holds %q, %v; want %q", name, data, err, name)
+		}
+	}
+	link, err := s2.Lookup(upspin.PathName(user2 + "/link"))
+	if err != upspin.ErrFollowLink || link.Link != upspin.PathName(user2+"/a") {
+		t.Errorf("link is %v, %v; want a link to %s/a", link, err, user2)
+	}
+
+	for _, test := range []struct {
+		hdr  tar.Header
+		kind errors.Kind
+	}{
+		{tar.Header{Name: "../x", Typeflag: tar.TypeDir}, errors.Invalid},
+		{tar.Header{Name: "a/hard", Typeflag: tar.TypeLink, Linkname: "f1"}, errors.Invalid},
+		{tar.Header{Name: "a/sym", Typeflag: tar.TypeSymlink, Linkname: "../../x"}, errors.Invalid},
+		{tar.Header{Name: "f1/x", Typeflag: tar.TypeDir}, errors.NotDir},
+	} {
+		var buf bytes.Buffer
+		tw := tar.NewWriter(&buf)
+		if err := tw.WriteHeader(&test.hdr); err != nil {
+			t.Fatal(err)
+		}
+		tw.Close()
+		if err := s2.ImportTar(config2.UserName(), &buf, upspin.PlainPack); !errors.Match(errors.E(test.kind), err) {
+			t.Errorf("ImportTar of %q: err = %v; want %v", test.hdr.Name, err, test.kind)
+		}
+	}
+	if err := s.ImportTar(config2.UserName(), &buf, upspin.PlainPack); !errors.Match(errors.E(errors.Permission), err) {
+		t.Errorf("ImportTar by another user: err = %v; want Permission", err)
+	}
+}
+
+func TestTarTarget(t *testing.T) {
+	const prefix = "u@x.com/"
+	for _, test := range []struct {
+		name, linkname, want string
+	}{
+		{"link", "a", "u@x.com/a"},
+		{"a/b/link", "../../c/f", "u@x.com/c/f"},
+		{"link", ".", "u@x.com/"},
+		{"link", "v@x.com/a", "v@x.com/a"},
+	} {
+		got, err := tarTarget(test.name, test.linkname, prefix)
+		if err != nil || got != test.want {
+			t.Errorf("tarTarget(%q, %q) = %q, %v; want %q", test.name, test.linkname, got, err, test.want)
+		}
+	}
+	for _, linkname := range []string{"/etc/passwd", "../x"} {
+		if _, err := tarTarget("link", linkname, prefix); err == nil {
+			t.Errorf("tarTarget(%q) succeeded", linkname)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+// Copyright 2017 The Upspin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package inprocess
+
+import (
+	"strings"
+
+	"upspin.io/errors"
+	"upspin.io/upspin"
+)
+
+// Touch sets the time of the named file or link to t, or to the current
+// time if t is zero, leaving its data as it is, as the Unix touch command
+// does. A link is touched itself rather than followed, as by touch -h.
+// The entry gets the next sequence number like any other update.
+// As with UpdateMeta, the entry is not signed again, so for a packing
+// that signs the time its signature no longer verifies; Touch is meant
+// for exercising the server's handling of times. If the name does not
+// exist and createIfMissing is set, an empty file is made there, packed
+// with the directory's default packing or, if it has none, the caller's;
+// otherwise the error is NotExist.
+// The caller needs write rights for the name, or create rights to make
+// it. Directories cannot be touched.
+func (s *server) Touch(name upspin.PathName, t upspin.Time, createIfMissing bool) error {
+	const op = "dir/inprocess.Touch"
+	if t == 0 {
+		t = s.db.now()
+	}
+	setTime := func(entry *upspin.DirEntry) {
+		entry.Time = t
+	}
+	err := s.updateMeta(op, name, setTime)
+	if !createIfMissing || !errors.Match(notExist, err) {
+		return err
+	}
+	parsed, err := s.parse(name)
+	if err != nil {
+		return errors.E(op, err)
+	}
+	packing := s.db.defaultPackingFor(parsed.Drop(1).Path())
+	if packing == upspin.UnassignedPack {
+		packing = s.config.Packing()
+	}
+	entry, err := s.PutReader(name, strings.NewReader(""), packing, nil)
+	if err != nil {
+		return errors.E(op, err)
+	}
+	if entry.Time == t {
+		return nil
+	}
+	return s.updateMeta(op, name, setTime)
+}
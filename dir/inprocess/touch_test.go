@@ -0,0 +1,103 @@
+// Copyright 2017 The Upspin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package inprocess
+
+import (
+	"strings"
+	"testing"
+
+	"upspin.io/errors"
+	"upspin.io/upspin"
+)
+
+func TestTouch(t *testing.T) {
+	config, dir := setup()
+	s := dir.(*server)
+	user := config.UserName()
+	name := upspin.PathName(user + "/file")
+	before, err := s.PutReader(name, strings.NewReader("touch me"), upspin.PlainPack, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const when upspin.Time = 12345
+	if err := s.Touch(name, when, false); err != nil {
+		t.Fatal(err)
+	}
+	after, err := dir.Lookup(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after.Time != when {
+		t.Errorf("Time = %d; want %d", after.Time, when)
+	}
+	if after.Sequence <= before.Sequence {
+		t.Errorf("Sequence = %d; want more than %d", after.Sequence, before.Sequence)
+	}
+	if len(after.Blocks) != len(before.Blocks) || after.Blocks[0].Location != before.Blocks[0].Location {
+		t.Errorf("Blocks changed: %v; want %v", after.Blocks, before.Blocks)
+	}
+
+	// A zero time is now.
+	if err := s.Touch(name, 0, false); err != nil {
+		t.Fatal(err)
+	}
+	if after, err = dir.Lookup(name); err != nil {
+		t.Fatal(err)
+	}
+	if after.Time <= when {
+		t.Errorf("Touch with zero time: Time = %d; want now", after.Time)
+	}
+
+	// A missing name is made only if asked.
+	missing := upspin.PathName(user + "/missing")
+	if err := s.Touch(missing, when, false); !errors.Match(errors.E(errors.NotExist), err) {
+		t.Errorf("Touch of missing file: err = %v; want NotExist", err)
+	}
+	if err := s.Touch(missing, when, true); err != nil {
+		t.Fatal(err)
+	}
+	made, err := dir.Lookup(missing)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size, _ := made.Size(); size != 0 || made.Time != when {
+		t.Errorf("made file has size %d, time %d; want 0, %d", size, made.Time, when)
+	}
+
+	if err := s.Touch(upspin.PathName(user+"/"), when, false); !errors.Match(errors.E(errors.IsDir), err) {
+		t.Errorf("Touch of directory: err = %v; want IsDir", err)
+	}
+}
+
+func TestTouchLink(t *testing.T) {
+	config, dir := globTree(t)
+	s := dir.(*server)
+	user := config.UserName()
+	name := upspin.PathName(user + "/link")
+	target, err := dir.Lookup(upspin.PathName(user + "/a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const when upspin.Time = 12345
+	if err := s.Touch(name, when, false); err != nil {
+		t.Fatal(err)
+	}
+	entry, err := dir.Lookup(name)
+	if err != upspin.ErrFollowLink {
+		t.Fatalf("Lookup(%s): err = %v; want %v", name, err, upspin.ErrFollowLink)
+	}
+	if entry.Time != when {
+		t.Errorf("link Time = %d; want %d", entry.Time, when)
+	}
+	after, err := dir.Lookup(target.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after.Time != target.Time {
+		t.Errorf("target Time = %d; want %d", after.Time, target.Time)
+	}
+}
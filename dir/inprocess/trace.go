@@ -0,0 +1,99 @@
+// Copyright 2017 The Upspin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package inprocess
+
+import (
+	"sync/atomic"
+
+	"upspin.io/upspin"
+)
+
+// Tracer creates spans that time the server's interactions with the
+// store. It is a minimal interface that can be implemented on top of a
+// tracing system such as OpenTelemetry.
+type Tracer interface {
+	// StartSpan starts a span for the operation op on the named item.
+	// The operations are:
+	//	fetchDir:  read a directory to find an entry in it
+	//	Store.Get: read the data of a directory or Access or Group file
+	//	Store.Put: write a directory, or a block of a file for PutReader
+	StartSpan(op string, name upspin.PathName) Span
+}
+
+// Span is a single timed operation started by a Tracer.
+type Span interface {
+	// End marks the end of the operation.
+	End()
+}
+
+// tracerValue is the type stored in db.tracer. An atomic.Value must
+// always hold the same concrete type, and Tracer is an interface.
+type tracerValue struct {
+	Tracer
+}
+
+// SetTracer sets the Tracer used to trace the server's store operations.
+// If tracer is nil, which is the default, nothing is traced. The setting
+// applies to all users of the server.
+func (s *server) SetTracer(tracer Tracer) {
+	s.db.tracer.Store(tracerValue{tracer})
+}
+
+// startSpan starts a span if a Tracer is set. It returns nil otherwise,
+// so the usual call is
+//
+//	if span := s.startSpan(op, name); span != nil {
+//		defer span.End()
+//	}
+//
+// The tracer is held in an atomic.Value rather than guarded by s.db.mu
+// because spans are started with and without the lock held.
+func (s *server) startSpan(op string, name upspin.PathName) Span {
+	t, _ := s.db.tracer.Load().(tracerValue)
+	if t.Tracer == nil {
+		return nil
+	}
+	return t.StartSpan(op, name)
+}
+
+// WithStoreCounter runs fn and returns the number of Store.Get and
+// Store.Put operations, as reported to a Tracer, that the server made
+// while it ran, for tests to check the cost of an operation. It counts
+// the operations of all users of the server, from any goroutine, so fn
+// should be the only activity on the server. Any Tracer set by SetTracer
+// still sees every span. Calls to WithStoreCounter are serialized, and
+// SetTracer must not be called while one is in progress.
+func (s *server) WithStoreCounter(fn func()) int {
+	s.db.counterMu.Lock()
+	defer s.db.counterMu.Unlock()
+	prev, _ := s.db.tracer.Load().(tracerValue)
+	c := &countingTracer{next: prev.Tracer}
+	s.db.tracer.Store(tracerValue{c})
+	defer s.db.tracer.Store(prev)
+	fn()
+	return int(atomic.LoadInt64(&c.n))
+}
+
+// countingTracer is a Tracer that counts the store operations and passes
+// each span on to the next Tracer, if any.
+type countingTracer struct {
+	n    int64 // Accessed atomically.
+	next Tracer
+}
+
+func (c *countingTracer) StartSpan(op string, name upspin.PathName) Span {
+	if op == "Store.Get" || op == "Store.Put" {
+		atomic.AddInt64(&c.n, 1)
+	}
+	if c.next == nil {
+		return noSpan{}
+	}
+	return c.next.StartSpan(op, name)
+}
+
+// noSpan is a Span that does nothing.
+type noSpan struct{}
+
+func (noSpan) End() {}
@@ -0,0 +1,123 @@
+// Copyright 2017 The Upspin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package inprocess
+
+import (
+	"strings"
+	"sync"
+	"testing"
+
+	"upspin.io/upspin"
+)
+
+// testTracer counts the spans started and ended for each operation.
+type testTracer struct {
+	mu      sync.Mutex
+	started map[string]int
+	ended   map[string]int
+}
+
+type testSpan struct {
+	t  *testTracer
+	op string
+}
+
+func (t *testTracer) StartSpan(op string, name upspin.PathName) Span {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.started[op]++
+	return testSpan{t, op}
+}
+
+func (s testSpan) End() {
+	s.t.mu.Lock()
+	defer s.t.mu.Unlock()
+	s.t.ended[s.op]++
+}
+
+func TestTracer(t *testing.T) {
+	config, dir := setup()
+	s := dir.(*server)
+	user := config.UserName()
+	tracer := &testTracer{
+		started: make(map[string]int),
+		ended:   make(map[string]int),
+	}
+	s.SetTracer(tracer)
+
+	name := upspin.PathName(user + "/file")
+	if _, err := s.PutReader(name, strings.NewReader("traced"), upspin.PlainPack, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dir.Lookup(name); err != nil {
+		t.Fatal(err)
+	}
+	for _, op := range []string{"fetchDir", "Store.Get", "Store.Put"} {
+		if tracer.started[op] == 0 {
+			t.Errorf("no %s spans", op)
+		}
+		if tracer.started[op] != tracer.ended[op] {
+			t.Errorf("%s: %d spans started, %d ended", op, tracer.started[op], tracer.ended[op])
+		}
+	}
+
+	// Removing the tracer stops tracing.
+	s.SetTracer(nil)
+	n := tracer.started["fetchDir"]
+	if _, err := dir.Lookup(name); err != nil {
+		t.Fatal(err)
+	}
+	if tracer.started["fetchDir"] != n {
+		t.Error("span started with no tracer set")
+	}
+}
+
+func TestWithStoreCounter(t *testing.T) {
+	config, dir := setup()
+	s := dir.(*server)
+	user := config.UserName()
+	for _, d := range []string{"/a", "/a/b"} {
+		if _, err := makeDirectory(dir, upspin.PathName(user)+upspin.PathName(d)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	name := upspin.PathName(user + "/a/b/file")
+
+	n := s.WithStoreCounter(func() {
+		if _, err := s.PutReader(name, strings.NewReader("counted"), upspin.PlainPack, nil); err != nil {
+			t.Fatal(err)
+		}
+	})
+	// At least the block and the three directories on the path.
+	if n < 4 {
+		t.Errorf("PutReader: %d store operations; want at least 4", n)
+	}
+
+	// The chained tracer still sees the spans.
+	tracer := &testTracer{
+		started: make(map[string]int),
+		ended:   make(map[string]int),
+	}
+	s.SetTracer(tracer)
+	n = s.WithStoreCounter(func() {
+		if _, err := dir.Lookup(name); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if n != 3 {
+		t.Errorf("Lookup of %s: %d store operations; want 3", name, n)
+	}
+	if got := tracer.started["Store.Get"]; got != n {
+		t.Errorf("chained tracer saw %d Store.Get spans; want %d", got, n)
+	}
+
+	// The previous tracer is restored afterwards.
+	if _, err := dir.Lookup(name); err != nil {
+		t.Fatal(err)
+	}
+	if got := tracer.started["Store.Get"]; got != 2*n {
+		t.Errorf("restored tracer saw %d Store.Get spans; want %d", got, 2*n)
+	}
+}
@@ -0,0 +1,129 @@
+// Copyright 2017 The Upspin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package inprocess
+
+import (
+	"upspin.io/access"
+	"upspin.io/errors"
+	"upspin.io/path"
+	"upspin.io/upspin"
+)
+
+// Transfer moves the file or link at src into the tree of dstUser, where
+// it is installed under dstPath, a full path name within that tree that
+// must not already exist. Both users' roots must exist. The new entry
+// refers to the same data in the store; only the two directory trees are
+// rewritten, under a single lock so no intermediate state is visible,
+// and the new roots are installed together, so a failure leaves both
+// trees as they were.
+// The caller needs read and delete rights for src and create rights
+// for dstPath. Directories, Access files and Group files cannot be
+// transferred.
+func (s *server) Transfer(src upspin.PathName, dstUser upspin.UserName, dstPath upspin.PathName) error {
+	const op = "dir/inprocess.Transfer"
+	srcParsed, err := s.parse(src)
+	if err != nil {
+		return errors.E(op, err)
+	}
+	dstParsed, err := s.parse(dstPath)
+	if err != nil {
+		return errors.E(op, err)
+	}
+	if dstParsed.User() != normalizeUser(dstUser, s.db.foldLocal) {
+		return errors.E(op, dstPath, errors.Invalid, errors.Errorf("destination not in tree of %s", dstUser))
+	}
+	for _, p := range []path.Parsed{srcParsed, dstParsed} {
+		if access.IsAccessFile(p.Path()) || access.IsGroupFile(p.Path()) {
+			return errors.E(op, p.Path(), errors.Invalid, errors.Str("cannot transfer an Access or Group file"))
+		}
+	}
+	entry, err := s.lookup(op, srcParsed, false)
+	if err != nil {
+		_, err = s.errLink(op, entry, err)
+		return err
+	}
+	if entry.IsDir() {
+		return errors.E(op, src, errors.IsDir)
+	}
+	for _, right := range []access.Right{access.Read, access.Delete} {
+		can, err := s.can(right, srcParsed)
+		if err != nil {
+			return errors.E(op, err)
+		}
+		if !can {
+			return s.errPerm(op, srcParsed)
+		}
+	}
+	if e, err := s.canPut(op, dstParsed, false); err != nil {
+		_, err = s.errLink(op, e, err)
+		return err
+	}
+
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	if s.db.readOnly {
+		return errors.E(op, src, errors.Permission, errReadOnly)
+	}
+	// The source may have changed since we looked.
+	entry, err = s.lookupLocked(op, srcParsed, false)
+	if err != nil {
+		return errors.E(op, err)
+	}
+	if entry.IsDir() {
+		return errors.E(op, src, errors.IsDir)
+	}
+	if _, err := s.move(op, entry, srcParsed, dstParsed); err != nil {
+		return err
+	}
+	return nil
+}
+
+// move moves the entry, which must not be a directory, from one name to
+// another, which must not exist, and sends the events for both names.
+// The new entry refers to the same data as the old one. Both trees are
+// changed together, so if either change fails neither is made. It
+// returns the entry installed at the new name. s.db.mu is held.
+func (s *server) move(op string, entry *upspin.DirEntry, from, to path.Parsed) (*upspin.DirEntry, error) {
+	newEntry, changes, err := s.moveChanges(op, entry, from, to)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := s.putChanges(op, changes); err != nil {
+		return nil, err
+	}
+	s.moveEvents(entry, newEntry)
+	return newEntry, nil
+}
+
+// moveChanges checks that the entry may be moved from one name to
+// another and returns the entry for the new name and the changes,
+// for putChanges, that make the move. s.db.mu is held.
+func (s *server) moveChanges(op string, entry *upspin.DirEntry, from, to path.Parsed) (*upspin.DirEntry, []change, error) {
+	if err := s.checkUnlocked(op, from); err != nil {
+		return nil, nil, err
+	}
+	if err := s.checkSticky(op, from.Drop(1).Path(), entry); err != nil {
+		return nil, nil, err
+	}
+	newEntry := entry.Copy()
+	newEntry.Name = to.Path()
+	newEntry.Sequence = upspin.SeqNotExist
+	changes := []change{
+		{dir: to.Drop(1), entries: []*upspin.DirEntry{newEntry}},
+		{dir: from.Drop(1), entries: []*upspin.DirEntry{entry}, deleting: true},
+	}
+	return newEntry, changes, nil
+}
+
+// moveEvents sends the events for a move of entry to newEntry.
+func (s *server) moveEvents(entry, newEntry *upspin.DirEntry) {
+	s.db.eventMgr.newEvent <- upspin.Event{
+		Entry: newEntry,
+	}
+	s.db.eventMgr.newEvent <- upspin.Event{
+		Entry:  entry,
+		Delete: true,
+	}
+}
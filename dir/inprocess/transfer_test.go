@@ -0,0 +1,115 @@
+// Copyright 2017 The Upspin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package inprocess
+
+import (
+	"fmt"
+	"testing"
+
+	"upspin.io/errors"
+	"upspin.io/upspin"
+)
+
+// transferSetup makes a second user, served by the same directory, who
+// grants the first all rights and has a directory named dir.
+func transferSetup(t *testing.T) (upspin.Config, upspin.DirServer, upspin.UserName, upspin.PathName) {
+	config, dir := setup()
+	s := dir.(*server)
+	user := config.UserName()
+	other := nextUser()
+	otherConfig, key, _, _ := newConfigAndServices(other)
+	if err := key.Put(&upspin.User{
+		Name:      other,
+		Dirs:      []upspin.Endpoint{otherConfig.DirEndpoint()},
+		Stores:    []upspin.Endpoint{otherConfig.StoreEndpoint()},
+		PublicKey: otherConfig.Factotum().PublicKey(),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	svc, err := s.Dial(otherConfig, s.Endpoint())
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherDir := svc.(upspin.DirServer)
+	otherRoot := upspin.PathName(other + "/")
+	if _, err := makeDirectory(otherDir, otherRoot); err != nil {
+		t.Fatal(err)
+	}
+	accessFile := storePlainWithIntegrity(t, otherConfig, []byte(fmt.Sprintf("*: %s, %s\n", user, other)), otherRoot+"Access")
+	if _, err := otherDir.Put(accessFile); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := makeDirectory(otherDir, otherRoot+"dir"); err != nil {
+		t.Fatal(err)
+	}
+	return config, dir, other, otherRoot
+}
+
+func TestTransfer(t *testing.T) {
+	config, dir, other, otherRoot := transferSetup(t)
+	s := dir.(*server)
+	user := config.UserName()
+
+	src := upspin.PathName(user + "/file")
+	dst := otherRoot + "dir/file"
+	const text = "moving on"
+	if _, err := dir.Put(storeData(t, config, []byte(text), src)); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Transfer(src, other, dst); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dir.Lookup(src); !errors.Match(errors.E(errors.NotExist), err) {
+		t.Errorf("Lookup of source: err = %v; want NotExist", err)
+	}
+	data, err := s.GetData(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != text {
+		t.Errorf("data = %q; want %q", data, text)
+	}
+
+	// The destination must be in the named user's tree.
+	if err := s.Transfer(dst, user, dst+"2"); !errors.Match(errors.E(errors.Invalid), err) {
+		t.Errorf("Transfer to wrong tree: err = %v; want Invalid", err)
+	}
+	// Directories can't be transferred.
+	if err := s.Transfer(otherRoot+"dir", user, upspin.PathName(user+"/dir")); !errors.Match(errors.E(errors.IsDir), err) {
+		t.Errorf("Transfer of directory: err = %v; want IsDir", err)
+	}
+}
+
+func TestTransferFailAfter(t *testing.T) {
+	config, dir, other, otherRoot := transferSetup(t)
+	s := dir.(*server)
+	user := config.UserName()
+	src := upspin.PathName(user + "/file")
+	dst := otherRoot + "dir/file"
+	const text = "moving on"
+	if _, err := dir.Put(storeData(t, config, []byte(text), src)); err != nil {
+		t.Fatal(err)
+	}
+	stores := failEachStore(t, s, []upspin.UserName{user, other}, func() error {
+		return s.Transfer(src, other, dst)
+	}, func(n int) {
+		if data, err := s.GetData(src); err != nil || string(data) != text {
+			t.Errorf("failing after %d stores: source holds %q, %v", n, data, err)
+		}
+		if _, err := dir.Lookup(dst); !errors.Match(errors.E(errors.NotExist), err) {
+			t.Errorf("failing after %d stores: destination visible: %v", n, err)
+		}
+	})
+	// The other user's dir and root, then our root.
+	if stores != 3 {
+		t.Errorf("Transfer made %d stores; want 3", stores)
+	}
+	if _, err := dir.Lookup(src); !errors.Match(errors.E(errors.NotExist), err) {
+		t.Errorf("Lookup of source: err = %v; want NotExist", err)
+	}
+	if data, err := s.GetData(dst); err != nil || string(data) != text {
+		t.Errorf("destination holds %q, %v; want %q", data, err, text)
+	}
+}
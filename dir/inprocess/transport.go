@@ -0,0 +1,44 @@
+// Copyright 2017 The Upspin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package inprocess
+
+import (
+	"upspin.io/bind"
+	"upspin.io/errors"
+	"upspin.io/upspin"
+)
+
+// RegisterTransport registers the server with bind for the transport, in
+// addition to InProcess, and makes Dial accept endpoints with it. Dialing
+// through either transport reaches the same tree. It lets tests exercise
+// bind's dispatch on transports other than InProcess without a network.
+// As with bind.RegisterDirServer, a transport can be registered only once
+// in a process.
+func (s *server) RegisterTransport(transport upspin.Transport) error {
+	const op = "dir/inprocess.RegisterTransport"
+	if transport == upspin.InProcess {
+		return errors.E(op, errors.Invalid, errors.Str("InProcess is always accepted"))
+	}
+	if err := bind.RegisterDirServer(transport, s); err != nil {
+		return errors.E(op, err)
+	}
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	if s.db.transports == nil {
+		s.db.transports = make(map[upspin.Transport]bool)
+	}
+	s.db.transports[transport] = true
+	return nil
+}
+
+// acceptsTransport reports whether Dial accepts the transport.
+func (db *database) acceptsTransport(transport upspin.Transport) bool {
+	if transport == upspin.InProcess {
+		return true
+	}
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return db.transports[transport]
+}
@@ -0,0 +1,113 @@
+// Copyright 2017 The Upspin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package inprocess
+
+import (
+	"fmt"
+	"testing"
+
+	"upspin.io/bind"
+	"upspin.io/errors"
+	"upspin.io/upspin"
+)
+
+func TestRegisterTransport(t *testing.T) {
+	config, dir := setup()
+	s := dir.(*server)
+	user := config.UserName()
+	other := upspin.Endpoint{Transport: upspin.Remote, NetAddr: "dir.example.com:443"}
+
+	if _, err := s.Dial(config, other); !errors.Match(errors.E(errors.Invalid), err) {
+		t.Fatalf("Dial of unregistered transport: err = %v; want Invalid", err)
+	}
+	if err := s.RegisterTransport(upspin.InProcess); !errors.Match(errors.E(errors.Invalid), err) {
+		t.Errorf("RegisterTransport(InProcess): err = %v; want Invalid", err)
+	}
+	if err := s.RegisterTransport(upspin.Remote); err != nil {
+		t.Fatal(err)
+	}
+
+	// The tree is reachable through bind with the new transport.
+	if _, err := makeDirectory(dir, upspin.PathName(user+"/dir")); err != nil {
+		t.Fatal(err)
+	}
+	remote, err := bind.DirServer(config, other)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry, err := remote.Lookup(upspin.PathName(user + "/dir"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !entry.IsDir() {
+		t.Errorf("%s is not a directory", entry.Name)
+	}
+}
+
+func TestConcurrentDial(t *testing.T) {
+	config, dir := setup()
+	s := dir.(*server)
+	const n = 10
+	// Even-numbered goroutines dial as a new user; the others dial as
+	// the existing one. The users are made beforehand.
+	configs := make([]upspin.Config, n)
+	for i := range configs {
+		configs[i] = config
+		if i%2 != 0 {
+			continue
+		}
+		cfg, key, _, _ := newConfigAndServices(nextUser())
+		err := key.Put(&upspin.User{
+			Name:      cfg.UserName(),
+			Dirs:      []upspin.Endpoint{cfg.DirEndpoint()},
+			Stores:    []upspin.Endpoint{cfg.StoreEndpoint()},
+			PublicKey: cfg.Factotum().PublicKey(),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		configs[i] = cfg
+	}
+	errs := make(chan error, n)
+	for i := 0; i < n; i++ {
+		go func(i int) {
+			cfg := configs[i]
+			svc, err := s.Dial(cfg, s.Endpoint())
+			if err != nil {
+				errs <- err
+				return
+			}
+			d := svc.(upspin.DirServer)
+			user := cfg.UserName()
+			if i%2 == 0 {
+				if _, err := makeDirectory(d, upspin.PathName(user+"/")); err != nil {
+					errs <- err
+					return
+				}
+			}
+			name := upspin.PathName(fmt.Sprintf("%s/dial%d", user, i))
+			if _, err := makeDirectory(d, name); err != nil {
+				errs <- err
+				return
+			}
+			_, err = d.Lookup(name)
+			errs <- err
+		}(i)
+	}
+	for i := 0; i < n; i++ {
+		if err := <-errs; err != nil {
+			t.Error(err)
+		}
+	}
+	// The existing user's directories were all made through views of
+	// the same tree.
+	entries, err := dir.Glob(string(config.UserName()) + "/dial*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != n/2 {
+		t.Errorf("found %d directories; want %d", len(entries), n/2)
+	}
+}
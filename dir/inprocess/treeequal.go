@@ -0,0 +1,111 @@
+// Copyright 2017 The Upspin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package inprocess
+
+import (
+	"strings"
+
+	"upspin.io/errors"
+	"upspin.io/upspin"
+)
+
+// TreeEqual compares the trees rooted at a and b, which may belong to
+// different users, and reports whether they have the same shape and
+// content: the same names relative to their roots, each with the same
+// type, link target and data references. Since the store is content
+// addressed, equal references mean equal data, so no data is read; data
+// packed with a packing that encrypts has different references each
+// time it is packed, however. The names that differ are returned, as
+// named under a, or under b for names only there. Each tree is walked
+// as by Walk, with the caller's rights, so entries the caller cannot
+// read compare as empty and directories it cannot list as empty.
+func (s *server) TreeEqual(a, b upspin.PathName) (bool, []upspin.PathName, error) {
+	const op = "dir/inprocess.TreeEqual"
+	var trees [2][]treeItem
+	for i, root := range []upspin.PathName{a, b} {
+		var rootName string
+		err := s.Walk(root, func(e *upspin.DirEntry) error {
+			if rootName == "" {
+				rootName = string(e.Name)
+			}
+			rel := strings.TrimPrefix(strings.TrimPrefix(string(e.Name), rootName), "/")
+			trees[i] = append(trees[i], treeItem{rel: rel, entry: e})
+			return nil
+		})
+		if err == upspin.ErrFollowLink {
+			return false, nil, err
+		}
+		if err != nil {
+			return false, nil, errors.E(op, err)
+		}
+	}
+
+	// Both lists are in walk order, so they can be merged in step.
+	var diffs []upspin.PathName
+	x, y := trees[0], trees[1]
+	for len(x) > 0 || len(y) > 0 {
+		switch c := compareTreeItems(x, y); {
+		case c < 0:
+			diffs = append(diffs, x[0].entry.Name)
+			x = x[1:]
+		case c > 0:
+			diffs = append(diffs, y[0].entry.Name)
+			y = y[1:]
+		default:
+			if !sameContent(x[0].entry, y[0].entry) {
+				diffs = append(diffs, x[0].entry.Name)
+			}
+			x, y = x[1:], y[1:]
+		}
+	}
+	return len(diffs) == 0, diffs, nil
+}
+
+// treeItem is an entry found by TreeEqual and its name relative to the
+// root of the walk.
+type treeItem struct {
+	rel   string
+	entry *upspin.DirEntry
+}
+
+// compareTreeItems compares the first items of the lists in walk order,
+// element by element, treating an empty list as greater than any item.
+func compareTreeItems(x, y []treeItem) int {
+	switch {
+	case len(x) == 0:
+		return 1
+	case len(y) == 0:
+		return -1
+	}
+	xe := strings.Split(x[0].rel, "/")
+	ye := strings.Split(y[0].rel, "/")
+	for i := 0; i < len(xe) && i < len(ye); i++ {
+		if c := strings.Compare(xe[i], ye[i]); c != 0 {
+			return c
+		}
+	}
+	return len(xe) - len(ye)
+}
+
+// sameContent reports whether the entries have the same type, link
+// target and data references.
+func sameContent(x, y *upspin.DirEntry) bool {
+	if x.IsDir() != y.IsDir() || x.IsLink() != y.IsLink() || x.Link != y.Link {
+		return false
+	}
+	if x.IsDir() {
+		// A directory's references depend on its names and times.
+		return true
+	}
+	if len(x.Blocks) != len(y.Blocks) {
+		return false
+	}
+	for i := range x.Blocks {
+		if x.Blocks[i].Location.Reference != y.Blocks[i].Location.Reference || x.Blocks[i].Size != y.Blocks[i].Size {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,67 @@
+// Copyright 2017 The Upspin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package inprocess
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"upspin.io/upspin"
+)
+
+func TestTreeEqual(t *testing.T) {
+	config, dir := setup()
+	s := dir.(*server)
+	user := string(config.UserName())
+	p := func(name string) upspin.PathName { return upspin.PathName(user + name) }
+	put := func(name, data string) {
+		if _, err := s.PutReader(p(name), strings.NewReader(data), upspin.PlainPack, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Two copies of a tree, made in different orders. The names a,
+	// a.b and a/x sort differently by string and by element.
+	for _, tree := range []string{"/one", "/two"} {
+		for _, d := range []string{"", "/a", "/a/x"} {
+			if tree == "/two" && d == "/a/x" {
+				put(tree+"/a.b", "a.b")
+			}
+			if _, err := makeDirectory(dir, p(tree+d)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		put(tree+"/a/x/f", "f")
+		if tree == "/one" {
+			put(tree+"/a.b", "a.b")
+		}
+	}
+	equal, diffs, err := s.TreeEqual(p("/one"), p("/two"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !equal || len(diffs) != 0 {
+		t.Errorf("TreeEqual of copies = %t, %v; want true", equal, diffs)
+	}
+	// The root compares with a subtree too.
+	if equal, _, err := s.TreeEqual(p("/one/a"), p("/two/a")); err != nil || !equal {
+		t.Errorf("TreeEqual of subtrees = %t, %v; want true", equal, err)
+	}
+
+	put("/one/a/x/f", "changed")
+	put("/one/a/only", "one")
+	put("/two/z", "two")
+	if _, err := makeDirectory(dir, p("/two/a/only")); err != nil {
+		t.Fatal(err)
+	}
+	equal, diffs, err = s.TreeEqual(p("/one"), p("/two"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []upspin.PathName{p("/one/a/only"), p("/one/a/x/f"), p("/two/z")}
+	if equal || !reflect.DeepEqual(diffs, want) {
+		t.Errorf("TreeEqual after changes = %t, %v; want false, %v", equal, diffs, want)
+	}
+}
@@ -0,0 +1,190 @@
+// Copyright 2017 The Upspin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package inprocess
+
+import (
+	"strings"
+
+	"upspin.io/access"
+	"upspin.io/errors"
+	"upspin.io/path"
+	"upspin.io/upspin"
+)
+
+// DeleteUser removes the user's root and with it the user's entire tree,
+// which need not be empty. Only the user may do this. The data in the store
+// is not deleted, but the server forgets any bookkeeping it holds for the
+// tree, and afterwards the user's names refer to no such user.
+func (s *server) DeleteUser(userName upspin.UserName) error {
+	const op = "dir/inprocess.DeleteUser"
+	userName = normalizeUser(userName, s.db.foldLocal)
+	if normalizeUser(s.config.UserName(), s.db.foldLocal) != userName {
+		return errors.E(op, userName, errors.Permission)
+	}
+
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	if s.db.readOnly {
+		return errors.E(op, userName, errors.Permission, errReadOnly)
+	}
+	if _, ok := s.db.root[userName]; !ok {
+		return errors.E(op, userName, errors.NotExist, errors.Str("no such user"))
+	}
+	if err := s.recordMutation("deleteUser", upspin.PathName(userName+"/"), nil, nil); err != nil {
+		return errors.E(op, err)
+	}
+	if err := s.dropTree(userName); err != nil {
+		return errors.E(op, err)
+	}
+	return nil
+}
+
+// InitUser creates the user's root, which must not exist, and then each
+// of the named directories in order, so each must be in the user's tree
+// and its parent must already exist or come earlier in the list. It is
+// all or nothing: the tree is built under one write lock and the new root
+// is installed only once every directory is made, so if any step fails
+// the user still has no root. Only the user may do this.
+func (s *server) InitUser(userName upspin.UserName, dirs []upspin.PathName) error {
+	const op = "dir/inprocess.InitUser"
+	userName = normalizeUser(userName, s.db.foldLocal)
+	if normalizeUser(s.config.UserName(), s.db.foldLocal) != userName {
+		return errors.E(op, userName, errors.Permission)
+	}
+	parsedDirs := make([]path.Parsed, len(dirs))
+	for i, dir := range dirs {
+		parsed, err := s.parse(dir)
+		if err != nil {
+			return errors.E(op, err)
+		}
+		if parsed.User() != userName || parsed.IsRoot() {
+			return errors.E(op, dir, errors.Invalid, errors.Str("not below the user's root"))
+		}
+		parsedDirs[i] = parsed
+	}
+	rootName := upspin.PathName(userName + "/")
+
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	if s.db.readOnly {
+		return errors.E(op, userName, errors.Permission, errReadOnly)
+	}
+	if _, present := s.db.root[userName]; present {
+		return errors.E(op, rootName, errors.Exist)
+	}
+	root, err := s.newDirEntry(rootName, nil, s.newSequence())
+	if err != nil {
+		return errors.E(op, err)
+	}
+	// Build the tree in place, which no one else can see while we
+	// hold the lock, then take the root out again until it is done.
+	s.db.root[userName] = root
+	made, err := s.initDirs(op, parsedDirs)
+	root = s.db.root[userName]
+	delete(s.db.root, userName)
+	if err != nil {
+		return err
+	}
+	if err := s.recordMutation("initUser", rootName, nil, root); err != nil {
+		return errors.E(op, err)
+	}
+	s.db.root[userName] = root
+	for _, entry := range append([]*upspin.DirEntry{root}, made...) {
+		s.db.eventMgr.newEvent <- upspin.Event{
+			Entry: entry,
+		}
+	}
+	return nil
+}
+
+// initDirs makes each directory in turn in the user's tree, installing
+// the new root after each, and returns their entries. It is the body
+// of InitUser. s.db.mu is held.
+func (s *server) initDirs(op string, dirs []path.Parsed) ([]*upspin.DirEntry, error) {
+	var made []*upspin.DirEntry
+	for _, parsed := range dirs {
+		if _, err := s.lookupLocked(op, parsed, true); err == nil {
+			return nil, errors.E(op, parsed.Path(), errors.Exist)
+		} else if !errors.Match(notExist, err) {
+			return nil, errors.E(op, err)
+		}
+		entry, err := s.newDirEntry(parsed.Path(), []byte(""), upspin.SeqIgnore)
+		if err != nil {
+			return nil, errors.E(op, err)
+		}
+		_, p, err := s.preparePut(op, parsed.Drop(1), []*upspin.DirEntry{entry}, false)
+		if err != nil {
+			return nil, err
+		}
+		s.db.root[parsed.User()] = p.root
+		made = append(made, entry)
+	}
+	return made, nil
+}
+
+// dropTree removes the user's root and forgets the bookkeeping held
+// for the tree below it, including what is recorded about data no
+// longer referred to. A caller installing a new tree that keeps some of
+// the data must reference it first. s.db.mu is held.
+func (s *server) dropTree(userName upspin.UserName) error {
+	root, ok := s.db.root[userName]
+	if !ok {
+		return nil
+	}
+	err := s.walkTree(root, func(entry *upspin.DirEntry) error {
+		s.db.unref(entry)
+		if access.IsGroupFile(entry.Name) {
+			access.RemoveGroup(entry.Name)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	delete(s.db.root, userName)
+	delete(s.db.rootAccess, userName)
+	prefix := string(userName) + "/"
+	for name := range s.db.access {
+		if strings.HasPrefix(string(name), prefix) {
+			delete(s.db.access, name)
+		}
+	}
+	for name := range s.db.locked {
+		if strings.HasPrefix(string(name), prefix) {
+			delete(s.db.locked, name)
+		}
+	}
+	for name := range s.db.expire {
+		if strings.HasPrefix(string(name), prefix) {
+			delete(s.db.expire, name)
+		}
+	}
+	for dir := range s.db.defaultPacking {
+		if strings.HasPrefix(string(dir)+"/", prefix) {
+			delete(s.db.defaultPacking, dir)
+		}
+	}
+	for dir := range s.db.sticky {
+		if strings.HasPrefix(string(dir)+"/", prefix) {
+			delete(s.db.sticky, dir)
+		}
+	}
+	for name := range s.db.history {
+		if strings.HasPrefix(string(name), prefix) {
+			s.db.dropHistory(name)
+		}
+	}
+	for alias := range s.db.aliases {
+		if strings.HasPrefix(string(alias), prefix) {
+			delete(s.db.aliases, alias)
+		}
+	}
+	for name := range s.db.contentType {
+		if strings.HasPrefix(string(name), prefix) {
+			delete(s.db.contentType, name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,134 @@
+// Copyright 2017 The Upspin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package inprocess
+
+import (
+	"strings"
+	"testing"
+
+	"upspin.io/errors"
+	"upspin.io/upspin"
+)
+
+func TestDeleteUser(t *testing.T) {
+	config, dir := setup()
+	s := dir.(*server)
+	user := config.UserName()
+	dirName := upspin.PathName(user + "/dir")
+	fileName := dirName + "/file"
+	if _, err := makeDirectory(dir, dirName); err != nil {
+		t.Fatal(err)
+	}
+	entry := storeData(t, config, []byte("data"), fileName)
+	if _, err := dir.Put(entry); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.DeleteUser(user); err != nil {
+		t.Fatal(err)
+	}
+	if !s.db.orphaned(entry.Blocks[0].Location.Reference) {
+		t.Error("file data still referenced after DeleteUser")
+	}
+	notExist := errors.E(errors.NotExist)
+	if _, err := dir.Lookup(fileName); !errors.Match(notExist, err) {
+		t.Errorf("Lookup after DeleteUser: err = %v; want NotExist", err)
+	}
+	if _, err := dir.Glob(string(user + "/*")); !errors.Match(notExist, err) {
+		t.Errorf("Glob after DeleteUser: err = %v; want NotExist", err)
+	}
+	if _, err := dir.Put(storeData(t, config, []byte("data"), upspin.PathName(user+"/file"))); err == nil {
+		t.Error("Put after DeleteUser succeeded")
+	}
+	if err := s.DeleteUser(user); !errors.Match(notExist, err) {
+		t.Errorf("second DeleteUser: err = %v; want NotExist", err)
+	}
+	// The user can start again.
+	if _, err := makeDirectory(dir, upspin.PathName(user)); err != nil {
+		t.Fatal(err)
+	}
+	// Expiry records go with the tree.
+	if _, err := s.PutReader(upspin.PathName(user+"/temp"), strings.NewReader("temp"), upspin.PlainPack, &PutOptions{ExpireAt: upspin.Now() + 100}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.DeleteUser(user); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(s.db.expire); n != 0 {
+		t.Errorf("%d expiry records kept after DeleteUser; want none", n)
+	}
+	if _, err := makeDirectory(dir, upspin.PathName(user)); err != nil {
+		t.Fatal(err)
+	}
+	// But not delete another user.
+	if err := s.DeleteUser("other@example.com"); !errors.Match(errors.E(errors.Permission), err) {
+		t.Errorf("DeleteUser of another user: err = %v; want Permission", err)
+	}
+}
+
+func TestInitUser(t *testing.T) {
+	newUser := func() (upspin.UserName, *server) {
+		userName := nextUser()
+		config, key, dir, _ := newConfigAndServices(userName)
+		if err := key.Put(&upspin.User{
+			Name:      userName,
+			Dirs:      []upspin.Endpoint{config.DirEndpoint()},
+			Stores:    []upspin.Endpoint{config.StoreEndpoint()},
+			PublicKey: config.Factotum().PublicKey(),
+		}); err != nil {
+			t.Fatal(err)
+		}
+		return userName, dir.(*server)
+	}
+
+	user, s := newUser()
+	dirs := []upspin.PathName{
+		upspin.PathName(user + "/a"),
+		upspin.PathName(user + "/a/b"),
+		upspin.PathName(user + "/c"),
+	}
+	if err := s.InitUser(user, dirs); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range dirs {
+		entry, err := s.Lookup(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !entry.IsDir() {
+			t.Errorf("%s is not a directory", name)
+		}
+	}
+	if err := s.InitUser(user, nil); !errors.Match(errors.E(errors.Exist), err) {
+		t.Errorf("InitUser of existing user: err = %v; want Exist", err)
+	}
+
+	// A failing step leaves no root behind.
+	for _, rest := range [][]upspin.PathName{
+		{"/a", "/x/y"}, // Parent missing.
+		{"/a", "/a"},   // Made twice.
+	} {
+		user, s := newUser()
+		var dirs []upspin.PathName
+		for _, r := range rest {
+			dirs = append(dirs, upspin.PathName(user)+r)
+		}
+		if err := s.InitUser(user, dirs); err == nil {
+			t.Errorf("InitUser(%v) succeeded", rest)
+		}
+		if _, err := s.Lookup(upspin.PathName(user + "/")); !errors.Match(errors.E(errors.NotExist), err) {
+			t.Errorf("after failed InitUser(%v), Lookup of root: err = %v; want NotExist", rest, err)
+		}
+		// It can be tried again.
+		if err := s.InitUser(user, dirs[:1]); err != nil {
+			t.Errorf("InitUser after failure: %v", err)
+		}
+	}
+
+	other, _ := newUser()
+	if err := s.InitUser(other, nil); !errors.Match(errors.E(errors.Permission), err) {
+		t.Errorf("InitUser of another user: err = %v; want Permission", err)
+	}
+}
@@ -0,0 +1,50 @@
+// Copyright 2017 The Upspin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package inprocess
+
+import (
+	"upspin.io/client/clientutil"
+	"upspin.io/errors"
+	"upspin.io/upspin"
+)
+
+// LookupVerified is Lookup but also checks that the entry's data is
+// still present in the store, following any redirection the store
+// returns. For a directory it also checks that the data unpacks and
+// parses as a directory; for a file it only fetches each block, since
+// the caller may not be able to unpack it. As with Lookup, a link
+// yields ErrFollowLink.
+// A failure to find or parse the data is returned as an error, making
+// visible any dangling reference left by an inconsistent update.
+func (s *server) LookupVerified(name upspin.PathName) (*upspin.DirEntry, error) {
+	const op = "dir/inprocess.LookupVerified"
+	entry, err := s.Lookup(name)
+	if err != nil {
+		return entry, err
+	}
+	switch {
+	case entry.IsDir():
+		payload, err := s.readAll(entry)
+		if err != nil {
+			return nil, errors.E(op, entry.Name, err)
+		}
+		contents, err := parseDir(payload)
+		if err != nil {
+			return nil, errors.E(op, entry.Name, err)
+		}
+		for i := 0; i < contents.len(); i++ {
+			if _, err := contents.entry(i); err != nil {
+				return nil, errors.E(op, entry.Name, errors.Internal, err)
+			}
+		}
+	case entry.IsRegular():
+		for _, b := range entry.Blocks {
+			if _, err := clientutil.ReadLocation(s.config, b.Location); err != nil {
+				return nil, errors.E(op, entry.Name, err)
+			}
+		}
+	}
+	return entry, nil
+}
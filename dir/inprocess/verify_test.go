@@ -0,0 +1,48 @@
+// Copyright 2017 The Upspin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package inprocess
+
+import (
+	"testing"
+
+	"upspin.io/bind"
+	"upspin.io/upspin"
+)
+
+func TestLookupVerified(t *testing.T) {
+	config, dir := globTree(t)
+	s := dir.(*server)
+	user := string(config.UserName())
+	store, err := bind.StoreServer(config, config.StoreEndpoint())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"/", "/a", "/f1"} {
+		if _, err := s.LookupVerified(upspin.PathName(user + name)); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+	if _, err := s.LookupVerified(upspin.PathName(user + "/link")); err != upspin.ErrFollowLink {
+		t.Errorf("link: err = %v; want ErrFollowLink", err)
+	}
+
+	// Remove the data of a file and of a directory.
+	for _, name := range []string{"/c/f4", "/c/d"} {
+		entry, err := dir.Lookup(upspin.PathName(user + name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := store.Delete(entry.Blocks[0].Location.Reference); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := dir.Lookup(upspin.PathName(user + name)); err != nil {
+			t.Errorf("Lookup of %s: %v", name, err)
+		}
+		if _, err := s.LookupVerified(upspin.PathName(user + name)); err == nil {
+			t.Errorf("LookupVerified of %s succeeded with missing data", name)
+		}
+	}
+}
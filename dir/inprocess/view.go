@@ -0,0 +1,70 @@
+// Copyright 2017 The Upspin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package inprocess
+
+import (
+	"upspin.io/errors"
+	"upspin.io/upspin"
+)
+
+// A View is a read-only view of a user's tree as it was when the View
+// was created. Since every change to a tree writes new directory blocks
+// and a new root, and blocks in the store are never modified, a View
+// needs only to remember the root entry: lookups start from it rather
+// than from the current root, so they see a consistent tree however
+// the server changes. A View holds no lock between calls.
+//
+// Access is checked against the Access files in effect at the time
+// of each call, not those of the snapshot.
+type View struct {
+	s *server
+}
+
+// SnapshotView returns a View of the user's tree as it is now.
+func (s *server) SnapshotView(userName upspin.UserName) (*View, error) {
+	const op = "dir/inprocess.SnapshotView"
+	userName = normalizeUser(userName, s.db.foldLocal)
+	s.db.mu.RLock()
+	root, ok := s.db.root[userName]
+	s.db.mu.RUnlock()
+	if !ok {
+		return nil, errors.E(op, userName, errors.NotExist, errors.Str("no such user"))
+	}
+	v := *s // Make a copy.
+	v.snapshot = root
+	return &View{s: &v}, nil
+}
+
+// Lookup is like the server's Lookup but looks in the snapshot.
+// The name must be in the snapshot's tree.
+func (v *View) Lookup(name upspin.PathName) (*upspin.DirEntry, error) {
+	const op = "dir/inprocess.View.Lookup"
+	if err := v.check(name); err != nil {
+		return nil, errors.E(op, err)
+	}
+	return v.s.Lookup(name)
+}
+
+// Glob is like the server's Glob but looks in the snapshot.
+// The pattern must be in the snapshot's tree.
+func (v *View) Glob(pattern string) ([]*upspin.DirEntry, error) {
+	const op = "dir/inprocess.View.Glob"
+	if err := v.check(upspin.PathName(pattern)); err != nil {
+		return nil, errors.E(op, err)
+	}
+	return v.s.Glob(pattern)
+}
+
+// check returns an error if the name is not in the snapshot's tree.
+func (v *View) check(name upspin.PathName) error {
+	parsed, err := v.s.parse(name)
+	if err != nil {
+		return err
+	}
+	if v.s.snapshot.Name != upspin.PathName(parsed.User()+"/") {
+		return errors.E(name, errors.Invalid, errors.Str("not in snapshot"))
+	}
+	return nil
+}
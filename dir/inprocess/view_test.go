@@ -0,0 +1,61 @@
+// Copyright 2017 The Upspin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package inprocess
+
+import (
+	"testing"
+
+	"upspin.io/errors"
+	"upspin.io/upspin"
+)
+
+func TestSnapshotView(t *testing.T) {
+	config, dir := globTree(t)
+	s := dir.(*server)
+	user := string(config.UserName())
+
+	v, err := s.SnapshotView(config.UserName())
+	if err != nil {
+		t.Fatal(err)
+	}
+	before, err := v.Glob(user + "/[ac]/f*")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Change the tree.
+	if _, err := dir.Delete(upspin.PathName(user + "/a/f2")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dir.Put(storeData(t, config, []byte("new"), upspin.PathName(user+"/c/new"))); err != nil {
+		t.Fatal(err)
+	}
+
+	after, err := v.Glob(user + "/[ac]/f*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(after) != len(before) {
+		t.Fatalf("view changed: %d entries before, %d after", len(before), len(after))
+	}
+	for i := range before {
+		if before[i].Name != after[i].Name {
+			t.Errorf("entry %d: %q before, %q after", i, before[i].Name, after[i].Name)
+		}
+	}
+	if _, err := v.Lookup(upspin.PathName(user + "/a/f2")); err != nil {
+		t.Errorf("deleted file missing from view: %v", err)
+	}
+	if _, err := v.Lookup(upspin.PathName(user + "/c/new")); !errors.Match(errors.E(errors.NotExist), err) {
+		t.Errorf("new file in view: err = %v; want NotExist", err)
+	}
+	// The server itself sees the changes.
+	if _, err := dir.Lookup(upspin.PathName(user + "/c/new")); err != nil {
+		t.Error(err)
+	}
+	if _, err := v.Lookup(upspin.PathName(nextUser() + "/")); !errors.Match(errors.E(errors.Invalid), err) {
+		t.Errorf("other user in view: err = %v; want Invalid", err)
+	}
+}
@@ -0,0 +1,233 @@
+// Copyright 2017 The Upspin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package inprocess
+
+// Mutation log.
+//
+// If a log is set by SetMutationLog, every change to a user's root is
+// appended to it as a record just before the root is changed. Since the
+// directories of the new tree are already in the store by then, the
+// record's root is enough to recover the tree after a crash, and Replay
+// does so by installing the last root logged for each user.
+//
+// A record is a version byte, currently 1, followed by four fields,
+// each a uvarint length and that many bytes:
+//	op:    the operation, such as "put" or "delete"
+//	name:  the path name operated on
+//	entry: the marshaled entry put or deleted, if any, which holds its
+//	       packdata and the references of its data
+//	root:  the marshaled new root, or empty if the root was removed
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+
+	"upspin.io/access"
+	"upspin.io/errors"
+	"upspin.io/path"
+	"upspin.io/upspin"
+)
+
+const mutationVersion = 1
+
+// mutation is a record of the mutation log.
+type mutation struct {
+	op    string
+	name  upspin.PathName
+	entry *upspin.DirEntry // May be nil.
+	root  *upspin.DirEntry // Nil if the root was removed.
+}
+
+// SetMutationLog sets the writer to which the server logs each change
+// to a user's root. Each record is written with a single call to Write,
+// and if the write fails the change is abandoned and its error returned.
+// If w is nil, which is the default, nothing is logged. The setting
+// applies to all users of the server.
+func (s *server) SetMutationLog(w io.Writer) {
+	s.db.mu.Lock()
+	s.db.mutationLog = w
+	s.db.mu.Unlock()
+}
+
+// recordMutation writes a record of the mutation to the log, if there is
+// one, and then tells any subscribers to the user about it. The entry and
+// root may be nil. s.db.mu is held.
+func (s *server) recordMutation(op string, name upspin.PathName, entry, root *upspin.DirEntry) error {
+	if err := s.logMutation(op, name, entry, root); err != nil {
+		return err
+	}
+	s.notify(op, name, root)
+	return nil
+}
+
+// logMutation writes a record of the mutation to the log, if there is
+// one. s.db.mu is held.
+func (s *server) logMutation(op string, name upspin.PathName, entry, root *upspin.DirEntry) error {
+	if s.db.mutationLog == nil {
+		return nil
+	}
+	var entryData, rootData []byte
+	var err error
+	if entry != nil {
+		if entryData, err = entry.Marshal(); err != nil {
+			return errors.E(name, err)
+		}
+	}
+	if root != nil {
+		if rootData, err = root.Marshal(); err != nil {
+			return errors.E(name, err)
+		}
+	}
+	record := []byte{mutationVersion}
+	for _, field := range [][]byte{[]byte(op), []byte(name), entryData, rootData} {
+		record = appendField(record, field)
+	}
+	if _, err := s.db.mutationLog.Write(record); err != nil {
+		return errors.E(name, errors.IO, err)
+	}
+	return nil
+}
+
+// appendField appends the length of the field and then the field to b.
+func appendField(b, field []byte) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(buf[:], uint64(len(field)))
+	return append(append(b, buf[:n]...), field...)
+}
+
+// readMutation reads the next record from the log. At the end of the
+// log it returns io.EOF.
+func readMutation(r *bufio.Reader) (*mutation, error) {
+	version, err := r.ReadByte()
+	if err != nil {
+		return nil, err // Possibly io.EOF.
+	}
+	if version != mutationVersion {
+		return nil, errors.E(errors.Invalid, errors.Errorf("unknown mutation log version %d", version))
+	}
+	var fields [4][]byte
+	for i := range fields {
+		n, err := binary.ReadUvarint(r)
+		if err == nil && n > 1<<30 {
+			err = errors.Str("field too long")
+		}
+		if err == nil {
+			fields[i] = make([]byte, n)
+			_, err = io.ReadFull(r, fields[i])
+		}
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, errors.E(errors.Invalid, errors.Errorf("truncated mutation log record: %v", err))
+		}
+	}
+	m := &mutation{
+		op:   string(fields[0]),
+		name: upspin.PathName(fields[1]),
+	}
+	for i, e := range []**upspin.DirEntry{&m.entry, &m.root} {
+		data := fields[2+i]
+		if len(data) == 0 {
+			continue
+		}
+		*e = new(upspin.DirEntry)
+		if _, err := (*e).Unmarshal(data); err != nil {
+			return nil, errors.E(m.name, errors.Invalid, err)
+		}
+	}
+	return m, nil
+}
+
+// Replay reads a mutation log written by the server, or by an earlier
+// server using the same store, and installs for each user in it the
+// last root the log records, replacing the user's tree. Users not in
+// the log are not affected. The directories the roots refer to must be
+// in the store. Nothing is changed unless the whole log can be read and
+// every installed tree read back from the store. No Watch events are
+// sent, and the replay itself is not logged.
+// The replay applies to all users of the server.
+func (s *server) Replay(r io.Reader) error {
+	const op = "dir/inprocess.Replay"
+
+	// Find the last root of each user.
+	roots := make(map[upspin.UserName]*upspin.DirEntry)
+	br := bufio.NewReader(r)
+	for {
+		m, err := readMutation(br)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return errors.E(op, err)
+		}
+		parsed, err := path.Parse(m.name)
+		if err != nil {
+			return errors.E(op, err)
+		}
+		roots[parsed.User()] = m.root
+	}
+
+	// Read the Access files of the new trees, which also checks
+	// that the trees are all in the store.
+	accessFiles := make(map[upspin.PathName]*access.Access)
+	for _, root := range roots {
+		if root == nil {
+			continue
+		}
+		err := s.walkTree(root, func(entry *upspin.DirEntry) error {
+			if !access.IsAccessFile(entry.Name) {
+				return nil
+			}
+			data, err := s.readAll(entry)
+			if err != nil {
+				return err
+			}
+			a, err := access.Parse(entry.Name, data)
+			if err != nil {
+				return err
+			}
+			accessFiles[path.DropPath(entry.Name, 1)] = a
+			return nil
+		})
+		if err != nil {
+			return errors.E(op, err)
+		}
+	}
+
+	// Replace the trees.
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	if s.db.readOnly {
+		return errors.E(op, errors.Permission, errReadOnly)
+	}
+	for userName, root := range roots {
+		// Reference the new tree before dropping the old one, so what
+		// is recorded about the data they share is kept.
+		if root != nil {
+			err := s.walkTree(root, func(entry *upspin.DirEntry) error {
+				s.db.ref(entry)
+				if access.IsGroupFile(entry.Name) {
+					access.RemoveGroup(entry.Name)
+				}
+				return nil
+			})
+			if err != nil {
+				return errors.E(op, err)
+			}
+		}
+		if err := s.dropTree(userName); err != nil {
+			return errors.E(op, err)
+		}
+		if root != nil {
+			s.db.root[userName] = root
+		}
+	}
+	for dir, a := range accessFiles {
+		s.db.access[dir] = a
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+// Copyright 2017 The Upspin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package inprocess
+
+import (
+	"bufio"
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"upspin.io/errors"
+	"upspin.io/upspin"
+)
+
+type failWriter struct{}
+
+func (failWriter) Write([]byte) (int, error) {
+	return 0, errors.Str("disk full")
+}
+
+// walkNames returns the names in the user's tree, less the user name.
+func walkNames(t *testing.T, s *server, user upspin.UserName) []string {
+	var names []string
+	err := s.Walk(upspin.PathName(user+"/"), func(e *upspin.DirEntry) error {
+		names = append(names, strings.TrimPrefix(string(e.Name), string(user)))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return names
+}
+
+func TestMutationLog(t *testing.T) {
+	config, dir := setup()
+	s := dir.(*server)
+	user := config.UserName()
+	var log bytes.Buffer
+	s.SetMutationLog(&log)
+
+	if _, err := dir.Put(storeData(t, config, []byte("one"), upspin.PathName(user+"/f1"))); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := makeDirectory(dir, upspin.PathName(user+"/a")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dir.Put(storeData(t, config, []byte("two"), upspin.PathName(user+"/a/f2"))); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dir.Delete(upspin.PathName(user + "/f1")); err != nil {
+		t.Fatal(err)
+	}
+	want := walkNames(t, s, user)
+
+	var ops []string
+	r := bytes.NewReader(log.Bytes())
+	for br := bufio.NewReader(r); ; {
+		m, err := readMutation(br)
+		if err != nil {
+			break
+		}
+		ops = append(ops, m.op+" "+strings.TrimPrefix(string(m.name), string(user)))
+	}
+	if got, want := strings.Join(ops, ", "), "put /f1, put /a, put /a/f2, delete /f1"; got != want {
+		t.Errorf("log holds %q; want %q", got, want)
+	}
+
+	// A new server with nothing in it recovers the tree from the log.
+	fresh := New(config).(*server)
+	if err := fresh.Replay(bytes.NewReader(log.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+	if got := walkNames(t, fresh, user); !reflect.DeepEqual(got, want) {
+		t.Errorf("replayed tree has %q; want %q", got, want)
+	}
+	if errs := fresh.checkUser(user); len(errs) != 0 {
+		t.Errorf("replayed tree is inconsistent: %v", errs)
+	}
+
+	// A truncated log changes nothing.
+	other := New(config).(*server)
+	if err := other.Replay(bytes.NewReader(log.Bytes()[:log.Len()-1])); !errors.Match(errors.E(errors.Invalid), err) {
+		t.Errorf("Replay of truncated log: err = %v; want Invalid", err)
+	}
+	if _, err := other.Lookup(upspin.PathName(user + "/")); err == nil {
+		t.Error("truncated replay created the root")
+	}
+
+	// If the log cannot be written, the change is not made.
+	s.SetMutationLog(failWriter{})
+	if _, err := dir.Put(storeData(t, config, []byte("three"), upspin.PathName(user+"/f3"))); !errors.Match(errors.E(errors.IO), err) {
+		t.Errorf("Put with failing log: err = %v; want IO", err)
+	}
+	s.SetMutationLog(nil)
+	if got := walkNames(t, s, user); !reflect.DeepEqual(got, want) {
+		t.Errorf("after failed Put tree has %q; want %q", got, want)
+	}
+}
@@ -0,0 +1,111 @@
+// Copyright 2017 The Upspin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package inprocess
+
+import (
+	"upspin.io/errors"
+	"upspin.io/upspin"
+)
+
+// walkTree calls fn for every entry in the tree below the directory dir,
+// depth first, in the order the entries are stored, which is sorted by name. It does not call fn
+// for dir itself. It does no access checks and does not lock s.db.mu;
+// callers must hold it.
+func (s *server) walkTree(dir *upspin.DirEntry, fn func(*upspin.DirEntry) error) error {
+	const op = "dir/inprocess.walkTree"
+	payload, err := s.readAll(dir)
+	if err != nil {
+		return errors.E(op, dir.Name, err)
+	}
+	contents, err := parseDir(payload)
+	if err != nil {
+		return errors.E(op, dir.Name, err)
+	}
+	for i := 0; i < contents.len(); i++ {
+		entry, err := contents.entry(i)
+		if err != nil {
+			return errors.E(op, dir.Name, err)
+		}
+		if err := fn(entry); err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			if err := s.walkTree(entry, fn); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// SkipDir is used as a return value from the function passed to Walk to
+// indicate that the directory named in the call is to be skipped. It is
+// not returned as an error by Walk.
+var SkipDir = errors.Str("skip this directory")
+
+// Walk calls fn for the entry named by root and then, if it is a
+// directory, for every entry in the tree below it, depth first and in
+// name order. Links within the tree are reported but not followed;
+// if root is itself a link, Walk returns ErrFollowLink. If fn returns SkipDir
+// for a directory, Walk does not descend into it; if it returns SkipDir
+// for any other entry, Walk skips the rest of the containing directory.
+// Any other error from fn stops the walk and is returned by Walk.
+//
+// Each directory is read with the caller's rights, as by Glob:
+// directories the caller cannot list are reported but not descended
+// into, and entries the caller cannot read are marked incomplete.
+// The tree is not locked for the duration of the walk, so changes made
+// while it is in progress may or may not be seen.
+func (s *server) Walk(root upspin.PathName, fn func(entry *upspin.DirEntry) error) error {
+	const op = "dir/inprocess.Walk"
+	entry, err := s.Lookup(root)
+	if err == upspin.ErrFollowLink {
+		return err
+	}
+	if err != nil {
+		return errors.E(op, err)
+	}
+	err = fn(entry)
+	if err == SkipDir || err == nil && !entry.IsDir() {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return s.walk(entry.Name, fn)
+}
+
+// walk is the recursive implementation of Walk. It calls fn for each
+// entry in the named directory and descends into the subdirectories.
+func (s *server) walk(dirName upspin.PathName, fn func(entry *upspin.DirEntry) error) error {
+	entries, err := s.listDir(dirName)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		err := fn(e)
+		if err == SkipDir {
+			if e.IsDir() {
+				continue
+			}
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if !e.IsDir() {
+			continue
+		}
+		err = s.walk(e.Name, fn)
+		if errors.Match(errPrivate, err) || errors.Match(errPermission, err) {
+			// Report but do not descend into directories we can't list.
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
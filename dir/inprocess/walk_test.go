@@ -0,0 +1,89 @@
+// Copyright 2017 The Upspin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package inprocess
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"upspin.io/errors"
+	"upspin.io/upspin"
+)
+
+func TestWalk(t *testing.T) {
+	config, dir := globTree(t)
+	s := dir.(*server)
+	user := string(config.UserName())
+
+	// walk returns the names visited, relative to the user root.
+	walk := func(root string, fn func(*upspin.DirEntry) error) ([]string, error) {
+		var names []string
+		err := s.Walk(upspin.PathName(user+root), func(e *upspin.DirEntry) error {
+			names = append(names, strings.TrimPrefix(string(e.Name), user))
+			return fn(e)
+		})
+		return names, err
+	}
+	none := func(*upspin.DirEntry) error { return nil }
+
+	names, err := walk("/", none)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"/", "/a", "/a/b", "/a/b/f3", "/a/f2", "/c", "/c/d", "/c/d/f5", "/c/f4", "/f1", "/link"}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("Walk visited %q; want %q", names, want)
+	}
+
+	// Skip a directory.
+	names, err = walk("/", func(e *upspin.DirEntry) error {
+		if e.Name == upspin.PathName(user+"/a") {
+			return SkipDir
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = []string{"/", "/a", "/c", "/c/d", "/c/d/f5", "/c/f4", "/f1", "/link"}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("Walk with SkipDir visited %q; want %q", names, want)
+	}
+
+	// SkipDir on a file skips the rest of its directory.
+	names, err = walk("/c", func(e *upspin.DirEntry) error {
+		if e.Name == upspin.PathName(user+"/c/d/f5") {
+			return SkipDir
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = []string{"/c", "/c/d", "/c/d/f5", "/c/f4"}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("Walk with SkipDir on file visited %q; want %q", names, want)
+	}
+
+	// Other errors stop the walk.
+	stop := errors.Str("stop")
+	names, err = walk("/", func(e *upspin.DirEntry) error {
+		if e.Name == upspin.PathName(user+"/a/b") {
+			return stop
+		}
+		return nil
+	})
+	if err != stop {
+		t.Errorf("Walk returned %v; want %v", err, stop)
+	}
+	if len(names) != 3 {
+		t.Errorf("Walk visited %q after error", names)
+	}
+
+	if _, err := walk("/nothing", none); !errors.Match(errors.E(errors.NotExist), err) {
+		t.Errorf("Walk of missing root: err = %v; want NotExist", err)
+	}
+}
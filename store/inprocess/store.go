@@ -28,7 +28,8 @@ func New() upspin.StoreServer {
 				Transport: upspin.InProcess,
 				NetAddr:   "", // Ignored.
 			},
-			blob: make(map[upspin.Reference][]byte),
+			blob:     make(map[upspin.Reference][]byte),
+			redirect: make(map[upspin.Reference][]upspin.Location),
 		},
 	}
 }
@@ -43,6 +44,8 @@ type dataService struct {
 	dialed bool
 	// blob contains the underlying data.
 	blob map[upspin.Reference][]byte // reference is made from SHA256 hash of data.
+	// redirect holds the locations returned for references made by PutRedirect.
+	redirect map[upspin.Reference][]upspin.Location
 }
 
 func copyOf(in []byte) (out []byte) {
@@ -75,6 +78,10 @@ func (s *service) Delete(ref upspin.Reference) error {
 	const op = "store/inprocess.Delete"
 	s.data.mu.Lock()
 	defer s.data.mu.Unlock()
+	if _, ok := s.data.redirect[ref]; ok {
+		delete(s.data.redirect, ref)
+		return nil
+	}
 	_, ok := s.data.blob[ref]
 	if !ok {
 		return errors.E(op, errors.NotExist, errors.Errorf("no such blob: %s", ref))
@@ -83,10 +90,39 @@ func (s *service) Delete(ref upspin.Reference) error {
 	return nil
 }
 
+// PutRedirect returns a new reference for which Get returns the given
+// locations rather than data, telling the caller to look there instead.
+// It is used to exercise redirection in clients of the store.
+func (s *service) PutRedirect(locs []upspin.Location) (*upspin.Refdata, error) {
+	const op = "store/inprocess.PutRedirect"
+	if len(locs) == 0 {
+		return nil, errors.E(op, errors.Invalid, errors.Str("no locations"))
+	}
+	var b []byte
+	for _, loc := range locs {
+		b = append(b, loc.Endpoint.String()...)
+		b = append(b, 0)
+		b = append(b, loc.Reference...)
+		b = append(b, 0)
+	}
+	// The prefix keeps these references distinct from those of data.
+	ref := upspin.Reference("redirect:" + sha256key.Of(b).String())
+	s.data.mu.Lock()
+	s.data.redirect[ref] = append([]upspin.Location(nil), locs...)
+	s.data.mu.Unlock()
+	refdata := &upspin.Refdata{
+		Reference: ref,
+		Volatile:  false,
+		Duration:  0,
+	}
+	return refdata, nil
+}
+
 // DeleteAll deletes all data from memory.
 func (s *service) DeleteAll() {
 	s.data.mu.Lock()
 	s.data.blob = make(map[upspin.Reference][]byte)
+	s.data.redirect = make(map[upspin.Reference][]upspin.Location)
 	s.data.mu.Unlock()
 }
 
@@ -99,7 +135,11 @@ func (s *service) Get(ref upspin.Reference) (ciphertext []byte, refdata *upspin.
 	}
 	s.data.mu.Lock()
 	data, ok := s.data.blob[ref]
+	locs, isRedirect := s.data.redirect[ref]
 	s.data.mu.Unlock()
+	if isRedirect {
+		return nil, nil, append([]upspin.Location(nil), locs...), nil
+	}
 	if !ok {
 		return nil, nil, nil, errors.E(op, errors.NotExist, errors.Errorf("no such blob: %s", ref))
 	}